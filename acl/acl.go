@@ -0,0 +1,238 @@
+package acl
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- Access Control Lists --------
+// An ACL is loaded from a JSON file shared by every node:
+//
+//	{
+//	  "cluster_token": "long-random-string",
+//	  "users": [
+//	    {"name": "admin", "password": "sha256:5e8848...", "commands": ["@all"], "keys": ["*"]},
+//	    {"name": "tenant-a", "tokens": ["sha256:..."], "commands": ["@read", "set"], "keys": ["*"], "namespaces": ["tenant-a"]},
+//	    {"name": "default", "commands": ["ping"]}
+//	  ]
+//	}
+//
+// A connection authenticates with a user name and password, or with a token
+// alone. Commands are listed by name (see protocol.CommandType.String) or by
+// category: @read (see protocol.CommandType.ReadOnly), @write and @all.
+// Keys are prefixes; "*" allows every key.
+// Namespaces are the keyspaces a user may use, "" being the default one and
+// "*" allowing them all; a user without any may only use the default one.
+//
+// Connections that never authenticate run as the "default" user, if there
+// is one, and are refused otherwise. Nodes authenticate to each other with
+// the cluster token, which lets them pass on the user a request runs as.
+//
+// User secrets may be written in plain text or, better, as "sha256:"
+// followed by the hex SHA-256 of the secret. The cluster token has to be
+// plain text, since nodes send it, and is required: without it a node
+// could not forward a request to another.
+
+// DefaultUser is the user unauthenticated connections run as.
+const DefaultUser = "default"
+
+// ACL is a set of users and the cluster token.
+type ACL struct {
+	ClusterToken string  `json:"cluster_token"`
+	Users        []*User `json:"users"`
+
+	clusterToken []byte
+	byName       map[string]*User
+}
+
+// User is a credential and what it may do.
+type User struct {
+	Name     string   `json:"name"`
+	Password string   `json:"password,omitempty"`
+	Tokens   []string `json:"tokens,omitempty"`
+	Commands []string `json:"commands"`
+	Keys     []string `json:"keys"`
+	// Namespaces is nil for the default namespace alone.
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	password []byte   // SHA-256 of the password, nil if none
+	tokens   [][]byte // SHA-256 of each token
+	commands map[protocol.CommandType]bool
+}
+
+// Load reads an ACL from a JSON file.
+func Load(path string) (*ACL, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse reads an ACL from JSON and checks it for mistakes.
+func Parse(data []byte) (*ACL, error) {
+	a := &ACL{}
+	if err := json.Unmarshal(data, a); err != nil {
+		return nil, fmt.Errorf("acl: %w", err)
+	}
+
+	if strings.HasPrefix(a.ClusterToken, "sha256:") {
+		return nil, fmt.Errorf("acl: cluster_token must be plain text")
+	}
+	if a.ClusterToken == "" {
+		return nil, fmt.Errorf("acl: cluster_token is required for nodes to forward requests")
+	}
+	sum := sha256.Sum256([]byte(a.ClusterToken))
+	a.clusterToken = sum[:]
+
+	a.byName = make(map[string]*User, len(a.Users))
+	for _, u := range a.Users {
+		if u.Name == "" {
+			return nil, fmt.Errorf("acl: user without a name")
+		}
+		if a.byName[u.Name] != nil {
+			return nil, fmt.Errorf("acl: duplicate user %q", u.Name)
+		}
+		if err := u.compile(); err != nil {
+			return nil, fmt.Errorf("acl: user %q: %w", u.Name, err)
+		}
+		a.byName[u.Name] = u
+	}
+	return a, nil
+}
+
+func (u *User) compile() error {
+	var err error
+	if u.Password != "" {
+		if u.password, err = hashSecret(u.Password); err != nil {
+			return err
+		}
+	}
+	for _, t := range u.Tokens {
+		h, err := hashSecret(t)
+		if err != nil {
+			return err
+		}
+		u.tokens = append(u.tokens, h)
+	}
+
+	u.commands = make(map[protocol.CommandType]bool)
+	for _, name := range u.Commands {
+		switch name {
+		case "@all", "@read", "@write":
+			for _, c := range protocol.Commands() {
+				if name == "@all" || c.ReadOnly() == (name == "@read") {
+					u.commands[c] = true
+				}
+			}
+		default:
+			c, ok := protocol.CommandByName(name)
+			if !ok {
+				return fmt.Errorf("unknown command %q", name)
+			}
+			u.commands[c] = true
+			// The HTTP, gRPC and memcached front-ends read with getmeta.
+			if c == protocol.CmdGet {
+				u.commands[protocol.CmdGetMeta] = true
+			}
+		}
+	}
+	return nil
+}
+
+// hashSecret returns the SHA-256 of a plain secret, or decodes a
+// "sha256:<hex>" one.
+func hashSecret(secret string) ([]byte, error) {
+	if hexSum, ok := strings.CutPrefix(secret, "sha256:"); ok {
+		sum, err := hex.DecodeString(hexSum)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("malformed sha256 secret")
+		}
+		return sum, nil
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:], nil
+}
+
+func matches(hash []byte, secret string) bool {
+	sum := sha256.Sum256([]byte(secret))
+	return hash != nil && subtle.ConstantTimeCompare(hash, sum[:]) == 1
+}
+
+// Authenticate checks a user name and password, or a token when name is
+// empty, and returns the matching user.
+func (a *ACL) Authenticate(name, secret string) (*User, bool) {
+	if name != "" {
+		u := a.byName[name]
+		if u == nil || !matches(u.password, secret) {
+			return nil, false
+		}
+		return u, true
+	}
+
+	for _, u := range a.Users {
+		for _, t := range u.tokens {
+			if matches(t, secret) {
+				return u, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// IsClusterToken reports whether secret is the token nodes use with each other.
+func (a *ACL) IsClusterToken(secret string) bool {
+	return matches(a.clusterToken, secret)
+}
+
+// User returns the named user, or nil. An empty name means DefaultUser.
+func (a *ACL) User(name string) *User {
+	if name == "" {
+		name = DefaultUser
+	}
+	return a.byName[name]
+}
+
+// Can reports whether u may run cmd on key. Commands that take no key
+// (ping, keys, auth, stats, info) only need the command to be allowed; CmdKeys results
+// are filtered with CanAccess instead.
+func (u *User) Can(cmd protocol.CommandType, key string) bool {
+	if !u.commands[cmd] {
+		return false
+	}
+	switch cmd {
+	case protocol.CmdPing, protocol.CmdKeys, protocol.CmdAuth, protocol.CmdStats, protocol.CmdInfo:
+		return true
+	}
+	return u.CanAccess(key)
+}
+
+// CanUse reports whether u may use the namespace ns.
+func (u *User) CanUse(ns string) bool {
+	if u.Namespaces == nil {
+		return ns == ""
+	}
+	for _, name := range u.Namespaces {
+		if name == "*" || name == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccess reports whether key falls under one of u's key prefixes.
+func (u *User) CanAccess(key string) bool {
+	for _, prefix := range u.Keys {
+		if prefix == "*" || strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
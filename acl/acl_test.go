@@ -0,0 +1,131 @@
+package acl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+func sha(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func testACL(t *testing.T) *ACL {
+	t.Helper()
+	a, err := Parse([]byte(`{
+		"cluster_token": "cluster-secret",
+		"users": [
+			{"name": "admin", "password": "` + sha("hunter2") + `", "commands": ["@all"], "keys": ["*"]},
+			{"name": "tenant-a", "tokens": ["token-a"], "commands": ["@read", "set"], "keys": ["a:", "shared:"]},
+			{"name": "tenant-b", "tokens": ["token-b"], "commands": ["@all"], "keys": ["*"], "namespaces": ["b", ""]},
+			{"name": "ops", "commands": ["stats"], "namespaces": ["*"]},
+			{"name": "default", "commands": ["ping", "get"], "keys": ["public:"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := testACL(t)
+
+	if u, ok := a.Authenticate("admin", "hunter2"); !ok || u.Name != "admin" {
+		t.Errorf("expected admin to log in with a hashed password, got %v %v", u, ok)
+	}
+	if _, ok := a.Authenticate("admin", "hunter3"); ok {
+		t.Error("expected a wrong password to fail")
+	}
+	if _, ok := a.Authenticate("nobody", "hunter2"); ok {
+		t.Error("expected an unknown user to fail")
+	}
+	if u, ok := a.Authenticate("", "token-a"); !ok || u.Name != "tenant-a" {
+		t.Errorf("expected token-a to log in as tenant-a, got %v %v", u, ok)
+	}
+	if _, ok := a.Authenticate("tenant-a", "token-a"); ok {
+		t.Error("a token is not a password")
+	}
+	if _, ok := a.Authenticate("default", ""); ok {
+		t.Error("a user without a password must not log in by name")
+	}
+
+	if !a.IsClusterToken("cluster-secret") || a.IsClusterToken("token-a") {
+		t.Error("cluster token check is wrong")
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	a := testACL(t)
+	tenant := a.User("tenant-a")
+
+	tests := []struct {
+		user *User
+		cmd  protocol.CommandType
+		key  string
+		want bool
+	}{
+		{a.User("admin"), protocol.CmdDelete, "anything", true},
+		{a.User("admin"), protocol.CmdKeys, "", true},
+		{tenant, protocol.CmdGet, "a:1", true},
+		{tenant, protocol.CmdHGetAll, "shared:h", true},
+		{tenant, protocol.CmdSet, "a:1", true},
+		{tenant, protocol.CmdSet, "b:1", false},    // Outside its prefixes
+		{tenant, protocol.CmdDelete, "a:1", false}, // Not a listed command
+		{tenant, protocol.CmdKeys, "", true},
+		{tenant, protocol.CmdSet, "", false},
+		{a.User(""), protocol.CmdGet, "public:x", true},
+		{a.User(""), protocol.CmdGetMeta, "public:x", true}, // get covers it
+		{a.User(""), protocol.CmdSet, "public:x", false},
+	}
+	for _, tt := range tests {
+		if got := tt.user.Can(tt.cmd, tt.key); got != tt.want {
+			t.Errorf("%s %s %q: got %v, want %v", tt.user.Name, tt.cmd, tt.key, got, tt.want)
+		}
+	}
+
+	if tenant.CanAccess("b:1") || !tenant.CanAccess("a:1") {
+		t.Error("CanAccess does not follow the key prefixes")
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	a := testACL(t)
+
+	tests := []struct {
+		user string
+		ns   string
+		want bool
+	}{
+		{"tenant-a", "", true}, // No namespaces: the default one only
+		{"tenant-a", "b", false},
+		{"tenant-b", "b", true},
+		{"tenant-b", "", true},
+		{"tenant-b", "c", false},
+		{"ops", "c", true},
+	}
+	for _, tt := range tests {
+		if got := a.User(tt.user).CanUse(tt.ns); got != tt.want {
+			t.Errorf("%s in %q: got %v, want %v", tt.user, tt.ns, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, config := range []string{
+		`{"cluster_token": "t", "users": [{"name": "x", "commands": ["fly"]}]}`,
+		`{"cluster_token": "t", "users": [{"name": "x"}, {"name": "x"}]}`,
+		`{"cluster_token": "t", "users": [{"commands": ["get"]}]}`,
+		`{"cluster_token": "t", "users": [{"name": "x", "password": "sha256:nothex"}]}`,
+		`{"cluster_token": "sha256:abcd"}`,
+		`{"users": [{"name": "x", "commands": ["get"]}]}`,
+		`not json`,
+	} {
+		if _, err := Parse([]byte(config)); err == nil {
+			t.Errorf("expected an error for %s", config)
+		}
+	}
+}
@@ -1,10 +1,18 @@
 package cache
 
 import (
+	"errors"
+	"log/slog"
+	"strconv"
 	"sync"
 	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/hlc"
 )
 
+// ErrNotInteger is returned by Incr when the stored value is not a decimal integer.
+var ErrNotInteger = errors.New("value is not an integer or out of range")
+
 // -------- Core Data Structures --------
 
 // Item represents a single cached value with expiration support.
@@ -14,6 +22,30 @@ type Item struct {
 	value     []byte
 	createdAt time.Time
 	ttl       time.Duration
+	version   uint64
+	// stamp orders writes across nodes (see replica.go).
+	stamp hlc.Timestamp
+	// flags is an opaque client value stored next to the data (memcached flags).
+	flags uint32
+
+	// kind says which of the fields below holds the value.
+	kind Kind
+	hash map[string][]byte
+	list [][]byte
+	set  map[string]struct{}
+	zset *sortedSet
+}
+
+// Metadata describes a cached item without exposing the Item itself.
+// TTL is the time left before the item expires; 0 means it never expires.
+// Stamp is the item's write stamp (see replica.go).
+type Metadata struct {
+	CreatedAt time.Time
+	TTL       time.Duration
+	Version   uint64
+	Stamp     hlc.Timestamp
+	Size      int
+	Flags     uint32
 }
 
 // Cache is an in-memory key-value store with TTL-based expiration.
@@ -22,6 +54,16 @@ type Cache struct {
 	mu sync.RWMutex
 	// YOUR CODE HERE
 	kv map[string]Item
+	// tombstones holds the stamps of deleted keys (see replica.go).
+	tombstones map[string]hlc.Timestamp
+	// version is bumped on every write so each stored Item gets a unique,
+	// increasing version number.
+	version uint64
+
+	// Quota, recency and stats of this namespace (see namespace.go).
+	keyspace
+
+	logger *slog.Logger
 }
 
 // -------- Constructor --------
@@ -29,10 +71,13 @@ type Cache struct {
 // NewCache creates a new Cache and starts a background goroutine
 // that periodically evicts expired items (garbage collection).
 // Accept a cleanup interval (e.g. every 5s) and launch a goroutine with a ticker.
-func NewCache(cleanupInterval time.Duration) *Cache {
+// The goroutine also sweeps every namespace created from the cache.
+func NewCache(cleanupInterval time.Duration, opts ...Option) *Cache {
 	// YOUR CODE HERE
-	c := Cache{
-		kv: make(map[string]Item),
+	c := newKeyspace(nil, "")
+	c.logger = slog.Default()
+	for _, opt := range opts {
+		opt(c)
 	}
 	ticker := time.NewTicker(cleanupInterval)
 	go func() {
@@ -40,10 +85,13 @@ func NewCache(cleanupInterval time.Duration) *Cache {
 			select {
 			case <-ticker.C:
 				c.evictExpired()
+				for _, ns := range c.children() {
+					ns.evictExpired()
+				}
 			}
 		}
 	}()
-	return &c
+	return c
 }
 
 // -------- Core Operations --------
@@ -52,47 +100,283 @@ func NewCache(cleanupInterval time.Duration) *Cache {
 //
 //	Lock the mutex, create an Item, store it in the map.
 //
-// If ttl == 0, the item never expires.
+// If ttl == 0, the item never expires. Like Redis SET, it replaces
+// whatever the key held, collections included.
 func (c *Cache) Set(key string, value []byte, ttl time.Duration) {
 	// YOUR CODE HERE
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	now := time.Now()
-	i := Item{
+
+	c.set(key, value, ttl, 0)
+}
+
+// SetWithFlags is like Set but also stores an opaque flags value with the item.
+func (c *Cache) SetWithFlags(key string, value []byte, ttl time.Duration, flags uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, value, ttl, flags)
+}
+
+// set stores a new Item for key. The caller must hold c.mu for writing.
+func (c *Cache) set(key string, value []byte, ttl time.Duration, flags uint32) {
+	c.version++
+	c.store(key, Item{
 		value:     value,
-		createdAt: now,
+		createdAt: time.Now(),
 		ttl:       ttl,
-	}
-	c.kv[key] = i
+		version:   c.version,
+		stamp:     c.NextStamp(),
+		flags:     flags,
+	})
 }
 
 // Get retrieves a value by key.
 // RLock the mutex, check if the key exists, check if it's expired.
 // Return the value and true if found & valid, nil and false otherwise.
-func (c *Cache) Get(key string) ([]byte, bool) {
+// Keys holding a hash, list, set or sorted set return ErrWrongType.
+func (c *Cache) Get(key string) ([]byte, bool, error) {
 	// YOUR CODE HERE
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	item, ok := c.kv[key]
+	if !ok || item.isExpired() {
+		c.countLookup(false)
+		return nil, false, nil
+	}
+
+	c.countLookup(true)
+	if item.kind != KindString {
+		return nil, false, ErrWrongType
+	}
+	c.touch(key)
+	return item.value, true, nil
+}
+
+// GetWithMetadata is like Get but also returns the item's metadata.
+// Collections return ErrWrongType, along with their metadata so that TTL
+// and the like still work: Size counts the bytes of all elements.
+func (c *Cache) GetWithMetadata(key string) ([]byte, Metadata, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, ok := c.kv[key]
+	c.countLookup(ok && !item.isExpired())
+	if !ok || item.isExpired() {
+		return nil, Metadata{}, false, nil
+	}
+
+	c.touch(key)
+	if item.kind != KindString {
+		return nil, item.metadata(), true, ErrWrongType
+	}
+	return item.value, item.metadata(), true, nil
+}
+
+// GetAndSet atomically stores value under key and returns the previous value.
+// The boolean reports whether a non-expired previous value existed. A key
+// holding a collection is left alone and ErrWrongType returned.
+func (c *Cache) GetAndSet(key string, value []byte, ttl time.Duration) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old, ok := c.kv[key]
+	ok = ok && !old.isExpired()
+	if ok && old.kind != KindString {
+		return nil, false, ErrWrongType
+	}
+	c.set(key, value, ttl, 0)
 	if !ok {
-		return nil, false
+		return nil, false, nil
 	}
 
+	return old.value, true, nil
+}
+
+// GetAndDelete atomically removes key and returns the value it held.
+// Only one of several concurrent callers can observe ok=true for the same item,
+// which makes it suitable for consuming one-time tokens. A key holding a
+// collection is left alone and ErrWrongType returned.
+func (c *Cache) GetAndDelete(key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.kv[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !item.isExpired() && item.kind != KindString {
+		return nil, false, ErrWrongType
+	}
+
+	c.remove(key)
+	c.bury(key, c.NextStamp())
 	if item.isExpired() {
-		return nil, false
+		return nil, false, nil
 	}
+	c.deletes.Add(1)
 
-	return item.value, true
+	return item.value, true, nil
 }
 
 // Delete removes a key from the cache.
 // Lock the mutex, delete the key from the map.
-func (c *Cache) Delete(key string) {
+// It reports whether a non-expired item was removed.
+func (c *Cache) Delete(key string) bool {
 	// YOUR CODE HERE
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	delete(c.kv, key)
+	item, ok := c.kv[key]
+	if !ok {
+		return false
+	}
+	c.remove(key)
+	c.bury(key, c.NextStamp())
+	if item.isExpired() {
+		return false
+	}
+	c.deletes.Add(1)
+	return true
+}
+
+// -------- Conditional Updates --------
+
+// exists reports whether key holds a non-expired item.
+// The caller must hold c.mu.
+func (c *Cache) exists(key string) bool {
+	item, ok := c.kv[key]
+	return ok && !item.isExpired()
+}
+
+// Add stores the value only if the key does not exist yet (SET NX).
+// It reports whether the value was stored.
+func (c *Cache) Add(key string, value []byte, ttl time.Duration, flags uint32) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.exists(key) {
+		return false
+	}
+	c.set(key, value, ttl, flags)
+	return true
+}
+
+// Replace stores the value only if the key already exists (SET XX).
+// It reports whether the value was stored.
+func (c *Cache) Replace(key string, value []byte, ttl time.Duration, flags uint32) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.exists(key) {
+		return false
+	}
+	c.set(key, value, ttl, flags)
+	return true
+}
+
+// CompareAndSwap stores the value only if the item's current version equals
+// version (memcached CAS). found reports whether the key exists at all.
+// A key holding a collection returns ErrWrongType.
+func (c *Cache) CompareAndSwap(key string, value []byte, ttl time.Duration, flags uint32, version uint64) (stored, found bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.kv[key]
+	if !ok || item.isExpired() {
+		return false, false, nil
+	}
+	if item.kind != KindString {
+		return false, true, ErrWrongType
+	}
+	if item.version != version {
+		return false, true, nil
+	}
+
+	c.set(key, value, ttl, flags)
+	return true, true, nil
+}
+
+// Incr adds delta to the decimal integer stored at key and returns the result.
+// A missing key counts as 0. The item keeps its remaining TTL.
+func (c *Cache) Incr(key string, delta int64) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var n int64
+	var ttl time.Duration
+	item, ok := c.kv[key]
+	if ok && !item.isExpired() {
+		if item.kind != KindString {
+			return 0, ErrWrongType
+		}
+		v, err := strconv.ParseInt(string(item.value), 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+		n = v
+		ttl = item.metadata().TTL
+	}
+
+	sum := n + delta
+	if (delta > 0 && sum < n) || (delta < 0 && sum > n) {
+		return 0, ErrNotInteger
+	}
+
+	c.set(key, []byte(strconv.FormatInt(sum, 10)), ttl, item.flags)
+	return sum, nil
+}
+
+// IncrExisting is Incr with memcached semantics: the key must already exist
+// (ok is false otherwise) and decrementing stops at 0 instead of going negative.
+func (c *Cache) IncrExisting(key string, delta int64) (n int64, ok bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.kv[key]
+	if !ok || item.isExpired() {
+		return 0, false, nil
+	}
+	if item.kind != KindString {
+		return 0, true, ErrWrongType
+	}
+
+	cur, err := strconv.ParseInt(string(item.value), 10, 64)
+	if err != nil || cur < 0 {
+		return 0, true, ErrNotInteger
+	}
+
+	n = cur + delta
+	if delta > 0 && n < cur {
+		return 0, true, ErrNotInteger
+	}
+	if n < 0 {
+		n = 0
+	}
+
+	c.set(key, []byte(strconv.FormatInt(n, 10)), item.metadata().TTL, item.flags)
+	return n, true, nil
+}
+
+// Expire changes the TTL of an existing key, counting from now.
+// A ttl of 0 removes the expiration. It reports whether the key exists.
+func (c *Cache) Expire(key string, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.kv[key]
+	if !ok || item.isExpired() {
+		return false
+	}
+
+	// The TTL is measured from createdAt, which we keep for metadata.
+	if ttl != 0 {
+		ttl += time.Since(item.createdAt)
+	}
+	item.ttl = ttl
+	item.stamp = c.NextStamp()
+	c.kv[key] = item
+	return true
 }
 
 // Keys returns all non-expired keys currently in the cache.
@@ -139,6 +423,23 @@ func (item *Item) isExpired() bool {
 	return time.Now().After(item.createdAt.Add(item.ttl))
 }
 
+// metadata builds the Metadata view of an item.
+func (item *Item) metadata() Metadata {
+	var remaining time.Duration
+	if item.ttl != 0 {
+		remaining = time.Until(item.createdAt.Add(item.ttl))
+	}
+
+	return Metadata{
+		CreatedAt: item.createdAt,
+		TTL:       remaining,
+		Version:   item.version,
+		Stamp:     item.stamp,
+		Size:      item.size(),
+		Flags:     item.flags,
+	}
+}
+
 // evictExpired iterates all items and removes any that are expired.
 // Lock the mutex, range over the map, delete expired entries.
 func (c *Cache) evictExpired() {
@@ -146,9 +447,18 @@ func (c *Cache) evictExpired() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	n := 0
 	for k, v := range c.kv {
 		if v.isExpired() {
-			delete(c.kv, k)
+			c.remove(k)
+			n++
 		}
 	}
+	if n > 0 {
+		c.expirations.Add(uint64(n))
+		c.logger.Debug("removed expired keys", "namespace", c.name, "count", n)
+	}
+	if n := c.collectTombstones(); n > 0 {
+		c.logger.Debug("dropped tombstones past their grace period", "namespace", c.name, "count", n)
+	}
 }
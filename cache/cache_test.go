@@ -1,10 +1,14 @@
 package cache
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/hlc"
 )
 
 // TODO: Write tests for each of the following scenarios.
@@ -17,7 +21,7 @@ func TestSetAndGet(t *testing.T) {
 	c.Set("name", []byte("jin"), 0)
 
 	// 3. Get it back
-	val, ok := c.Get("name")
+	val, ok, _ := c.Get("name")
 
 	// 4. Verify it was found
 	if !ok {
@@ -34,7 +38,7 @@ func TestGetMiss(t *testing.T) {
 	c := NewCache(1 * time.Second)
 
 	// Try to get a key that was never set
-	_, ok := c.Get("doesnotexist")
+	_, ok, _ := c.Get("doesnotexist")
 
 	// It should return false
 	if ok {
@@ -50,7 +54,7 @@ func TestDelete(t *testing.T) {
 	c.Delete("temp")
 
 	// Verify it's gone
-	_, ok := c.Get("temp")
+	_, ok, _ := c.Get("temp")
 	if ok {
 		t.Fatal("expected key 'temp' to be deleted, but it still exists")
 	}
@@ -64,7 +68,7 @@ func TestTTLExpiration(t *testing.T) {
 
 	time.Sleep(1 * time.Second)
 
-	_, ok := c.Get("name")
+	_, ok, _ := c.Get("name")
 
 	if ok {
 		t.Fatal("expected ok=false for passing ttl, got ture")
@@ -118,3 +122,550 @@ func TestConcurrentAccess(t *testing.T) {
 
 	// If we reach here without a panic or race detector complaint, the test passes.
 }
+
+func TestGetWithMetadata(t *testing.T) {
+	c := NewCache(1 * time.Second)
+
+	c.Set("name", []byte("jin"), 10*time.Second)
+	_, first, _, _ := c.GetWithMetadata("name")
+
+	c.Set("name", []byte("bichong"), 10*time.Second)
+	val, meta, ok, _ := c.GetWithMetadata("name")
+	if !ok {
+		t.Fatal("expected key 'name' to exist, but got ok=false")
+	}
+	if string(val) != "bichong" {
+		t.Fatalf("expected value 'bichong', got '%s'", string(val))
+	}
+
+	// Every write should produce a newer version.
+	if meta.Version <= first.Version {
+		t.Fatalf("expected version to grow, got %d then %d", first.Version, meta.Version)
+	}
+	if meta.Size != len("bichong") {
+		t.Fatalf("expected size %d, got %d", len("bichong"), meta.Size)
+	}
+	if meta.TTL <= 0 || meta.TTL > 10*time.Second {
+		t.Fatalf("expected remaining TTL in (0, 10s], got %v", meta.TTL)
+	}
+}
+
+func TestGetAndSet(t *testing.T) {
+	c := NewCache(1 * time.Second)
+
+	// No previous value: the new one is still stored.
+	if _, ok, _ := c.GetAndSet("counter", []byte("1"), 0); ok {
+		t.Fatal("expected ok=false when there is no previous value")
+	}
+
+	old, ok, _ := c.GetAndSet("counter", []byte("2"), 0)
+	if !ok || string(old) != "1" {
+		t.Fatalf("expected previous value '1', got '%s' (ok=%v)", string(old), ok)
+	}
+
+	val, _, _ := c.Get("counter")
+	if string(val) != "2" {
+		t.Fatalf("expected value '2', got '%s'", string(val))
+	}
+}
+
+func TestGetAndDeleteOnce(t *testing.T) {
+	c := NewCache(1 * time.Second)
+	c.Set("token", []byte("secret"), 0)
+
+	// Many goroutines race to consume the same token; exactly one may win.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	winners := 0
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok, _ := c.GetAndDelete("token"); ok {
+				mu.Lock()
+				winners++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if winners != 1 {
+		t.Fatalf("expected exactly one consumer, got %d", winners)
+	}
+}
+
+func TestHash(t *testing.T) {
+	c := NewCache(1 * time.Second)
+
+	c.HSet("user:1", "name", []byte("jin"))
+	c.HSet("user:1", "city", []byte("tokyo"))
+
+	// Updating one field should leave the others alone.
+	created, _ := c.HSet("user:1", "city", []byte("osaka"))
+	if created {
+		t.Fatal("expected existing field 'city' to be updated, not created")
+	}
+
+	val, ok, err := c.HGet("user:1", "city")
+	if err != nil || !ok || string(val) != "osaka" {
+		t.Fatalf("expected 'osaka', got '%s' (ok=%v, err=%v)", string(val), ok, err)
+	}
+
+	all, _ := c.HGetAll("user:1")
+	if len(all) != 2 || string(all["name"]) != "jin" {
+		t.Fatalf("unexpected HGetAll result: %v", all)
+	}
+
+	// Removing the last field removes the key.
+	c.HDel("user:1", "name", "city")
+	if _, ok := c.Type("user:1"); ok {
+		t.Fatal("expected empty hash to be removed")
+	}
+}
+
+func TestList(t *testing.T) {
+	c := NewCache(1 * time.Second)
+
+	n, _ := c.LPush("queue", []byte("a"), []byte("b"), []byte("c"))
+	if n != 3 {
+		t.Fatalf("expected length 3, got %d", n)
+	}
+
+	// LPUSH a b c leaves the list as [c b a].
+	vals, _ := c.LRange("queue", 0, -1)
+	got := ""
+	for _, v := range vals {
+		got += string(v)
+	}
+	if got != "cba" {
+		t.Fatalf("expected 'cba', got '%s'", got)
+	}
+
+	last, ok, _ := c.RPop("queue")
+	if !ok || string(last) != "a" {
+		t.Fatalf("expected RPop to return 'a', got '%s'", string(last))
+	}
+
+	vals, _ = c.LRange("queue", -1, 100)
+	if len(vals) != 1 || string(vals[0]) != "b" {
+		t.Fatalf("expected ['b'], got %q", vals)
+	}
+}
+
+func TestSet(t *testing.T) {
+	c := NewCache(1 * time.Second)
+
+	added, _ := c.SAdd("tags", "go", "cache", "go")
+	if added != 2 {
+		t.Fatalf("expected 2 new members, got %d", added)
+	}
+
+	ok, _ := c.SIsMember("tags", "cache")
+	if !ok {
+		t.Fatal("expected 'cache' to be a member")
+	}
+
+	members, _ := c.SMembers("tags")
+	if len(members) != 2 || members[0] != "cache" || members[1] != "go" {
+		t.Fatalf("expected [cache go], got %v", members)
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	c := NewCache(1 * time.Second)
+
+	c.Set("plain", []byte("value"), 0)
+	c.LPush("list", []byte("x"))
+
+	if _, err := c.HSet("plain", "f", []byte("v")); !errors.Is(err, ErrWrongType) {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+	if _, err := c.SAdd("list", "m"); !errors.Is(err, ErrWrongType) {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+
+	// Nor do string commands work on collections, which they leave alone.
+	if _, _, err := c.Get("list"); !errors.Is(err, ErrWrongType) {
+		t.Fatalf("Get: expected ErrWrongType, got %v", err)
+	}
+	if _, meta, _, err := c.GetWithMetadata("list"); !errors.Is(err, ErrWrongType) || meta.Size != 1 {
+		t.Fatalf("GetWithMetadata: expected ErrWrongType and the list's metadata, got %v, %+v", err, meta)
+	}
+	if _, _, err := c.GetAndSet("list", []byte("v"), 0); !errors.Is(err, ErrWrongType) {
+		t.Fatalf("GetAndSet: expected ErrWrongType, got %v", err)
+	}
+	if _, _, err := c.GetAndDelete("list"); !errors.Is(err, ErrWrongType) {
+		t.Fatalf("GetAndDelete: expected ErrWrongType, got %v", err)
+	}
+	if _, _, err := c.CompareAndSwap("list", []byte("v"), 0, 0, 0); !errors.Is(err, ErrWrongType) {
+		t.Fatalf("CompareAndSwap: expected ErrWrongType, got %v", err)
+	}
+	// Replicas do not see collections as strings either, nor replace them.
+	if _, meta, ok, err := c.Peek("list"); !errors.Is(err, ErrWrongType) || !ok || meta.Size != 1 {
+		t.Fatalf("Peek: expected ErrWrongType and the list's metadata, got %v, %v, %+v", err, ok, meta)
+	}
+	if stored, err := c.SetStamped("list", []byte("v"), 0, 0, c.NextStamp()); stored || !errors.Is(err, ErrWrongType) {
+		t.Fatalf("SetStamped: expected ErrWrongType, got %v, %v", stored, err)
+	}
+	if kind, _ := c.Type("list"); kind != KindList {
+		t.Fatalf("the list became a %v", kind)
+	}
+
+	// Set replaces anything, as in Redis.
+	c.Set("list", []byte("v"), 0)
+	if v, _, err := c.Get("list"); err != nil || string(v) != "v" {
+		t.Fatalf("Set over a list: got %q, %v", v, err)
+	}
+}
+
+func TestSortedSet(t *testing.T) {
+	c := NewCache(1 * time.Second)
+
+	c.ZAdd("leaderboard",
+		ZMember{Member: "alice", Score: 30},
+		ZMember{Member: "bob", Score: 10},
+		ZMember{Member: "carol", Score: 20},
+	)
+
+	// Updating a score moves the member instead of adding it twice.
+	added, _ := c.ZAdd("leaderboard", ZMember{Member: "bob", Score: 40})
+	if added != 0 {
+		t.Fatalf("expected 0 new members, got %d", added)
+	}
+
+	score, _ := c.ZIncrBy("leaderboard", "carol", 5)
+	if score != 25 {
+		t.Fatalf("expected score 25, got %v", score)
+	}
+
+	top, _ := c.ZRange("leaderboard", -2, -1)
+	if len(top) != 2 || top[0].Member != "alice" || top[1].Member != "bob" {
+		t.Fatalf("expected [alice bob], got %v", top)
+	}
+
+	mid, _ := c.ZRangeByScore("leaderboard", 25, 30)
+	if len(mid) != 2 || mid[0].Member != "carol" || mid[1].Member != "alice" {
+		t.Fatalf("expected [carol alice], got %v", mid)
+	}
+
+	c.ZRem("leaderboard", "alice")
+	if n, _ := c.ZCard("leaderboard"); n != 2 {
+		t.Fatalf("expected 2 members, got %d", n)
+	}
+}
+
+func TestSortedSetNaN(t *testing.T) {
+	c := NewCache(1 * time.Second)
+	c.ZAdd("z", ZMember{Member: "a", Score: math.Inf(1)})
+
+	if _, err := c.ZAdd("z", ZMember{Member: "b", Score: 1}, ZMember{Member: "c", Score: math.NaN()}); !errors.Is(err, ErrNotANumber) {
+		t.Fatalf("ZAdd NaN: expected ErrNotANumber, got %v", err)
+	}
+	if _, err := c.ZIncrBy("z", "a", math.Inf(-1)); !errors.Is(err, ErrNotANumber) {
+		t.Fatalf("ZIncrBy +Inf-Inf: expected ErrNotANumber, got %v", err)
+	}
+	if _, err := c.ZIncrBy("z", "a", math.NaN()); !errors.Is(err, ErrNotANumber) {
+		t.Fatalf("ZIncrBy NaN: expected ErrNotANumber, got %v", err)
+	}
+
+	// Nothing was added or changed.
+	members, _ := c.ZRange("z", 0, -1)
+	if len(members) != 1 || !math.IsInf(members[0].Score, 1) {
+		t.Fatalf("expected only a at +Inf, got %v", members)
+	}
+}
+
+func TestSortedSetRanks(t *testing.T) {
+	// Insert and remove many members, then check every rank against a
+	// plain sorted slice to make sure the skiplist spans stay correct.
+	c := NewCache(1 * time.Second)
+	for i := 0; i < 1000; i++ {
+		c.ZAdd("z", ZMember{Member: fmt.Sprintf("m%04d", i), Score: float64((i * 7919) % 1000)})
+	}
+	for i := 0; i < 1000; i += 3 {
+		c.ZRem("z", fmt.Sprintf("m%04d", i))
+	}
+
+	all, _ := c.ZRange("z", 0, -1)
+	for i := 1; i < len(all); i++ {
+		if all[i-1].Score > all[i].Score {
+			t.Fatalf("members out of order at rank %d: %v then %v", i, all[i-1], all[i])
+		}
+	}
+
+	for _, rank := range []int{0, 1, 17, 333, len(all) - 1} {
+		got, _ := c.ZRange("z", rank, rank)
+		if len(got) != 1 || got[0] != all[rank] {
+			t.Fatalf("rank %d: expected %v, got %v", rank, all[rank], got)
+		}
+	}
+}
+
+func TestNamespacesAreIsolated(t *testing.T) {
+	c := NewCache(1 * time.Second)
+	a := c.Namespace("a")
+
+	c.Set("k", []byte("default"), 0)
+	a.Set("k", []byte("a"), 0)
+
+	if v, _, _ := c.Get("k"); string(v) != "default" {
+		t.Errorf("default namespace: got %q", v)
+	}
+	if v, _, _ := c.Namespace("a").Get("k"); string(v) != "a" {
+		t.Errorf("namespace a: got %q", v)
+	}
+	if _, ok, _ := c.Namespace("b").Get("k"); ok {
+		t.Error("namespace b should not see k")
+	}
+	if a.Namespace("") != c {
+		t.Error("the empty name should lead back to the default namespace")
+	}
+
+	got := fmt.Sprint(c.Namespaces())
+	if got != "[ a b]" {
+		t.Errorf("expected namespaces [ a b], got %s", got)
+	}
+}
+
+func TestMaxNamespaces(t *testing.T) {
+	c := NewCache(1*time.Second, WithMaxNamespaces(2))
+	c.SetQuotas(map[string]Quota{"configured": {MaxItems: 10}})
+
+	for _, name := range []string{"", "a", "b", "a", "configured"} {
+		if _, err := c.OpenNamespace(name); err != nil {
+			t.Errorf("namespace %q: %v", name, err)
+		}
+	}
+	if _, err := c.OpenNamespace("c"); !errors.Is(err, ErrTooManyNamespaces) {
+		t.Errorf("expected ErrTooManyNamespaces past the limit, got %v", err)
+	}
+	if got := fmt.Sprint(c.Namespaces()); got != "[ a b configured]" {
+		t.Errorf("expected namespaces [ a b configured], got %s", got)
+	}
+}
+
+func TestQuotaEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewCache(1 * time.Second)
+	ns := c.Namespace("small")
+	ns.SetQuota(Quota{MaxItems: 2})
+
+	ns.Set("a", []byte("1"), 0)
+	ns.Set("b", []byte("2"), 0)
+	ns.Get("a") // b is now the least recently used
+	ns.Set("c", []byte("3"), 0)
+
+	if _, ok, _ := ns.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok, _ := ns.Get(k); !ok {
+			t.Errorf("expected %s to stay", k)
+		}
+	}
+
+	// The default namespace has no quota.
+	for i := range 10 {
+		c.Set(fmt.Sprint(i), nil, 0)
+	}
+	if c.Count() != 10 {
+		t.Errorf("default namespace lost keys: %d left", c.Count())
+	}
+}
+
+func TestQuotaBytes(t *testing.T) {
+	c := NewCache(1 * time.Second)
+	c.SetQuotas(map[string]Quota{DefaultQuota: {MaxBytes: 20}})
+	ns := c.Namespace("tenant")
+
+	ns.Set("k1", []byte("12345678"), 0)  // 10 bytes with the key
+	ns.HSet("h", "f", []byte("1234567")) // 9 bytes
+	if s := ns.Stats(); s.Bytes != 19 || s.Items != 2 {
+		t.Fatalf("expected 19 bytes in 2 items, got %+v", s)
+	}
+
+	ns.Set("k2", []byte("xx"), 0)
+	if _, ok, _ := ns.Get("k1"); ok {
+		t.Error("expected k1 to be evicted to make room")
+	}
+
+	ns.Set("big", make([]byte, 100), 0)
+	if _, ok, _ := ns.Get("big"); ok {
+		t.Error("an item larger than the quota should not stay")
+	}
+	if s := ns.Stats(); s.Bytes > 20 {
+		t.Errorf("namespace is over its quota: %+v", s)
+	}
+}
+
+func TestNamespaceStats(t *testing.T) {
+	c := NewCache(10 * time.Millisecond)
+	ns := c.Namespace("stats")
+
+	ns.Set("a", []byte("1"), 0)
+	ns.Set("tmp", []byte("1"), 20*time.Millisecond)
+	ns.Get("a")
+	ns.Get("missing")
+	ns.HGet("missing", "f")
+	ns.Delete("a")
+
+	time.Sleep(50 * time.Millisecond)
+
+	s := ns.Stats()
+	if s.Hits != 1 || s.Misses != 2 || s.Sets != 2 || s.Deletes != 1 ||
+		s.Expirations != 1 || s.Items != 0 || s.Bytes != 0 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	if c.Stats().Hits != 0 {
+		t.Error("lookups in a namespace should not count towards the default one")
+	}
+}
+
+// setStamped calls SetStamped, failing the test if it returns an error.
+func setStamped(t *testing.T, c *Cache, key, value string, flags uint32, stamp hlc.Timestamp) bool {
+	t.Helper()
+	stored, err := c.SetStamped(key, []byte(value), 0, flags, stamp)
+	if err != nil {
+		t.Fatalf("SetStamped(%q): %v", key, err)
+	}
+	return stored
+}
+
+func deleteStamped(t *testing.T, c *Cache, key string, stamp hlc.Timestamp) bool {
+	t.Helper()
+	existed, err := c.DeleteStamped(key, stamp)
+	if err != nil {
+		t.Fatalf("DeleteStamped(%q): %v", key, err)
+	}
+	return existed
+}
+
+func TestSetStampedKeepsNewest(t *testing.T) {
+	c := NewCache(time.Second)
+	ns := c.Namespace("replicas")
+
+	ns.Set("k", []byte("local"), 0)
+	_, meta, _, _ := ns.Peek("k")
+	if meta.Stamp == 0 {
+		t.Fatal("a write should be stamped")
+	}
+
+	if setStamped(t, ns, "k", "older", 0, meta.Stamp-1) {
+		t.Error("an older write replaced a newer one")
+	}
+	if !setStamped(t, ns, "k", "newer", 7, meta.Stamp+1) {
+		t.Error("a newer write was not stored")
+	}
+	val, meta2, _, _ := ns.Peek("k")
+	if string(val) != "newer" || meta2.Stamp != meta.Stamp+1 || meta2.Flags != 7 {
+		t.Errorf("got %q %+v after the newer write", val, meta2)
+	}
+
+	// Equal stamps pick the same winner whatever the order.
+	setStamped(t, ns, "tie", "a", 0, 100)
+	setStamped(t, ns, "tie", "b", 0, 100)
+	setStamped(t, ns, "tie", "a", 0, 100)
+	if val, _, _ := ns.Get("tie"); string(val) != "b" {
+		t.Errorf("tie went to %q, want b", val)
+	}
+
+	if deleteStamped(t, ns, "k", meta.Stamp) {
+		t.Error("a delete older than the write removed it")
+	}
+	if !deleteStamped(t, ns, "k", meta2.Stamp+1) {
+		t.Error("a newer delete did not remove the key")
+	}
+
+	if got := ns.Stamps(); len(got) != 2 || got["tie"] != 100 || got["k"] != meta2.Stamp+1 {
+		t.Errorf("Stamps() = %v, want the tie and the tombstone of k", got)
+	}
+	if s := ns.Stats(); s.Hits != 1 || s.Misses != 0 {
+		t.Errorf("Peek should not count lookups: %+v", s)
+	}
+}
+
+func TestStampsIncrease(t *testing.T) {
+	c := NewCache(time.Second)
+	last := hlc.Timestamp(0)
+	for i := range 1000 {
+		stamp := c.Namespace(fmt.Sprint(i % 3)).NextStamp()
+		if stamp <= last {
+			t.Fatalf("stamp %d after %d", stamp, last)
+		}
+		last = stamp
+	}
+}
+
+func TestTombstones(t *testing.T) {
+	c := NewCache(10*time.Millisecond, WithTombstoneGrace(50*time.Millisecond))
+
+	c.Set("k", []byte("v"), 0)
+	_, meta, _, _ := c.Peek("k")
+	if !c.Delete("k") {
+		t.Fatal("Delete did not find the key")
+	}
+	_, dead, ok, _ := c.Peek("k")
+	if ok || dead.Stamp <= meta.Stamp {
+		t.Fatalf("Peek after Delete = %v, %+v; want a tombstone newer than the write", ok, dead)
+	}
+
+	// A replica replaying the write it missed the delete for cannot bring
+	// the key back; a later write can.
+	if setStamped(t, c, "k", "v", 0, meta.Stamp) {
+		t.Error("a write older than the delete was stored")
+	}
+	if c.Count() != 0 || c.Tombstones() != 1 {
+		t.Errorf("%d items and %d tombstones, want 0 and 1", c.Count(), c.Tombstones())
+	}
+	if !setStamped(t, c, "k", "w", 0, c.NextStamp()) || c.Tombstones() != 0 {
+		t.Error("a write after the delete should replace the tombstone")
+	}
+
+	// Deletes of keys never seen still leave a tombstone, for the write
+	// that may arrive late.
+	deleteStamped(t, c, "late", c.NextStamp())
+	if setStamped(t, c, "late", "v", 0, meta.Stamp) {
+		t.Error("a late write older than the delete was stored")
+	}
+
+	// The janitor drops tombstones after the grace period.
+	time.Sleep(100 * time.Millisecond)
+	if n := c.Tombstones(); n != 0 {
+		t.Errorf("%d tombstones left after the grace period", n)
+	}
+}
+
+func TestStampsFollowOtherNodes(t *testing.T) {
+	c := NewCache(time.Second)
+
+	// A write from a node whose clock is ahead: writes made here after
+	// seeing it are stamped after it.
+	remote := hlc.New(time.Now().Add(30*time.Second), 0)
+	setStamped(t, c.Namespace("other"), "k", "remote", 0, remote)
+	c.Set("k", []byte("local"), 0)
+	if _, meta, _, _ := c.Peek("k"); meta.Stamp <= remote {
+		t.Errorf("local write stamped %v, before the remote %v it followed", meta.Stamp, remote)
+	}
+}
+
+func TestStampsTooFarAhead(t *testing.T) {
+	c := NewCache(time.Second)
+	c.Set("k", []byte("local"), 0)
+
+	// A node whose clock is broken cannot plant writes that would win
+	// over every other one until the wall clock caught up.
+	far := hlc.New(time.Now().Add(hlc.MaxDrift+time.Minute), 0)
+	if _, err := c.SetStamped("k", []byte("remote"), 0, 0, far); !errors.Is(err, ErrStampAhead) {
+		t.Errorf("SetStamped beyond MaxDrift: %v, want ErrStampAhead", err)
+	}
+	if _, err := c.DeleteStamped("k", far); !errors.Is(err, ErrStampAhead) {
+		t.Errorf("DeleteStamped beyond MaxDrift: %v, want ErrStampAhead", err)
+	}
+	if val, _, _ := c.Get("k"); string(val) != "local" {
+		t.Errorf("got %q, want the local write", val)
+	}
+	if stamp := c.NextStamp(); stamp >= far {
+		t.Errorf("clock moved to %v, past the refused stamp %v", stamp, far)
+	}
+}
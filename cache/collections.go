@@ -0,0 +1,338 @@
+package cache
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// -------- Typed Values --------
+// Besides plain []byte strings, a key can hold a hash, a list, a set or a
+// sorted set (see sortedset.go).
+// Collections are updated in place under the cache lock, so changing one
+// field of a hash no longer needs a read-modify-write of the whole value.
+
+// Kind identifies what type of value a key holds.
+type Kind byte
+
+const (
+	KindString Kind = iota // Plain []byte value (the zero value)
+	KindHash               // Field → value map
+	KindList               // Ordered list of values
+	KindSet                // Unordered set of unique members
+	KindZSet               // Members ordered by score
+)
+
+// ErrWrongType is returned when an operation is used against a key holding
+// a different kind of value, e.g. HGET on a list.
+var ErrWrongType = errors.New("operation against a key holding the wrong kind of value")
+
+// collection returns a copy of the item stored at key, checking that it
+// holds the wanted kind. If the key is missing (or expired) and create is
+// true, a new empty collection is returned; otherwise nil is returned.
+// The caller must hold c.mu and write the item back with putCollection.
+func (c *Cache) collection(key string, kind Kind, create bool) (*Item, error) {
+	item, ok := c.kv[key]
+	if !create {
+		c.countLookup(ok && !item.isExpired())
+	}
+	if ok && !item.isExpired() {
+		if item.kind != kind {
+			return nil, ErrWrongType
+		}
+		c.touch(key)
+		return &item, nil
+	}
+
+	if !create {
+		return nil, nil
+	}
+
+	item = Item{kind: kind, createdAt: time.Now()}
+	switch kind {
+	case KindHash:
+		item.hash = make(map[string][]byte)
+	case KindSet:
+		item.set = make(map[string]struct{})
+	case KindZSet:
+		item.zset = newSortedSet()
+	}
+	return &item, nil
+}
+
+// putCollection stores a modified collection back, deleting the key once
+// the collection becomes empty. The caller must hold c.mu for writing.
+// The whole collection is measured again for the namespace's byte count.
+func (c *Cache) putCollection(key string, item *Item) {
+	if item.length() == 0 {
+		c.remove(key)
+		return
+	}
+
+	c.version++
+	item.version = c.version
+	item.stamp = c.NextStamp()
+	c.store(key, *item)
+}
+
+// Type reports the kind of value stored at key.
+func (c *Cache) Type(key string) (Kind, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, ok := c.kv[key]
+	if !ok || item.isExpired() {
+		return 0, false
+	}
+	return item.kind, true
+}
+
+// -------- Hashes --------
+
+// HSet sets field in the hash stored at key, creating the hash if needed.
+// It reports whether the field is new.
+func (c *Cache) HSet(key, field string, value []byte) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, err := c.collection(key, KindHash, true)
+	if err != nil {
+		return false, err
+	}
+
+	_, exists := item.hash[field]
+	item.hash[field] = value
+	c.putCollection(key, item)
+	return !exists, nil
+}
+
+// HGet returns the value of field in the hash stored at key.
+func (c *Cache) HGet(key, field string) ([]byte, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, err := c.collection(key, KindHash, false)
+	if err != nil || item == nil {
+		return nil, false, err
+	}
+
+	val, ok := item.hash[field]
+	return val, ok, nil
+}
+
+// HDel removes fields from the hash stored at key and returns how many existed.
+func (c *Cache) HDel(key string, fields ...string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, err := c.collection(key, KindHash, false)
+	if err != nil || item == nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, f := range fields {
+		if _, ok := item.hash[f]; ok {
+			delete(item.hash, f)
+			removed++
+		}
+	}
+	c.putCollection(key, item)
+	return removed, nil
+}
+
+// HGetAll returns a copy of every field and value in the hash stored at key.
+func (c *Cache) HGetAll(key string) (map[string][]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, err := c.collection(key, KindHash, false)
+	if err != nil || item == nil {
+		return map[string][]byte{}, err
+	}
+
+	all := make(map[string][]byte, len(item.hash))
+	for f, v := range item.hash {
+		all[f] = v
+	}
+	return all, nil
+}
+
+// -------- Lists --------
+
+// LPush prepends values to the list stored at key, one after another, so the
+// last value ends up at the head. It returns the new length of the list.
+func (c *Cache) LPush(key string, values ...[]byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, err := c.collection(key, KindList, true)
+	if err != nil {
+		return 0, err
+	}
+
+	list := make([][]byte, 0, len(values)+len(item.list))
+	for i := len(values) - 1; i >= 0; i-- {
+		list = append(list, values[i])
+	}
+	item.list = append(list, item.list...)
+	c.putCollection(key, item)
+	return len(item.list), nil
+}
+
+// RPop removes and returns the last element of the list stored at key.
+func (c *Cache) RPop(key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, err := c.collection(key, KindList, false)
+	if err != nil || item == nil {
+		return nil, false, err
+	}
+
+	last := item.list[len(item.list)-1]
+	item.list = item.list[:len(item.list)-1]
+	c.putCollection(key, item)
+	return last, true, nil
+}
+
+// LRange returns the elements between start and stop (both inclusive).
+// Negative indexes count from the end of the list, -1 being the last element.
+func (c *Cache) LRange(key string, start, stop int) ([][]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, err := c.collection(key, KindList, false)
+	if err != nil || item == nil {
+		return [][]byte{}, err
+	}
+
+	start, stop, ok := clampRange(start, stop, len(item.list))
+	if !ok {
+		return [][]byte{}, nil
+	}
+
+	out := make([][]byte, stop-start+1)
+	copy(out, item.list[start:stop+1])
+	return out, nil
+}
+
+// clampRange resolves negative indexes against n and clamps the range to
+// [0, n-1]. It returns false if the resulting range is empty.
+func clampRange(start, stop, n int) (int, int, bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+// -------- Sets --------
+
+// SAdd adds members to the set stored at key and returns how many were new.
+func (c *Cache) SAdd(key string, members ...string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, err := c.collection(key, KindSet, true)
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, m := range members {
+		if _, ok := item.set[m]; !ok {
+			item.set[m] = struct{}{}
+			added++
+		}
+	}
+	c.putCollection(key, item)
+	return added, nil
+}
+
+// SIsMember reports whether member belongs to the set stored at key.
+func (c *Cache) SIsMember(key, member string) (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, err := c.collection(key, KindSet, false)
+	if err != nil || item == nil {
+		return false, err
+	}
+
+	_, ok := item.set[member]
+	return ok, nil
+}
+
+// SMembers returns all members of the set stored at key, sorted.
+func (c *Cache) SMembers(key string) ([]string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, err := c.collection(key, KindSet, false)
+	if err != nil || item == nil {
+		return []string{}, err
+	}
+
+	members := make([]string, 0, len(item.set))
+	for m := range item.set {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// -------- Sizing --------
+
+// length returns the number of elements in a collection item.
+func (item *Item) length() int {
+	switch item.kind {
+	case KindHash:
+		return len(item.hash)
+	case KindList:
+		return len(item.list)
+	case KindSet:
+		return len(item.set)
+	case KindZSet:
+		return item.zset.sl.length
+	default:
+		return len(item.value)
+	}
+}
+
+// size returns the number of payload bytes held by an item.
+func (item *Item) size() int {
+	n := 0
+	switch item.kind {
+	case KindHash:
+		for f, v := range item.hash {
+			n += len(f) + len(v)
+		}
+	case KindList:
+		for _, v := range item.list {
+			n += len(v)
+		}
+	case KindSet:
+		for m := range item.set {
+			n += len(m)
+		}
+	case KindZSet:
+		for m := range item.zset.scores {
+			n += len(m) + 8
+		}
+	default:
+		n = len(item.value)
+	}
+	return n
+}
@@ -0,0 +1,325 @@
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/hlc"
+)
+
+// -------- Namespaces --------
+// A Cache can hold isolated keyspaces, one per namespace, so teams sharing
+// a cluster cannot see or overwrite each other's keys:
+//
+//	c := cache.NewCache(5 * time.Second)
+//	orders := c.Namespace("orders")
+//	orders.Set("k", v, 0) // c.Get("k") does not see it
+//
+// Each namespace is a *Cache of its own with its own lock, so a busy tenant
+// does not hold up the others. The cache returned by NewCache is the
+// default namespace "" and owns the rest; its janitor sweeps them all.
+//
+// Namespaces are created by whoever names them first. WithMaxNamespaces
+// caps how many OpenNamespace creates, so clients cannot grow the cache
+// (and the per-namespace metrics) without bound; namespaces given a quota
+// by SetQuotas are always allowed.
+
+// ErrTooManyNamespaces is returned by OpenNamespace when the cache already
+// holds as many namespaces as WithMaxNamespaces allows.
+var ErrTooManyNamespaces = errors.New("too many namespaces")
+
+// -------- Quotas & Eviction --------
+// A namespace can be given a Quota on its number of items and on its size
+// in bytes (keys plus payload, see Item.size). A write that takes it over
+// the quota evicts the least recently used keys until it fits again, so an
+// item larger than the whole quota does not stay.
+//
+// Recency is kept in a list guarded by lruMu instead of c.mu, so reads can
+// move a key to the front while holding only the read lock.
+// Lock order is c.mu, then lruMu.
+
+// DefaultQuota is the SetQuotas entry used by namespaces without their own.
+const DefaultQuota = "*"
+
+// Quota limits the size of a namespace. Zero fields mean no limit.
+type Quota struct {
+	MaxItems int `json:"max_items"`
+	MaxBytes int `json:"max_bytes"`
+}
+
+// Stats describes a namespace. Items and Bytes include expired items the
+// janitor has not removed yet.
+type Stats struct {
+	Items       int
+	Bytes       int
+	Hits        uint64 // Lookups that found the key
+	Misses      uint64 // Lookups that did not
+	Sets        uint64 // Writes, including changes to collections
+	Deletes     uint64 // Keys removed by Delete or GetAndDelete
+	Evictions   uint64 // Keys dropped to stay within the quota
+	Expirations uint64 // Expired keys removed by the janitor
+	Quota       Quota
+}
+
+// lruEntry is what the recency list holds for each key.
+type lruEntry struct {
+	key  string
+	size int
+}
+
+// keyspace holds the per-namespace bookkeeping embedded in every Cache.
+type keyspace struct {
+	name  string
+	quota Quota // Guarded by c.mu
+
+	lruMu   sync.Mutex
+	lru     *list.List // Front is the most recently used
+	entries map[string]*list.Element
+	bytes   int
+
+	hits, misses, sets, deletes, evictions, expirations atomic.Uint64
+
+	// root is the cache returned by NewCache. Only the root uses the
+	// fields below.
+	root      *Cache
+	nsMu      sync.Mutex
+	spaces    map[string]*Cache
+	quotas    map[string]Quota
+	maxSpaces int // Zero means no limit
+
+	// Write stamps and deleted keys, see replica.go.
+	clock          *hlc.Clock
+	tombstoneGrace time.Duration
+}
+
+func newKeyspace(root *Cache, name string) *Cache {
+	c := &Cache{kv: make(map[string]Item), tombstones: make(map[string]hlc.Timestamp)}
+	c.name = name
+	c.lru = list.New()
+	c.entries = make(map[string]*list.Element)
+	c.root = root
+	if root != nil {
+		c.logger = root.logger
+	} else {
+		c.root = c
+		c.spaces = make(map[string]*Cache)
+		c.quotas = make(map[string]Quota)
+		c.clock = hlc.NewClock()
+		c.tombstoneGrace = DefaultTombstoneGrace
+	}
+	return c
+}
+
+// Namespace returns the keyspace for name, creating it on first use
+// whatever the limit. The empty name is the default namespace.
+func (c *Cache) Namespace(name string) *Cache {
+	ns, _ := c.namespace(name, false)
+	return ns
+}
+
+// OpenNamespace returns the keyspace for name like Namespace, but fails
+// with ErrTooManyNamespaces rather than create one past the limit.
+func (c *Cache) OpenNamespace(name string) (*Cache, error) {
+	return c.namespace(name, true)
+}
+
+func (c *Cache) namespace(name string, limit bool) (*Cache, error) {
+	root := c.root
+	if name == "" {
+		return root, nil
+	}
+
+	root.nsMu.Lock()
+	defer root.nsMu.Unlock()
+
+	ns, ok := root.spaces[name]
+	if !ok {
+		_, configured := root.quotas[name]
+		if limit && !configured && root.maxSpaces > 0 && len(root.spaces) >= root.maxSpaces {
+			return nil, ErrTooManyNamespaces
+		}
+		ns = newKeyspace(root, name)
+		ns.quota = root.quotaFor(name)
+		root.spaces[name] = ns
+	}
+	return ns, nil
+}
+
+// Namespaces returns the names of all namespaces in use, sorted, starting
+// with the default namespace "".
+func (c *Cache) Namespaces() []string {
+	root := c.root
+	root.nsMu.Lock()
+	defer root.nsMu.Unlock()
+
+	names := []string{""}
+	for name := range root.spaces {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// children returns every namespace but the default one.
+func (c *Cache) children() []*Cache {
+	c.nsMu.Lock()
+	defer c.nsMu.Unlock()
+
+	spaces := make([]*Cache, 0, len(c.spaces))
+	for _, ns := range c.spaces {
+		spaces = append(spaces, ns)
+	}
+	return spaces
+}
+
+// SetQuotas sets the quota of each named namespace, "" being the default
+// one. The DefaultQuota entry applies to every other namespace.
+// Namespaces already over their new quota are trimmed straight away.
+func (c *Cache) SetQuotas(quotas map[string]Quota) {
+	root := c.root
+	root.nsMu.Lock()
+	root.quotas = make(map[string]Quota, len(quotas))
+	for name, q := range quotas {
+		root.quotas[name] = q
+	}
+	spaces := map[string]*Cache{"": root}
+	for name, ns := range root.spaces {
+		spaces[name] = ns
+	}
+	root.nsMu.Unlock()
+
+	for name, ns := range spaces {
+		q, ok := quotas[name]
+		if !ok && name != "" {
+			q = quotas[DefaultQuota]
+		}
+		ns.SetQuota(q)
+	}
+}
+
+// quotaFor returns the configured quota of a new namespace.
+// The caller must hold c.nsMu.
+func (c *Cache) quotaFor(name string) Quota {
+	if q, ok := c.quotas[name]; ok {
+		return q
+	}
+	return c.quotas[DefaultQuota]
+}
+
+// SetQuota changes the quota of this namespace, evicting keys if needed.
+func (c *Cache) SetQuota(q Quota) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.quota = q
+	c.enforceQuota()
+}
+
+// Stats returns the current statistics of this namespace.
+func (c *Cache) Stats() Stats {
+	c.mu.RLock()
+	quota := c.quota
+	c.mu.RUnlock()
+
+	c.lruMu.Lock()
+	items, bytes := c.lru.Len(), c.bytes
+	c.lruMu.Unlock()
+
+	return Stats{
+		Items:       items,
+		Bytes:       bytes,
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Sets:        c.sets.Load(),
+		Deletes:     c.deletes.Load(),
+		Evictions:   c.evictions.Load(),
+		Expirations: c.expirations.Load(),
+		Quota:       quota,
+	}
+}
+
+// -------- Bookkeeping --------
+// Every change to c.kv goes through store or remove so the byte count and
+// the recency list stay in step with the map.
+
+// store puts item under key and evicts other keys if that breaks the
+// quota. The caller must hold c.mu for writing.
+func (c *Cache) store(key string, item Item) {
+	c.kv[key] = item
+	delete(c.tombstones, key)
+	c.sets.Add(1)
+	size := len(key) + item.size()
+
+	c.lruMu.Lock()
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*lruEntry)
+		c.bytes += size - entry.size
+		entry.size = size
+		c.lru.MoveToFront(e)
+	} else {
+		c.entries[key] = c.lru.PushFront(&lruEntry{key: key, size: size})
+		c.bytes += size
+	}
+	c.lruMu.Unlock()
+
+	c.enforceQuota()
+}
+
+// remove deletes key. The caller must hold c.mu for writing.
+func (c *Cache) remove(key string) {
+	delete(c.kv, key)
+
+	c.lruMu.Lock()
+	defer c.lruMu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.unlink(e)
+	}
+}
+
+// unlink drops a recency list element. The caller must hold c.lruMu.
+func (c *Cache) unlink(e *list.Element) {
+	entry := c.lru.Remove(e).(*lruEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size
+}
+
+// enforceQuota evicts least recently used keys until the namespace fits
+// its quota. The caller must hold c.mu for writing.
+func (c *Cache) enforceQuota() {
+	c.lruMu.Lock()
+	defer c.lruMu.Unlock()
+
+	for c.lru.Len() > 0 &&
+		((c.quota.MaxItems > 0 && c.lru.Len() > c.quota.MaxItems) ||
+			(c.quota.MaxBytes > 0 && c.bytes > c.quota.MaxBytes)) {
+		e := c.lru.Back()
+		key := e.Value.(*lruEntry).key
+		delete(c.kv, key)
+		c.unlink(e)
+		c.evictions.Add(1)
+		c.logger.Debug("evicted key to stay within quota", "namespace", c.name, "key", key)
+	}
+}
+
+// touch marks key as just used. The caller must hold c.mu, for reading
+// is enough.
+func (c *Cache) touch(key string) {
+	c.lruMu.Lock()
+	defer c.lruMu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+	}
+}
+
+// countLookup records a hit or a miss.
+func (c *Cache) countLookup(found bool) {
+	if found {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+}
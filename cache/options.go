@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"log/slog"
+	"time"
+)
+
+// -------- Cache Options --------
+// Optional settings passed to NewCache, e.g.
+//
+//	cache.NewCache(5*time.Second, cache.WithLogger(logger))
+
+// Option configures a Cache.
+type Option func(*Cache)
+
+// WithLogger sets where the cache logs expirations and evictions, all at
+// debug level. The default is slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(c *Cache) { c.logger = l }
+}
+
+// WithTombstoneGrace sets how long deleted keys are remembered so that
+// replicas do not bring them back (see replica.go). The default is
+// DefaultTombstoneGrace.
+func WithTombstoneGrace(d time.Duration) Option {
+	return func(c *Cache) { c.tombstoneGrace = d }
+}
+
+// WithMaxNamespaces caps how many namespaces OpenNamespace creates besides
+// the default one (see namespace.go). Zero, the default, means no limit.
+func WithMaxNamespaces(n int) Option {
+	return func(c *Cache) { c.maxSpaces = n }
+}
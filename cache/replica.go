@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/hlc"
+)
+
+// -------- Replication Support --------
+// When a key is kept on several nodes, the copies have to agree on which
+// write came last. Every write is stamped with the cache's hybrid logical
+// clock (see the hlc package). A replica receiving a write from another
+// node stores it with SetStamped, which keeps whichever of the two writes
+// has the higher stamp (last write wins), and moves its clock past the
+// stamp so its own later writes win over it. Equal stamps fall back to
+// comparing the values, so every replica picks the same winner. Stamps
+// more than hlc.MaxDrift ahead of the local clock are refused: stored,
+// such a write would win over every later one until the wall clock
+// caught up with it.
+//
+// -------- Tombstones --------
+// A delete cannot simply forget the key: a replica that missed it still
+// has the old value, and read repair or anti-entropy would copy it back.
+// So deletes leave a tombstone holding the delete's stamp. Tombstones are
+// invisible to reads, but writes older than one are refused and they take
+// part in anti-entropy like any item. After the grace period
+// (WithTombstoneGrace) the janitor drops them; it must be longer than a
+// replica can miss writes for.
+
+// DefaultTombstoneGrace is how long tombstones are kept unless
+// WithTombstoneGrace says otherwise.
+const DefaultTombstoneGrace = 2 * time.Hour
+
+// NextStamp returns a stamp for a write happening now.
+func (c *Cache) NextStamp() hlc.Timestamp {
+	return c.root.clock.Now()
+}
+
+// ErrStampAhead is returned for a replicated write stamped more than
+// hlc.MaxDrift ahead of the local clock.
+var ErrStampAhead = errors.New("timestamp too far ahead of the local clock")
+
+// Observe moves the cache's clock past a stamp seen on another node. It
+// reports false, leaving the clock alone, if the stamp is more than
+// hlc.MaxDrift ahead of it.
+func (c *Cache) Observe(stamp hlc.Timestamp) bool {
+	return stamp == 0 || c.root.clock.Update(stamp)
+}
+
+// Newer reports whether a write (stamp, value) wins over one already
+// stored as (oldStamp, oldValue).
+func Newer(stamp hlc.Timestamp, value []byte, oldStamp hlc.Timestamp, oldValue []byte) bool {
+	if stamp != oldStamp {
+		return stamp > oldStamp
+	}
+	return bytes.Compare(value, oldValue) > 0
+}
+
+// SetStamped stores value under key with the given stamp, unless the key
+// holds a newer write or was deleted later. It reports whether the value
+// was stored. A key holding a collection is left alone and ErrWrongType
+// returned: collections are not replicated, so the replicas would no
+// longer agree on what the key holds. A stamp too far ahead is refused
+// with ErrStampAhead.
+func (c *Cache) SetStamped(key string, value []byte, ttl time.Duration, flags uint32, stamp hlc.Timestamp) (bool, error) {
+	if !c.Observe(stamp) {
+		return false, ErrStampAhead
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old, ok := c.kv[key]; ok && !old.isExpired() {
+		if old.kind != KindString {
+			return false, ErrWrongType
+		}
+		if !Newer(stamp, value, old.stamp, old.value) {
+			return false, nil
+		}
+	}
+	if deleted, ok := c.tombstones[key]; ok && stamp <= deleted {
+		return false, nil
+	}
+	c.version++
+	c.store(key, Item{
+		value:     value,
+		createdAt: time.Now(),
+		ttl:       ttl,
+		version:   c.version,
+		stamp:     stamp,
+		flags:     flags,
+	})
+	return true, nil
+}
+
+// DeleteStamped removes key unless it was written after stamp, leaving a
+// tombstone. It reports whether a non-expired item was removed. A stamp
+// too far ahead is refused with ErrStampAhead.
+func (c *Cache) DeleteStamped(key string, stamp hlc.Timestamp) (bool, error) {
+	if !c.Observe(stamp) {
+		return false, ErrStampAhead
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.kv[key]
+	if ok && item.stamp > stamp {
+		return false, nil
+	}
+	c.bury(key, stamp)
+	if !ok {
+		return false, nil
+	}
+	c.remove(key)
+	if item.isExpired() {
+		return false, nil
+	}
+	c.deletes.Add(1)
+	return true, nil
+}
+
+// bury records that key was deleted at stamp, unless it already holds a
+// later tombstone. The caller must hold c.mu for writing.
+func (c *Cache) bury(key string, stamp hlc.Timestamp) {
+	if deleted, ok := c.tombstones[key]; !ok || stamp > deleted {
+		c.tombstones[key] = stamp
+	}
+}
+
+// Peek returns a string value and its metadata like GetWithMetadata, but
+// is not counted as a hit or miss: nodes use it to compare their copies.
+// For a deleted key whose tombstone is still kept, ok is false and
+// Metadata.Stamp is the delete's stamp. A key holding a collection
+// returns ErrWrongType with its metadata, as GetWithMetadata does.
+func (c *Cache) Peek(key string) ([]byte, Metadata, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, ok := c.kv[key]
+	if !ok || item.isExpired() {
+		return nil, Metadata{Stamp: c.tombstones[key]}, false, nil
+	}
+	if item.kind != KindString {
+		return nil, item.metadata(), true, ErrWrongType
+	}
+	return item.value, item.metadata(), true, nil
+}
+
+// Stamps returns the stamp of every string item and tombstone in the
+// namespace.
+func (c *Cache) Stamps() map[string]hlc.Timestamp {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stamps := make(map[string]hlc.Timestamp, len(c.kv)+len(c.tombstones))
+	for k, stamp := range c.tombstones {
+		stamps[k] = stamp
+	}
+	for k, item := range c.kv {
+		if !item.isExpired() && item.kind == KindString {
+			stamps[k] = item.stamp
+		}
+	}
+	return stamps
+}
+
+// Tombstones returns how many deleted keys the namespace still remembers.
+func (c *Cache) Tombstones() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.tombstones)
+}
+
+// collectTombstones drops the tombstones older than the grace period.
+// The caller must hold c.mu for writing.
+func (c *Cache) collectTombstones() int {
+	cutoff := time.Now().Add(-c.root.tombstoneGrace)
+	n := 0
+	for k, stamp := range c.tombstones {
+		if stamp.Wall().Before(cutoff) {
+			delete(c.tombstones, k)
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,178 @@
+package cache
+
+import "math/rand/v2"
+
+// -------- Skiplist --------
+// A skiplist keeps sorted-set members ordered by (score, member).
+// Each node has a random number of levels; higher levels skip further ahead,
+// which gives O(log n) insert, delete and lookup on average.
+// Every forward link also records its span (how many nodes it jumps over),
+// so we can find the node at a given rank without walking the whole list.
+
+const (
+	skiplistMaxLevel = 32
+	skiplistP        = 0.25 // Probability of promoting a node one level up
+)
+
+type skipLevel struct {
+	forward *skipNode
+	span    int
+}
+
+type skipNode struct {
+	member   string
+	score    float64
+	backward *skipNode
+	levels   []skipLevel
+}
+
+type skiplist struct {
+	head   *skipNode
+	tail   *skipNode
+	length int
+	level  int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head:  &skipNode{levels: make([]skipLevel, skiplistMaxLevel)},
+		level: 1,
+	}
+}
+
+// less reports whether node n sorts before (score, member).
+func (n *skipNode) less(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+// insert adds a node. The caller must make sure the member is not already present.
+func (sl *skiplist) insert(score float64, member string) {
+	var update [skiplistMaxLevel]*skipNode
+	var rank [skiplistMaxLevel]int
+
+	// Walk down from the top level, remembering the last node visited on each
+	// level and how many nodes we passed to get there.
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.levels[i].forward != nil && x.levels[i].forward.less(score, member) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = sl.head
+			update[i].levels[i].span = sl.length
+		}
+		sl.level = level
+	}
+
+	x = &skipNode{member: member, score: score, levels: make([]skipLevel, level)}
+	for i := 0; i < level; i++ {
+		x.levels[i].forward = update[i].levels[i].forward
+		update[i].levels[i].forward = x
+
+		x.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+
+	// Levels above the new node now skip over one more node.
+	for i := level; i < sl.level; i++ {
+		update[i].levels[i].span++
+	}
+
+	if update[0] != sl.head {
+		x.backward = update[0]
+	}
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+}
+
+// delete removes the node with the given score and member, if present.
+func (sl *skiplist) delete(score float64, member string) bool {
+	var update [skiplistMaxLevel]*skipNode
+
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && x.levels[i].forward.less(score, member) {
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.levels[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := 0; i < sl.level; i++ {
+		if update[i].levels[i].forward == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].forward = x.levels[i].forward
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+	for sl.level > 1 && sl.head.levels[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+	return true
+}
+
+// byRank returns the node at the given 0-based rank, or nil.
+func (sl *skiplist) byRank(rank int) *skipNode {
+	if rank < 0 || rank >= sl.length {
+		return nil
+	}
+
+	// Spans count from 1, so we are looking for traversed == rank+1.
+	traversed := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && traversed+x.levels[i].span <= rank+1 {
+			traversed += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+	return nil
+}
+
+// firstAtLeast returns the first node whose score is >= min, or nil.
+func (sl *skiplist) firstAtLeast(min float64) *skipNode {
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && x.levels[i].forward.score < min {
+			x = x.levels[i].forward
+		}
+	}
+	return x.levels[0].forward
+}
@@ -0,0 +1,196 @@
+package cache
+
+import (
+	"errors"
+	"math"
+)
+
+// -------- Sorted Sets --------
+// A sorted set maps unique members to float64 scores and keeps them ordered
+// by score (ties broken by member). The map gives O(1) score lookups; the
+// skiplist gives ordered range queries by rank or by score.
+
+// ErrNotANumber is returned when a score is, or would become, NaN, which
+// has no place in the order.
+var ErrNotANumber = errors.New("resulting score is not a number")
+
+// ZMember is a sorted-set member together with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+type sortedSet struct {
+	scores map[string]float64
+	sl     *skiplist
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{
+		scores: make(map[string]float64),
+		sl:     newSkiplist(),
+	}
+}
+
+// add sets member's score and reports whether the member is new.
+func (z *sortedSet) add(member string, score float64) bool {
+	old, exists := z.scores[member]
+	if exists {
+		if old == score {
+			return false
+		}
+		z.sl.delete(old, member)
+	}
+
+	z.scores[member] = score
+	z.sl.insert(score, member)
+	return !exists
+}
+
+func (z *sortedSet) remove(member string) bool {
+	score, ok := z.scores[member]
+	if !ok {
+		return false
+	}
+
+	delete(z.scores, member)
+	z.sl.delete(score, member)
+	return true
+}
+
+// ZAdd adds members to the sorted set stored at key, updating the scores of
+// members that already exist. It returns how many members were new. A NaN
+// score fails the whole call with ErrNotANumber.
+func (c *Cache) ZAdd(key string, members ...ZMember) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, m := range members {
+		if math.IsNaN(m.Score) {
+			return 0, ErrNotANumber
+		}
+	}
+	item, err := c.collection(key, KindZSet, true)
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, m := range members {
+		if item.zset.add(m.Member, m.Score) {
+			added++
+		}
+	}
+	c.putCollection(key, item)
+	return added, nil
+}
+
+// ZIncrBy adds delta to member's score (starting from 0 if the member is new)
+// and returns the new score, or ErrNotANumber if that would be NaN.
+func (c *Cache) ZIncrBy(key, member string, delta float64) (float64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, err := c.collection(key, KindZSet, true)
+	if err != nil {
+		return 0, err
+	}
+
+	// Adding -Inf to +Inf gives NaN too.
+	score := item.zset.scores[member] + delta
+	if math.IsNaN(score) {
+		return 0, ErrNotANumber
+	}
+	item.zset.add(member, score)
+	c.putCollection(key, item)
+	return score, nil
+}
+
+// ZRem removes members from the sorted set stored at key and returns how many existed.
+func (c *Cache) ZRem(key string, members ...string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, err := c.collection(key, KindZSet, false)
+	if err != nil || item == nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, m := range members {
+		if item.zset.remove(m) {
+			removed++
+		}
+	}
+	c.putCollection(key, item)
+	return removed, nil
+}
+
+// ZCard returns the number of members in the sorted set stored at key.
+func (c *Cache) ZCard(key string) (int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, err := c.collection(key, KindZSet, false)
+	if err != nil || item == nil {
+		return 0, err
+	}
+	return item.zset.sl.length, nil
+}
+
+// ZScore returns the score of member in the sorted set stored at key.
+func (c *Cache) ZScore(key, member string) (float64, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, err := c.collection(key, KindZSet, false)
+	if err != nil || item == nil {
+		return 0, false, err
+	}
+
+	score, ok := item.zset.scores[member]
+	return score, ok, nil
+}
+
+// ZRange returns the members ranked between start and stop (both inclusive),
+// lowest score first. Negative ranks count from the end, -1 being the highest.
+func (c *Cache) ZRange(key string, start, stop int) ([]ZMember, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, err := c.collection(key, KindZSet, false)
+	if err != nil || item == nil {
+		return []ZMember{}, err
+	}
+
+	start, stop, ok := clampRange(start, stop, item.zset.sl.length)
+	if !ok {
+		return []ZMember{}, nil
+	}
+
+	out := make([]ZMember, 0, stop-start+1)
+	x := item.zset.sl.byRank(start)
+	for i := start; i <= stop && x != nil; i++ {
+		out = append(out, ZMember{Member: x.member, Score: x.score})
+		x = x.levels[0].forward
+	}
+	return out, nil
+}
+
+// ZRangeByScore returns the members whose score lies within [min, max],
+// lowest score first.
+func (c *Cache) ZRangeByScore(key string, min, max float64) ([]ZMember, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, err := c.collection(key, KindZSet, false)
+	if err != nil || item == nil {
+		return []ZMember{}, err
+	}
+
+	out := []ZMember{}
+	for x := item.zset.sl.firstAtLeast(min); x != nil && x.score <= max; x = x.levels[0].forward {
+		out = append(out, ZMember{Member: x.member, Score: x.score})
+	}
+	return out, nil
+}
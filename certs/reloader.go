@@ -0,0 +1,176 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// -------- Certificate Reloading --------
+// A Reloader holds a node's certificate, private key and CA bundle, and
+// re-reads them from disk when the files change, so certificates can be
+// rotated without restarting the node.
+//
+// Instead of running a background goroutine, the Reloader checks the files'
+// modification times whenever it is asked for a config, at most once per
+// CheckInterval. If the new files cannot be loaded (e.g. the key was
+// replaced but not yet the certificate), the previous material stays in use.
+
+// DefaultCheckInterval is how often the files are checked for changes.
+const DefaultCheckInterval = 5 * time.Second
+
+// Files names the PEM files a Reloader loads. Cert and Key are needed to
+// serve TLS or to present a client certificate; CA is needed to verify peers.
+// A client that only verifies servers may leave Cert and Key empty.
+type Files struct {
+	Cert string
+	Key  string
+	CA   string
+}
+
+// Reloader serves TLS configs built from files that may change on disk.
+type Reloader struct {
+	files         Files
+	CheckInterval time.Duration
+
+	mu       sync.Mutex
+	checked  time.Time
+	modTimes [3]time.Time
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+}
+
+// NewReloader loads files and returns a Reloader for them.
+func NewReloader(files Files) (*Reloader, error) {
+	if (files.Cert == "") != (files.Key == "") {
+		return nil, errors.New("certs: cert and key must be given together")
+	}
+	if files.Cert == "" && files.CA == "" {
+		return nil, errors.New("certs: no certificate or CA given")
+	}
+
+	r := &Reloader{files: files, CheckInterval: DefaultCheckInterval}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ServerConfig returns a config for accepting TLS connections. With a CA,
+// client certificates are verified when presented; with requireClientCert
+// (mutual TLS) they are mandatory.
+func (r *Reloader) ServerConfig(requireClientCert bool) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			if cert == nil {
+				return nil, errors.New("certs: no server certificate configured")
+			}
+
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    pool,
+			}
+			switch {
+			case requireClientCert:
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			case pool != nil:
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// ClientConfig returns a config for dialing addr. The certificate, if any,
+// is presented to servers that ask for one (mutual TLS). Call it for each
+// new connection so rotated files are picked up.
+func (r *Reloader) ClientConfig(addr string) *tls.Config {
+	cert, pool := r.current()
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if host == "" {
+		host = "localhost"
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: host, RootCAs: pool}
+	if cert != nil {
+		cfg.Certificates = []tls.Certificate{*cert}
+	}
+	return cfg
+}
+
+// current returns the loaded material, reloading it first if the files
+// changed since the last check.
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) >= r.CheckInterval {
+		r.checked = time.Now()
+		if r.modTimes != r.stat() {
+			// Keep serving the old material if the new files are broken.
+			r.loadLocked()
+		}
+	}
+	return r.cert, r.pool
+}
+
+// stat returns the modification times of the configured files.
+func (r *Reloader) stat() [3]time.Time {
+	var times [3]time.Time
+	for i, name := range []string{r.files.Cert, r.files.Key, r.files.CA} {
+		if name == "" {
+			continue
+		}
+		if info, err := os.Stat(name); err == nil {
+			times[i] = info.ModTime()
+		}
+	}
+	return times
+}
+
+func (r *Reloader) load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checked = time.Now()
+	return r.loadLocked()
+}
+
+func (r *Reloader) loadLocked() error {
+	modTimes := r.stat()
+
+	var cert *tls.Certificate
+	if r.files.Cert != "" {
+		c, err := tls.LoadX509KeyPair(r.files.Cert, r.files.Key)
+		if err != nil {
+			return fmt.Errorf("certs: loading key pair: %w", err)
+		}
+		cert = &c
+	}
+
+	var pool *x509.CertPool
+	if r.files.CA != "" {
+		pem, err := os.ReadFile(r.files.CA)
+		if err != nil {
+			return fmt.Errorf("certs: reading CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("certs: no certificates found in %s", r.files.CA)
+		}
+	}
+
+	r.cert, r.pool, r.modTimes = cert, pool, modTimes
+	return nil
+}
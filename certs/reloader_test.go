@@ -0,0 +1,283 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is a throwaway certificate authority for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key for 127.0.0.1, usable by both
+// servers and clients, as nodes use one certificate for both roles.
+func (ca *testCA) issue(t *testing.T, name string, serial int64) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeFiles issues a certificate and writes it, its key and the CA to dir.
+func writeFiles(t *testing.T, dir string, ca *testCA, name string, serial int64) Files {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, name, serial)
+	files := Files{
+		Cert: filepath.Join(dir, name+".pem"),
+		Key:  filepath.Join(dir, name+"-key.pem"),
+		CA:   filepath.Join(dir, "ca.pem"),
+	}
+	writeFile(t, files.Cert, certPEM)
+	writeFile(t, files.Key, keyPEM)
+	writeFile(t, files.CA, ca.pem)
+	return files
+}
+
+// handshake runs a TLS handshake over loopback TCP and returns the
+// server's view of the connection, or the first error from either side.
+func handshake(t *testing.T, serverCfg, clientCfg *tls.Config) (tls.ConnectionState, error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	type result struct {
+		state tls.ConnectionState
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		nc, err := ln.Accept()
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer nc.Close()
+		srv := tls.Server(nc, serverCfg)
+		err = srv.Handshake()
+		if err == nil {
+			// Under TLS 1.3 a rejected client certificate is only noticed
+			// here, after the client already considers the handshake done.
+			srv.Write([]byte{1})
+		}
+		done <- result{srv.ConnectionState(), err}
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), clientCfg)
+	if err == nil {
+		defer conn.Close()
+		_, err = conn.Read(make([]byte, 1))
+	}
+	res := <-done
+	if res.err != nil {
+		return tls.ConnectionState{}, res.err
+	}
+	return res.state, err
+}
+
+func TestTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+
+	server, err := NewReloader(writeFiles(t, dir, ca, "server", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A client that only verifies the server.
+	client, err := NewReloader(Files{CA: filepath.Join(dir, "ca.pem")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := handshake(t, server.ServerConfig(false), client.ClientConfig("127.0.0.1:7000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.PeerCertificates) != 0 {
+		t.Error("client should not have presented a certificate")
+	}
+
+	// A client that does not trust the CA must fail.
+	if _, err := handshake(t, server.ServerConfig(false), &tls.Config{ServerName: "127.0.0.1"}); err == nil {
+		t.Error("expected handshake to fail without the CA")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+
+	server, err := NewReloader(writeFiles(t, dir, ca, "server", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := NewReloader(writeFiles(t, dir, ca, "peer", 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonymous, err := NewReloader(Files{CA: filepath.Join(dir, "ca.pem")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := handshake(t, server.ServerConfig(true), peer.ClientConfig("127.0.0.1:7000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.PeerCertificates) == 0 || state.PeerCertificates[0].Subject.CommonName != "peer" {
+		t.Errorf("expected the peer's certificate, got %v", state.PeerCertificates)
+	}
+
+	if _, err := handshake(t, server.ServerConfig(true), anonymous.ClientConfig("127.0.0.1:7000")); err == nil {
+		t.Error("expected mutual TLS to reject a client without a certificate")
+	}
+
+	// A certificate from another CA is rejected even when mTLS is optional.
+	other := newTestCA(t)
+	strangerDir := t.TempDir()
+	stranger, err := NewReloader(writeFiles(t, strangerDir, other, "stranger", 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := stranger.ClientConfig("127.0.0.1:7000")
+	cfg.RootCAs = anonymous.ClientConfig("").RootCAs
+	if _, err := handshake(t, server.ServerConfig(false), cfg); err == nil {
+		t.Error("expected a certificate from an unknown CA to be rejected")
+	}
+}
+
+func TestReload(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+
+	files := writeFiles(t, dir, ca, "server", 2)
+	server, err := NewReloader(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.CheckInterval = 0
+	client, err := NewReloader(Files{CA: files.CA})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial := func() int64 {
+		t.Helper()
+		cfg := client.ClientConfig("127.0.0.1:7000")
+		var got int64
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			got = cs.PeerCertificates[0].SerialNumber.Int64()
+			return nil
+		}
+		if _, err := handshake(t, server.ServerConfig(false), cfg); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if got := serial(); got != 2 {
+		t.Fatalf("expected serial 2, got %d", got)
+	}
+
+	// Rotate the certificate on disk. Bump the modification time so the
+	// change is seen even on filesystems with coarse timestamps.
+	certPEM, keyPEM := ca.issue(t, "server", 5)
+	writeFile(t, files.Cert, certPEM)
+	writeFile(t, files.Key, keyPEM)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(files.Cert, later, later)
+	os.Chtimes(files.Key, later, later)
+
+	if got := serial(); got != 5 {
+		t.Fatalf("expected the rotated certificate (serial 5), got %d", got)
+	}
+
+	// A broken file keeps the last good certificate in use.
+	writeFile(t, files.Cert, []byte("not a certificate"))
+	later = later.Add(time.Minute)
+	os.Chtimes(files.Cert, later, later)
+	if got := serial(); got != 5 {
+		t.Fatalf("expected the last good certificate (serial 5), got %d", got)
+	}
+}
+
+func TestNewReloaderErrors(t *testing.T) {
+	if _, err := NewReloader(Files{}); err == nil {
+		t.Error("expected an error with no files")
+	}
+	if _, err := NewReloader(Files{Cert: "cert.pem"}); err == nil {
+		t.Error("expected an error for a cert without a key")
+	}
+	if _, err := NewReloader(Files{CA: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Error("expected an error for a missing CA file")
+	}
+}
@@ -0,0 +1,84 @@
+package client
+
+import "time"
+
+// -------- Circuit Breaker --------
+// Each seed node has a breaker that stops the client from dialing a node
+// that keeps failing:
+//
+//	closed    → requests go through; threshold failures in a row open it
+//	open      → the node is skipped until cooldown has passed
+//	half-open → one request is let through as a trial; success closes the
+//	            breaker, failure opens it for another cooldown
+//
+// A trial that ends without telling either way (the caller gave up, the
+// node refused the credentials) is aborted, so the next request gets to
+// run another one.
+//
+// Breakers are only used under the client's lock, so they need none of
+// their own.
+
+// DefaultBreakerThreshold and DefaultBreakerCooldown are the breaker
+// settings unless WithCircuitBreaker says otherwise.
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 10 * time.Second
+)
+
+type breaker struct {
+	threshold int // Failures in a row that open the breaker; 0 never opens it
+	cooldown  time.Duration
+
+	failures int
+	openedAt time.Time
+	trial    bool // A half-open trial is in flight
+	now      func() time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// open reports whether the breaker is open, i.e. the node should not be
+// tried now.
+func (b *breaker) open() bool {
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return false
+	}
+	return b.trial || b.now().Sub(b.openedAt) < b.cooldown
+}
+
+// allow reports whether the node may be tried, starting the half-open
+// trial if the cooldown is over.
+func (b *breaker) allow() bool {
+	if b.open() {
+		return false
+	}
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.trial = true
+	}
+	return true
+}
+
+// success closes the breaker.
+func (b *breaker) success() {
+	b.failures = 0
+	b.trial = false
+}
+
+// failure counts a failure and reports whether it opened the breaker.
+func (b *breaker) failure() bool {
+	b.failures++
+	b.trial = false
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.openedAt = b.now()
+		return true
+	}
+	return false
+}
+
+// abort ends a trial that neither succeeded nor failed. It does nothing
+// if there is none.
+func (b *breaker) abort() {
+	b.trial = false
+}
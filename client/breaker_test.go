@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := newBreaker(3, 10*time.Second)
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if b.failure() {
+			t.Fatalf("opened after %d failures", i+1)
+		}
+	}
+	b.success()
+	b.failure()
+	b.failure()
+	if !b.allow() {
+		t.Fatal("a success should have reset the failure count")
+	}
+	if !b.failure() {
+		t.Fatal("expected the third failure in a row to open the breaker")
+	}
+	if b.allow() {
+		t.Fatal("an open breaker let a request through")
+	}
+
+	// After the cooldown, one trial goes through at a time.
+	now = now.Add(10 * time.Second)
+	if !b.allow() {
+		t.Fatal("expected a half-open trial after the cooldown")
+	}
+	if b.allow() {
+		t.Fatal("a second trial went through while the first was in flight")
+	}
+	b.abort()
+	if !b.allow() {
+		t.Fatal("expected a new trial once the first was aborted")
+	}
+
+	// A failed trial opens the breaker for another cooldown.
+	b.failure()
+	if b.allow() {
+		t.Fatal("a failed trial should reopen the breaker")
+	}
+	now = now.Add(10 * time.Second)
+	if !b.allow() {
+		t.Fatal("expected another trial after the second cooldown")
+	}
+	b.success()
+	if !b.allow() || !b.allow() {
+		t.Fatal("a successful trial should close the breaker")
+	}
+}
+
+func TestBreakerTrialCancelled(t *testing.T) {
+	// A node that accepts connections but never answers the handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	c := NewClient(l.Addr().String(), WithCircuitBreaker(1, time.Millisecond), WithRetry(1, 0, 0))
+	defer c.Close()
+	b := c.nodes[0].breaker
+	b.failure()
+	time.Sleep(time.Millisecond)
+
+	// The trial runs out of time before the node can tell either way.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetContext(ctx, "k"); err == nil {
+		t.Fatal("expected the request to fail")
+	}
+	if b.trial || b.open() {
+		t.Fatal("a cancelled trial left the breaker open")
+	}
+	if !b.allow() {
+		t.Fatal("expected another trial after a cancelled one")
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := newBreaker(0, time.Second)
+	for i := 0; i < 100; i++ {
+		if b.failure() || !b.allow() {
+			t.Fatal("a breaker with threshold 0 must never open")
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := NewClient("localhost:0", WithRetry(5, 10*time.Millisecond, 50*time.Millisecond))
+	for attempt, max := range []time.Duration{10, 20, 40, 50, 50, 50} {
+		max *= time.Millisecond
+		for range 100 {
+			if d := c.backoff(attempt); d <= 0 || d > max {
+				t.Fatalf("backoff(%d) = %v, want within (0, %v]", attempt, d, max)
+			}
+		}
+	}
+}
@@ -1,34 +1,124 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/BiChong-Jin/distributed-cache/certs"
 	"github.com/BiChong-Jin/distributed-cache/protocol"
+	"github.com/BiChong-Jin/distributed-cache/tracing"
 )
 
 // -------- Client SDK --------
 // This is what application code uses to talk to the cache cluster.
 // The client connects to ANY node; that node routes the request to the right place.
 
+// ErrNotFound is returned when the requested key does not exist.
+var ErrNotFound = errors.New("key not found")
+
+// ErrWrongType is returned when a command is used on a key holding a
+// different kind of value, e.g. HGET on a list.
+var ErrWrongType = errors.New("operation against a key holding the wrong kind of value")
+
+// ErrDenied is returned when the node refuses the credentials, or the ACL
+// does not allow the command on the key.
+var ErrDenied = errors.New("access denied")
+
 // Client is a cache client that connects to a cluster node.
+// It keeps one connection open, made on first use, and sends requests over
+// it one at a time. If the node goes away, the client fails over to the
+// other seed nodes (see retry.go).
 type Client struct {
-	Addr string
+	Addr string // The first seed node
+
+	tls       *certs.Reloader
+	auth      *protocol.Request // CmdAuth sent on each new connection, if any
+	namespace string            // Set on every request, see WithNamespace
+	strong    bool              // See WithStrong
+	logger    *slog.Logger
+
+	tracerProvider trace.TracerProvider // See WithTracerProvider
+	tracer         trace.Tracer
+
+	timeout     time.Duration // See WithTimeout
+	dialTimeout time.Duration // See WithDialTimeout
+
+	// Failover and retries, see retry.go and breaker.go.
+	seeds            []string
+	retryAttempts    int
+	retryBackoff     time.Duration
+	retryMaxBackoff  time.Duration
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	busy    chan struct{} // Held while a request uses conn, see lock
+	nodes   []*node
+	current int            // Index in nodes of the node conn is to, or will be tried first
+	conn    *protocol.Conn // Open connection to nodes[current], or nil
+}
+
+// node is a seed node and the state of its circuit breaker.
+type node struct {
+	addr    string
+	logger  *slog.Logger
+	breaker *breaker
 }
 
 // NewClient creates a client that talks to the cache cluster via the given node address.
-func NewClient(addr string) *Client {
-	return &Client{Addr: addr}
+func NewClient(addr string, opts ...Option) *Client {
+	c := &Client{
+		Addr:             addr,
+		logger:           slog.Default(),
+		dialTimeout:      DefaultDialTimeout,
+		retryAttempts:    DefaultRetryAttempts,
+		retryBackoff:     DefaultRetryBackoff,
+		retryMaxBackoff:  DefaultRetryMaxBackoff,
+		breakerThreshold: DefaultBreakerThreshold,
+		breakerCooldown:  DefaultBreakerCooldown,
+		busy:             make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	for _, seed := range append([]string{addr}, c.seeds...) {
+		c.nodes = append(c.nodes, &node{
+			addr:    seed,
+			logger:  c.logger.With("node", seed),
+			breaker: newBreaker(c.breakerThreshold, c.breakerCooldown),
+		})
+	}
+	c.tracer = tracing.Tracer(c.tracerProvider)
+	return c
 }
 
 // Close cleans up any open connections.
 func (c *Client) Close() error {
-	return nil
+	c.lock(context.Background())
+	defer c.unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
 }
 
 // Set stores a key-value pair with the given TTL.
 func (c *Client) Set(key string, value []byte, ttl time.Duration) error {
+	return c.SetContext(context.Background(), key, value, ttl)
+}
+
+// SetContext is Set bounded by ctx.
+func (c *Client) SetContext(ctx context.Context, key string, value []byte, ttl time.Duration) error {
 	req := &protocol.Request{
 		CommandType: protocol.CmdSet,
 		Key:         key,
@@ -36,7 +126,7 @@ func (c *Client) Set(key string, value []byte, ttl time.Duration) error {
 		TTL:         ttl,
 	}
 
-	_, err := c.sendRequest(req)
+	_, err := c.do(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -44,14 +134,87 @@ func (c *Client) Set(key string, value []byte, ttl time.Duration) error {
 	return nil
 }
 
-// Get retrieves a value by key.
+// Get retrieves a value by key. A missing key gives a nil value and no error.
 func (c *Client) Get(key string) ([]byte, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+// GetContext is Get bounded by ctx.
+func (c *Client) GetContext(ctx context.Context, key string) ([]byte, error) {
 	req := &protocol.Request{
 		CommandType: protocol.CmdGet,
 		Key:         key,
 	}
 
-	resp, err := c.sendRequest(req)
+	resp, err := c.do(ctx, req)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Value, nil
+}
+
+// GetWithMetadata retrieves a value together with its metadata.
+// It returns ErrNotFound if the key does not exist.
+func (c *Client) GetWithMetadata(key string) ([]byte, protocol.Metadata, error) {
+	return c.GetWithMetadataContext(context.Background(), key)
+}
+
+// GetWithMetadataContext is GetWithMetadata bounded by ctx.
+func (c *Client) GetWithMetadataContext(ctx context.Context, key string) ([]byte, protocol.Metadata, error) {
+	req := &protocol.Request{
+		CommandType: protocol.CmdGetMeta,
+		Key:         key,
+	}
+
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, protocol.Metadata{}, err
+	}
+
+	return resp.Value, resp.Meta, nil
+}
+
+// GetAndSet atomically stores a value and returns the previous one.
+// It returns ErrNotFound (and still stores the value) if there was no previous value.
+func (c *Client) GetAndSet(key string, value []byte, ttl time.Duration) ([]byte, error) {
+	return c.GetAndSetContext(context.Background(), key, value, ttl)
+}
+
+// GetAndSetContext is GetAndSet bounded by ctx.
+func (c *Client) GetAndSetContext(ctx context.Context, key string, value []byte, ttl time.Duration) ([]byte, error) {
+	req := &protocol.Request{
+		CommandType: protocol.CmdGetSet,
+		Key:         key,
+		Value:       value,
+		TTL:         ttl,
+	}
+
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Value, nil
+}
+
+// GetAndDelete atomically removes a key and returns its value.
+// Only one caller can consume a given value; the others get ErrNotFound.
+func (c *Client) GetAndDelete(key string) ([]byte, error) {
+	return c.GetAndDeleteContext(context.Background(), key)
+}
+
+// GetAndDeleteContext is GetAndDelete bounded by ctx.
+func (c *Client) GetAndDeleteContext(ctx context.Context, key string) ([]byte, error) {
+	req := &protocol.Request{
+		CommandType: protocol.CmdGetDel,
+		Key:         key,
+	}
+
+	resp, err := c.do(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -61,12 +224,17 @@ func (c *Client) Get(key string) ([]byte, error) {
 
 // Delete removes a key.
 func (c *Client) Delete(key string) error {
+	return c.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext is Delete bounded by ctx.
+func (c *Client) DeleteContext(ctx context.Context, key string) error {
 	req := &protocol.Request{
 		CommandType: protocol.CmdDelete,
 		Key:         key,
 	}
 
-	_, err := c.sendRequest(req)
+	_, err := c.do(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -75,11 +243,16 @@ func (c *Client) Delete(key string) error {
 
 // Keys returns all keys in the cluster (queries the connected node).
 func (c *Client) Keys() ([]string, error) {
+	return c.KeysContext(context.Background())
+}
+
+// KeysContext is Keys bounded by ctx.
+func (c *Client) KeysContext(ctx context.Context) ([]string, error) {
 	req := &protocol.Request{
 		CommandType: protocol.CmdKeys,
 	}
 
-	resp, err := c.sendRequest(req)
+	resp, err := c.do(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -93,39 +266,268 @@ func (c *Client) Keys() ([]string, error) {
 	return keys, nil
 }
 
+// Stats returns the statistics the connected node keeps for each
+// namespace, keyed by name. A client bound to a namespace only gets that
+// one; otherwise every namespace on the node is listed, the default one
+// under "".
+func (c *Client) Stats() (map[string]protocol.NamespaceStats, error) {
+	return c.StatsContext(context.Background())
+}
+
+// StatsContext is Stats bounded by ctx.
+func (c *Client) StatsContext(ctx context.Context) (map[string]protocol.NamespaceStats, error) {
+	resp, err := c.do(ctx, &protocol.Request{CommandType: protocol.CmdStats})
+	if err != nil {
+		return nil, err
+	}
+
+	var stats map[string]protocol.NamespaceStats
+	if err := json.Unmarshal(resp.Value, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
 // Ping checks whether the connected node is alive.
 func (c *Client) Ping() error {
+	return c.PingContext(context.Background())
+}
+
+// PingContext is Ping bounded by ctx.
+func (c *Client) PingContext(ctx context.Context) error {
 	req := &protocol.Request{
 		CommandType: protocol.CmdPing,
 	}
 
-	_, err := c.sendRequest(req)
+	_, err := c.do(ctx, req)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// sendRequest is a helper that handles the TCP send/receive cycle.
-func (c *Client) sendRequest(req *protocol.Request) (*protocol.Response, error) {
-	conn, err := net.Dial("tcp", c.Addr)
+// do sends req and converts a non-OK status into an error.
+func (c *Client) do(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	resp, err := c.sendRequest(ctx, req)
 	if err != nil {
-		return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: "Cannot connect to the cluster."}, err
+		return nil, err
+	}
+	if err := responseError(resp); err != nil {
+		return nil, err
 	}
-	defer conn.Close()
+	return resp, nil
+}
 
-	reqBytes, err := req.Encode()
-	if err != nil {
-		return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: "Bad request."}, err
+// responseError converts a non-OK response status into an error.
+func responseError(resp *protocol.Response) error {
+	switch resp.StatusCode {
+	case protocol.StatusOK:
+		return nil
+	case protocol.StatusNotFound:
+		return ErrNotFound
+	case protocol.StatusWrongType:
+		return ErrWrongType
+	case protocol.StatusDenied:
+		return fmt.Errorf("%w: %s", ErrDenied, resp.ErrorMessage)
+	default:
+		return errors.New(resp.ErrorMessage)
+	}
+}
+
+// sendRequest is a helper that handles the TCP send/receive cycle.
+// The first request dials a node and runs the HELLO handshake; a node
+// that refuses it (incompatible version) makes every request fail.
+// Each request is traced, and carries the span's context to the node.
+func (c *Client) sendRequest(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if _, ok := ctx.Deadline(); !ok && c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	req.Namespace = c.namespace
+	req.Strong = req.Strong || c.strong
+	ctx, span := tracing.Start(ctx, c.tracer, "client", trace.SpanKindClient, req)
+
+	var resp *protocol.Response
+	var err error
+	for attempt := 0; ; attempt++ {
+		tracing.Inject(ctx, req)
+		resp, err = c.roundTrip(ctx, req)
+		if err == nil || attempt+1 >= c.retryAttempts || !retryable(req, err) {
+			break
+		}
+
+		wait := c.backoff(attempt)
+		c.logger.Debug("retrying request", "cmd", req.CommandType, "attempt", attempt+1, "backoff", wait, "err", err)
+		span.AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", attempt+1), attribute.String("error", err.Error())))
+		if sleepErr := sleep(ctx, wait); sleepErr != nil {
+			err = sleepErr
+			break
+		}
+	}
+
+	var te *transientError
+	if errors.As(err, &te) {
+		err = te.err
+	}
+	tracing.End(span, resp, err)
+	return resp, err
+}
+
+// -------- Deadlines & Cancellation --------
+// Every request runs under a context. Its deadline bounds the wait for the
+// connection, the dial, the handshake and the round trip, and is sent
+// along (Request.Timeout) so the node stops forwarding the request once
+// nobody is waiting for it. Cancelling the context abandons the request
+// at once. A connection whose request was cut short may still receive the
+// late response, so it is closed rather than reused.
+
+// lock waits for the connection to be free, or for ctx to be done.
+func (c *Client) lock(ctx context.Context) error {
+	select {
+	case c.busy <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	conn.Write(reqBytes)
-	buf := make([]byte, 4096)
-	n, err := conn.Read(buf)
+func (c *Client) unlock() {
+	<-c.busy
+}
+
+// gaveUp reports whether err means the caller stopped waiting, rather
+// than the node failing. The connection's deadline may fire a moment
+// before ctx's timer, so err says so before ctx does.
+func gaveUp(ctx context.Context, err error) bool {
+	return ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// roundTrip makes one attempt at req: over the open connection, or over
+// a new one to the first seed that answers. If the open connection fails,
+// the request goes out again on a new one when retryable allows it.
+// Network failures come back as a *transientError.
+func (c *Client) roundTrip(ctx context.Context, req *protocol.Request) (*protocol.Response, error) {
+	if err := c.lock(ctx); err != nil {
+		return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: err.Error()}, err
+	}
+	defer c.unlock()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if req.Timeout = time.Until(deadline); req.Timeout <= 0 {
+			err := context.DeadlineExceeded
+			return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: err.Error()}, err
+		}
+	}
+
+	reused := c.conn != nil
+	if !reused {
+		if err := c.connect(ctx); err != nil {
+			return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: "Cannot connect to the cluster."}, err
+		}
+	}
+	n := c.nodes[c.current]
+	// Whatever becomes of the request, a half-open trial must not stay
+	// in flight.
+	defer func() { n.breaker.abort() }()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("cache.node", n.addr))
+
+	if f := c.conn.Missing(req); f != "" {
+		err := fmt.Errorf("node %s does not support %s", n.addr, f)
+		return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: err.Error()}, err
+	}
+
+	resp, err := c.conn.RoundTripContext(ctx, req)
+	if err != nil && reused && !gaveUp(ctx, err) && retryable(req, sendError(err)) {
+		// The node may have dropped the idle connection; redial once.
+		n.logger.Debug("connection lost, redialing", "err", err)
+		c.conn.Close()
+		c.conn = nil
+		if err := c.connect(ctx); err != nil {
+			return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: "Cannot connect to the cluster."}, err
+		}
+		n = c.nodes[c.current]
+		resp, err = c.conn.RoundTripContext(ctx, req)
+	}
 	if err != nil {
+		n.logger.Warn("request failed", "cmd", req.CommandType, "err", err)
+		c.conn.Close()
+		c.conn = nil
+		if !gaveUp(ctx, err) {
+			c.fail(n)
+			err = sendError(err)
+		}
 		return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: "Cannot get response."}, err
 	}
-	resp := buf[:n]
 
-	return protocol.DecodeResponse(resp)
+	n.breaker.success()
+	return resp, nil
+}
+
+// connect opens c.conn to the first seed, starting with the current one,
+// that is not behind an open circuit breaker and answers. The caller must
+// hold the lock.
+func (c *Client) connect(ctx context.Context) error {
+	var errs []error
+	for i := range c.nodes {
+		idx := (c.current + i) % len(c.nodes)
+		n := c.nodes[idx]
+		if !n.breaker.allow() {
+			errs = append(errs, fmt.Errorf("%s: circuit breaker open", n.addr))
+			continue
+		}
+
+		conn, err := c.dial(ctx, n)
+		if err != nil {
+			if gaveUp(ctx, err) || errors.Is(err, ErrDenied) || errors.Is(err, protocol.ErrIncompatible) {
+				// Another node would not do better.
+				n.breaker.abort()
+				return err
+			}
+			c.fail(n)
+			errs = append(errs, fmt.Errorf("%s: %w", n.addr, err))
+			continue
+		}
+
+		if idx != c.current {
+			n.logger.Info("failed over", "from", c.nodes[c.current].addr)
+		}
+		c.current, c.conn = idx, conn
+		return nil
+	}
+	return &transientError{err: fmt.Errorf("%w: %w", ErrUnavailable, errors.Join(errs...))}
+}
+
+// fail counts a failure against n's circuit breaker.
+func (c *Client) fail(n *node) {
+	if n.breaker.failure() {
+		n.logger.Warn("circuit breaker open", "failures", n.breaker.failures, "cooldown", n.breaker.cooldown)
+	}
+}
+
+// dial connects to n, runs the handshake and authenticates.
+func (c *Client) dial(ctx context.Context, n *node) (*protocol.Conn, error) {
+	conn, err := protocol.DialContext(ctx, func(ctx context.Context) (net.Conn, error) {
+		return c.dialNode(ctx, n.addr)
+	}, protocol.DefaultHello())
+	if err != nil {
+		n.logger.Warn("cannot connect", "err", err)
+		return nil, err
+	}
+	n.logger.Debug("connected", "version", conn.Version, "features", conn.Features)
+	if c.auth == nil {
+		return conn, nil
+	}
+
+	resp, err := conn.RoundTripContext(ctx, c.auth)
+	if err == nil {
+		err = responseError(resp)
+	}
+	if err != nil {
+		n.logger.Warn("authentication failed", "err", err)
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
 }
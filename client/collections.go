@@ -0,0 +1,253 @@
+package client
+
+import (
+	"context"
+
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- Typed Value Commands --------
+
+// HSet sets a field in the hash stored at key and reports whether it is new.
+func (c *Client) HSet(key, field string, value []byte) (bool, error) {
+	return c.HSetContext(context.Background(), key, field, value)
+}
+
+// HSetContext is HSet bounded by ctx.
+func (c *Client) HSetContext(ctx context.Context, key, field string, value []byte) (bool, error) {
+	resp, err := c.do(ctx, &protocol.Request{CommandType: protocol.CmdHSet, Key: key, Field: field, Value: value})
+	if err != nil {
+		return false, err
+	}
+	return resp.Int == 1, nil
+}
+
+// HGet returns a field of the hash stored at key, or ErrNotFound.
+func (c *Client) HGet(key, field string) ([]byte, error) {
+	return c.HGetContext(context.Background(), key, field)
+}
+
+// HGetContext is HGet bounded by ctx.
+func (c *Client) HGetContext(ctx context.Context, key, field string) ([]byte, error) {
+	resp, err := c.do(ctx, &protocol.Request{CommandType: protocol.CmdHGet, Key: key, Field: field})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Value, nil
+}
+
+// HDel removes fields from the hash stored at key and returns how many existed.
+func (c *Client) HDel(key string, fields ...string) (int, error) {
+	return c.HDelContext(context.Background(), key, fields...)
+}
+
+// HDelContext is HDel bounded by ctx.
+func (c *Client) HDelContext(ctx context.Context, key string, fields ...string) (int, error) {
+	resp, err := c.do(ctx, &protocol.Request{CommandType: protocol.CmdHDel, Key: key, Fields: fields})
+	if err != nil {
+		return 0, err
+	}
+	return int(resp.Int), nil
+}
+
+// HGetAll returns every field and value of the hash stored at key.
+func (c *Client) HGetAll(key string) (map[string][]byte, error) {
+	return c.HGetAllContext(context.Background(), key)
+}
+
+// HGetAllContext is HGetAll bounded by ctx.
+func (c *Client) HGetAllContext(ctx context.Context, key string) (map[string][]byte, error) {
+	resp, err := c.do(ctx, &protocol.Request{CommandType: protocol.CmdHGetAll, Key: key})
+	if err != nil {
+		return nil, err
+	}
+
+	all := make(map[string][]byte, len(resp.Fields))
+	for i, f := range resp.Fields {
+		all[f] = resp.Values[i]
+	}
+	return all, nil
+}
+
+// LPush prepends values to the list stored at key and returns its new length.
+func (c *Client) LPush(key string, values ...[]byte) (int, error) {
+	return c.LPushContext(context.Background(), key, values...)
+}
+
+// LPushContext is LPush bounded by ctx.
+func (c *Client) LPushContext(ctx context.Context, key string, values ...[]byte) (int, error) {
+	resp, err := c.do(ctx, &protocol.Request{CommandType: protocol.CmdLPush, Key: key, Values: values})
+	if err != nil {
+		return 0, err
+	}
+	return int(resp.Int), nil
+}
+
+// RPop removes and returns the last element of the list stored at key, or ErrNotFound.
+func (c *Client) RPop(key string) ([]byte, error) {
+	return c.RPopContext(context.Background(), key)
+}
+
+// RPopContext is RPop bounded by ctx.
+func (c *Client) RPopContext(ctx context.Context, key string) ([]byte, error) {
+	resp, err := c.do(ctx, &protocol.Request{CommandType: protocol.CmdRPop, Key: key})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Value, nil
+}
+
+// LRange returns the list elements between start and stop (inclusive, negative counts from the end).
+func (c *Client) LRange(key string, start, stop int) ([][]byte, error) {
+	return c.LRangeContext(context.Background(), key, start, stop)
+}
+
+// LRangeContext is LRange bounded by ctx.
+func (c *Client) LRangeContext(ctx context.Context, key string, start, stop int) ([][]byte, error) {
+	resp, err := c.do(ctx, &protocol.Request{CommandType: protocol.CmdLRange, Key: key, Start: start, Stop: stop})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Values, nil
+}
+
+// SAdd adds members to the set stored at key and returns how many were new.
+func (c *Client) SAdd(key string, members ...string) (int, error) {
+	return c.SAddContext(context.Background(), key, members...)
+}
+
+// SAddContext is SAdd bounded by ctx.
+func (c *Client) SAddContext(ctx context.Context, key string, members ...string) (int, error) {
+	resp, err := c.do(ctx, &protocol.Request{CommandType: protocol.CmdSAdd, Key: key, Fields: members})
+	if err != nil {
+		return 0, err
+	}
+	return int(resp.Int), nil
+}
+
+// SIsMember reports whether member belongs to the set stored at key.
+func (c *Client) SIsMember(key, member string) (bool, error) {
+	return c.SIsMemberContext(context.Background(), key, member)
+}
+
+// SIsMemberContext is SIsMember bounded by ctx.
+func (c *Client) SIsMemberContext(ctx context.Context, key, member string) (bool, error) {
+	resp, err := c.do(ctx, &protocol.Request{CommandType: protocol.CmdSIsMember, Key: key, Field: member})
+	if err != nil {
+		return false, err
+	}
+	return resp.Int == 1, nil
+}
+
+// SMembers returns the members of the set stored at key.
+func (c *Client) SMembers(key string) ([]string, error) {
+	return c.SMembersContext(context.Background(), key)
+}
+
+// SMembersContext is SMembers bounded by ctx.
+func (c *Client) SMembersContext(ctx context.Context, key string) ([]string, error) {
+	resp, err := c.do(ctx, &protocol.Request{CommandType: protocol.CmdSMembers, Key: key})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Fields, nil
+}
+
+// ZAdd adds scored members to the sorted set stored at key and returns how many were new.
+func (c *Client) ZAdd(key string, members ...protocol.ZMember) (int, error) {
+	return c.ZAddContext(context.Background(), key, members...)
+}
+
+// ZAddContext is ZAdd bounded by ctx.
+func (c *Client) ZAddContext(ctx context.Context, key string, members ...protocol.ZMember) (int, error) {
+	req := &protocol.Request{CommandType: protocol.CmdZAdd, Key: key}
+	for _, m := range members {
+		req.Fields = append(req.Fields, m.Member)
+		req.Scores = append(req.Scores, m.Score)
+	}
+
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+	return int(resp.Int), nil
+}
+
+// ZRange returns the members ranked between start and stop (inclusive), lowest score first.
+func (c *Client) ZRange(key string, start, stop int) ([]protocol.ZMember, error) {
+	return c.ZRangeContext(context.Background(), key, start, stop)
+}
+
+// ZRangeContext is ZRange bounded by ctx.
+func (c *Client) ZRangeContext(ctx context.Context, key string, start, stop int) ([]protocol.ZMember, error) {
+	resp, err := c.do(ctx, &protocol.Request{CommandType: protocol.CmdZRange, Key: key, Start: start, Stop: stop})
+	if err != nil {
+		return nil, err
+	}
+	return zmembers(resp), nil
+}
+
+// ZRangeByScore returns the members whose score lies within [min, max], lowest score first.
+func (c *Client) ZRangeByScore(key string, min, max float64) ([]protocol.ZMember, error) {
+	return c.ZRangeByScoreContext(context.Background(), key, min, max)
+}
+
+// ZRangeByScoreContext is ZRangeByScore bounded by ctx.
+func (c *Client) ZRangeByScoreContext(ctx context.Context, key string, min, max float64) ([]protocol.ZMember, error) {
+	resp, err := c.do(ctx, &protocol.Request{CommandType: protocol.CmdZRangeByScore, Key: key, Min: min, Max: max})
+	if err != nil {
+		return nil, err
+	}
+	return zmembers(resp), nil
+}
+
+// ZRem removes members from the sorted set stored at key and returns how many existed.
+func (c *Client) ZRem(key string, members ...string) (int, error) {
+	return c.ZRemContext(context.Background(), key, members...)
+}
+
+// ZRemContext is ZRem bounded by ctx.
+func (c *Client) ZRemContext(ctx context.Context, key string, members ...string) (int, error) {
+	resp, err := c.do(ctx, &protocol.Request{CommandType: protocol.CmdZRem, Key: key, Fields: members})
+	if err != nil {
+		return 0, err
+	}
+	return int(resp.Int), nil
+}
+
+// ZCard returns the number of members in the sorted set stored at key.
+func (c *Client) ZCard(key string) (int, error) {
+	return c.ZCardContext(context.Background(), key)
+}
+
+// ZCardContext is ZCard bounded by ctx.
+func (c *Client) ZCardContext(ctx context.Context, key string) (int, error) {
+	resp, err := c.do(ctx, &protocol.Request{CommandType: protocol.CmdZCard, Key: key})
+	if err != nil {
+		return 0, err
+	}
+	return int(resp.Int), nil
+}
+
+// ZIncrBy adds delta to member's score and returns the new score.
+func (c *Client) ZIncrBy(key, member string, delta float64) (float64, error) {
+	return c.ZIncrByContext(context.Background(), key, member, delta)
+}
+
+// ZIncrByContext is ZIncrBy bounded by ctx.
+func (c *Client) ZIncrByContext(ctx context.Context, key, member string, delta float64) (float64, error) {
+	resp, err := c.do(ctx, &protocol.Request{CommandType: protocol.CmdZIncrBy, Key: key, Field: member, Score: delta})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Score, nil
+}
+
+// zmembers pairs up the Fields and Scores of a sorted-set range reply.
+func zmembers(resp *protocol.Response) []protocol.ZMember {
+	members := make([]protocol.ZMember, len(resp.Fields))
+	for i, m := range resp.Fields {
+		members[i] = protocol.ZMember{Member: m, Score: resp.Scores[i]}
+	}
+	return members
+}
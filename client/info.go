@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- Node Introspection --------
+
+// Info returns a snapshot of node, which must be in the ring. An empty
+// node means the node the client is connected to.
+func (c *Client) Info(node string) (*protocol.NodeInfo, error) {
+	return c.InfoContext(context.Background(), node)
+}
+
+// InfoContext is Info bounded by ctx.
+func (c *Client) InfoContext(ctx context.Context, node string) (*protocol.NodeInfo, error) {
+	resp, err := c.do(ctx, &protocol.Request{CommandType: protocol.CmdInfo, Key: node})
+	if err != nil {
+		return nil, err
+	}
+
+	info := &protocol.NodeInfo{}
+	if err := json.Unmarshal(resp.Value, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// InfoAll returns a snapshot of every node in the ring, as seen by the
+// node the client is connected to, which comes first. Nodes that cannot
+// be reached are left out and their errors joined into err.
+func (c *Client) InfoAll() ([]*protocol.NodeInfo, error) {
+	return c.InfoAllContext(context.Background())
+}
+
+// InfoAllContext is InfoAll bounded by ctx.
+func (c *Client) InfoAllContext(ctx context.Context) ([]*protocol.NodeInfo, error) {
+	local, err := c.InfoContext(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	all := []*protocol.NodeInfo{local}
+	var errs []error
+	for _, member := range local.Ring {
+		if member.Addr == local.Node {
+			continue
+		}
+		info, err := c.InfoContext(ctx, member.Addr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", member.Addr, err))
+			continue
+		}
+		all = append(all, info)
+	}
+	return all, errors.Join(errs...)
+}
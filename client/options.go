@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/BiChong-Jin/distributed-cache/certs"
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- Client Options --------
+// Optional settings passed to NewClient, e.g.
+//
+//	client.NewClient("cache-1:7000", client.WithTLS(reloader))
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithTLS connects over TLS, verifying the node against r's CA. If r also
+// has a certificate, it is presented to nodes that require mutual TLS.
+func WithTLS(r *certs.Reloader) Option {
+	return func(c *Client) { c.tls = r }
+}
+
+// WithAuth authenticates as user with password on every connection.
+func WithAuth(user, password string) Option {
+	return func(c *Client) {
+		c.auth = &protocol.Request{CommandType: protocol.CmdAuth, Key: user, Value: []byte(password)}
+	}
+}
+
+// WithToken authenticates with an access token on every connection.
+func WithToken(token string) Option {
+	return func(c *Client) { c.auth = &protocol.Request{CommandType: protocol.CmdAuth, Value: []byte(token)} }
+}
+
+// WithNamespace runs every request in namespace ns instead of the default
+// one, so keys, quotas and stats are kept apart from other tenants.
+func WithNamespace(ns string) Option {
+	return func(c *Client) { c.namespace = ns }
+}
+
+// WithStrong runs every request in strong mode: reads and writes of keys
+// are linearizable, at the cost of a consensus round each. The nodes must
+// run Raft (see server.WithRaft), and only Get, GetMeta, Set, Add,
+// Replace, CAS and Delete are available.
+func WithStrong() Option {
+	return func(c *Client) { c.strong = true }
+}
+
+// WithLogger sets where the client logs connection problems. The default
+// is slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(c *Client) { c.logger = l }
+}
+
+// WithTracerProvider sets where the client's spans go. The default is the
+// global provider, see tracing.SetupOTLP.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *Client) { c.tracerProvider = tp }
+}
+
+// DefaultDialTimeout bounds connecting to the node unless WithDialTimeout
+// says otherwise.
+const DefaultDialTimeout = 5 * time.Second
+
+// WithTimeout bounds every request that has no deadline of its own, which
+// includes all calls to the methods without a context. The default is no
+// limit.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithDialTimeout bounds connecting to the node, the TLS handshake
+// included. Zero means no limit beyond the request's own deadline.
+func WithDialTimeout(d time.Duration) Option {
+	return func(c *Client) { c.dialTimeout = d }
+}
+
+// WithSeeds adds nodes to fail over to when the node given to NewClient,
+// or the one the client last used, cannot be reached (see retry.go).
+func WithSeeds(addrs ...string) Option {
+	return func(c *Client) { c.seeds = append(c.seeds, addrs...) }
+}
+
+// WithRetry sets how many times in all a request is attempted when the
+// network fails, and the backoff between attempts: a random wait up to
+// backoff, doubling with every retry up to maxBackoff. One attempt turns
+// retries off.
+func WithRetry(attempts int, backoff, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.retryAttempts, c.retryBackoff, c.retryMaxBackoff = attempts, backoff, maxBackoff
+	}
+}
+
+// WithCircuitBreaker sets how many failures in a row make the client stop
+// trying a node, and for how long (see breaker.go). A threshold of 0 turns
+// the breakers off.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(c *Client) { c.breakerThreshold, c.breakerCooldown = threshold, cooldown }
+}
+
+// dialNode opens a connection to addr, over TLS if configured.
+func (c *Client) dialNode(ctx context.Context, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: c.dialTimeout}
+	if c.tls != nil {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: c.tls.ClientConfig(addr)}
+		return tlsDialer.DialContext(ctx, "tcp", addr)
+	}
+	return dialer.DialContext(ctx, "tcp", addr)
+}
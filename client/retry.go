@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- Retries & Failover --------
+// The client knows several seed nodes (NewClient's addr plus WithSeeds) and
+// talks to one at a time. When that node cannot be reached, the client
+// moves on to the next seed whose circuit breaker is closed (breaker.go).
+//
+// A request that failed because of the network is tried again, up to the
+// WithRetry limit, sleeping between attempts with exponential backoff and
+// full jitter so clients that lost the same node do not all come back at
+// once. Requests that never left the client, because no node could be
+// reached, are always safe to retry; requests that may have reached a
+// node are only retried if running them twice does no harm (see
+// protocol.CommandType.Idempotent).
+// Errors the node answered with, like ErrNotFound or ErrDenied, are final.
+
+// ErrUnavailable is returned when no seed node can be reached, either
+// because dialing failed or because its circuit breaker is open.
+var ErrUnavailable = errors.New("no cache node available")
+
+// DefaultRetryAttempts, DefaultRetryBackoff and DefaultRetryMaxBackoff are
+// the retry settings unless WithRetry says otherwise.
+const (
+	DefaultRetryAttempts   = 3
+	DefaultRetryBackoff    = 50 * time.Millisecond
+	DefaultRetryMaxBackoff = time.Second
+)
+
+// transientError is a network failure worth another attempt. sent tells
+// whether the request may have reached the node.
+type transientError struct {
+	err  error
+	sent bool
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// sendError wraps a network failure while sending a request, which may
+// have reached the node unless the connection says it was never sent.
+func sendError(err error) *transientError {
+	return &transientError{err: err, sent: !errors.Is(err, protocol.ErrNotSent)}
+}
+
+// retryable reports whether req may be tried again after err.
+func retryable(req *protocol.Request, err error) bool {
+	var te *transientError
+	if !errors.As(err, &te) {
+		return false
+	}
+	return !te.sent || req.CommandType.Idempotent()
+}
+
+// backoff returns how long to wait before retry number attempt (from 0):
+// a random duration up to base·2^attempt, capped at max.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.retryMaxBackoff
+	if attempt < 30 && c.retryBackoff<<attempt < d {
+		d = c.retryBackoff << attempt
+	}
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d) + 1
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
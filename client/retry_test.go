@@ -0,0 +1,123 @@
+package client
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// flakyNode answers every request with StatusOK, but hangs up on a
+// request, after reading it, when told to.
+type flakyNode struct {
+	l net.Listener
+
+	mu     sync.Mutex
+	hangUp bool
+	seen   map[protocol.CommandType]int
+}
+
+func startFlakyNode(t *testing.T) *flakyNode {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &flakyNode{l: l, seen: make(map[protocol.CommandType]int)}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go n.serve(nc)
+		}
+	}()
+	return n
+}
+
+func (n *flakyNode) serve(nc net.Conn) {
+	defer nc.Close()
+	conn, _, err := protocol.Accept(nc, protocol.DefaultHello())
+	if err != nil {
+		return
+	}
+	for {
+		data, err := protocol.ReadFrame(conn)
+		if err != nil {
+			return
+		}
+		req, err := protocol.DecodeRequest(data)
+		if err != nil {
+			return
+		}
+
+		n.mu.Lock()
+		n.seen[req.CommandType]++
+		hangUp := n.hangUp
+		n.hangUp = false
+		n.mu.Unlock()
+		if hangUp {
+			return
+		}
+
+		data, _ = (&protocol.Response{StatusCode: protocol.StatusOK, Value: []byte("v")}).EncodeWith(conn.Codec)
+		if protocol.WriteFrame(conn, data) != nil {
+			return
+		}
+	}
+}
+
+// hangUpNext makes the node hang up on the next request and returns how
+// many requests of cmd it has seen so far.
+func (n *flakyNode) hangUpNext(cmd protocol.CommandType) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.hangUp = true
+	return n.seen[cmd]
+}
+
+func (n *flakyNode) count(cmd protocol.CommandType) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.seen[cmd]
+}
+
+func TestRedialOnlyRetryable(t *testing.T) {
+	n := startFlakyNode(t)
+	// No retries: only the redial may send a request twice.
+	c := NewClient(n.l.Addr().String(), WithRetry(1, 0, 0), WithCircuitBreaker(0, time.Second))
+	defer c.Close()
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A Get may run twice, so it goes out again on a new connection.
+	before := n.hangUpNext(protocol.CmdGet)
+	if v, err := c.Get("k"); err != nil || string(v) != "v" {
+		t.Fatalf("get: got %q, %v", v, err)
+	}
+	if sent := n.count(protocol.CmdGet) - before; sent != 2 {
+		t.Errorf("get: sent %d times, want 2", sent)
+	}
+
+	// A GetAndDelete may have run, so it does not.
+	before = n.hangUpNext(protocol.CmdGetDel)
+	if _, err := c.GetAndDelete("k"); err == nil {
+		t.Fatal("getdel: expected an error")
+	}
+	if sent := n.count(protocol.CmdGetDel) - before; sent != 1 {
+		t.Errorf("getdel: sent %d times, want 1", sent)
+	}
+
+	// Nor does a Delete: run twice, it would find nothing to delete.
+	before = n.hangUpNext(protocol.CmdDelete)
+	if err := c.Delete("k"); err == nil {
+		t.Fatal("delete: expected an error")
+	}
+	if sent := n.count(protocol.CmdDelete) - before; sent != 1 {
+		t.Errorf("delete: sent %d times, want 1", sent)
+	}
+}
@@ -0,0 +1,141 @@
+package consistent
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"math/bits"
+)
+
+// -------- Hash Functions --------
+// The ring places virtual nodes and keys with a HashFunc. Node names
+// ("10.0.0.1:7000-17") and keys ("user:42") often differ only in a few
+// trailing characters, which the functions below spread with more or
+// less success (see TestHashDistribution and the benchmarks):
+//
+//	crc32    The default, for compatibility. A checksum rather than a
+//	         hash: similar inputs get related positions, leaving nodes
+//	         about a quarter off a fair share. Hardware-accelerated, so the
+//	         fastest on long keys but the slowest on short ones.
+//	fnv1a    A tiny loop, but the last bytes barely reach the high bits
+//	         that decide the position: nodes end up far off a fair share.
+//	         Slow on long keys.
+//	xxhash   xxHash32, the fastest on short keys and fast on long ones.
+//	         Nodes stay within about 15% of a fair share.
+//	murmur3  MurmurHash3 x86_32, as even as xxhash, and a bit slower.
+//
+// All nodes of a cluster must use the same one, or they disagree on who
+// owns a key. Changing it moves nearly every key, as if the ring were new.
+
+// HashFunc maps data to a position on the ring. It must be safe for
+// concurrent use.
+type HashFunc func(data []byte) uint32
+
+// DefaultHash is the hash function a ring uses unless WithHash says
+// otherwise.
+const DefaultHash = "crc32"
+
+var hashFuncs = map[string]HashFunc{
+	"crc32":   crc32.ChecksumIEEE,
+	"fnv1a":   FNV1a,
+	"xxhash":  XXHash,
+	"murmur3": Murmur3,
+}
+
+// Hash returns the hash function called name, or nil if there is none.
+func Hash(name string) HashFunc {
+	return hashFuncs[name]
+}
+
+// FNV1a is the 32-bit FNV-1a hash.
+func FNV1a(data []byte) uint32 {
+	h := uint32(2166136261)
+	for _, b := range data {
+		h ^= uint32(b)
+		h *= 16777619
+	}
+	return h
+}
+
+// XXHash is the 32-bit xxHash (XXH32) with seed 0.
+func XXHash(data []byte) uint32 {
+	const (
+		p1 = 2654435761
+		p2 = 2246822519
+		p3 = 3266489917
+		p4 = 668265263
+		p5 = 374761393
+	)
+	round := func(acc, lane uint32) uint32 {
+		return bits.RotateLeft32(acc+lane*p2, 13) * p1
+	}
+
+	n := len(data)
+	var h uint32
+	if n >= 16 {
+		var v1, v2, v3, v4 uint32 = p1, p2, 0, 0
+		v1 += p2
+		v4 -= p1
+		for ; len(data) >= 16; data = data[16:] {
+			v1 = round(v1, binary.LittleEndian.Uint32(data[0:]))
+			v2 = round(v2, binary.LittleEndian.Uint32(data[4:]))
+			v3 = round(v3, binary.LittleEndian.Uint32(data[8:]))
+			v4 = round(v4, binary.LittleEndian.Uint32(data[12:]))
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) + bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = p5
+	}
+	h += uint32(n)
+
+	for ; len(data) >= 4; data = data[4:] {
+		h = bits.RotateLeft32(h+binary.LittleEndian.Uint32(data)*p3, 17) * p4
+	}
+	for _, b := range data {
+		h = bits.RotateLeft32(h+uint32(b)*p5, 11) * p1
+	}
+
+	h ^= h >> 15
+	h *= p2
+	h ^= h >> 13
+	h *= p3
+	h ^= h >> 16
+	return h
+}
+
+// Murmur3 is the 32-bit MurmurHash3 (x86_32) with seed 0.
+func Murmur3(data []byte) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+	mix := func(k uint32) uint32 {
+		return bits.RotateLeft32(k*c1, 15) * c2
+	}
+
+	n := len(data)
+	var h uint32
+	for ; len(data) >= 4; data = data[4:] {
+		h ^= mix(binary.LittleEndian.Uint32(data))
+		h = bits.RotateLeft32(h, 13)*5 + 0xe6546b64
+	}
+	var k uint32
+	switch len(data) {
+	case 3:
+		k ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(data[0])
+		h ^= mix(k)
+	}
+
+	h ^= uint32(n)
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
@@ -0,0 +1,151 @@
+package consistent
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestHashVectors(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   HashFunc
+		in   string
+		want uint32
+	}{
+		{"fnv1a", FNV1a, "", 0x811c9dc5},
+		{"fnv1a", FNV1a, "a", 0xe40c292c},
+		{"fnv1a", FNV1a, "foobar", 0xbf9cf968},
+		{"xxhash", XXHash, "", 0x02cc5d05},
+		{"xxhash", XXHash, "a", 0x550d7456},
+		{"xxhash", XXHash, "abc", 0x32d153ff},
+		{"xxhash", XXHash, "Nobody inspects the spammish repetition", 0xe2293b2f},
+		{"murmur3", Murmur3, "", 0},
+		{"murmur3", Murmur3, "hello", 0x248bfa47},
+		{"murmur3", Murmur3, "The quick brown fox jumps over the lazy dog", 0x2e4ff723},
+	}
+	for _, tt := range tests {
+		if got := tt.fn([]byte(tt.in)); got != tt.want {
+			t.Errorf("%s(%q) = %#08x, want %#08x", tt.name, tt.in, got, tt.want)
+		}
+	}
+
+	for i := range 100 {
+		data := []byte(strconv.Itoa(i * 7919))
+		std := fnv.New32a()
+		std.Write(data)
+		if got, want := FNV1a(data), std.Sum32(); got != want {
+			t.Fatalf("FNV1a(%q) = %#08x, hash/fnv says %#08x", data, got, want)
+		}
+	}
+	if Hash("md5") != nil || Hash(DefaultHash) == nil {
+		t.Error("Hash knows md5, or not the default")
+	}
+}
+
+// spread places 100,000 short keys on a ring of 10 nodes with the server's
+// 150 virtual nodes each, and returns how far the busiest or idlest node
+// is from a fair share, as a fraction of it.
+func spread(fn HashFunc) float64 {
+	h := NewHashRing(150, WithHash(fn))
+	const nodes, keys = 10, 100_000
+	for i := range nodes {
+		h.AddNode(fmt.Sprintf("10.0.0.%d:7000", i+1))
+	}
+	counts := make(map[string]int)
+	for i := range keys {
+		counts[h.GetNode(fmt.Sprintf("user:%d", i))]++
+	}
+	var worst float64
+	for _, c := range counts {
+		worst = max(worst, math.Abs(float64(c)*nodes/keys-1))
+	}
+	return worst
+}
+
+func TestHashDistribution(t *testing.T) {
+	// The bounds record what each function does with node names and keys
+	// that differ in a few trailing characters (see hash.go): crc32 leaves
+	// a node about a quarter off a fair share and fnv1a up to 80%, while
+	// xxhash and murmur3 stay within about 15%.
+	tests := []struct {
+		name string
+		max  float64
+	}{
+		{"crc32", 0.3},
+		{"fnv1a", 1},
+		{"xxhash", 0.2},
+		{"murmur3", 0.2},
+	}
+	for _, tt := range tests {
+		got := spread(Hash(tt.name))
+		t.Logf("%-8s busiest or idlest node %.0f%% off a fair share", tt.name, got*100)
+		if got > tt.max {
+			t.Errorf("%s: a node is %.0f%% off a fair share, want at most %.0f%%", tt.name, got*100, tt.max*100)
+		}
+	}
+}
+
+func TestSetHash(t *testing.T) {
+	h := NewHashRing(50)
+	for _, addr := range []string{"a", "b", "c"} {
+		h.AddNode(addr)
+	}
+	h.SetHash(Murmur3)
+
+	want := NewHashRing(50, WithHash(Murmur3))
+	for _, addr := range []string{"c", "a", "b"} {
+		want.AddNode(addr)
+	}
+	for i := range 1000 {
+		key := fmt.Sprint("key-", i)
+		if got := h.GetNode(key); got != want.GetNode(key) || h.Token(key) != Murmur3([]byte(key)) {
+			t.Fatalf("after SetHash %q is on %s, want %s", key, got, want.GetNode(key))
+		}
+	}
+	if counts := h.VirtualNodes(); len(counts) != 3 || counts["a"] != 50 {
+		t.Errorf("after SetHash the virtual nodes are %v", counts)
+	}
+}
+
+// Run with -race: lookups used to share one hasher.
+func TestConcurrentLookups(t *testing.T) {
+	h := NewHashRing(50)
+	h.AddNode("a")
+	h.AddNode("b")
+
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Go(func() {
+			for i := range 1000 {
+				key := fmt.Sprint(g, "-", i)
+				if h.GetNode(key) == "" || len(h.PreferenceList(key, 2)) != 2 {
+					t.Errorf("no owner for %q", key)
+					return
+				}
+			}
+		})
+	}
+	wg.Go(func() { h.SetHash(XXHash) })
+	wg.Wait()
+}
+
+func benchmarkHash(b *testing.B, fn HashFunc, size int) {
+	data := make([]byte, size)
+	b.SetBytes(int64(size))
+	for b.Loop() {
+		fn(data)
+	}
+}
+
+func BenchmarkCRC32Short(b *testing.B)   { benchmarkHash(b, Hash("crc32"), 12) }
+func BenchmarkFNV1aShort(b *testing.B)   { benchmarkHash(b, FNV1a, 12) }
+func BenchmarkXXHashShort(b *testing.B)  { benchmarkHash(b, XXHash, 12) }
+func BenchmarkMurmur3Short(b *testing.B) { benchmarkHash(b, Murmur3, 12) }
+func BenchmarkCRC32Long(b *testing.B)    { benchmarkHash(b, Hash("crc32"), 1024) }
+func BenchmarkFNV1aLong(b *testing.B)    { benchmarkHash(b, FNV1a, 1024) }
+func BenchmarkXXHashLong(b *testing.B)   { benchmarkHash(b, XXHash, 1024) }
+func BenchmarkMurmur3Long(b *testing.B)  { benchmarkHash(b, Murmur3, 1024) }
@@ -2,8 +2,6 @@ package consistent
 
 import (
 	"fmt"
-	"hash"
-	"hash/crc32"
 	"sort"
 	"sync"
 )
@@ -12,67 +10,158 @@ import (
 // This is how you decide WHICH node owns a given key.
 // Without this, adding/removing a node would remap almost all keys.
 // With consistent hashing, only ~1/N keys get remapped.
+//
+// Nodes can have a weight, for machines of different sizes: a node of
+// weight w holds w times as many virtual nodes, and so about w times as
+// many keys. Virtual nodes are numbered, and a node of weight w holds the
+// first replicas·w of them, so changing the weight only adds or removes
+// its last ones: the keys that move all move to or from that node.
+// Weights are capped at MaxWeight, which keeps a node to a bounded number
+// of virtual nodes.
+//
+// Two virtual nodes can hash to the same position. The position goes to
+// the node with the smaller address, never to whichever came last, so
+// every node's ring agrees whatever order it learned of the others in.
+
+// MaxWeight is the largest weight a node can have.
+const MaxWeight = 1024
 
 // HashRing distributes keys across nodes using consistent hashing.
 type HashRing struct {
 	mu sync.RWMutex
 	//   - hashes []int           → sorted ring positions
 	//   - ring   map[int]string  → hash position → node name
-	//   - replicas int           → number of virtual nodes per real node
-	//   - hasher hash.Hash32     → hash function (e.g. crc32, fnv)
+	//   - replicas int           → number of virtual nodes per unit of weight
+	//   - weights map[string]int → real node → weight
+	//   - hash HashFunc          → hash function (see hash.go)
 	hashes   []int
 	replicas int
 	ring     map[int]string
-	hasher   hash.Hash32
+	weights  map[string]int
+	hash     HashFunc
 }
 
-// Ensure the compiler knows we use hash.Hash (suppress unused import).
-var _ hash.Hash
-
 // NewHashRing creates a ring with the given number of virtual nodes per real node.
-func NewHashRing(replicas int) *HashRing {
-	return &HashRing{
+func NewHashRing(replicas int, opts ...Option) *HashRing {
+	h := &HashRing{
 		replicas: replicas,
 		ring:     make(map[int]string),
-		hasher:   crc32.NewIEEE(),
+		weights:  make(map[string]int),
+		hash:     Hash(DefaultHash),
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	return h
 }
 
-// AddNode adds a real node to the ring.
+// AddNode adds a real node of weight 1 to the ring.
 func (h *HashRing) AddNode(addr string) {
+	h.AddNodeWithWeight(addr, 1)
+}
+
+// AddNodeWithWeight adds a real node holding weight times the virtual
+// nodes of AddNode. Weights below 1 count as 1, and above MaxWeight as
+// MaxWeight. If the node is already in the ring, its weight changes as
+// with SetWeight.
+func (h *HashRing) AddNodeWithWeight(addr string, weight int) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	replicas := h.replicas
-	for i := 0; i < replicas; i++ {
-		replicaAddr := fmt.Sprintf("%s-%d", addr, i)
-		h.hasher.Reset()
-		h.hasher.Write([]byte(replicaAddr))
-		hashReplicaValue := int(h.hasher.Sum32())
-		h.ring[hashReplicaValue] = addr
+	h.setWeight(addr, weight)
+}
+
+// SetWeight changes the weight of a node in the ring, moving only the
+// keys of the virtual nodes it gains or loses. It reports false, and does
+// nothing, if the node is not in the ring.
+func (h *HashRing) SetWeight(addr string, weight int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.weights[addr]; !ok {
+		return false
+	}
+	h.setWeight(addr, weight)
+	return true
+}
+
+// Weight returns the weight of a node, or 0 if it is not in the ring.
+func (h *HashRing) Weight(addr string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.weights[addr]
+}
+
+// setWeight places or removes the virtual nodes of addr past the ones
+// both its old and new weight hold. The caller must hold h.mu.
+func (h *HashRing) setWeight(addr string, weight int) {
+	weight = min(max(weight, 1), MaxWeight)
+	from, to := h.weights[addr]*h.replicas, weight*h.replicas
+	h.weights[addr] = weight
+
+	if to < from {
+		// A position given up may have been claimed by another node too.
+		h.rebuild()
+		return
+	}
+	for i := from; i < to; i++ {
+		h.claim(addr, h.virtualNode(addr, i))
+	}
+	sort.Ints(h.hashes)
+}
+
+// claim gives a ring position to addr, unless a node with a smaller
+// address holds it. The caller must hold h.mu and sort h.hashes after.
+func (h *HashRing) claim(addr string, hashReplicaValue int) {
+	owner, taken := h.ring[hashReplicaValue]
+	if !taken {
 		h.hashes = append(h.hashes, hashReplicaValue)
 	}
+	if !taken || addr < owner {
+		h.ring[hashReplicaValue] = addr
+	}
+}
 
+// rebuild places the virtual nodes of every node again, from h.weights.
+// The caller must hold h.mu.
+func (h *HashRing) rebuild() {
+	h.ring = make(map[int]string)
+	h.hashes = nil
+	for addr, weight := range h.weights {
+		for i := range weight * h.replicas {
+			h.claim(addr, h.virtualNode(addr, i))
+		}
+	}
 	sort.Ints(h.hashes)
 }
 
+// virtualNode returns the ring position of virtual node i of addr.
+func (h *HashRing) virtualNode(addr string, i int) int {
+	replicaAddr := fmt.Sprintf("%s-%d", addr, i)
+	return int(h.hash([]byte(replicaAddr)))
+}
+
+// SetHash switches the ring to another hash function, placing every node
+// again. Nearly all keys change owners.
+func (h *HashRing) SetHash(fn HashFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.hash = fn
+	h.rebuild()
+}
+
 // RemoveNode removes a node and all its virtual nodes from the ring.
 func (h *HashRing) RemoveNode(addr string) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	for key, val := range h.ring {
-		if val == addr {
-			delete(h.ring, key)
-		}
-	}
-
-	h.hashes = []int{}
-	for keys := range h.ring {
-		h.hashes = append(h.hashes, keys)
+	if _, ok := h.weights[addr]; !ok {
+		return
 	}
-
-	sort.Ints(h.hashes)
+	delete(h.weights, addr)
+	h.rebuild()
 }
 
 // GetNode returns the node responsible for the given key.
@@ -84,9 +173,7 @@ func (h *HashRing) GetNode(key string) string {
 		return ""
 	}
 
-	h.hasher.Reset()
-	h.hasher.Write([]byte(key))
-	hashKey := int(h.hasher.Sum32())
+	hashKey := int(h.hash([]byte(key)))
 
 	idx := sort.Search(len(h.hashes), func(i int) bool {
 		return h.hashes[i] >= hashKey
@@ -101,6 +188,19 @@ func (h *HashRing) GetNode(key string) string {
 	return nn
 }
 
+// VirtualNodes returns how many ring positions each real node holds.
+// A node can hold fewer than replicas·weight if hashes collide.
+func (h *HashRing) VirtualNodes() map[string]int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, addr := range h.ring {
+		counts[addr]++
+	}
+	return counts
+}
+
 // GetNodes returns all unique real node addresses currently in the ring.
 func (h *HashRing) GetNodes() []string {
 	h.mu.RLock()
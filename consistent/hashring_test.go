@@ -1,6 +1,12 @@
 package consistent
 
-import "testing"
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+)
 
 func TestAddAndGetNode(t *testing.T) {
 	// TODO: Create a ring, add 3 nodes, verify GetNode returns one of them for any key.
@@ -24,3 +30,200 @@ func TestConsistencyAfterAddition(t *testing.T) {
 func TestEmptyRing(t *testing.T) {
 	// TODO: GetNode on an empty ring should return "".
 }
+
+func TestVirtualNodes(t *testing.T) {
+	h := NewHashRing(50)
+	h.AddNode("a")
+	h.AddNode("b")
+	h.RemoveNode("b")
+
+	counts := h.VirtualNodes()
+	if len(counts) != 1 || counts["a"] != 50 {
+		t.Errorf("expected 50 virtual nodes for a only, got %v", counts)
+	}
+}
+
+func TestPreferenceList(t *testing.T) {
+	h := NewHashRing(50)
+	for _, addr := range []string{"a", "b", "c", "d"} {
+		h.AddNode(addr)
+	}
+
+	for i := range 1000 {
+		key := fmt.Sprintf("key-%d", i)
+		nodes := h.PreferenceList(key, 3)
+		if len(nodes) != 3 || nodes[0] != h.GetNode(key) {
+			t.Fatalf("PreferenceList(%q) = %v, owner %s", key, nodes, h.GetNode(key))
+		}
+		if nodes[0] == nodes[1] || nodes[1] == nodes[2] || nodes[0] == nodes[2] {
+			t.Fatalf("PreferenceList(%q) = %v has duplicates", key, nodes)
+		}
+	}
+
+	if nodes := h.PreferenceList("k", 10); len(nodes) != 4 {
+		t.Errorf("asking for more nodes than the ring has gave %v", nodes)
+	}
+	if nodes := NewHashRing(50).PreferenceList("k", 3); len(nodes) != 0 {
+		t.Errorf("empty ring gave %v", nodes)
+	}
+}
+
+func TestRanges(t *testing.T) {
+	h := NewHashRing(50)
+	h.AddNode("a")
+	h.AddNode("b")
+
+	ranges := h.Ranges()
+	if len(ranges) != 100 {
+		t.Fatalf("got %d ranges, want 100", len(ranges))
+	}
+	var total uint64
+	for _, r := range ranges {
+		total += r.Size()
+	}
+	if total != 1<<32 {
+		t.Errorf("ranges cover %d tokens, want the whole ring", total)
+	}
+
+	for i := range 1000 {
+		key := fmt.Sprintf("key-%d", i)
+		token := h.Token(key)
+		idx := Find(ranges, token)
+		if idx < 0 {
+			t.Fatalf("no range holds %q", key)
+		}
+		if got := h.RangeReplicas(ranges[idx], 2); got[0] != h.GetNode(key) || !slices.Equal(got, h.PreferenceList(key, 2)) {
+			t.Fatalf("range of %q has replicas %v, key has %v", key, got, h.PreferenceList(key, 2))
+		}
+
+		// Split parts line up with Slot, and with Find once sorted by End.
+		parts := ranges[idx].Split(8)
+		slot := ranges[idx].Slot(token, 8)
+		sorted := slices.SortedFunc(slices.Values(parts), func(a, b Range) int { return cmp.Compare(a.End, b.End) })
+		if !parts[slot].Contains(token) || sorted[Find(sorted, token)] != parts[slot] {
+			t.Fatalf("token %d: slot %d of %v", token, slot, parts)
+		}
+	}
+
+	whole := Range{Start: 7, End: 7}
+	if !whole.Contains(7) || !whole.Contains(0) || whole.Size() != 1<<32 {
+		t.Error("a range with Start == End should be the whole ring")
+	}
+}
+
+func TestWeightedLoad(t *testing.T) {
+	// Say two small machines, one twice their size and one four times.
+	weights := map[string]int{"10.0.0.1:7000": 1, "10.0.0.2:7000": 1, "10.0.0.3:7000": 2, "10.0.0.4:7000": 4}
+	h := NewHashRing(150, WithHash(Murmur3))
+	for addr, weight := range weights {
+		h.AddNodeWithWeight(addr, weight)
+	}
+
+	const keys = 100_000
+	counts := make(map[string]int)
+	for i := range keys {
+		counts[h.GetNode(fmt.Sprintf("user:%d", i))]++
+	}
+	for addr, weight := range weights {
+		share, fair := float64(counts[addr])/keys, float64(weight)/8
+		t.Logf("%s weight %d: %.1f%% of the keys, %.1f%% fair", addr, weight, share*100, fair*100)
+		if share < fair*0.85 || share > fair*1.15 {
+			t.Errorf("%s of weight %d holds %.1f%% of the keys, want about %.1f%%", addr, weight, share*100, fair*100)
+		}
+		if got := h.VirtualNodes()[addr]; got != 150*weight {
+			t.Errorf("%s of weight %d has %d virtual nodes", addr, weight, got)
+		}
+	}
+}
+
+func TestSetWeight(t *testing.T) {
+	h := NewHashRing(50)
+	for _, addr := range []string{"a", "b", "c"} {
+		h.AddNode(addr)
+	}
+	owners := func() map[string]string {
+		m := make(map[string]string)
+		for i := range 10_000 {
+			key := fmt.Sprint("key-", i)
+			m[key] = h.GetNode(key)
+		}
+		return m
+	}
+	before := owners()
+
+	// Doubling a's weight only moves keys to a, about a sixth of them
+	// (from a third to half).
+	if !h.SetWeight("a", 2) || h.Weight("a") != 2 {
+		t.Fatal("SetWeight did not take")
+	}
+	moved := 0
+	for key, owner := range owners() {
+		if owner != before[key] {
+			moved++
+			if owner != "a" {
+				t.Fatalf("%q moved from %s to %s, not to a", key, before[key], owner)
+			}
+		}
+	}
+	if moved < 1000 || moved > 2500 {
+		t.Errorf("%d of 10000 keys moved, want about 1667", moved)
+	}
+
+	// And back, as if it never happened.
+	h.AddNodeWithWeight("a", 0)
+	if h.Weight("a") != 1 || !maps.Equal(owners(), before) {
+		t.Error("back to weight 1, keys are not where they were")
+	}
+	if h.SetWeight("d", 2) || h.Weight("d") != 0 {
+		t.Error("SetWeight added a node that was not in the ring")
+	}
+	if h.SetWeight("a", 1<<40); h.Weight("a") != MaxWeight {
+		t.Errorf("weight 1<<40 gave %d, want it capped at %d", h.Weight("a"), MaxWeight)
+	}
+}
+
+func TestCollidingVirtualNodes(t *testing.T) {
+	// The first virtual nodes of a and b, and the key k, all hash to 42.
+	collide := func(data []byte) uint32 {
+		switch string(data) {
+		case "a-0", "b-0", "k":
+			return 42
+		}
+		return Murmur3(data)
+	}
+
+	for _, order := range [][]string{{"a", "b"}, {"b", "a"}} {
+		h := NewHashRing(2, WithHash(collide))
+		for _, addr := range order {
+			h.AddNode(addr)
+		}
+		// The smaller address holds the position, whoever came first, and
+		// it is on the ring once.
+		if got := h.GetNode("k"); got != "a" {
+			t.Errorf("added %v: k on %s, want a", order, got)
+		}
+		if counts := h.VirtualNodes(); counts["a"] != 2 || counts["b"] != 1 || len(h.hashes) != 3 {
+			t.Errorf("added %v: virtual nodes %v on %d positions, want a:2 b:1 on 3", order, counts, len(h.hashes))
+		}
+
+		// Without a, b gets the position back.
+		h.RemoveNode("a")
+		if got := h.GetNode("k"); got != "b" || h.VirtualNodes()["b"] != 2 {
+			t.Errorf("added %v, removed a: k on %s, virtual nodes %v", order, got, h.VirtualNodes())
+		}
+	}
+
+	// Weight changes settle collisions the same way.
+	h := NewHashRing(1, WithHash(collide))
+	h.AddNode("b")
+	h.AddNodeWithWeight("a", 2)
+	h.SetWeight("b", 2)
+	if got := h.GetNode("k"); got != "a" {
+		t.Errorf("k on %s, want a", got)
+	}
+	h.SetWeight("a", 1)
+	h.SetWeight("b", 1)
+	if got := h.GetNode("k"); got != "a" {
+		t.Errorf("back to weight 1, k on %s, want a", got)
+	}
+}
@@ -0,0 +1,15 @@
+package consistent
+
+// -------- Ring Options --------
+// Optional settings passed to NewHashRing, e.g.
+//
+//	consistent.NewHashRing(150, consistent.WithHash(consistent.XXHash))
+
+// Option configures a HashRing.
+type Option func(*HashRing)
+
+// WithHash places virtual nodes and keys with fn instead of crc32 (see
+// hash.go).
+func WithHash(fn HashFunc) Option {
+	return func(h *HashRing) { h.hash = fn }
+}
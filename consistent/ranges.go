@@ -0,0 +1,142 @@
+package consistent
+
+import "sort"
+
+// -------- Replicas & Token Ranges --------
+// A key's token is its position on the ring. Walking clockwise from the
+// token, the first distinct real nodes met form the key's preference list:
+// the first one is the owner GetNode returns, the next ones keep replicas.
+//
+// The virtual nodes cut the ring into token ranges, each ending at (and
+// owned by) one virtual node. Every key in a range has the same preference
+// list, which makes ranges the unit replicas compare their data in.
+
+// Token returns the ring position of key, using the same hash as GetNode.
+func (h *HashRing) Token(key string) uint32 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.hash([]byte(key))
+}
+
+// PreferenceList returns up to n distinct nodes for key, owner first.
+// It returns fewer if the ring has fewer nodes.
+func (h *HashRing) PreferenceList(key string, n int) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.successors(h.hash([]byte(key)), n)
+}
+
+// RangeReplicas returns the preference list shared by every key in r.
+func (h *HashRing) RangeReplicas(r Range, n int) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.successors(r.End, n)
+}
+
+// successors walks clockwise from token and returns the first n distinct
+// nodes. The caller must hold h.mu.
+func (h *HashRing) successors(token uint32, n int) []string {
+	if len(h.hashes) == 0 {
+		return nil
+	}
+
+	idx := sort.Search(len(h.hashes), func(i int) bool {
+		return h.hashes[i] >= int(token)
+	})
+
+	nodes := []string{}
+	seen := make(map[string]bool)
+	for i := 0; i < len(h.hashes) && len(nodes) < n; i++ {
+		addr := h.ring[h.hashes[(idx+i)%len(h.hashes)]]
+		if !seen[addr] {
+			seen[addr] = true
+			nodes = append(nodes, addr)
+		}
+	}
+	return nodes
+}
+
+// Range is the arc of the ring after Start up to and including End.
+// Start == End means the whole ring.
+type Range struct {
+	Start, End uint32
+}
+
+// Ranges returns the ring's token ranges, one per distinct virtual node,
+// sorted by End.
+func (h *HashRing) Ranges() []Range {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var positions []uint32
+	for _, hash := range h.hashes {
+		if n := len(positions); n == 0 || positions[n-1] != uint32(hash) {
+			positions = append(positions, uint32(hash))
+		}
+	}
+
+	ranges := make([]Range, len(positions))
+	for i, end := range positions {
+		ranges[i] = Range{Start: positions[(i+len(positions)-1)%len(positions)], End: end}
+	}
+	return ranges
+}
+
+// Size returns how many tokens r holds.
+func (r Range) Size() uint64 {
+	if d := r.End - r.Start; d != 0 {
+		return uint64(d)
+	}
+	return 1 << 32
+}
+
+// offset returns how far after Start token lies, from 1 for the first
+// token of the range to size for End.
+func (r Range) offset(token uint32) uint64 {
+	if d := token - r.Start; d != 0 {
+		return uint64(d)
+	}
+	return 1 << 32
+}
+
+// Contains reports whether token lies in r.
+func (r Range) Contains(token uint32) bool {
+	return r.offset(token) <= r.Size()
+}
+
+// Split cuts r into n ranges of (nearly) equal size, in ring order. n must
+// not exceed r.Size(), or some parts would be empty.
+func (r Range) Split(n int) []Range {
+	parts := make([]Range, n)
+	start := r.Start
+	for i := range parts {
+		end := r.Start + uint32(r.Size()*uint64(i+1)/uint64(n))
+		parts[i] = Range{Start: start, End: end}
+		start = end
+	}
+	return parts
+}
+
+// Slot returns which of r.Split(n) holds token, which must lie in r.
+func (r Range) Slot(token uint32, n int) int {
+	return int((r.offset(token)*uint64(n)+r.Size()-1)/r.Size()) - 1
+}
+
+// Find returns the index of the range holding token, or -1 if none does.
+// The ranges must not overlap and must be sorted by End, like the ones
+// Ranges returns or any subset of them.
+func Find(ranges []Range, token uint32) int {
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].End >= token })
+	if i < len(ranges) && ranges[i].Contains(token) {
+		return i
+	}
+	// Past the last End, token can only be in a range wrapping around
+	// zero, which has the lowest End.
+	if len(ranges) > 0 && ranges[0].Contains(token) {
+		return 0
+	}
+	return -1
+}
@@ -1,6 +1,8 @@
 package discovery
 
 import (
+	"log/slog"
+	"sort"
 	"sync"
 	"time"
 )
@@ -17,6 +19,20 @@ const (
 	StatusDead
 )
 
+// String returns the status in lowercase, e.g. "suspect".
+func (s NodeStatus) String() string {
+	switch s {
+	case StatusAlive:
+		return "alive"
+	case StatusSuspect:
+		return "suspect"
+	case StatusDead:
+		return "dead"
+	default:
+		return "unknown"
+	}
+}
+
 // Node holds metadata about a single cache node in the cluster.
 //
 //	we received a heartbeat from it.
@@ -33,13 +49,36 @@ type Registry struct {
 	AddrNode map[string]Node
 	mu       sync.Mutex
 	timeOut  time.Duration
+	logger   *slog.Logger
+	notify   func(addr string, status NodeStatus) // See WithNotify
+}
+
+// event is a status change waiting to be passed to notify once the lock
+// is released.
+type event struct {
+	addr   string
+	status NodeStatus
+}
+
+// emit passes events to notify. The caller must not hold r.mu.
+func (r *Registry) emit(events []event) {
+	if r.notify == nil {
+		return
+	}
+	for _, e := range events {
+		r.notify(e.addr, e.status)
+	}
 }
 
 // NewRegistry creates a Registry that marks nodes dead after the given timeout.
-func NewRegistry(healthTimeout time.Duration) *Registry {
+func NewRegistry(healthTimeout time.Duration, opts ...Option) *Registry {
 	r := &Registry{
 		AddrNode: make(map[string]Node),
 		timeOut:  healthTimeout,
+		logger:   slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(r)
 	}
 
 	ticker := time.NewTicker(healthTimeout)
@@ -57,34 +96,51 @@ func NewRegistry(healthTimeout time.Duration) *Registry {
 // Register adds a new node to the cluster or updates an existing one's heartbeat.
 func (r *Registry) Register(addr string) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	var events []event
+	defer func() {
+		r.mu.Unlock()
+		r.emit(events)
+	}()
 
 	node, ok := r.AddrNode[addr]
 	if !ok {
+		r.logger.Info("node joined", "peer", addr)
 		r.AddrNode[addr] = Node{
 			Addr:       addr,
 			CurrStatus: StatusAlive,
 			LastHB:     time.Now(),
 		}
+		events = append(events, event{addr, StatusAlive})
 	} else {
 		node.LastHB = time.Now()
 		r.AddrNode[addr] = node
 	}
 }
 
-// Heartbeat updates the last-seen time for a node.
+// Heartbeat updates the last-seen time for a node. A suspect node is alive
+// again, and one already removed as dead is added back: it answered after
+// all.
 func (r *Registry) Heartbeat(addr string) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	var events []event
+	defer func() {
+		r.mu.Unlock()
+		r.emit(events)
+	}()
 
 	node, ok := r.AddrNode[addr]
 	if !ok {
+		r.logger.Info("node recovered", "peer", addr)
+		r.AddrNode[addr] = Node{Addr: addr, CurrStatus: StatusAlive, LastHB: time.Now()}
+		events = append(events, event{addr, StatusAlive})
 		return
 	}
 
 	node.LastHB = time.Now()
 	if node.CurrStatus == StatusSuspect {
+		r.logger.Info("node recovered", "peer", addr)
 		node.CurrStatus = StatusAlive
+		events = append(events, event{addr, StatusAlive})
 	}
 
 	r.AddrNode[addr] = node
@@ -95,6 +151,9 @@ func (r *Registry) Unregister(addr string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	if _, ok := r.AddrNode[addr]; ok {
+		r.logger.Info("node left", "peer", addr)
+	}
 	delete(r.AddrNode, addr)
 }
 
@@ -112,22 +171,45 @@ func (r *Registry) AliveNodes() []string {
 	return addr
 }
 
+// Nodes returns a snapshot of every known node, sorted by address.
+func (r *Registry) Nodes() []Node {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	nodes := make([]Node, 0, len(r.AddrNode))
+	for _, node := range r.AddrNode {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Addr < nodes[j].Addr })
+	return nodes
+}
+
 // checkHealth iterates over all nodes and marks those with stale heartbeats
 // as StatusSuspect or StatusDead.
 //
 //	If last heartbeat > 2*timeout, mark StatusDead and remove.
 func (r *Registry) checkHealth() {
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	var events []event
+	defer func() {
+		r.mu.Unlock()
+		r.emit(events)
+	}()
 
 	for add, node := range r.AddrNode {
-		if time.Since(node.LastHB) > r.timeOut {
-			node.CurrStatus = StatusSuspect
-			r.AddrNode[add] = node
-		}
-		if time.Since(node.LastHB) > 2*r.timeOut {
+		since := time.Since(node.LastHB)
+		if since > 2*r.timeOut {
+			r.logger.Warn("node dead, removing it", "peer", add, "last_heartbeat", node.LastHB)
 			node.CurrStatus = StatusDead
 			delete(r.AddrNode, add)
+			events = append(events, event{add, StatusDead})
+		} else if since > r.timeOut {
+			if node.CurrStatus != StatusSuspect {
+				r.logger.Warn("node suspect", "peer", add, "last_heartbeat", node.LastHB)
+				events = append(events, event{add, StatusSuspect})
+			}
+			node.CurrStatus = StatusSuspect
+			r.AddrNode[add] = node
 		}
 	}
 }
@@ -0,0 +1,25 @@
+package discovery
+
+import "log/slog"
+
+// -------- Registry Options --------
+// Optional settings passed to NewRegistry, e.g.
+//
+//	discovery.NewRegistry(10*time.Second, discovery.WithLogger(logger))
+
+// Option configures a Registry.
+type Option func(*Registry)
+
+// WithNotify calls fn whenever a node changes status: when it joins or
+// recovers (StatusAlive), stops answering (StatusSuspect) or is removed
+// (StatusDead). fn runs without the registry's lock held, so it may call
+// back into the registry.
+func WithNotify(fn func(addr string, status NodeStatus)) Option {
+	return func(r *Registry) { r.notify = fn }
+}
+
+// WithLogger sets where the registry logs membership and health changes.
+// The default is slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(r *Registry) { r.logger = l }
+}
@@ -0,0 +1,95 @@
+package hlc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// -------- Hybrid Logical Clocks --------
+// Replicas order writes by timestamp, but wall clocks drift: a node whose
+// clock lags behind stamps its writes in the past, and a later write can
+// lose to an earlier one. A hybrid logical clock (Kulkarni et al., 2014)
+// keeps the wall time as the high part of the timestamp and adds a logical
+// counter below it:
+//
+//   - Now, for a local event (a write), takes the wall time if it moved
+//     past the last timestamp, or bumps the counter otherwise.
+//   - Update, for a timestamp received from another node, moves the clock
+//     past it.
+//
+// So timestamps never go backwards on one node, and anything a node does
+// after hearing of a write is stamped after it, however the clocks drift.
+// They also stay close to the wall time, which is what makes them usable
+// for a grace period.
+//
+// A Timestamp packs both parts into an int64, milliseconds since the Unix
+// epoch above a 16-bit counter, so timestamps compare as plain integers:
+//
+//	[48 bits wall time in ms][16 bits logical counter]
+
+// MaxDrift is how far ahead of the local clock a received timestamp may
+// be. Updates from further ahead are ignored, so one node with a broken
+// clock cannot drag the others into the future.
+const MaxDrift = time.Minute
+
+const logicalBits = 16
+
+// Timestamp is a hybrid logical clock reading. Zero means no timestamp.
+type Timestamp int64
+
+// New returns the timestamp for wall time t with the given counter.
+func New(t time.Time, logical uint16) Timestamp {
+	return Timestamp(t.UnixMilli()<<logicalBits | int64(logical))
+}
+
+// Wall returns the wall-clock part of t.
+func (t Timestamp) Wall() time.Time {
+	return time.UnixMilli(int64(t) >> logicalBits)
+}
+
+// Logical returns the counter part of t.
+func (t Timestamp) Logical() uint16 {
+	return uint16(t)
+}
+
+// String formats t as wall time and counter, e.g. "2024-05-01T10:00:00.123Z+2".
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%s+%d", t.Wall().UTC().Format("2006-01-02T15:04:05.000Z07:00"), t.Logical())
+}
+
+// Clock hands out timestamps. It is safe for concurrent use.
+type Clock struct {
+	now func() time.Time
+
+	mu   sync.Mutex
+	last Timestamp
+}
+
+// NewClock returns a clock reading the system time.
+func NewClock() *Clock {
+	return &Clock{now: time.Now}
+}
+
+// Now returns a timestamp for a local event, greater than every timestamp
+// the clock returned or was updated with before.
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.last = max(New(c.now(), 0), c.last+1)
+	return c.last
+}
+
+// Update moves the clock past a timestamp received from another node and
+// reports whether it did; timestamps more than MaxDrift ahead are ignored.
+func (c *Clock) Update(remote Timestamp) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if remote.Wall().Sub(c.now()) > MaxDrift {
+		return false
+	}
+	c.last = max(c.last, remote)
+	return true
+}
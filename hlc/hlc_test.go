@@ -0,0 +1,79 @@
+package hlc
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock returns a Clock whose wall time is *wall.
+func fakeClock(wall *time.Time) *Clock {
+	c := NewClock()
+	c.now = func() time.Time { return *wall }
+	return c
+}
+
+func TestPacking(t *testing.T) {
+	wall := time.UnixMilli(1700000000123)
+	ts := New(wall, 7)
+	if !ts.Wall().Equal(wall) || ts.Logical() != 7 {
+		t.Errorf("New(%v, 7) unpacks to %v, %d", wall, ts.Wall(), ts.Logical())
+	}
+	if New(wall, 65535) >= New(wall.Add(time.Millisecond), 0) {
+		t.Error("a later wall time should beat any counter")
+	}
+	if got := ts.String(); got != "2023-11-14T22:13:20.123Z+7" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestNowNeverGoesBack(t *testing.T) {
+	wall := time.UnixMilli(1700000000000)
+	c := fakeClock(&wall)
+
+	a := c.Now()
+	b := c.Now() // Same millisecond: the counter moves
+	if b <= a || b.Wall() != a.Wall() || b.Logical() != 1 {
+		t.Errorf("second reading %v after %v", b, a)
+	}
+
+	wall = wall.Add(-time.Second) // The system clock steps back
+	if d := c.Now(); d <= b {
+		t.Errorf("reading %v after %v when the wall clock went back", d, b)
+	}
+
+	wall = wall.Add(time.Hour)
+	if e := c.Now(); e != New(wall, 0) {
+		t.Errorf("reading %v, want the wall time once it is ahead again", e)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	wall := time.UnixMilli(1700000000000)
+	c := fakeClock(&wall)
+
+	// A node with a clock 10s ahead wrote something we have now seen:
+	// our next write must come after it.
+	remote := New(wall.Add(10*time.Second), 3)
+	if !c.Update(remote) {
+		t.Fatal("Update refused a timestamp within MaxDrift")
+	}
+	if ts := c.Now(); ts <= remote {
+		t.Errorf("Now() = %v after seeing %v", ts, remote)
+	}
+
+	// Older timestamps do not move the clock back.
+	before := c.Now()
+	c.Update(New(wall, 0))
+	if ts := c.Now(); ts <= before {
+		t.Errorf("Now() = %v after %v", ts, before)
+	}
+
+	// Timestamps from too far ahead are ignored.
+	far := New(wall.Add(MaxDrift+time.Second), 0)
+	if c.Update(far) {
+		t.Error("Update accepted a timestamp beyond MaxDrift")
+	}
+	if ts := c.Now(); ts >= far {
+		t.Errorf("Now() = %v jumped to the far timestamp", ts)
+	}
+}
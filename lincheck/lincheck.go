@@ -0,0 +1,231 @@
+package lincheck
+
+import (
+	"cmp"
+	"encoding/binary"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// -------- Linearizability Checking --------
+// A history of concurrent operations is linearizable if each operation
+// can be given a point in time, somewhere between its call and its
+// return, such that running them one at a time in that order, against a
+// sequential model of the system, gives the outputs the clients saw. It
+// is what Jepsen (through Knossos) checks a database's history for.
+//
+// Check searches for such an order as Wing & Gong (1993) did, with the
+// memoization Lowe (2017) added: walking the history in time order, it
+// tries to linearize an operation that was called, puts it on a stack and
+// starts over among those left; when it reaches an operation's return
+// before linearizing it, the last choice was wrong, so it backtracks. A
+// set of linearized operations and a model state that were seen together
+// before lead nowhere new and are skipped. The search is exponential in
+// the worst case, so histories should be partitioned (Model.Partition),
+// e.g. by key, when the model allows it.
+//
+// An operation whose outcome is unknown, say the client timed out, may
+// have taken effect at any point after its call, or never. It has no
+// return and a zero output, which the model should take as any output.
+
+// Operation is one call a client made and what it returned. Call and
+// Return are positions in a single order of events, as a Recorder
+// assigns them; Return is 0 if the call never returned.
+type Operation[I, O any] struct {
+	Client int
+	Input  I
+	Output O
+	Call   int64
+	Return int64
+}
+
+// Model is the sequential specification a history is checked against.
+type Model[S comparable, I, O any] struct {
+	// Init returns the state before any operation.
+	Init func() S
+	// Step applies input to state and reports whether the system could
+	// have returned output, and the state after.
+	Step func(state S, input I, output O) (bool, S)
+	// Partition, if set, splits a history into independent ones, each of
+	// which must be linearizable on its own.
+	Partition func(history []Operation[I, O]) [][]Operation[I, O]
+}
+
+// Check reports whether history is linearizable with respect to model.
+func Check[S comparable, I, O any](model Model[S, I, O], history []Operation[I, O]) bool {
+	parts := [][]Operation[I, O]{history}
+	if model.Partition != nil {
+		parts = model.Partition(history)
+	}
+	for _, part := range parts {
+		if !checkPart(model, part) {
+			return false
+		}
+	}
+	return true
+}
+
+// event is a call or a return in the doubly linked list Check walks.
+type event struct {
+	op         int // Index in the history
+	call       bool
+	match      *event // A call's return
+	prev, next *event
+}
+
+// lift takes a call and its return out of the list.
+func (e *event) lift() {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	r := e.match
+	r.prev.next = r.next
+	if r.next != nil {
+		r.next.prev = r.prev
+	}
+}
+
+// unlift puts them back, undoing the last lift.
+func (e *event) unlift() {
+	r := e.match
+	r.prev.next = r
+	if r.next != nil {
+		r.next.prev = r
+	}
+	e.prev.next = e
+	e.next.prev = e
+}
+
+// memoKey is a set of linearized operations and a model state.
+type memoKey[S comparable] struct {
+	linearized string
+	state      S
+}
+
+func checkPart[S comparable, I, O any](model Model[S, I, O], history []Operation[I, O]) bool {
+	// The events in time order, a call before its return.
+	type point struct {
+		at int64
+		ev *event
+	}
+	var points []point
+	for i, op := range history {
+		call := &event{op: i, call: true}
+		ret := &event{op: i}
+		call.match = ret
+		end := op.Return
+		if end == 0 {
+			end = math.MaxInt64
+		}
+		points = append(points, point{op.Call, call}, point{end, ret})
+	}
+	slices.SortStableFunc(points, func(a, b point) int {
+		if a.at != b.at {
+			return cmp.Compare(a.at, b.at)
+		}
+		// Calls first: overlapping operations may go in either order.
+		return boolInt(!a.ev.call) - boolInt(!b.ev.call)
+	})
+	head := &event{}
+	prev := head
+	for _, p := range points {
+		prev.next, p.ev.prev = p.ev, prev
+		prev = p.ev
+	}
+
+	type frame struct {
+		ev    *event
+		state S
+	}
+	var stack []frame
+	linearized := make([]uint64, (len(history)+63)/64)
+	seen := make(map[memoKey[S]]bool)
+	state := model.Init()
+
+	e := head.next
+	for head.next != nil {
+		if e.call {
+			op := history[e.op]
+			ok, next := model.Step(state, op.Input, op.Output)
+			if ok {
+				linearized[e.op/64] |= 1 << (e.op % 64)
+				key := memoKey[S]{bitsKey(linearized), next}
+				if !seen[key] {
+					seen[key] = true
+					stack = append(stack, frame{e, state})
+					state = next
+					e.lift()
+					e = head.next
+					continue
+				}
+				linearized[e.op/64] &^= 1 << (e.op % 64)
+			}
+			e = e.next
+			continue
+		}
+
+		// A return whose call is not linearized yet: backtrack.
+		if len(stack) == 0 {
+			return false
+		}
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		state = top.state
+		linearized[top.ev.op/64] &^= 1 << (top.ev.op % 64)
+		top.ev.unlift()
+		e = top.ev.next
+	}
+	return true
+}
+
+func bitsKey(bits []uint64) string {
+	b := make([]byte, 0, 8*len(bits))
+	for _, w := range bits {
+		b = binary.LittleEndian.AppendUint64(b, w)
+	}
+	return string(b)
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// -------- Recording --------
+
+// Recorder collects the history of concurrent clients. It is safe for
+// concurrent use.
+type Recorder[I, O any] struct {
+	clock atomic.Int64
+
+	mu  sync.Mutex
+	ops []Operation[I, O]
+}
+
+// Call records that client called with input, and returns the function to
+// call with the output once it returns. An operation whose function is
+// never called has an unknown outcome.
+func (r *Recorder[I, O]) Call(client int, input I) func(output O) {
+	at := r.clock.Add(1)
+	r.mu.Lock()
+	i := len(r.ops)
+	r.ops = append(r.ops, Operation[I, O]{Client: client, Input: input, Call: at})
+	r.mu.Unlock()
+
+	return func(output O) {
+		at := r.clock.Add(1)
+		r.mu.Lock()
+		r.ops[i].Output, r.ops[i].Return = output, at
+		r.mu.Unlock()
+	}
+}
+
+// History returns the operations recorded so far.
+func (r *Recorder[I, O]) History() []Operation[I, O] {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.ops)
+}
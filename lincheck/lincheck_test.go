@@ -0,0 +1,149 @@
+package lincheck
+
+import (
+	"sync"
+	"testing"
+)
+
+// A register holding an int, read and written by the clients.
+type regInput struct {
+	write bool
+	value int
+}
+
+type regOutput struct {
+	done  bool // Zero for an operation that never returned
+	value int
+}
+
+var register = Model[int, regInput, regOutput]{
+	Init: func() int { return 0 },
+	Step: func(state int, in regInput, out regOutput) (bool, int) {
+		if in.write {
+			return true, in.value
+		}
+		return !out.done || out.value == state, state
+	},
+}
+
+func write(client int, v int, call, ret int64) Operation[regInput, regOutput] {
+	return Operation[regInput, regOutput]{Client: client, Input: regInput{true, v}, Output: regOutput{done: ret != 0}, Call: call, Return: ret}
+}
+
+func read(client int, v int, call, ret int64) Operation[regInput, regOutput] {
+	return Operation[regInput, regOutput]{Client: client, Input: regInput{}, Output: regOutput{done: ret != 0, value: v}, Call: call, Return: ret}
+}
+
+func TestCheckRegister(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []Operation[regInput, regOutput]
+		want    bool
+	}{
+		{"empty", nil, true},
+		{"sequential", []Operation[regInput, regOutput]{
+			write(0, 1, 1, 2), read(1, 1, 3, 4), write(0, 2, 5, 6), read(1, 2, 7, 8),
+		}, true},
+		{"stale read after the write returned", []Operation[regInput, regOutput]{
+			write(0, 1, 1, 2), read(1, 0, 3, 4),
+		}, false},
+		{"concurrent read may see either value", []Operation[regInput, regOutput]{
+			write(0, 1, 1, 4), read(1, 0, 2, 3), read(2, 1, 2, 5),
+		}, true},
+		// Once one read saw the new value, a read that started after it
+		// returned cannot see the old one.
+		{"new then old", []Operation[regInput, regOutput]{
+			write(0, 1, 1, 10), read(1, 1, 2, 3), read(2, 0, 4, 5),
+		}, false},
+		{"unknown write may have happened", []Operation[regInput, regOutput]{
+			write(0, 1, 1, 0), read(1, 0, 2, 3), read(1, 1, 4, 5),
+		}, true},
+		{"unknown write may have happened not at all", []Operation[regInput, regOutput]{
+			write(0, 1, 1, 0), read(1, 0, 2, 3), read(1, 0, 4, 5),
+		}, true},
+		{"a value nobody wrote", []Operation[regInput, regOutput]{
+			write(0, 1, 1, 2), write(1, 2, 1, 3), read(2, 3, 4, 5),
+		}, false},
+	}
+	for _, tt := range tests {
+		if got := Check(register, tt.history); got != tt.want {
+			t.Errorf("%s: Check = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPartitioned(t *testing.T) {
+	// Two registers, keyed by Client%2 for the sake of the test: each
+	// history is fine on its own but not if they were one register.
+	keyed := register
+	keyed.Partition = func(history []Operation[regInput, regOutput]) [][]Operation[regInput, regOutput] {
+		parts := make([][]Operation[regInput, regOutput], 2)
+		for _, op := range history {
+			parts[op.Client%2] = append(parts[op.Client%2], op)
+		}
+		return parts
+	}
+	history := []Operation[regInput, regOutput]{
+		write(0, 1, 1, 2), write(1, 2, 3, 4), read(2, 1, 5, 6), read(3, 2, 5, 6),
+	}
+	if !Check(keyed, history) {
+		t.Error("independent registers reported not linearizable")
+	}
+	if Check(register, history) {
+		t.Error("one register holding two values at once reported linearizable")
+	}
+}
+
+// run has clients hammer a register through get and set, recording what
+// they see.
+func run(get func() int, set func(int)) []Operation[regInput, regOutput] {
+	var rec Recorder[regInput, regOutput]
+	var wg sync.WaitGroup
+	for client := range 4 {
+		wg.Go(func() {
+			for i := range 50 {
+				if i%3 == 0 {
+					v := client*100 + i
+					done := rec.Call(client, regInput{true, v})
+					set(v)
+					done(regOutput{done: true})
+				} else {
+					done := rec.Call(client, regInput{})
+					done(regOutput{done: true, value: get()})
+				}
+			}
+		})
+	}
+	wg.Wait()
+	return rec.History()
+}
+
+func TestRecordedHistories(t *testing.T) {
+	var mu sync.Mutex
+	var value int
+	history := run(
+		func() int { mu.Lock(); defer mu.Unlock(); return value },
+		func(v int) { mu.Lock(); defer mu.Unlock(); value = v },
+	)
+	if !Check(register, history) {
+		t.Error("a mutex-guarded register reported not linearizable")
+	}
+
+	// Every client reads its own copy, which only its own writes update.
+	var copies [4]int
+	var rec Recorder[regInput, regOutput]
+	for round := range 3 {
+		for client := range 4 {
+			done := rec.Call(client, regInput{true, round*10 + client})
+			copies[client] = round*10 + client
+			done(regOutput{done: true})
+		}
+		for client := range 4 {
+			done := rec.Call(client, regInput{})
+			done(regOutput{done: true, value: copies[client]})
+		}
+	}
+	if Check(register, rec.History()) {
+		t.Error("a register with stale per-client copies reported linearizable")
+	}
+}
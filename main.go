@@ -1,13 +1,30 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
+	"maps"
+	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/BiChong-Jin/distributed-cache/acl"
+	"github.com/BiChong-Jin/distributed-cache/cache"
+	"github.com/BiChong-Jin/distributed-cache/certs"
+	"github.com/BiChong-Jin/distributed-cache/client"
+	"github.com/BiChong-Jin/distributed-cache/consistent"
+	"github.com/BiChong-Jin/distributed-cache/protocol"
 	"github.com/BiChong-Jin/distributed-cache/server"
+	"github.com/BiChong-Jin/distributed-cache/tracing"
 )
 
 // -------- Entry Point --------
@@ -15,22 +32,167 @@ import (
 //   go run main.go -addr :7000
 //   go run main.go -addr :7001 -join :7000
 //   go run main.go -addr :7002 -join :7000
+//   go run main.go -addr :7003 -join :7000 -weight 4
+//   go run main.go -addr :7000 -acl acl.json
+//   go run main.go -addr :7000 -quotas quotas.json
+//   go run main.go -addr :7000 -otlp-endpoint localhost:4317 -otlp-insecure
+//   go run main.go -addr :7000 -tls-cert node.pem -tls-key node-key.pem -tls-ca ca.pem -mtls
+//   go run main.go -addr :7000 -raft :7000,:7001,:7002 -raft-dir /var/lib/dcache
+//   go run main.go -addr :7000 -raft :7000,:7001,:7002 -replicas 3 -strong flags,leases
+//
+// "go run main.go info" inspects running nodes instead, see runInfo.
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "info" {
+		os.Exit(runInfo(os.Args[2:]))
+	}
+
 	addr := flag.String("addr", ":7000", "listen address for this node")
 	join := flag.String("join", "", "address of an existing node to join the cluster")
+	respAddr := flag.String("resp", "", "optional listen address for Redis (RESP) clients, e.g. :6379")
+	memcachedAddr := flag.String("memcached", "", "optional listen address for memcached clients, e.g. :11211")
+	httpAddr := flag.String("http", "", "optional listen address for the HTTP/JSON gateway, e.g. :8080")
+	grpcAddr := flag.String("grpc", "", "optional listen address for the gRPC API, e.g. :9090")
+	metricsAddr := flag.String("metrics", "", "optional listen address for Prometheus metrics on /metrics, e.g. :9100")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate for TLS on every listener and peer link")
+	tlsKey := flag.String("tls-key", "", "PEM private key for -tls-cert")
+	tlsCA := flag.String("tls-ca", "", "PEM CA bundle used to verify peers and client certificates")
+	mtls := flag.Bool("mtls", false, "require clients and peers to present a certificate signed by -tls-ca")
+	aclFile := flag.String("acl", "", "JSON file with users, their permissions and the cluster token")
+	quotaFile := flag.String("quotas", "", `JSON file with per-namespace quotas, e.g. {"*": {"max_items": 10000, "max_bytes": 67108864}}`)
+	maxNamespaces := flag.Int("max-namespaces", server.DefaultMaxNamespaces, "how many namespaces clients may create besides those in -quotas (0: no limit)")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "log format: text or json")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/gRPC collector to send traces to, e.g. localhost:4317")
+	otlpInsecure := flag.Bool("otlp-insecure", false, "send traces to -otlp-endpoint without TLS")
+	replicas := flag.Int("replicas", 1, "number of nodes keeping a copy of each key")
+	weight := flag.Int("weight", 1, "this node's share of the keys relative to others, e.g. 4 on a machine with four times the memory")
+	ringHash := flag.String("hash", consistent.DefaultHash, "hash function placing keys on the ring: crc32, fnv1a, xxhash or murmur3; must match across the cluster")
+	readQuorum := flag.Int("read-quorum", 0, "replicas that must answer a read (0: a majority of -replicas)")
+	writeQuorum := flag.Int("write-quorum", 0, "replicas that must take a write (0: a majority of -replicas)")
+	antiEntropy := flag.Duration("anti-entropy", server.DefaultAntiEntropyInterval, "how often to compare data with the other replicas (0: never)")
+	raftVoters := flag.String("raft", "", "comma-separated nodes voting on the cluster metadata; enables Raft-backed membership")
+	raftDir := flag.String("raft-dir", "", "directory to keep this node's Raft state in (default: memory only)")
+	strongNamespaces := flag.String("strong", "", "comma-separated namespaces whose keys are linearizable by default; needs -raft")
+	tombstoneGrace := flag.Duration("tombstone-grace", cache.DefaultTombstoneGrace, "how long deleted keys are remembered so replicas cannot bring them back")
 	flag.Parse()
 
-	fmt.Printf("Starting cache node on %s\n", *addr)
-	if *join != "" {
-		fmt.Printf("Joining cluster via %s\n", *join)
+	logger, err := newLogger(*logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+	fatal := func(msg string, err error) {
+		logger.Error(msg, "err", err)
+		os.Exit(1)
+	}
+
+	opts := []server.Option{
+		server.WithLogger(logger),
+		server.WithReplicas(*replicas),
+		server.WithRingHash(*ringHash),
+		server.WithWeight(*weight),
+		server.WithQuorum(*readQuorum, *writeQuorum),
+		server.WithAntiEntropy(*antiEntropy),
+		server.WithTombstoneGrace(*tombstoneGrace),
+		server.WithMaxNamespaces(*maxNamespaces),
+	}
+	if *raftVoters != "" {
+		path := ""
+		if *raftDir != "" {
+			if err := os.MkdirAll(*raftDir, 0o700); err != nil {
+				fatal("cannot create -raft-dir", err)
+			}
+			path = filepath.Join(*raftDir, "meta.raft")
+		}
+		opts = append(opts, server.WithRaft(strings.Split(*raftVoters, ","), path))
+	}
+	if *strongNamespaces != "" {
+		if *raftVoters == "" {
+			fatal("bad flags", errors.New("-strong needs -raft"))
+		}
+		opts = append(opts, server.WithStrongNamespaces(strings.Split(*strongNamespaces, ",")...))
+	}
+	if consistent.Hash(*ringHash) == nil {
+		fatal("bad flags", fmt.Errorf("unknown -hash %q", *ringHash))
+	}
+	if *weight < 1 || *weight > consistent.MaxWeight {
+		fatal("bad flags", fmt.Errorf("-weight must be between 1 and %d", consistent.MaxWeight))
+	}
+	if *mtls && *tlsCA == "" {
+		fatal("bad flags", errors.New("-mtls needs -tls-ca to verify certificates"))
+	}
+	if *tlsCert != "" || *tlsCA != "" {
+		// Certificates are re-read when the files change, so they can be
+		// rotated without restarting the node.
+		reloader, err := certs.NewReloader(certs.Files{Cert: *tlsCert, Key: *tlsKey, CA: *tlsCA})
+		if err != nil {
+			fatal("cannot load certificates", err)
+		}
+		opts = append(opts, server.WithTLS(reloader))
+		if *mtls {
+			opts = append(opts, server.WithMutualTLS())
+		}
+	}
+
+	if *aclFile != "" {
+		rules, err := acl.Load(*aclFile)
+		if err != nil {
+			fatal("cannot load ACL", err)
+		}
+		opts = append(opts, server.WithACL(rules))
 	}
 
-	s := server.NewServer(*addr)
+	if *quotaFile != "" {
+		data, err := os.ReadFile(*quotaFile)
+		var quotas map[string]cache.Quota
+		if err == nil {
+			err = json.Unmarshal(data, &quotas)
+		}
+		if err != nil {
+			fatal("cannot load quotas", err)
+		}
+		opts = append(opts, server.WithQuotas(quotas))
+	}
+
+	if *otlpEndpoint != "" {
+		shutdown, err := tracing.SetupOTLP(context.Background(), *otlpEndpoint, *otlpInsecure, "dcache", *addr)
+		if err != nil {
+			fatal("cannot set up tracing", err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdown(ctx); err != nil {
+				logger.Warn("cannot flush traces", "err", err)
+			}
+		}()
+	}
+
+	logger.Info("starting cache node", "addr", *addr, "version", server.Version)
+	s := server.NewServer(*addr, opts...)
 	if *join != "" {
 		s.JoinCluster(*join)
 	}
-	go s.Start()
+
+	// Each listener runs until Stop closes it; any other error is fatal.
+	serve := func(frontend string, start func(string) error, addr string) {
+		if addr == "" {
+			return
+		}
+		go func() {
+			if err := start(addr); err != nil && !errors.Is(err, net.ErrClosed) {
+				fatal("cannot serve "+frontend, err)
+			}
+		}()
+	}
+	serve("tcp", func(string) error { return s.Start() }, *addr)
+	serve("resp", s.StartRESP, *respAddr)
+	serve("memcached", s.StartMemcached, *memcachedAddr)
+	serve("http", s.StartHTTP, *httpAddr)
+	serve("grpc", s.StartGRPC, *grpcAddr)
+	serve("metrics", s.StartMetrics, *metricsAddr)
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -38,3 +200,114 @@ func main() {
 	<-sigCh
 	s.Stop()
 }
+
+// newLogger builds the node's logger from the -log-level and -log-format flags.
+func newLogger(level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("-log-level: %w", err)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("-log-format: unknown format %q", format)
+	}
+}
+
+// -------- info Subcommand --------
+// Prints a snapshot of one node or of the whole ring:
+//   go run main.go info -addr :7000
+//   go run main.go info -addr :7000 -node 10.0.0.2:7000
+//   go run main.go info -addr :7000 -all -json
+
+func runInfo(args []string) int {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	addr := fs.String("addr", ":7000", "node to connect to")
+	node := fs.String("node", "", "ring member to describe, reached through -addr (default: -addr itself)")
+	all := fs.Bool("all", false, "describe every node in the ring")
+	asJSON := fs.Bool("json", false, "print JSON instead of text")
+	user := fs.String("user", "", "user to authenticate as")
+	password := fs.String("password", "", "password for -user")
+	token := fs.String("token", "", "access token to authenticate with")
+	tlsCA := fs.String("tls-ca", "", "PEM CA bundle to verify the node; enables TLS")
+	tlsCert := fs.String("tls-cert", "", "PEM client certificate for mutual TLS")
+	tlsKey := fs.String("tls-key", "", "PEM private key for -tls-cert")
+	fs.Parse(args)
+
+	var opts []client.Option
+	switch {
+	case *user != "":
+		opts = append(opts, client.WithAuth(*user, *password))
+	case *token != "":
+		opts = append(opts, client.WithToken(*token))
+	}
+	if *tlsCA != "" {
+		reloader, err := certs.NewReloader(certs.Files{Cert: *tlsCert, Key: *tlsKey, CA: *tlsCA})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		opts = append(opts, client.WithTLS(reloader))
+	}
+
+	c := client.NewClient(*addr, opts...)
+	defer c.Close()
+
+	var infos []*protocol.NodeInfo
+	var err error
+	if *all {
+		infos, err = c.InfoAll()
+	} else {
+		var info *protocol.NodeInfo
+		if info, err = c.Info(*node); err == nil {
+			infos = append(infos, info)
+		}
+	}
+
+	if *asJSON {
+		out, _ := json.MarshalIndent(infos, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		for _, info := range infos {
+			printInfo(info)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func printInfo(info *protocol.NodeInfo) {
+	uptime := time.Duration(info.UptimeSeconds * float64(time.Second)).Round(time.Second)
+	fmt.Printf("node %s (version %s, up %s)\n", info.Node, info.Version, uptime)
+	fmt.Printf("  items %d in %d namespaces, %d bytes stored, %d bytes of heap\n",
+		info.Items, info.Namespaces, info.MemoryBytes, info.HeapBytes)
+	fmt.Printf("  hits %d, misses %d, hit ratio %.1f%%\n", info.Hits, info.Misses, 100*info.HitRatio)
+	fmt.Printf("  connected clients %d\n", info.ConnectedClients)
+	fmt.Printf("  ring (%s):\n", info.RingHash)
+	for _, m := range info.Ring {
+		fmt.Printf("    %s (weight %d, %d virtual nodes)\n", m.Addr, m.Weight, m.VirtualNodes)
+	}
+	fmt.Println("  peers:")
+	for _, p := range info.Peers {
+		fmt.Printf("    %s %s, last heartbeat %s ago\n", p.Addr, p.Status, time.Since(p.LastHeartbeat).Round(time.Second))
+	}
+	if r := info.Raft; r != nil {
+		fmt.Printf("  raft: %s in term %d, leader %q, applied %d\n", r.Role, r.Term, r.Leader, r.Applied)
+	}
+	if len(info.Shards) > 0 {
+		fmt.Println("  strong shards:")
+		for _, name := range slices.Sorted(maps.Keys(info.Shards)) {
+			r := info.Shards[name]
+			fmt.Printf("    %s: %s in term %d, leader %q, applied %d\n", name, r.Role, r.Term, r.Leader, r.Applied)
+		}
+	}
+	fmt.Println()
+}
@@ -0,0 +1,149 @@
+package merkle
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+)
+
+// -------- Merkle Trees --------
+// Two nodes holding the same keys want to find out where their copies
+// differ without sending each other every key. Each builds a tree over the
+// same token range: the range is cut into 2^depth leaves, a leaf hashes the
+// keys (and write stamps) that fall into it, and every inner node hashes
+// its two children. Equal roots mean equal data. Otherwise the nodes walk
+// down only where hashes differ, which narrows the difference to a few
+// leaves and so to a few keys.
+//
+//	t := merkle.New(6)
+//	t.Add(leaf, key, stamp) // for every key in the range
+//	leaves := merkle.Diff(t, theirs)
+//
+// A leaf's hash is the sum of its keys' hashes, so keys can be added in
+// any order.
+
+// MaxDepth bounds the depth of a tree, and so its size, to something
+// reasonable to send over the network.
+const MaxDepth = 16
+
+var errBadTree = errors.New("merkle: malformed tree")
+
+// Tree is a Merkle tree of a fixed depth. The nodes are stored level by
+// level: the root at 0 and the children of node i at 2i+1 and 2i+2.
+type Tree struct {
+	depth int
+	nodes []uint64
+	dirty bool // Leaves changed since the inner nodes were computed
+}
+
+// New returns an empty tree with 2^depth leaves.
+func New(depth int) *Tree {
+	depth = min(max(depth, 0), MaxDepth)
+	return &Tree{depth: depth, nodes: make([]uint64, 2<<depth-1)}
+}
+
+// Depth returns the tree's depth; it has 2^Depth leaves.
+func (t *Tree) Depth() int { return t.depth }
+
+// Leaves returns the number of leaves.
+func (t *Tree) Leaves() int { return 1 << t.depth }
+
+// Add hashes a key and its stamp into a leaf.
+func (t *Tree) Add(leaf int, key string, stamp int64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(stamp)))
+	t.nodes[t.Leaves()-1+leaf] += h.Sum64()
+	t.dirty = true
+}
+
+// Root returns the hash of the whole tree.
+func (t *Tree) Root() uint64 {
+	t.seal()
+	return t.nodes[0]
+}
+
+// seal computes the inner nodes from the leaves. Empty subtrees hash to 0.
+func (t *Tree) seal() {
+	if !t.dirty {
+		return
+	}
+	for i := t.Leaves() - 2; i >= 0; i-- {
+		left, right := t.nodes[2*i+1], t.nodes[2*i+2]
+		if left == 0 && right == 0 {
+			t.nodes[i] = 0
+			continue
+		}
+		h := fnv.New64a()
+		h.Write(binary.BigEndian.AppendUint64(nil, left))
+		h.Write(binary.BigEndian.AppendUint64(nil, right))
+		t.nodes[i] = h.Sum64()
+	}
+	t.dirty = false
+}
+
+// Diff returns the leaves where a and b differ, in order. Trees of
+// different depths are compared by their roots alone: if those differ,
+// every leaf of a is returned.
+func Diff(a, b *Tree) []int {
+	a.seal()
+	b.seal()
+	if a.depth != b.depth {
+		if a.nodes[0] == b.nodes[0] {
+			return nil
+		}
+		leaves := make([]int, a.Leaves())
+		for i := range leaves {
+			leaves[i] = i
+		}
+		return leaves
+	}
+
+	var leaves []int
+	var walk func(i int)
+	walk = func(i int) {
+		if a.nodes[i] == b.nodes[i] {
+			return
+		}
+		if first := a.Leaves() - 1; i >= first {
+			leaves = append(leaves, i-first)
+			return
+		}
+		walk(2*i + 1)
+		walk(2*i + 2)
+	}
+	walk(0)
+	return leaves
+}
+
+// -------- Serialization --------
+//
+//	[1 depth][8 node]...
+//
+// every node, level by level, big-endian.
+
+// Encode serializes a tree.
+func (t *Tree) Encode() []byte {
+	t.seal()
+	buf := make([]byte, 0, 1+8*len(t.nodes))
+	buf = append(buf, byte(t.depth))
+	for _, n := range t.nodes {
+		buf = binary.BigEndian.AppendUint64(buf, n)
+	}
+	return buf
+}
+
+// Decode deserializes a tree.
+func Decode(data []byte) (*Tree, error) {
+	if len(data) == 0 || int(data[0]) > MaxDepth {
+		return nil, errBadTree
+	}
+	t := New(int(data[0]))
+	if len(data) != 1+8*len(t.nodes) {
+		return nil, errBadTree
+	}
+	for i := range t.nodes {
+		t.nodes[i] = binary.BigEndian.Uint64(data[1+8*i:])
+	}
+	return t, nil
+}
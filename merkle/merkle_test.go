@@ -0,0 +1,74 @@
+package merkle
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func build(depth int, stamps map[string]int64) *Tree {
+	t := New(depth)
+	for key, stamp := range stamps {
+		t.Add(len(key)%t.Leaves(), key, stamp)
+	}
+	return t
+}
+
+func TestEqualTrees(t *testing.T) {
+	stamps := map[string]int64{}
+	for i := range 100 {
+		stamps[fmt.Sprintf("key-%d", i)] = int64(i)
+	}
+
+	a, b := build(4, stamps), build(4, stamps)
+	if a.Root() != b.Root() || a.Root() == 0 {
+		t.Errorf("roots %x and %x of the same keys", a.Root(), b.Root())
+	}
+	if d := Diff(a, b); len(d) != 0 {
+		t.Errorf("Diff of equal trees = %v", d)
+	}
+	if New(4).Root() != 0 {
+		t.Error("an empty tree should hash to 0")
+	}
+}
+
+func TestDiffFindsChangedLeaves(t *testing.T) {
+	a := New(6)
+	b := New(6)
+	for i := range 1000 {
+		key := fmt.Sprintf("key-%d", i)
+		a.Add(i%64, key, 1)
+		b.Add(i%64, key, 1)
+	}
+
+	b.Add(5, "extra", 1)  // A key only b has
+	a.Add(40, "key-x", 1) // Same key,
+	b.Add(40, "key-x", 2) // different stamps
+	if d := Diff(a, b); !slices.Equal(d, []int{5, 40}) {
+		t.Errorf("Diff = %v, want [5 40]", d)
+	}
+
+	// Trees of different depths can only tell whether anything differs.
+	if d := Diff(a, New(2)); len(d) != 64 {
+		t.Errorf("Diff against a shallower tree gave %d leaves, want all 64", len(d))
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	a := New(3)
+	a.Add(2, "k", 7)
+
+	b, err := Decode(a.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Depth() != 3 || b.Root() != a.Root() || len(Diff(a, b)) != 0 {
+		t.Error("the tree did not survive encoding")
+	}
+
+	for _, data := range [][]byte{nil, {3, 0, 0}, {MaxDepth + 1}} {
+		if _, err := Decode(data); err == nil {
+			t.Errorf("Decode(%v) succeeded", data)
+		}
+	}
+}
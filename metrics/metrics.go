@@ -0,0 +1,312 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// -------- Prometheus Metrics --------
+// A small registry that writes metrics in the Prometheus text format
+// (version 0.0.4), so any Prometheus server can scrape a node without
+// pulling in the client library.
+//
+//	reg := metrics.NewRegistry()
+//	requests := reg.Counter("requests_total", "Requests served.", "command")
+//	requests.Inc("get")
+//	http.Handle("/metrics", reg)
+//
+// Counters and histograms are updated as things happen. Values the program
+// already keeps elsewhere, like the cache's hit counts, are read at scrape
+// time with Func instead of being copied into counters.
+//
+// Label values are passed in the order the labels were declared; passing
+// the wrong number of them is a programming error and panics.
+
+// Kind is the Prometheus type of a metric.
+type Kind string
+
+const (
+	KindCounter   Kind = "counter"
+	KindGauge     Kind = "gauge"
+	KindHistogram Kind = "histogram"
+)
+
+// LatencyBuckets are histogram bounds in seconds suited to cache requests,
+// from 100µs to 2.5s.
+var LatencyBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// Registry holds metrics and writes them out in registration order.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// metric is implemented by every kind of metric in a Registry.
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// desc is what every metric has: a name, a help text and its label names.
+type desc struct {
+	name   string
+	help   string
+	kind   Kind
+	labels []string
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) add(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// -------- Counters --------
+
+// Counter is a value that only goes up, kept per combination of labels.
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labels []string
+	value  float64
+}
+
+// Counter registers a new counter with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name, help, KindCounter, labels}, series: make(map[string]*counterSeries)}
+	r.add(c)
+	return c
+}
+
+// Inc adds 1 to the counter for the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter for the given
+// label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := c.key(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{labels: labelValues}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.header(w)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		c.sample(w, "", s.labels, "", s.value)
+	}
+}
+
+// -------- Histograms --------
+
+// Histogram counts observations into buckets, per combination of labels.
+type Histogram struct {
+	desc
+	buckets []float64 // Upper bounds, ascending
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64 // One per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// Histogram registers a new histogram with the given bucket upper bounds,
+// which must be sorted, and label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name, help, KindHistogram, labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	r.add(h)
+	return h
+}
+
+// Observe records one value for the given label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+	i := sort.SearchFloat64s(h.buckets, v) // First bucket with bound >= v
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labels: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.header(w)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			h.sample(w, "_bucket", s.labels, formatValue(bound), float64(cumulative))
+		}
+		h.sample(w, "_bucket", s.labels, "+Inf", float64(s.count))
+		h.sample(w, "_sum", s.labels, "", s.sum)
+		h.sample(w, "_count", s.labels, "", float64(s.count))
+	}
+}
+
+// -------- Scrape-Time Metrics --------
+
+// funcMetric is read when the registry is written out.
+type funcMetric struct {
+	desc
+	collect func(emit func(value float64, labelValues ...string))
+}
+
+// Func registers a counter or gauge whose samples are produced by collect
+// on every scrape. collect calls emit once per combination of labels.
+func (r *Registry) Func(name, help string, kind Kind, labels []string, collect func(emit func(value float64, labelValues ...string))) {
+	r.add(&funcMetric{desc: desc{name, help, kind, labels}, collect: collect})
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	f.header(w)
+	f.collect(func(value float64, labelValues ...string) {
+		f.key(labelValues)
+		f.sample(w, "", labelValues, "", value)
+	})
+}
+
+// -------- Exposition --------
+
+// WriteTo writes every metric in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP makes a Registry usable as the /metrics handler.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// key checks the number of label values and joins them into a map key.
+func (d *desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", d.name, len(d.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+func (d *desc) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, helpEscaper.Replace(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+// sample writes one line: the name with suffix, the labels (plus le for
+// histogram buckets) and the value.
+func (d *desc) sample(w *bufio.Writer, suffix string, labelValues []string, le string, value float64) {
+	w.WriteString(d.name)
+	w.WriteString(suffix)
+	if len(labelValues) > 0 || le != "" {
+		w.WriteByte('{')
+		for i, v := range labelValues {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", d.labels[i], labelEscaper.Replace(v))
+		}
+		if le != "" {
+			if len(labelValues) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "le=\"%s\"", le)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatValue(value))
+	w.WriteByte('\n')
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.Counter("requests_total", "Requests served.", "command")
+	latency := reg.Histogram("latency_seconds", "Request latency.", []float64{0.1, 1}, "command")
+	reg.Func("items", "Items stored.", KindGauge, []string{"namespace"}, func(emit func(float64, ...string)) {
+		emit(3, "")
+		emit(1, `odd "ns"`)
+	})
+
+	requests.Inc("set")
+	requests.Add(2, "get")
+	latency.Observe(0.05, "get")
+	latency.Observe(0.5, "get")
+	latency.Observe(5, "get")
+
+	var out strings.Builder
+	if _, err := reg.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{command="get"} 2
+requests_total{command="set"} 1
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{command="get",le="0.1"} 1
+latency_seconds_bucket{command="get",le="1"} 2
+latency_seconds_bucket{command="get",le="+Inf"} 3
+latency_seconds_sum{command="get"} 5.55
+latency_seconds_count{command="get"} 3
+# HELP items Items stored.
+# TYPE items gauge
+items{namespace=""} 3
+items{namespace="odd \"ns\""} 1
+`
+	if out.String() != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	reg := NewRegistry()
+	reg.Counter("up_total", "Scrapes.").Inc()
+
+	rec := httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "\nup_total 1\n") {
+		t.Errorf("missing sample in:\n%s", rec.Body.String())
+	}
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a missing label value")
+		}
+	}()
+	NewRegistry().Counter("x_total", "X.", "a", "b").Inc("only-a")
+}
@@ -0,0 +1,464 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/hlc"
+)
+
+// -------- Binary Codec --------
+// A hand-rolled layout that avoids gob's per-message type descriptors.
+// All integers are big-endian.
+//
+// Request:
+//
+//	[1 version][1 cmd][2 key len][key][4 val len][value][8 ttl][fields...]
+//
+// Response:
+//
+//	[1 version][1 status][4 val len][value][4 err len][error message][fields...]
+//
+// The fixed header carries what every message needs. The less common
+// fields follow as optional tagged fields, [1 tag][4 len][data], and are
+// only written when they are set. Decoders skip tags they do not know,
+// so new fields can be added without breaking older nodes.
+
+// BinaryVersion is the first byte of every binary-encoded message.
+const BinaryVersion byte = 0x81
+
+var (
+	errShortMessage = errors.New("protocol: message truncated")
+	errKeyTooLong   = errors.New("protocol: key longer than 65535 bytes")
+	errBadVersion   = errors.New("protocol: unsupported binary version")
+)
+
+// Optional request field tags.
+const (
+	reqTagField byte = iota + 1
+	reqTagFields
+	reqTagValues
+	reqTagStart
+	reqTagStop
+	reqTagScores
+	reqTagScore
+	reqTagMin
+	reqTagMax
+	reqTagInt
+	reqTagFlags
+	reqTagCAS
+	reqTagUser
+	reqTagNamespace
+	reqTagTraceParent
+	reqTagTraceState
+	reqTagTimeout
+	reqTagStamp
+	reqTagStrong
+)
+
+// Optional response field tags.
+const (
+	resTagMeta byte = iota + 1
+	resTagFields
+	resTagValues
+	resTagInt
+	resTagScores
+	resTagScore
+	resTagStamp
+)
+
+func (r *Request) encodeBinary() ([]byte, error) {
+	if len(r.Key) > math.MaxUint16 {
+		return nil, errKeyTooLong
+	}
+
+	e := &encoder{buf: make([]byte, 0, 16+len(r.Key)+len(r.Value))}
+	e.u8(BinaryVersion)
+	e.u8(byte(r.CommandType))
+	e.u16(uint16(len(r.Key)))
+	e.buf = append(e.buf, r.Key...)
+	e.bytes(r.Value)
+	e.u64(uint64(r.TTL))
+
+	if r.Field != "" {
+		e.tagged(reqTagField, func() { e.buf = append(e.buf, r.Field...) })
+	}
+	if len(r.Fields) > 0 {
+		e.tagged(reqTagFields, func() { e.strings(r.Fields) })
+	}
+	if len(r.Values) > 0 {
+		e.tagged(reqTagValues, func() { e.byteSlices(r.Values) })
+	}
+	if r.Start != 0 {
+		e.tagged(reqTagStart, func() { e.u64(uint64(int64(r.Start))) })
+	}
+	if r.Stop != 0 {
+		e.tagged(reqTagStop, func() { e.u64(uint64(int64(r.Stop))) })
+	}
+	if len(r.Scores) > 0 {
+		e.tagged(reqTagScores, func() { e.floats(r.Scores) })
+	}
+	if r.Score != 0 {
+		e.tagged(reqTagScore, func() { e.f64(r.Score) })
+	}
+	if r.Min != 0 {
+		e.tagged(reqTagMin, func() { e.f64(r.Min) })
+	}
+	if r.Max != 0 {
+		e.tagged(reqTagMax, func() { e.f64(r.Max) })
+	}
+	if r.Int != 0 {
+		e.tagged(reqTagInt, func() { e.u64(uint64(r.Int)) })
+	}
+	if r.Flags != 0 {
+		e.tagged(reqTagFlags, func() { e.u32(r.Flags) })
+	}
+	if r.CAS != 0 {
+		e.tagged(reqTagCAS, func() { e.u64(r.CAS) })
+	}
+	if r.User != "" {
+		e.tagged(reqTagUser, func() { e.buf = append(e.buf, r.User...) })
+	}
+	if r.Namespace != "" {
+		e.tagged(reqTagNamespace, func() { e.buf = append(e.buf, r.Namespace...) })
+	}
+	if r.TraceParent != "" {
+		e.tagged(reqTagTraceParent, func() { e.buf = append(e.buf, r.TraceParent...) })
+	}
+	if r.TraceState != "" {
+		e.tagged(reqTagTraceState, func() { e.buf = append(e.buf, r.TraceState...) })
+	}
+	if r.Timeout != 0 {
+		e.tagged(reqTagTimeout, func() { e.u64(uint64(r.Timeout)) })
+	}
+	if r.Stamp != 0 {
+		e.tagged(reqTagStamp, func() { e.u64(uint64(r.Stamp)) })
+	}
+	if r.Strong {
+		e.tagged(reqTagStrong, func() {})
+	}
+
+	return e.buf, nil
+}
+
+func decodeBinaryRequest(data []byte) (*Request, error) {
+	d := &decoder{data: data}
+	if d.u8() != BinaryVersion {
+		return nil, errBadVersion
+	}
+
+	req := &Request{}
+	req.CommandType = CommandType(d.u8())
+	req.Key = string(d.take(int(d.u16())))
+	req.Value = d.bytes()
+	req.TTL = time.Duration(d.u64())
+
+	for d.err == nil && len(d.data) > 0 {
+		tag, f := d.tagged()
+		switch tag {
+		case reqTagField:
+			req.Field = string(f.data)
+		case reqTagFields:
+			req.Fields = f.strings()
+		case reqTagValues:
+			req.Values = f.byteSlices()
+		case reqTagStart:
+			req.Start = int(int64(f.u64()))
+		case reqTagStop:
+			req.Stop = int(int64(f.u64()))
+		case reqTagScores:
+			req.Scores = f.floats()
+		case reqTagScore:
+			req.Score = f.f64()
+		case reqTagMin:
+			req.Min = f.f64()
+		case reqTagMax:
+			req.Max = f.f64()
+		case reqTagInt:
+			req.Int = int64(f.u64())
+		case reqTagFlags:
+			req.Flags = f.u32()
+		case reqTagCAS:
+			req.CAS = f.u64()
+		case reqTagUser:
+			req.User = string(f.data)
+		case reqTagNamespace:
+			req.Namespace = string(f.data)
+		case reqTagTraceParent:
+			req.TraceParent = string(f.data)
+		case reqTagTraceState:
+			req.TraceState = string(f.data)
+		case reqTagTimeout:
+			req.Timeout = time.Duration(f.u64())
+		case reqTagStamp:
+			req.Stamp = hlc.Timestamp(f.u64())
+		case reqTagStrong:
+			req.Strong = true
+		}
+		if f.err != nil {
+			return nil, f.err
+		}
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	return req, nil
+}
+
+func (r *Response) encodeBinary() ([]byte, error) {
+	e := &encoder{buf: make([]byte, 0, 16+len(r.Value)+len(r.ErrorMessage))}
+	e.u8(BinaryVersion)
+	e.u8(byte(r.StatusCode))
+	e.bytes(r.Value)
+	e.bytes([]byte(r.ErrorMessage))
+
+	if r.Meta != (Metadata{}) {
+		e.tagged(resTagMeta, func() {
+			var created int64
+			if !r.Meta.CreatedAt.IsZero() {
+				created = r.Meta.CreatedAt.UnixNano()
+			}
+			e.u64(uint64(created))
+			e.u64(uint64(r.Meta.TTL))
+			e.u64(r.Meta.Version)
+			e.u64(uint64(int64(r.Meta.Size)))
+			e.u32(r.Meta.Flags)
+		})
+	}
+	if len(r.Fields) > 0 {
+		e.tagged(resTagFields, func() { e.strings(r.Fields) })
+	}
+	if len(r.Values) > 0 {
+		e.tagged(resTagValues, func() { e.byteSlices(r.Values) })
+	}
+	if r.Int != 0 {
+		e.tagged(resTagInt, func() { e.u64(uint64(r.Int)) })
+	}
+	if len(r.Scores) > 0 {
+		e.tagged(resTagScores, func() { e.floats(r.Scores) })
+	}
+	if r.Score != 0 {
+		e.tagged(resTagScore, func() { e.f64(r.Score) })
+	}
+	if r.Meta.Stamp != 0 {
+		e.tagged(resTagStamp, func() { e.u64(uint64(r.Meta.Stamp)) })
+	}
+
+	return e.buf, nil
+}
+
+func decodeBinaryResponse(data []byte) (*Response, error) {
+	d := &decoder{data: data}
+	if d.u8() != BinaryVersion {
+		return nil, errBadVersion
+	}
+
+	res := &Response{}
+	res.StatusCode = StatusCode(d.u8())
+	res.Value = d.bytes()
+	res.ErrorMessage = string(d.bytes())
+
+	for d.err == nil && len(d.data) > 0 {
+		tag, f := d.tagged()
+		switch tag {
+		case resTagMeta:
+			if created := int64(f.u64()); created != 0 {
+				res.Meta.CreatedAt = time.Unix(0, created)
+			}
+			res.Meta.TTL = time.Duration(f.u64())
+			res.Meta.Version = f.u64()
+			res.Meta.Size = int(int64(f.u64()))
+			res.Meta.Flags = f.u32()
+		case resTagFields:
+			res.Fields = f.strings()
+		case resTagValues:
+			res.Values = f.byteSlices()
+		case resTagInt:
+			res.Int = int64(f.u64())
+		case resTagScores:
+			res.Scores = f.floats()
+		case resTagScore:
+			res.Score = f.f64()
+		case resTagStamp:
+			res.Meta.Stamp = hlc.Timestamp(f.u64())
+		}
+		if f.err != nil {
+			return nil, f.err
+		}
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	return res, nil
+}
+
+// -------- Encoding Helpers --------
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) u8(v byte)    { e.buf = append(e.buf, v) }
+func (e *encoder) u16(v uint16) { e.buf = binary.BigEndian.AppendUint16(e.buf, v) }
+func (e *encoder) u32(v uint32) { e.buf = binary.BigEndian.AppendUint32(e.buf, v) }
+func (e *encoder) u64(v uint64) { e.buf = binary.BigEndian.AppendUint64(e.buf, v) }
+
+func (e *encoder) f64(v float64) { e.u64(math.Float64bits(v)) }
+
+// bytes writes a 4-byte length followed by b.
+func (e *encoder) bytes(b []byte) {
+	e.u32(uint32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// tagged writes [tag][4 len] and then whatever write appends,
+// back-filling the length once it is known.
+func (e *encoder) tagged(tag byte, write func()) {
+	e.u8(tag)
+	at := len(e.buf)
+	e.u32(0)
+	write()
+	binary.BigEndian.PutUint32(e.buf[at:], uint32(len(e.buf)-at-4))
+}
+
+func (e *encoder) strings(ss []string) {
+	e.u32(uint32(len(ss)))
+	for _, s := range ss {
+		e.u32(uint32(len(s)))
+		e.buf = append(e.buf, s...)
+	}
+}
+
+func (e *encoder) byteSlices(bs [][]byte) {
+	e.u32(uint32(len(bs)))
+	for _, b := range bs {
+		e.bytes(b)
+	}
+}
+
+func (e *encoder) floats(fs []float64) {
+	e.u32(uint32(len(fs)))
+	for _, f := range fs {
+		e.f64(f)
+	}
+}
+
+// decoder reads from data, remembering the first error so callers can
+// check it once at the end instead of after every field.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+// take returns the next n bytes, or nil once the message is exhausted.
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data) {
+		d.err = errShortMessage
+		return nil
+	}
+	b := d.data[:n:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) u8() byte {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) u16() uint16 {
+	if b := d.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) u32() uint32 {
+	if b := d.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) u64() uint64 {
+	if b := d.take(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) f64() float64 { return math.Float64frombits(d.u64()) }
+
+// bytes reads a 4-byte length and that many bytes. A zero length gives nil
+// so that empty values round-trip the same way they do with gob.
+func (d *decoder) bytes() []byte {
+	b := d.take(int(d.u32()))
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+// tagged reads an optional field and returns its tag and a decoder over its data.
+func (d *decoder) tagged() (byte, *decoder) {
+	tag := d.u8()
+	data := d.take(int(d.u32()))
+	return tag, &decoder{data: data, err: d.err}
+}
+
+// count reads a list length, rejecting lengths that cannot possibly fit in
+// the remaining data (each element takes at least min bytes).
+// Empty lists decode as nil, matching what gob does.
+func (d *decoder) count(min int) int {
+	n := int(d.u32())
+	if d.err == nil && n > len(d.data)/min {
+		d.err = errShortMessage
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) strings() []string {
+	n := d.count(4)
+	if n == 0 {
+		return nil
+	}
+	ss := make([]string, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		ss = append(ss, string(d.take(int(d.u32()))))
+	}
+	return ss
+}
+
+func (d *decoder) byteSlices() [][]byte {
+	n := d.count(4)
+	if n == 0 {
+		return nil
+	}
+	bs := make([][]byte, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		bs = append(bs, d.bytes())
+	}
+	return bs
+}
+
+func (d *decoder) floats() []float64 {
+	n := d.count(8)
+	if n == 0 {
+		return nil
+	}
+	fs := make([]float64, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		fs = append(fs, d.f64())
+	}
+	return fs
+}
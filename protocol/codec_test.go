@@ -0,0 +1,242 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var sampleRequest = &Request{
+	CommandType: CmdZAdd,
+	Key:         "leaderboard",
+	Value:       []byte("value"),
+	TTL:         30 * time.Second,
+	Field:       "field",
+	Fields:      []string{"alice", "bob"},
+	Values:      [][]byte{[]byte("a"), []byte("b")},
+	Start:       -2,
+	Stop:        -1,
+	Scores:      []float64{1.5, -2},
+	Score:       3,
+	Min:         -10,
+	Max:         10,
+	Int:         -7,
+	Flags:       42,
+	CAS:         99,
+	User:        "tenant-a",
+	Namespace:   "team-a",
+	TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	TraceState:  "vendor=value",
+	Timeout:     250 * time.Millisecond,
+	Stamp:       1700000000123456789,
+	Strong:      true,
+}
+
+var sampleResponse = &Response{
+	StatusCode:   StatusOK,
+	Value:        []byte("hello"),
+	ErrorMessage: "oops",
+	Meta: Metadata{
+		CreatedAt: time.Unix(1700000000, 123),
+		TTL:       time.Minute,
+		Version:   5,
+		Stamp:     1700000000123456789,
+		Size:      5,
+		Flags:     1,
+	},
+	Fields: []string{"a"},
+	Values: [][]byte{[]byte("1")},
+	Int:    3,
+	Scores: []float64{0.5},
+	Score:  2.5,
+}
+
+func TestRequestRoundTrip(t *testing.T) {
+	for _, want := range []*Request{
+		sampleRequest,
+		{CommandType: CmdGet, Key: "k"},
+		{CommandType: CmdPing},
+	} {
+		data, err := want.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data[0] != BinaryVersion {
+			t.Fatalf("expected version byte %#x, got %#x", BinaryVersion, data[0])
+		}
+
+		got, err := DecodeRequest(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("round trip mismatch:\n got %+v\nwant %+v", got, want)
+		}
+	}
+}
+
+func TestResponseRoundTrip(t *testing.T) {
+	for _, want := range []*Response{
+		sampleResponse,
+		{StatusCode: StatusNotFound},
+	} {
+		data, err := want.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := DecodeResponse(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Meta.CreatedAt.Equal(want.Meta.CreatedAt) {
+			t.Fatalf("CreatedAt mismatch: got %v, want %v", got.Meta.CreatedAt, want.Meta.CreatedAt)
+		}
+		got.Meta.CreatedAt = want.Meta.CreatedAt
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("round trip mismatch:\n got %+v\nwant %+v", got, want)
+		}
+	}
+}
+
+func TestDecodeGob(t *testing.T) {
+	// Gob decodes when the connection agreed on it, and only then: the
+	// codec is never guessed from the message.
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&Request{CommandType: CmdSet, Key: "k", Value: []byte("v")}); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := DecodeRequestWith(buf.Bytes(), CodecGob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.CommandType != CmdSet || req.Key != "k" || string(req.Value) != "v" {
+		t.Fatalf("unexpected request: %+v", req)
+	}
+	if _, err := DecodeRequest(buf.Bytes()); !errors.Is(err, errBadVersion) {
+		t.Fatalf("decoding gob as binary: got %v, want %v", err, errBadVersion)
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	// Optional fields may legitimately end early, but cutting the fixed
+	// header (version, cmd, key, value, ttl) must be reported.
+	data, _ := sampleRequest.Encode()
+	header := 1 + 1 + 2 + len(sampleRequest.Key) + 4 + len(sampleRequest.Value) + 8
+	for i := 1; i < header; i++ {
+		if _, err := DecodeRequest(data[:i]); err == nil {
+			t.Fatalf("expected an error decoding %d of %d header bytes", i, header)
+		}
+	}
+
+	// Cutting anywhere else must never panic.
+	for i := header; i < len(data); i++ {
+		DecodeRequest(data[:i])
+	}
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	big := bytes.Repeat([]byte("x"), 100000)
+	WriteFrame(&buf, []byte("first"))
+	WriteFrame(&buf, big)
+
+	first, err := ReadFrame(&buf)
+	if err != nil || string(first) != "first" {
+		t.Fatalf("expected 'first', got %q (err=%v)", first, err)
+	}
+	second, err := ReadFrame(&buf)
+	if err != nil || !bytes.Equal(second, big) {
+		t.Fatalf("expected a %d byte frame, got %d bytes (err=%v)", len(big), len(second), err)
+	}
+}
+
+// -------- Fuzzing --------
+
+func FuzzDecodeRequest(f *testing.F) {
+	data, _ := sampleRequest.Encode()
+	f.Add(data)
+	data, _ = (&Request{CommandType: CmdGet, Key: "k"}).Encode()
+	f.Add(data)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) == 0 || data[0] != BinaryVersion {
+			return
+		}
+		req, err := DecodeRequest(data)
+		if err != nil {
+			return
+		}
+
+		// Anything we accept must survive a second round trip unchanged.
+		again, err := req.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req2, err := DecodeRequest(again)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(req, req2) {
+			t.Fatalf("unstable round trip:\n%+v\n%+v", req, req2)
+		}
+	})
+}
+
+func FuzzDecodeResponse(f *testing.F) {
+	data, _ := sampleResponse.Encode()
+	f.Add(data)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) == 0 || data[0] != BinaryVersion {
+			return
+		}
+		res, err := DecodeResponse(data)
+		if err != nil {
+			return
+		}
+		if _, err := res.Encode(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// -------- Benchmarks --------
+// go test ./protocol -bench . -benchmem
+
+func BenchmarkEncodeRequestBinary(b *testing.B) {
+	req := &Request{CommandType: CmdSet, Key: "user:1234", Value: bytes.Repeat([]byte("v"), 128), TTL: time.Minute}
+	for i := 0; i < b.N; i++ {
+		req.Encode()
+	}
+}
+
+func BenchmarkEncodeRequestGob(b *testing.B) {
+	req := &Request{CommandType: CmdSet, Key: "user:1234", Value: bytes.Repeat([]byte("v"), 128), TTL: time.Minute}
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		gob.NewEncoder(&buf).Encode(req)
+	}
+}
+
+func BenchmarkDecodeRequestBinary(b *testing.B) {
+	req := &Request{CommandType: CmdSet, Key: "user:1234", Value: bytes.Repeat([]byte("v"), 128), TTL: time.Minute}
+	data, _ := req.Encode()
+	for i := 0; i < b.N; i++ {
+		DecodeRequest(data)
+	}
+}
+
+func BenchmarkDecodeRequestGob(b *testing.B) {
+	req := &Request{CommandType: CmdSet, Key: "user:1234", Value: bytes.Repeat([]byte("v"), 128), TTL: time.Minute}
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(req)
+	data := buf.Bytes()
+	for i := 0; i < b.N; i++ {
+		DecodeRequestWith(data, CodecGob)
+	}
+}
@@ -0,0 +1,222 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// -------- Connections --------
+// A Conn is a network connection plus what the handshake settled on.
+// Clients and forwarding nodes keep Conns open and send many requests
+// over each one.
+
+// HandshakeTimeout bounds how long either side waits for the other's half
+// of the handshake.
+const HandshakeTimeout = 5 * time.Second
+
+// errNoHandshake means the peer hung up instead of answering a Hello,
+// which is what nodes from before the handshake do.
+var errNoHandshake = errors.New("protocol: peer does not support HELLO")
+
+// ErrNotSent wraps the errors RoundTrip returns when the request was not
+// written in full. The peer cannot have run it, so it is safe to send
+// again whatever the command.
+var ErrNotSent = errors.New("request not sent")
+
+// Conn is a connection whose protocol version and codec are known.
+type Conn struct {
+	net.Conn
+	Version  uint16
+	Codec    Codec
+	Features []string // Unknown if the peer skipped the handshake
+}
+
+// Dial connects with dial and runs the handshake. If the peer predates the
+// handshake, Dial reconnects and speaks LegacyVersion without one.
+func Dial(dial func() (net.Conn, error), hello *Hello) (*Conn, error) {
+	return DialContext(context.Background(), func(context.Context) (net.Conn, error) {
+		return dial()
+	}, hello)
+}
+
+// DialContext is Dial bounded by ctx: the handshake gives up when ctx is
+// done, and ctx is passed on to dial.
+func DialContext(ctx context.Context, dial func(context.Context) (net.Conn, error), hello *Hello) (*Conn, error) {
+	nc, err := dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := HandshakeContext(ctx, nc, hello)
+	if errors.Is(err, errNoHandshake) {
+		nc.Close()
+		if nc, err = dial(ctx); err != nil {
+			return nil, err
+		}
+		return &Conn{Conn: nc, Version: LegacyVersion, Codec: CodecBinary}, nil
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Handshake runs the dialing side of the handshake on nc.
+func Handshake(nc net.Conn, hello *Hello) (*Conn, error) {
+	return HandshakeContext(context.Background(), nc, hello)
+}
+
+// HandshakeContext is Handshake bounded by ctx as well as HandshakeTimeout.
+func HandshakeContext(ctx context.Context, nc net.Conn, hello *Hello) (conn *Conn, err error) {
+	deadline := time.Now().Add(HandshakeTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	defer bound(ctx, nc, deadline)(&err)
+
+	if err := WriteFrame(nc, hello.Encode()); err != nil {
+		return nil, err
+	}
+
+	data, err := ReadFrame(nc)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return nil, errNoHandshake
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := DecodeHelloReply(data)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Error != "" {
+		return nil, fmt.Errorf("%w: %s", ErrIncompatible, reply.Error)
+	}
+	return &Conn{Conn: nc, Version: reply.Version, Codec: reply.Codec, Features: reply.Features}, nil
+}
+
+// Accept runs the accepting side of the handshake on nc. If the peer skips
+// the handshake, the frame it sent instead is returned as pending so the
+// caller can serve it as the first request. If the handshake is refused,
+// the refusal is sent to the peer and an ErrIncompatible error returned.
+func Accept(nc net.Conn, local *Hello) (conn *Conn, pending []byte, err error) {
+	data, err := ReadFrame(nc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !IsHello(data) {
+		return &Conn{Conn: nc, Version: LegacyVersion, Codec: CodecBinary}, data, nil
+	}
+
+	hello, err := DecodeHello(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reply := Negotiate(local, hello)
+	nc.SetWriteDeadline(time.Now().Add(HandshakeTimeout))
+	defer nc.SetWriteDeadline(time.Time{})
+	if err := WriteFrame(nc, reply.Encode()); err != nil {
+		return nil, nil, err
+	}
+	if reply.Error != "" {
+		return nil, nil, fmt.Errorf("%w: %s", ErrIncompatible, reply.Error)
+	}
+	return &Conn{Conn: nc, Version: reply.Version, Codec: reply.Codec, Features: reply.Features}, nil, nil
+}
+
+// RoundTrip sends req and waits for its response. A Conn carries one
+// request at a time, so callers must not share it between goroutines
+// without their own locking.
+func (c *Conn) RoundTrip(req *Request) (*Response, error) {
+	return c.RoundTripContext(context.Background(), req)
+}
+
+// RoundTripContext is RoundTrip bounded by ctx. If ctx is done first, it
+// returns ctx.Err() and the Conn must be closed: the response may still
+// be on its way and would be read as the answer to the next request.
+// Other errors wrap ErrNotSent if the peer cannot have seen the request.
+func (c *Conn) RoundTripContext(ctx context.Context, req *Request) (res *Response, err error) {
+	deadline, _ := ctx.Deadline()
+	defer bound(ctx, c, deadline)(&err)
+
+	data, err := req.EncodeWith(c.Codec)
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteFrame(c, data); err != nil {
+		// A frame cut short is never read as a request.
+		return nil, fmt.Errorf("%w: %w", ErrNotSent, err)
+	}
+
+	data, err = ReadFrame(c)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeResponseWith(data, c.Codec)
+}
+
+// Supports reports whether the peer accepts cmd. Peers that skipped the
+// handshake are given the benefit of the doubt.
+func (c *Conn) Supports(cmd CommandType) bool {
+	f := CommandFeature(cmd)
+	return f == "" || c.Version == LegacyVersion || slices.Contains(c.Features, f)
+}
+
+// Missing returns the feature the peer lacks to serve req, or "".
+// Besides the command, it checks Request.Namespace and Request.Strong: a
+// peer that does not know the field would silently run the request in the
+// default namespace, or in the default mode, so such requests are never
+// sent to legacy peers either.
+func (c *Conn) Missing(req *Request) string {
+	if req.Namespace != "" && !slices.Contains(c.Features, FeatureNamespaces) {
+		return FeatureNamespaces
+	}
+	if req.Strong && !slices.Contains(c.Features, FeatureStrong) {
+		return FeatureStrong
+	}
+	if !c.Supports(req.CommandType) {
+		return CommandFeature(req.CommandType)
+	}
+	return ""
+}
+
+// bound makes I/O on nc fail at deadline (none if zero) or as soon as ctx
+// is done. Defer the function it returns, passing the caller's error: it
+// lifts the bound and, if ctx ended the I/O, replaces err with ctx.Err().
+//
+//	defer bound(ctx, nc, deadline)(&err)
+func bound(ctx context.Context, nc net.Conn, deadline time.Time) func(*error) {
+	nc.SetDeadline(deadline)
+	// A deadline in the past interrupts reads and writes already blocked.
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		nc.SetDeadline(time.Unix(1, 0))
+		close(interrupted)
+	})
+
+	return func(err *error) {
+		if !stop() {
+			<-interrupted
+		}
+		nc.SetDeadline(time.Time{})
+		if *err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			*err = ctx.Err()
+		} else if d, ok := ctx.Deadline(); ok && errors.Is(*err, os.ErrDeadlineExceeded) && !time.Now().Before(d) {
+			// The connection's deadline fired a moment before ctx's timer.
+			*err = context.DeadlineExceeded
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// -------- Framing --------
+// TCP is a byte stream, so each encoded message is sent as a frame:
+// [4 bytes payload length][payload]. Frames let a message be larger than
+// a single read and let several messages share one connection.
+
+// MaxFrameSize bounds the payload of a single frame.
+const MaxFrameSize = 64 << 20
+
+var errFrameTooLarge = errors.New("protocol: frame exceeds MaxFrameSize")
+
+// WriteFrame writes payload as one frame.
+func WriteFrame(w io.Writer, payload []byte) error {
+	if len(payload) > MaxFrameSize {
+		return errFrameTooLarge
+	}
+
+	buf := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	copy(buf[4:], payload)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadFrame reads one frame and returns its payload.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(header[:])
+	if n > MaxFrameSize {
+		return nil, errFrameTooLarge
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
@@ -0,0 +1,213 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// -------- HELLO Handshake --------
+// Before the first request, the dialing side sends a Hello listing the
+// protocol versions, codecs and features it supports. The accepting side
+// answers with a HelloReply naming the version and codec both sides will
+// use and the features both sides share, or with an error if they have
+// nothing in common. Then the connection carries normal request frames.
+//
+//	dialer                          acceptor
+//	  | -- Hello{1..2, codecs, features} --> |
+//	  | <-- HelloReply{2, codec, features} -- |
+//	  | -- Request --> / <-- Response --     |
+//
+// Nodes released before the handshake existed send requests straight away.
+// A Hello never starts with BinaryVersion, so an acceptor can tell the
+// two apart from the first frame and still serve old peers.
+
+// HelloMagic is the first byte of a Hello or HelloReply frame.
+const HelloMagic byte = 0x82
+
+// Protocol versions. Version 1 is the framed binary protocol without a
+// handshake; it is what a connection is assumed to speak if it skips HELLO.
+const (
+	LegacyVersion      uint16 = 1
+	MinProtocolVersion uint16 = 2
+	ProtocolVersion    uint16 = 2
+)
+
+// Feature flags advertised in the handshake. A node only sends a command
+// to a peer that advertised the command's feature (see CommandFeature).
+const (
+	FeatureCollections = "collections" // Hashes, lists and sets
+	FeatureSortedSets  = "sorted-sets"
+	FeatureConditional = "conditional" // Add, Replace, CAS and IncrExisting
+	FeatureAuth        = "auth"        // CmdAuth and Request.User
+	FeatureNamespaces  = "namespaces"  // Request.Namespace and CmdStats
+	FeatureInfo        = "info"        // CmdInfo
+	FeatureReplication = "replication" // The internal commands and Request.Stamp
+	FeatureRaft        = "raft"        // CmdRaft
+	FeatureStrong      = "strong"      // Request.Strong
+)
+
+// SupportedFeatures lists the features this build implements.
+var SupportedFeatures = []string{FeatureCollections, FeatureSortedSets, FeatureConditional, FeatureAuth, FeatureNamespaces, FeatureInfo, FeatureReplication, FeatureRaft, FeatureStrong}
+
+// ErrIncompatible is returned when the peer refused the handshake.
+var ErrIncompatible = errors.New("protocol: incompatible peer")
+
+var errNotHello = errors.New("protocol: not a HELLO message")
+
+// Hello opens the handshake.
+type Hello struct {
+	MinVersion uint16
+	MaxVersion uint16
+	Codecs     []Codec // In order of preference
+	Features   []string
+}
+
+// HelloReply answers a Hello. If Error is set the handshake failed and the
+// acceptor closes the connection.
+type HelloReply struct {
+	Version  uint16
+	Codec    Codec
+	Features []string
+	Error    string
+}
+
+// DefaultHello describes what this build supports.
+func DefaultHello() *Hello {
+	return &Hello{
+		MinVersion: MinProtocolVersion,
+		MaxVersion: ProtocolVersion,
+		Codecs:     []Codec{CodecBinary, CodecGob},
+		Features:   SupportedFeatures,
+	}
+}
+
+// Negotiate is run by the acceptor: it picks the highest version both sides
+// support, the dialer's most preferred codec that local also supports, and
+// the features both sides share.
+func Negotiate(local, remote *Hello) *HelloReply {
+	version := min(local.MaxVersion, remote.MaxVersion)
+	if version < local.MinVersion || version < remote.MinVersion {
+		return &HelloReply{Error: fmt.Sprintf(
+			"no common protocol version: peer speaks %d-%d, node speaks %d-%d",
+			remote.MinVersion, remote.MaxVersion, local.MinVersion, local.MaxVersion)}
+	}
+
+	reply := &HelloReply{Version: version}
+	for _, c := range remote.Codecs {
+		if slices.Contains(local.Codecs, c) {
+			reply.Codec = c
+			break
+		}
+	}
+	if reply.Codec == 0 {
+		return &HelloReply{Error: "no common codec"}
+	}
+
+	for _, f := range remote.Features {
+		if slices.Contains(local.Features, f) {
+			reply.Features = append(reply.Features, f)
+		}
+	}
+	return reply
+}
+
+// CommandFeature returns the feature a peer must advertise to accept cmd,
+// or "" if every peer understands it.
+func CommandFeature(cmd CommandType) string {
+	switch cmd {
+	case CmdHSet, CmdHGet, CmdHDel, CmdHGetAll,
+		CmdLPush, CmdRPop, CmdLRange,
+		CmdSAdd, CmdSIsMember, CmdSMembers:
+		return FeatureCollections
+	case CmdZAdd, CmdZRange, CmdZRangeByScore, CmdZRem, CmdZCard, CmdZIncrBy:
+		return FeatureSortedSets
+	case CmdAdd, CmdReplace, CmdCAS, CmdIncrExisting:
+		return FeatureConditional
+	case CmdAuth:
+		return FeatureAuth
+	case CmdStats:
+		return FeatureNamespaces
+	case CmdInfo:
+		return FeatureInfo
+	case CmdReplicaGet, CmdReplicaSet, CmdReplicaDelete, CmdMerkle, CmdDigest:
+		return FeatureReplication
+	case CmdRaft:
+		return FeatureRaft
+	default:
+		return ""
+	}
+}
+
+// IsHello reports whether a frame holds a Hello or HelloReply.
+func IsHello(data []byte) bool {
+	return len(data) > 0 && data[0] == HelloMagic
+}
+
+// -------- Handshake Serialization --------
+//
+//	Hello:      [1 magic][2 min version][2 max version][4 n][n codecs][features]
+//	HelloReply: [1 magic][2 version][1 codec][features][4 err len][error]
+//
+// Features are written like Request.Fields: [4 count] then [4 len][name] each.
+
+// Encode serializes a Hello.
+func (h *Hello) Encode() []byte {
+	e := &encoder{}
+	e.u8(HelloMagic)
+	e.u16(h.MinVersion)
+	e.u16(h.MaxVersion)
+	e.u32(uint32(len(h.Codecs)))
+	for _, c := range h.Codecs {
+		e.u8(byte(c))
+	}
+	e.strings(h.Features)
+	return e.buf
+}
+
+// DecodeHello deserializes a Hello.
+func DecodeHello(data []byte) (*Hello, error) {
+	d := &decoder{data: data}
+	if d.u8() != HelloMagic {
+		return nil, errNotHello
+	}
+
+	h := &Hello{MinVersion: d.u16(), MaxVersion: d.u16()}
+	for _, c := range d.take(int(d.u32())) {
+		h.Codecs = append(h.Codecs, Codec(c))
+	}
+	h.Features = d.strings()
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	return h, nil
+}
+
+// Encode serializes a HelloReply.
+func (r *HelloReply) Encode() []byte {
+	e := &encoder{}
+	e.u8(HelloMagic)
+	e.u16(r.Version)
+	e.u8(byte(r.Codec))
+	e.strings(r.Features)
+	e.bytes([]byte(r.Error))
+	return e.buf
+}
+
+// DecodeHelloReply deserializes a HelloReply.
+func DecodeHelloReply(data []byte) (*HelloReply, error) {
+	d := &decoder{data: data}
+	if d.u8() != HelloMagic {
+		return nil, errNotHello
+	}
+
+	r := &HelloReply{Version: d.u16(), Codec: Codec(d.u8())}
+	r.Features = d.strings()
+	r.Error = string(d.bytes())
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	return r, nil
+}
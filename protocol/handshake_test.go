@@ -0,0 +1,268 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNegotiate(t *testing.T) {
+	local := DefaultHello()
+
+	reply := Negotiate(local, &Hello{
+		MinVersion: 1,
+		MaxVersion: 9,
+		Codecs:     []Codec{CodecGob, CodecBinary},
+		Features:   []string{FeatureSortedSets, "teleport"},
+	})
+	if reply.Error != "" {
+		t.Fatalf("unexpected refusal: %s", reply.Error)
+	}
+	if reply.Version != ProtocolVersion {
+		t.Errorf("expected version %d, got %d", ProtocolVersion, reply.Version)
+	}
+	if reply.Codec != CodecGob {
+		t.Errorf("expected the peer's preferred codec, got %d", reply.Codec)
+	}
+	if !reflect.DeepEqual(reply.Features, []string{FeatureSortedSets}) {
+		t.Errorf("expected only shared features, got %v", reply.Features)
+	}
+
+	if reply := Negotiate(local, &Hello{MinVersion: 9, MaxVersion: 9, Codecs: []Codec{CodecBinary}}); reply.Error == "" {
+		t.Error("expected refusal for a version the node does not speak")
+	}
+	if reply := Negotiate(local, &Hello{MinVersion: 2, MaxVersion: 2, Codecs: []Codec{99}}); reply.Error == "" {
+		t.Error("expected refusal without a common codec")
+	}
+}
+
+func TestHelloRoundTrip(t *testing.T) {
+	hello := DefaultHello()
+	got, err := DecodeHello(hello.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, hello) {
+		t.Errorf("hello mismatch:\n got %+v\nwant %+v", got, hello)
+	}
+
+	reply := &HelloReply{Version: 2, Codec: CodecBinary, Features: []string{FeatureCollections}, Error: "nope"}
+	gotReply, err := DecodeHelloReply(reply.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotReply, reply) {
+		t.Errorf("reply mismatch:\n got %+v\nwant %+v", gotReply, reply)
+	}
+
+	if IsHello(sampleRequestBytes(t)) {
+		t.Error("a request frame must not look like a HELLO")
+	}
+}
+
+// serve runs Accept on one end of a pipe and echoes requests back as
+// responses until the connection closes.
+func serve(t *testing.T, nc net.Conn, local *Hello) {
+	t.Helper()
+	go func() {
+		defer nc.Close()
+		conn, data, err := Accept(nc, local)
+		if err != nil {
+			return
+		}
+		for {
+			if data == nil {
+				if data, err = ReadFrame(conn); err != nil {
+					return
+				}
+			}
+			req, err := DecodeRequestWith(data, conn.Codec)
+			if err != nil {
+				return
+			}
+			data = nil
+			out, _ := (&Response{StatusCode: StatusOK, Value: []byte(req.Key)}).EncodeWith(conn.Codec)
+			if WriteFrame(conn, out) != nil {
+				return
+			}
+		}
+	}()
+}
+
+func TestDialAccept(t *testing.T) {
+	conn, err := Dial(func() (net.Conn, error) {
+		client, server := net.Pipe()
+		serve(t, server, DefaultHello())
+		return client, nil
+	}, &Hello{MinVersion: 2, MaxVersion: 2, Codecs: []Codec{CodecGob}, Features: SupportedFeatures})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if conn.Version != ProtocolVersion || conn.Codec != CodecGob {
+		t.Fatalf("unexpected session: version %d codec %d", conn.Version, conn.Codec)
+	}
+	for _, key := range []string{"a", "b"} {
+		res, err := conn.RoundTrip(&Request{CommandType: CmdGet, Key: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(res.Value) != key {
+			t.Errorf("expected %q, got %q", key, res.Value)
+		}
+	}
+}
+
+func TestRoundTripContext(t *testing.T) {
+	// A node that completes the handshake, then never answers.
+	hung := func(nc net.Conn) {
+		defer nc.Close()
+		conn, _, err := Accept(nc, DefaultHello())
+		if err != nil {
+			return
+		}
+		for {
+			if _, err := ReadFrame(conn); err != nil {
+				return
+			}
+		}
+	}
+	dial := func() *Conn {
+		conn, err := Dial(func() (net.Conn, error) {
+			client, server := net.Pipe()
+			go hung(server)
+			return client, nil
+		}, DefaultHello())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	conn := dial()
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := conn.RoundTripContext(ctx, &Request{CommandType: CmdGet, Key: "k"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	conn = dial()
+	defer conn.Close()
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := conn.RoundTripContext(ctx, &Request{CommandType: CmdGet, Key: "k"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRoundTripNotSent(t *testing.T) {
+	// A node that reads one request, then hangs up.
+	conn, err := Dial(func() (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			if conn, _, err := Accept(server, DefaultHello()); err == nil {
+				ReadFrame(conn)
+			}
+		}()
+		return client, nil
+	}, DefaultHello())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.RoundTrip(&Request{CommandType: CmdGet, Key: "k"}); err == nil || errors.Is(err, ErrNotSent) {
+		t.Errorf("a request the node read: expected an error without ErrNotSent, got %v", err)
+	}
+	if _, err := conn.RoundTrip(&Request{CommandType: CmdGet, Key: "k"}); !errors.Is(err, ErrNotSent) {
+		t.Errorf("a request the node never read: expected ErrNotSent, got %v", err)
+	}
+}
+
+func TestDialRefused(t *testing.T) {
+	_, err := Dial(func() (net.Conn, error) {
+		client, server := net.Pipe()
+		serve(t, server, DefaultHello())
+		return client, nil
+	}, &Hello{MinVersion: 7, MaxVersion: 8, Codecs: []Codec{CodecBinary}})
+	if !errors.Is(err, ErrIncompatible) {
+		t.Fatalf("expected ErrIncompatible, got %v", err)
+	}
+}
+
+func TestDialLegacyPeer(t *testing.T) {
+	// A node from before the handshake hangs up on anything that is not a request.
+	legacy := func(nc net.Conn) {
+		defer nc.Close()
+		data, err := ReadFrame(nc)
+		if err != nil {
+			return
+		}
+		req, err := DecodeRequest(data)
+		if err != nil {
+			return
+		}
+		out, _ := (&Response{StatusCode: StatusOK, Value: []byte(req.Key)}).Encode()
+		WriteFrame(nc, out)
+	}
+
+	conn, err := Dial(func() (net.Conn, error) {
+		client, server := net.Pipe()
+		go legacy(server)
+		return client, nil
+	}, DefaultHello())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if conn.Version != LegacyVersion {
+		t.Fatalf("expected legacy version, got %d", conn.Version)
+	}
+	if !conn.Supports(CmdZAdd) {
+		t.Error("legacy peers should be assumed to support every command")
+	}
+	res, err := conn.RoundTrip(&Request{CommandType: CmdGet, Key: "k"})
+	if err != nil || string(res.Value) != "k" {
+		t.Fatalf("unexpected response %v, %v", res, err)
+	}
+}
+
+func TestSupports(t *testing.T) {
+	conn := &Conn{Version: ProtocolVersion, Features: []string{FeatureCollections}}
+	if !conn.Supports(CmdGet) || !conn.Supports(CmdHSet) {
+		t.Error("expected plain and collection commands to be supported")
+	}
+	if conn.Supports(CmdZAdd) {
+		t.Error("expected sorted-set commands to be rejected")
+	}
+	if f := conn.Missing(&Request{CommandType: CmdGet, Namespace: "a"}); f != FeatureNamespaces {
+		t.Errorf("expected a namespaced request to need %s, got %q", FeatureNamespaces, f)
+	}
+
+	legacy := &Conn{Version: LegacyVersion}
+	if legacy.Missing(&Request{CommandType: CmdZAdd}) != "" {
+		t.Error("legacy peers are assumed to support every command")
+	}
+	if legacy.Missing(&Request{CommandType: CmdGet, Namespace: "a"}) == "" {
+		t.Error("legacy peers would drop the namespace")
+	}
+	if f := conn.Missing(&Request{CommandType: CmdGet, Strong: true}); f != FeatureStrong {
+		t.Errorf("expected a strong request to need %s, got %q", FeatureStrong, f)
+	}
+}
+
+func sampleRequestBytes(t *testing.T) []byte {
+	t.Helper()
+	data, err := sampleRequest.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,60 @@
+package protocol
+
+import "time"
+
+// -------- Node Introspection --------
+// CmdInfo returns a NodeInfo snapshot as JSON in Response.Value. With an
+// empty Key it describes the node that received it; otherwise Key names a
+// node in the ring and the request is forwarded there, so a client can
+// inspect every node through the one it is connected to.
+
+// NodeInfo describes one node.
+type NodeInfo struct {
+	Node          string  `json:"node"`
+	Version       string  `json:"version"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+
+	Items       int     `json:"items"`        // Across all namespaces
+	MemoryBytes int     `json:"memory_bytes"` // Keys and values stored
+	HeapBytes   uint64  `json:"heap_bytes"`   // Go heap in use
+	Namespaces  int     `json:"namespaces"`
+	Hits        uint64  `json:"hits"`
+	Misses      uint64  `json:"misses"`
+	HitRatio    float64 `json:"hit_ratio"` // Hits / (Hits + Misses), 0 before any lookup
+
+	// Open connections on the TCP, RESP and memcached listeners, including
+	// those from other nodes.
+	ConnectedClients int `json:"connected_clients"`
+
+	Ring     []RingMember `json:"ring"`
+	RingHash string       `json:"ring_hash,omitempty"` // See consistent.Hash
+	Peers    []PeerStatus `json:"peers"`
+
+	// The node's view of the cluster metadata group, if it runs one, and
+	// of the strong-mode shard groups it votes in, by name.
+	Raft   *RaftStatus           `json:"raft,omitempty"`
+	Shards map[string]RaftStatus `json:"shards,omitempty"`
+}
+
+// RingMember is a node in the hash ring, its weight and its number of
+// virtual nodes.
+type RingMember struct {
+	Addr         string `json:"addr"`
+	Weight       int    `json:"weight,omitempty"`
+	VirtualNodes int    `json:"virtual_nodes"`
+}
+
+// RaftStatus is a node's place in a Raft group (see the raft package).
+type RaftStatus struct {
+	Role    string `json:"role"` // leader, follower, candidate or learner
+	Term    uint64 `json:"term"`
+	Leader  string `json:"leader"`
+	Applied uint64 `json:"applied"` // Index of the last log entry applied
+}
+
+// PeerStatus is a node as seen by the registry.
+type PeerStatus struct {
+	Addr          string    `json:"addr"`
+	Status        string    `json:"status"` // alive, suspect or dead
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+}
@@ -3,7 +3,10 @@ package protocol
 import (
 	"bytes"
 	"encoding/gob"
+	"strconv"
 	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/hlc"
 )
 
 // -------- Wire Protocol --------
@@ -14,13 +17,188 @@ import (
 type CommandType byte
 
 const (
-	CmdGet    CommandType = iota + 1 // Retrieve a value
-	CmdSet                           // Store a value
-	CmdDelete                        // Remove a value
-	CmdPing                          // Health check
-	CmdKeys                          // List all keys
+	CmdGet           CommandType = iota + 1 // Retrieve a value
+	CmdSet                                  // Store a value
+	CmdDelete                               // Remove a value
+	CmdPing                                 // Health check
+	CmdKeys                                 // List all keys
+	CmdGetMeta                              // Retrieve a value with its metadata
+	CmdGetSet                               // Store a value, returning the previous one
+	CmdGetDel                               // Remove a value, returning it
+	CmdHSet                                 // Set a field in a hash
+	CmdHGet                                 // Get a field from a hash
+	CmdHDel                                 // Remove fields from a hash
+	CmdHGetAll                              // Get every field and value of a hash
+	CmdLPush                                // Prepend values to a list
+	CmdRPop                                 // Remove and return the last list element
+	CmdLRange                               // Get a range of list elements
+	CmdSAdd                                 // Add members to a set
+	CmdSIsMember                            // Check set membership
+	CmdSMembers                             // List the members of a set
+	CmdZAdd                                 // Add scored members to a sorted set
+	CmdZRange                               // Get sorted-set members by rank
+	CmdZRangeByScore                        // Get sorted-set members by score
+	CmdZRem                                 // Remove members from a sorted set
+	CmdZCard                                // Count the members of a sorted set
+	CmdZIncrBy                              // Increment a member's score
+	CmdAdd                                  // Store a value only if the key is missing
+	CmdReplace                              // Store a value only if the key exists
+	CmdIncr                                 // Add Int to an integer value
+	CmdExpire                               // Change the TTL of a key
+	CmdCAS                                  // Store a value only if its version still equals CAS
+	CmdIncrExisting                         // Like CmdIncr, but only for existing keys and never below 0
+	CmdAuth                                 // Authenticate the connection (Key is the user, Value the password or token)
+	CmdStats                                // Per-namespace statistics of the node, as JSON in Value
+	CmdInfo                                 // Snapshot of a node, as JSON in Value (see info.go)
+
+	// Sent by nodes to each other only, to keep replicas in step (see
+	// server/replication.go and server/antientropy.go).
+	CmdReplicaGet    // Read the node's own copy of Key, with Meta.Stamp
+	CmdReplicaSet    // Store Value unless the node's copy has a newer Stamp
+	CmdReplicaDelete // Remove Key unless the node's copy is newer than Stamp
+	CmdMerkle        // Merkle trees, Int levels deep, over the token ranges in Values
+	CmdDigest        // Keys and stamps in the token ranges in Values
+
+	// A Raft message for the group named in Key, in Value (see
+	// server/metadata.go and the raft package).
+	CmdRaft
 )
 
+var commandNames = [...]string{
+	CmdGet:           "get",
+	CmdSet:           "set",
+	CmdDelete:        "delete",
+	CmdPing:          "ping",
+	CmdKeys:          "keys",
+	CmdGetMeta:       "getmeta",
+	CmdGetSet:        "getset",
+	CmdGetDel:        "getdel",
+	CmdHSet:          "hset",
+	CmdHGet:          "hget",
+	CmdHDel:          "hdel",
+	CmdHGetAll:       "hgetall",
+	CmdLPush:         "lpush",
+	CmdRPop:          "rpop",
+	CmdLRange:        "lrange",
+	CmdSAdd:          "sadd",
+	CmdSIsMember:     "sismember",
+	CmdSMembers:      "smembers",
+	CmdZAdd:          "zadd",
+	CmdZRange:        "zrange",
+	CmdZRangeByScore: "zrangebyscore",
+	CmdZRem:          "zrem",
+	CmdZCard:         "zcard",
+	CmdZIncrBy:       "zincrby",
+	CmdAdd:           "add",
+	CmdReplace:       "replace",
+	CmdIncr:          "incr",
+	CmdExpire:        "expire",
+	CmdCAS:           "cas",
+	CmdIncrExisting:  "increxisting",
+	CmdAuth:          "auth",
+	CmdStats:         "stats",
+	CmdInfo:          "info",
+	CmdReplicaGet:    "replicaget",
+	CmdReplicaSet:    "replicaset",
+	CmdReplicaDelete: "replicadelete",
+	CmdMerkle:        "merkle",
+	CmdDigest:        "digest",
+	CmdRaft:          "raft",
+}
+
+// String returns the command's lowercase name, e.g. "hset".
+func (c CommandType) String() string {
+	if int(c) < len(commandNames) && commandNames[c] != "" {
+		return commandNames[c]
+	}
+	return "CommandType(" + strconv.Itoa(int(c)) + ")"
+}
+
+// Commands returns every command type.
+func Commands() []CommandType {
+	var cmds []CommandType
+	for c, n := range commandNames {
+		if n != "" {
+			cmds = append(cmds, CommandType(c))
+		}
+	}
+	return cmds
+}
+
+// Internal reports whether c is only sent between nodes.
+func (c CommandType) Internal() bool {
+	return c >= CmdReplicaGet && c <= CmdRaft
+}
+
+// readOnly lists the commands that change nothing.
+var readOnly = map[CommandType]bool{
+	CmdGet:           true,
+	CmdPing:          true,
+	CmdKeys:          true,
+	CmdGetMeta:       true,
+	CmdHGet:          true,
+	CmdHGetAll:       true,
+	CmdLRange:        true,
+	CmdSIsMember:     true,
+	CmdSMembers:      true,
+	CmdZRange:        true,
+	CmdZRangeByScore: true,
+	CmdZCard:         true,
+	CmdStats:         true,
+	CmdInfo:          true,
+}
+
+// ReadOnly reports whether c only reads, leaving keys as they were.
+func (c CommandType) ReadOnly() bool {
+	return readOnly[c]
+}
+
+// idempotent lists the commands that may run twice with the same result.
+// The others (increments, pushes and pops, conditional writes, GETSET and
+// GETDEL) would apply twice or report a different outcome. So would the
+// writes that count what they changed (DEL, HSET, HDEL, SADD, ZADD, ZREM
+// and replica deletes): run again, they find nothing left to change and
+// answer 0. Replica sets are stamped, so a second copy changes nothing.
+var idempotent = map[CommandType]bool{
+	CmdGet:           true,
+	CmdGetMeta:       true,
+	CmdSet:           true,
+	CmdExpire:        true,
+	CmdKeys:          true,
+	CmdStats:         true,
+	CmdInfo:          true,
+	CmdPing:          true,
+	CmdHGet:          true,
+	CmdHGetAll:       true,
+	CmdLRange:        true,
+	CmdSIsMember:     true,
+	CmdSMembers:      true,
+	CmdZRange:        true,
+	CmdZRangeByScore: true,
+	CmdZCard:         true,
+	CmdReplicaGet:    true,
+	CmdReplicaSet:    true,
+	CmdMerkle:        true,
+	CmdDigest:        true,
+}
+
+// Idempotent reports whether running c twice does no more harm than
+// running it once, so a request that may or may not have reached a node
+// can be sent again.
+func (c CommandType) Idempotent() bool {
+	return idempotent[c]
+}
+
+// CommandByName returns the command with the given name (see String).
+func CommandByName(name string) (CommandType, bool) {
+	for c, n := range commandNames {
+		if n != "" && n == name {
+			return CommandType(c), true
+		}
+	}
+	return 0, false
+}
+
 // StatusCode indicates success or failure in a response.
 type StatusCode byte
 
@@ -28,46 +206,161 @@ const (
 	StatusOK StatusCode = iota + 1
 	StatusNotFound
 	StatusError
+	StatusWrongType // The key holds a different kind of value
+	StatusNotStored // A conditional write (CmdAdd, CmdReplace, CmdCAS) did not apply
+	StatusDenied    // Not authenticated, or the ACL does not allow the command
+	// The node, or the quorum of nodes, the request needed could not be reached
+	StatusUnavailable
 )
 
 // Request is the message a client sends to a cache node.
 // TODO: Include the command type, key, value (for Set), and TTL.
+//
+// Collection commands use the extra fields: Field names a single hash field
+// or set member, Fields holds several of them (HDEL, SADD), Values holds list
+// elements (LPUSH) and Start/Stop are the inclusive LRANGE indexes.
+// Sorted-set commands pair Fields with Scores (ZADD), use Field and Score
+// for ZINCRBY, Start/Stop for ZRANGE and Min/Max for ZRANGEBYSCORE.
+// CmdIncr adds Int to the stored integer.
+// Flags is an opaque value stored with the item (memcached flags) and CAS
+// is the version CmdCAS expects the item to still have.
+// User is the identity the request runs as. Nodes only trust it on
+// connections from peers; for everyone else it is set from the connection's
+// CmdAuth (see server/auth.go).
+// Namespace selects the keyspace the command runs in; "" is the default one.
+// TraceParent and TraceState are W3C trace context, so traces follow a
+// request across proxy hops (see the tracing package).
+// Timeout is how long the sender will wait for the response, 0 meaning no
+// limit. It is relative rather than a point in time, so the nodes' clocks
+// need not agree; a node stops forwarding the request once it runs out.
+// Stamp is the hybrid logical clock timestamp (see the hlc package) that
+// orders the write, or delete, among the copies of a replicated key.
+// Nodes receiving it move their clock past it.
+// Strong runs the request in strong mode, linearizable, whatever its
+// namespace's setting (see server/strong.go).
 type Request struct {
 	CommandType CommandType
 	Key         string
 	Value       []byte
 	TTL         time.Duration
+	Field       string
+	Fields      []string
+	Values      [][]byte
+	Start       int
+	Stop        int
+	Scores      []float64
+	Score       float64
+	Min         float64
+	Max         float64
+	Int         int64
+	Flags       uint32
+	CAS         uint64
+	User        string
+	Namespace   string
+	TraceParent string
+	TraceState  string
+	Timeout     time.Duration
+	Stamp       hlc.Timestamp
+	Strong      bool
 }
 
 // Response is the message a cache node sends back to a client.
 // TODO: Include the status code, value (for Get), and an error message if any.
+//
+// Collection commands reply through Fields (hash fields, set members),
+// Values (list elements, or hash values matching Fields) and Int (counts,
+// lengths and booleans as 0/1; CmdDelete sets it to 1 if the key existed
+// and CmdIncr returns the new value). Sorted-set ranges return Scores matching
+// Fields; ZINCRBY returns the new Score. CmdDigest returns keys in Fields
+// and their stamps, 8 bytes each, in Values. CmdRaft returns the Raft
+// reply in Value. CmdPing returns the node's ring weight in Int.
 type Response struct {
 	StatusCode   StatusCode
 	Value        []byte
 	ErrorMessage string
+	Meta         Metadata
+	Fields       []string
+	Values       [][]byte
+	Int          int64
+	Scores       []float64
+	Score        float64
+}
+
+// Metadata describes a stored item. It is only filled in for CmdGetMeta
+// and CmdReplicaGet.
+// TTL is the remaining time to live; 0 means the item never expires.
+type Metadata struct {
+	CreatedAt time.Time
+	TTL       time.Duration
+	Version   uint64
+	Stamp     hlc.Timestamp
+	Size      int
+	Flags     uint32
+}
+
+// NamespaceStats is what CmdStats reports for each namespace, keyed by
+// name in a JSON object. The default namespace is "".
+type NamespaceStats struct {
+	Items       int    `json:"items"`
+	Bytes       int    `json:"bytes"`
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Sets        uint64 `json:"sets"`
+	Deletes     uint64 `json:"deletes"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+	MaxItems    int    `json:"max_items,omitempty"`
+	MaxBytes    int    `json:"max_bytes,omitempty"`
+}
+
+// ZMember is a sorted-set member together with its score.
+type ZMember struct {
+	Member string
+	Score  float64
 }
 
 // -------- Serialization --------
-// You need to convert Request/Response to []byte and back.
-// Choose one approach:
-//   Option A (easier): Use encoding/gob or encoding/json
-//   Option B (learn more): Build a custom binary protocol
-//     e.g. [1 byte cmd][2 bytes key len][key][4 bytes val len][value][8 bytes ttl]
+// Messages are encoded with the compact binary layout in codec.go and sent
+// over TCP as frames (see frame.go). Peers may agree on encoding/gob
+// instead in the HELLO handshake (see handshake.go); a connection without
+// one speaks binary. Either way the codec is the connection's, not
+// guessed from the message.
+//
+// Nodes from before frames wrote bare gob streams onto the connection.
+// That wire format is gone: such nodes cannot talk to this one.
+
+// Codec identifies a message encoding.
+type Codec byte
+
+const (
+	CodecBinary Codec = iota + 1 // The layout in codec.go
+	CodecGob                     // encoding/gob, in frames
+)
 
 // Encode serializes a Request into bytes for sending over the network.
-// TODO: Use your chosen encoding strategy.
 func (r *Request) Encode() ([]byte, error) {
-	var buf bytes.Buffer
-	err := gob.NewEncoder(&buf).Encode(r)
+	return r.EncodeWith(CodecBinary)
+}
 
-	if err != nil {
-		return nil, err
+// EncodeWith serializes a Request with the given codec.
+func (r *Request) EncodeWith(c Codec) ([]byte, error) {
+	if c == CodecGob {
+		return encodeGob(r)
 	}
-	return buf.Bytes(), nil
+	return r.encodeBinary()
 }
 
-// DecodeRequest deserializes bytes back into a Request.
+// DecodeRequest deserializes binary-encoded bytes back into a Request.
 func DecodeRequest(data []byte) (*Request, error) {
+	return DecodeRequestWith(data, CodecBinary)
+}
+
+// DecodeRequestWith deserializes bytes encoded with the given codec.
+func DecodeRequestWith(data []byte, c Codec) (*Request, error) {
+	if c != CodecGob {
+		return decodeBinaryRequest(data)
+	}
+
 	var req Request
 	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&req)
 
@@ -80,18 +373,28 @@ func DecodeRequest(data []byte) (*Request, error) {
 
 // Encode serializes a Response into bytes.
 func (r *Response) Encode() ([]byte, error) {
-	var buf bytes.Buffer
-	err := gob.NewEncoder(&buf).Encode(r)
+	return r.EncodeWith(CodecBinary)
+}
 
-	if err != nil {
-		return nil, err
+// EncodeWith serializes a Response with the given codec.
+func (r *Response) EncodeWith(c Codec) ([]byte, error) {
+	if c == CodecGob {
+		return encodeGob(r)
 	}
-
-	return buf.Bytes(), nil
+	return r.encodeBinary()
 }
 
-// DecodeResponse deserializes bytes back into a Response.
+// DecodeResponse deserializes binary-encoded bytes back into a Response.
 func DecodeResponse(data []byte) (*Response, error) {
+	return DecodeResponseWith(data, CodecBinary)
+}
+
+// DecodeResponseWith deserializes bytes encoded with the given codec.
+func DecodeResponseWith(data []byte, c Codec) (*Response, error) {
+	if c != CodecGob {
+		return decodeBinaryResponse(data)
+	}
+
 	var res Response
 	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&res)
 
@@ -102,5 +405,12 @@ func DecodeResponse(data []byte) (*Response, error) {
 	return &res, nil
 }
 
-// Ensure the compiler knows we use time.Duration (suppress unused import).
-var _ time.Duration
+func encodeGob(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
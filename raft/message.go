@@ -0,0 +1,201 @@
+package raft
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// -------- Messages --------
+// Nodes talk with five requests, each answered by a reply of the same
+// shape:
+//
+//	msgVote       a candidate asks for a vote (RequestVote in the paper)
+//	msgAppend     the leader sends entries, or none as a heartbeat
+//	              (AppendEntries)
+//	msgPropose    a node hands a proposal to the leader
+//	msgSnapshot   the leader sends its snapshot (InstallSnapshot)
+//	msgReadIndex  a node asks the leader for a read index (read.go)
+//
+// All of them are one message struct, of which each kind uses a few
+// fields, written as:
+//
+//	[1 kind][8 term][s from][8 index][8 log term][8 commit][1 ok][8 hint]
+//	[s leader][s err][b data][4 count]([8 index][8 term][b data])...
+//
+// where s and b are a 4-byte length and the bytes.
+
+type msgKind byte
+
+const (
+	msgVote msgKind = iota + 1
+	msgVoteReply
+	msgAppend
+	msgAppendReply
+	msgPropose
+	msgProposeReply
+	msgSnapshot
+	msgSnapshotReply
+	msgReadIndex
+	msgReadIndexReply
+)
+
+var errBadMessage = errors.New("raft: malformed message")
+
+type message struct {
+	Kind msgKind
+	Term uint64 // The sender's term
+	From string
+
+	// msgVote: the candidate's last entry. msgAppend: the entry just
+	// before Entries, which the follower must hold. msgSnapshot: the last
+	// entry the snapshot covers.
+	Index   uint64
+	LogTerm uint64
+
+	Commit  uint64  // msgAppend: the leader's commit index
+	Entries []Entry // msgAppend
+
+	OK     bool   // Vote granted, entries appended, proposal applied or read index found
+	Hint   uint64 // msgAppendReply: the next index to try; msgProposeReply: the entry's index; msgReadIndexReply: the read index
+	Leader string // msgProposeReply, msgReadIndexReply: the leader, if the node is not it
+	Err    string // msgProposeReply, msgReadIndexReply
+	Data   []byte // msgPropose: the proposal; msgSnapshot: the snapshot
+}
+
+func (m *message) encode() []byte {
+	var e encoder
+	e.u8(byte(m.Kind))
+	e.u64(m.Term)
+	e.bytes([]byte(m.From))
+	e.u64(m.Index)
+	e.u64(m.LogTerm)
+	e.u64(m.Commit)
+	if m.OK {
+		e.u8(1)
+	} else {
+		e.u8(0)
+	}
+	e.u64(m.Hint)
+	e.bytes([]byte(m.Leader))
+	e.bytes([]byte(m.Err))
+	e.bytes(m.Data)
+	e.entries(m.Entries)
+	return e.buf
+}
+
+func decodeMessage(data []byte) (*message, error) {
+	d := decoder{data: data}
+	m := &message{
+		Kind:    msgKind(d.u8()),
+		Term:    d.u64(),
+		From:    string(d.bytes()),
+		Index:   d.u64(),
+		LogTerm: d.u64(),
+		Commit:  d.u64(),
+		OK:      d.u8() == 1,
+		Hint:    d.u64(),
+		Leader:  string(d.bytes()),
+		Err:     string(d.bytes()),
+		Data:    d.bytes(),
+		Entries: d.entries(),
+	}
+	if d.err == nil && len(d.data) > 0 {
+		d.err = errBadMessage
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return m, nil
+}
+
+// -------- Encoding Helpers --------
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) u8(v byte)    { e.buf = append(e.buf, v) }
+func (e *encoder) u32(v uint32) { e.buf = binary.BigEndian.AppendUint32(e.buf, v) }
+func (e *encoder) u64(v uint64) { e.buf = binary.BigEndian.AppendUint64(e.buf, v) }
+
+// bytes writes a 4-byte length followed by b.
+func (e *encoder) bytes(b []byte) {
+	e.u32(uint32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) entries(entries []Entry) {
+	e.u32(uint32(len(entries)))
+	for _, entry := range entries {
+		e.u64(entry.Index)
+		e.u64(entry.Term)
+		e.bytes(entry.Data)
+	}
+}
+
+// decoder reads from data, remembering the first error so callers can
+// check it once at the end.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+// take returns the next n bytes, or nil once the data is exhausted.
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data) {
+		d.err = errBadMessage
+		return nil
+	}
+	b := d.data[:n:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) u8() byte {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) u32() uint32 {
+	if b := d.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) u64() uint64 {
+	if b := d.take(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// bytes reads a 4-byte length and that many bytes; empty gives nil.
+func (d *decoder) bytes() []byte {
+	b := d.take(int(d.u32()))
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+func (d *decoder) entries() []Entry {
+	n := int(d.u32())
+	// Each entry takes at least 20 bytes.
+	if d.err == nil && n > len(d.data)/20 {
+		d.err = errBadMessage
+	}
+	if d.err != nil || n == 0 {
+		return nil
+	}
+	entries := make([]Entry, n)
+	for i := range entries {
+		entries[i] = Entry{Index: d.u64(), Term: d.u64(), Data: d.bytes()}
+	}
+	return entries
+}
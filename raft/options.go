@@ -0,0 +1,45 @@
+package raft
+
+import (
+	"log/slog"
+	"time"
+)
+
+// -------- Node Options --------
+// Optional settings passed to New, e.g.
+//
+//	raft.New(id, voters, transport, apply, raft.WithStorage("meta.raft"))
+
+// Option configures a Node.
+type Option func(*Node)
+
+// WithLogger sets where the node logs elections and storage errors. The
+// default is slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(n *Node) { n.logger = l }
+}
+
+// WithHeartbeat sets how often the leader contacts the other nodes. A
+// follower that hears nothing for 10 to 20 heartbeats starts an
+// election. The default is DefaultHeartbeat.
+func WithHeartbeat(d time.Duration) Option {
+	return func(n *Node) { n.heartbeat = d }
+}
+
+// WithStorage keeps the node's term, vote and log in the file at path
+// (see storage.go). The default is to keep them in memory.
+func WithStorage(path string) Option {
+	return func(n *Node) { n.path = path }
+}
+
+// WithSnapshots has the node replace its log with a snapshot every time
+// another every entries were applied (see snapshot.go). save returns the
+// state machine's state; load replaces it with one save returned. Both
+// are called from the goroutine that calls apply. The default is to keep
+// the whole log.
+func WithSnapshots(every int, save func() []byte, load func([]byte)) Option {
+	return func(n *Node) {
+		n.snapEvery = uint64(max(every, 1))
+		n.save, n.load = save, load
+	}
+}
@@ -0,0 +1,823 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+// -------- Raft Consensus --------
+// Raft (Ongaro & Ousterhout, 2014) keeps an append-only log identical on a
+// group of nodes, so that every node applies the same commands in the same
+// order and ends up in the same state, as long as a majority of them can
+// talk to each other.
+//
+//   - Roles: at most one leader per term accepts proposals and copies them
+//     to the others, the followers. A follower that hears nothing from a
+//     leader for its election timeout becomes a candidate: it starts a new
+//     term and asks the other voters for their vote. A majority makes it
+//     the leader.
+//   - Voting: a voter votes once per term, and only for candidates whose
+//     log is at least as up to date as its own, so a new leader holds
+//     every committed entry.
+//   - Replication: the leader sends each follower the entries it lacks,
+//     along with the index and term of the entry just before them. A
+//     follower whose log does not hold that entry refuses, and the leader
+//     backs up until the logs agree; the follower then drops whatever
+//     conflicts and appends the rest.
+//   - Commit: an entry of the leader's term is committed once a majority
+//     of voters hold it, and so are the entries before it. Committed
+//     entries are applied, in order, on every node.
+//
+// The voters are fixed when a node is created. Other nodes may follow the
+// log as learners (SetLearners): the leader sends them entries too, but
+// they neither vote nor count towards a majority.
+//
+// A log that keeps growing can be cut short with snapshots of the state
+// it built (snapshot.go), and reads that must see every committed entry
+// can wait on ReadIndex (read.go) instead of going through the log.
+//
+// Nodes exchange messages (message.go) as bytes over a Transport, so the
+// caller decides how they travel, and hands incoming ones to Handle.
+
+// DefaultHeartbeat is how often the leader contacts the other nodes unless
+// WithHeartbeat says otherwise.
+const DefaultHeartbeat = 100 * time.Millisecond
+
+// maxBatch bounds the entries sent in one msgAppend.
+const maxBatch = 64
+
+var (
+	// ErrNoLeader is returned by Propose when no leader could be reached
+	// before the context ended.
+	ErrNoLeader = errors.New("raft: no leader")
+	// ErrLost is returned by Propose when a new leader overwrote the
+	// proposed entry before it was committed, or when the node lost track
+	// of it because a snapshot replaced its log; then it may have been
+	// applied after all.
+	ErrLost = errors.New("raft: proposal lost to a new leader")
+	// ErrStopped is returned once Stop was called.
+	ErrStopped = errors.New("raft: node stopped")
+
+	errNotLeader = errors.New("raft: not the leader")
+	errEmpty     = errors.New("raft: empty proposal")
+)
+
+// Role is what a node currently does in its group.
+type Role int
+
+const (
+	Follower Role = iota
+	Candidate
+	Leader
+	Learner // Follows the log without voting
+)
+
+func (r Role) String() string {
+	switch r {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	case Learner:
+		return "learner"
+	default:
+		return "unknown"
+	}
+}
+
+// Entry is one command in the log. Index counts from 1; Term is the term
+// of the leader that added it.
+type Entry struct {
+	Index uint64
+	Term  uint64
+	Data  []byte
+}
+
+// Transport sends msg to peer and returns its reply; the peer passes msg
+// to its Node's Handle.
+type Transport func(ctx context.Context, peer string, msg []byte) ([]byte, error)
+
+// Status is a snapshot of a node's view of its group.
+type Status struct {
+	Role    Role
+	Term    uint64
+	Leader  string // "" while unknown
+	Commit  uint64 // Index of the last committed entry
+	Applied uint64 // Index of the last applied entry
+}
+
+// Node is one member of a Raft group. It is safe for concurrent use.
+type Node struct {
+	id        string
+	voters    []string
+	transport Transport
+	apply     func(Entry)
+	logger    *slog.Logger
+	heartbeat time.Duration
+	path      string // See storage.go
+
+	// See snapshot.go.
+	snapEvery uint64
+	save      func() []byte
+	load      func([]byte)
+
+	mu       sync.Mutex
+	role     Role
+	term     uint64
+	votedFor string
+	log      []Entry // log[0] stands for the last entry in the snapshot, or index 0
+	snapshot []byte  // The state up to log[0]
+	commit   uint64
+	applied  uint64
+	leader   string
+	learners []string
+	deadline time.Time // When a follower gives up on the leader
+
+	// Leader only: the next entry to send each peer, the last one it is
+	// known to hold, and whether a msgAppend to it is on its way.
+	next     map[string]uint64
+	match    map[string]uint64
+	inflight map[string]bool
+
+	// The entries this node proposed as leader, by index, until applied.
+	waiting map[uint64]waiter
+
+	newCommit *sync.Cond    // Signaled when commit moves or the node stops
+	newApply  chan struct{} // Closed, and replaced, whenever applied moves
+	stopped   bool
+	done      chan struct{}
+}
+
+// New creates the node id of the group made of voters, which may or may
+// not include id itself; if not, the node is a learner. apply is called
+// with every committed entry, in log order and from a single goroutine.
+func New(id string, voters []string, transport Transport, apply func(Entry), opts ...Option) *Node {
+	n := &Node{
+		id:        id,
+		voters:    slices.Clone(voters),
+		transport: transport,
+		apply:     apply,
+		logger:    slog.Default(),
+		heartbeat: DefaultHeartbeat,
+		log:       []Entry{{}},
+		waiting:   make(map[uint64]waiter),
+		newApply:  make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	n.newCommit = sync.NewCond(&n.mu)
+	for _, opt := range opts {
+		opt(n)
+	}
+	n.role = n.follower()
+	return n
+}
+
+// Start reads back the node's saved state, if WithStorage names a file
+// that exists, then runs its timers and applies committed entries until
+// Stop.
+func (n *Node) Start() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.path != "" {
+		if err := n.restore(); err != nil {
+			return err
+		}
+	}
+	n.resetDeadline()
+	go n.run()
+	go n.applyCommitted()
+	return nil
+}
+
+// Stop stops the node. Entries committed but not yet applied are not.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.halt()
+}
+
+// halt stops the node. The caller must hold n.mu.
+func (n *Node) halt() {
+	if n.stopped {
+		return
+	}
+	n.stopped = true
+	close(n.done)
+	n.newCommit.Broadcast()
+}
+
+// Status returns the node's current view of the group.
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return Status{Role: n.role, Term: n.term, Leader: n.leader, Commit: n.commit, Applied: n.applied}
+}
+
+// SetLearners sets the nodes that follow the log without voting. Voters
+// and the node itself are left out. Every node should be told, as any
+// voter may become the leader.
+func (n *Node) SetLearners(addrs []string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.learners = n.learners[:0]
+	for _, addr := range addrs {
+		if addr != n.id && !slices.Contains(n.voters, addr) && !slices.Contains(n.learners, addr) {
+			n.learners = append(n.learners, addr)
+		}
+	}
+	if n.role == Leader {
+		for _, peer := range n.learners {
+			if _, ok := n.next[peer]; !ok {
+				n.next[peer], n.match[peer] = n.last().Index+1, 0
+			}
+		}
+	}
+}
+
+// -------- Proposals --------
+
+// Propose appends data to the log through the leader and returns its
+// index once the entry is committed and applied on this node. A proposal
+// whose reply got lost may be retried and so applied twice: commands
+// should be idempotent.
+func (n *Node) Propose(ctx context.Context, data []byte) (uint64, error) {
+	if len(data) == 0 {
+		return 0, errEmpty
+	}
+	var lastErr error = ErrNoLeader
+	for {
+		n.mu.Lock()
+		role, leader, stopped := n.role, n.leader, n.stopped
+		n.mu.Unlock()
+		if stopped {
+			return 0, ErrStopped
+		}
+		if role == Leader {
+			return n.proposeHere(ctx, data)
+		}
+
+		// Try the leader if it is known, else every voter: one that is not
+		// the leader may name it.
+		targets := slices.Clone(n.voters)
+		if leader != "" {
+			targets = []string{leader}
+		}
+		for i := 0; i < len(targets) && i < 2*len(n.voters); i++ {
+			peer := targets[i]
+			if peer == n.id {
+				continue
+			}
+			reply, err := n.send(ctx, peer, &message{Kind: msgPropose, From: n.id, Data: data})
+			switch {
+			case err != nil:
+				lastErr = err
+			case reply.OK:
+				return reply.Hint, n.waitApplied(ctx, reply.Hint)
+			case reply.Err != errNotLeader.Error():
+				return 0, errors.New(reply.Err)
+			case reply.Leader != "" && reply.Leader != peer:
+				targets = append(targets, reply.Leader)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, errors.Join(lastErr, ctx.Err())
+		case <-n.done:
+			return 0, ErrStopped
+		case <-time.After(n.heartbeat):
+		}
+	}
+}
+
+// waiter is told whether the entry applied at its index was the one
+// proposed, or is closed if the node lost track of it.
+type waiter struct {
+	term uint64
+	done chan bool
+}
+
+// proposeHere appends data to the log of this node, the leader.
+func (n *Node) proposeHere(ctx context.Context, data []byte) (uint64, error) {
+	n.mu.Lock()
+	if n.role != Leader {
+		n.mu.Unlock()
+		return 0, errNotLeader
+	}
+	e := Entry{Index: n.last().Index + 1, Term: n.term, Data: data}
+	n.log = append(n.log, e)
+	if err := n.persist(); err != nil {
+		n.mu.Unlock()
+		return 0, err
+	}
+	w := waiter{term: e.Term, done: make(chan bool, 1)}
+	n.waiting[e.Index] = w
+	n.advanceCommit()
+	n.broadcast()
+	n.mu.Unlock()
+
+	select {
+	case ours := <-w.done:
+		if !ours {
+			return 0, ErrLost
+		}
+		return e.Index, nil
+	case <-ctx.Done():
+		n.mu.Lock()
+		delete(n.waiting, e.Index)
+		n.mu.Unlock()
+		return 0, ctx.Err()
+	case <-n.done:
+		return 0, ErrStopped
+	}
+}
+
+// waitApplied waits until the entry at index was applied here.
+func (n *Node) waitApplied(ctx context.Context, index uint64) error {
+	for {
+		n.mu.Lock()
+		applied, ch := n.applied, n.newApply
+		n.mu.Unlock()
+		if applied >= index {
+			return nil
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-n.done:
+			return ErrStopped
+		}
+	}
+}
+
+// applyCommitted hands committed entries to apply until Stop. Entries
+// without data are the no-ops new leaders add and are skipped. A node
+// whose log now starts past what it applied, because the leader sent it
+// a snapshot, loads that first.
+func (n *Node) applyCommitted() {
+	for {
+		n.mu.Lock()
+		for n.applied >= n.commit && !n.stopped {
+			n.newCommit.Wait()
+		}
+		if n.stopped {
+			n.mu.Unlock()
+			return
+		}
+		if base := n.base(); n.applied < base.Index {
+			snapshot := n.snapshot
+			n.mu.Unlock()
+			if n.load != nil {
+				n.load(snapshot)
+			}
+			n.mu.Lock()
+			for index, w := range n.waiting {
+				if index <= base.Index {
+					close(w.done)
+					delete(n.waiting, index)
+				}
+			}
+			n.setApplied(base.Index)
+			n.mu.Unlock()
+			continue
+		}
+		entries := slices.Clone(n.log[n.applied+1-n.base().Index : n.commit+1-n.base().Index])
+		n.mu.Unlock()
+
+		for _, e := range entries {
+			if len(e.Data) > 0 {
+				n.apply(e)
+			}
+		}
+
+		n.mu.Lock()
+		for _, e := range entries {
+			if w, ok := n.waiting[e.Index]; ok {
+				w.done <- w.term == e.Term
+				delete(n.waiting, e.Index)
+			}
+		}
+		n.setApplied(entries[len(entries)-1].Index)
+		n.mu.Unlock()
+
+		n.maybeSnapshot()
+	}
+}
+
+// setApplied records that the entries up to index were applied. The caller
+// must hold n.mu.
+func (n *Node) setApplied(index uint64) {
+	n.applied = index
+	close(n.newApply)
+	n.newApply = make(chan struct{})
+}
+
+// -------- Timers & Elections --------
+
+// run ticks every heartbeat until Stop: the leader sends heartbeats and
+// the others check whether their election timeout ran out.
+func (n *Node) run() {
+	ticker := time.NewTicker(n.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+			n.mu.Lock()
+			switch {
+			case n.role == Leader:
+				n.broadcast()
+			case n.role != Learner && time.Now().After(n.deadline):
+				n.campaign()
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+// resetDeadline picks a new random election timeout, so that followers
+// rarely all give up on a leader at once. The caller must hold n.mu.
+func (n *Node) resetDeadline() {
+	timeout := 10*n.heartbeat + rand.N(10*n.heartbeat)
+	n.deadline = time.Now().Add(timeout)
+}
+
+// campaign starts an election. The caller must hold n.mu.
+func (n *Node) campaign() {
+	n.role = Candidate
+	n.term++
+	n.votedFor = n.id
+	n.leader = ""
+	if n.persist() != nil {
+		return
+	}
+	n.resetDeadline()
+	n.logger.Info("starting election", "term", n.term)
+
+	term, last := n.term, n.last()
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeader()
+		return
+	}
+	req := &message{Kind: msgVote, Term: term, From: n.id, Index: last.Index, LogTerm: last.Term}
+	for _, peer := range n.voters {
+		if peer == n.id {
+			continue
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*n.heartbeat)
+			defer cancel()
+			reply, err := n.send(ctx, peer, req)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if reply.Term > n.term {
+				n.stepDown(reply.Term)
+				return
+			}
+			if n.role != Candidate || n.term != term || !reply.OK {
+				return
+			}
+			if votes++; votes == n.quorum() {
+				n.becomeLeader()
+			}
+		}()
+	}
+}
+
+// becomeLeader takes over after winning an election. It appends an empty
+// entry, since entries of earlier terms only count as committed once one
+// of its own term is. The caller must hold n.mu.
+func (n *Node) becomeLeader() {
+	n.role = Leader
+	n.leader = n.id
+	n.logger.Info("elected leader", "term", n.term)
+
+	n.log = append(n.log, Entry{Index: n.last().Index + 1, Term: n.term})
+	if n.persist() != nil {
+		return
+	}
+	n.next = make(map[string]uint64)
+	n.match = make(map[string]uint64)
+	n.inflight = make(map[string]bool)
+	for _, peer := range n.peers() {
+		n.next[peer], n.match[peer] = n.last().Index, 0
+	}
+	n.advanceCommit()
+	n.broadcast()
+}
+
+// stepDown follows whoever leads term, which is at least the node's own.
+// The caller must hold n.mu.
+func (n *Node) stepDown(term uint64) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.leader = ""
+		n.persist()
+	}
+	if n.role == Leader || n.role == Candidate {
+		n.logger.Info("stepping down", "term", n.term)
+	}
+	n.role = n.follower()
+	n.resetDeadline()
+}
+
+// -------- Replication --------
+
+// broadcast sends every peer the entries it lacks, or a heartbeat. The
+// caller must hold n.mu.
+func (n *Node) broadcast() {
+	for _, peer := range n.peers() {
+		n.replicate(peer)
+	}
+}
+
+// replicate sends one msgAppend to peer unless one is on its way. The
+// caller must hold n.mu.
+func (n *Node) replicate(peer string) {
+	if n.inflight[peer] {
+		return
+	}
+	next, ok := n.next[peer]
+	if !ok {
+		next = n.last().Index + 1
+		n.next[peer] = next
+	}
+	var req *message
+	if base := n.base(); next <= base.Index {
+		// The peer lacks entries the snapshot replaced.
+		req = &message{Kind: msgSnapshot, Term: n.term, From: n.id, Index: base.Index, LogTerm: base.Term, Data: n.snapshot}
+	} else {
+		prev := n.entry(next - 1)
+		end := min(next+maxBatch, n.last().Index+1)
+		req = &message{
+			Kind:    msgAppend,
+			Term:    n.term,
+			From:    n.id,
+			Index:   prev.Index,
+			LogTerm: prev.Term,
+			Commit:  n.commit,
+			Entries: slices.Clone(n.log[next-base.Index : end-base.Index]),
+		}
+	}
+	n.inflight[peer] = true
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*n.heartbeat)
+		defer cancel()
+		reply, err := n.send(ctx, peer, req)
+
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if n.role != Leader || n.term != req.Term {
+			if err == nil && reply.Term > n.term {
+				n.stepDown(reply.Term)
+			}
+			return
+		}
+		n.inflight[peer] = false
+		if err != nil {
+			return
+		}
+		if reply.Term > n.term {
+			n.stepDown(reply.Term)
+			return
+		}
+
+		if reply.OK {
+			// A snapshot has no entries: the peer holds up to its index.
+			n.match[peer] = max(n.match[peer], req.Index+uint64(len(req.Entries)))
+			n.next[peer] = n.match[peer] + 1
+			n.advanceCommit()
+		} else {
+			n.next[peer] = max(1, min(reply.Hint, n.next[peer]-1))
+		}
+		// Keep going while the peer is behind.
+		if n.next[peer] <= n.last().Index {
+			n.replicate(peer)
+		}
+	}()
+}
+
+// advanceCommit commits the newest entry of the current term a majority of
+// voters hold. The caller must hold n.mu.
+func (n *Node) advanceCommit() {
+	for index := n.last().Index; index > n.commit && n.entry(index).Term == n.term; index-- {
+		count := 0
+		for _, voter := range n.voters {
+			if voter == n.id || n.match[voter] >= index {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.commit = index
+			n.newCommit.Broadcast()
+			return
+		}
+	}
+}
+
+// -------- Incoming Messages --------
+
+// Handle answers a message another node sent through its Transport.
+func (n *Node) Handle(ctx context.Context, data []byte) ([]byte, error) {
+	select {
+	case <-n.done:
+		return nil, ErrStopped
+	default:
+	}
+	m, err := decodeMessage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var reply *message
+	switch m.Kind {
+	case msgVote:
+		reply = n.handleVote(m)
+	case msgAppend:
+		reply = n.handleAppend(m)
+	case msgPropose:
+		reply = n.handlePropose(ctx, m)
+	case msgSnapshot:
+		reply = n.handleSnapshot(m)
+	case msgReadIndex:
+		reply = n.handleReadIndex(ctx, m)
+	default:
+		return nil, errBadMessage
+	}
+	reply.From = n.id
+	return reply.encode(), nil
+}
+
+func (n *Node) handleVote(m *message) *message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if m.Term > n.term {
+		n.stepDown(m.Term)
+	}
+	reply := &message{Kind: msgVoteReply, Term: n.term}
+	last := n.last()
+	upToDate := m.LogTerm > last.Term || (m.LogTerm == last.Term && m.Index >= last.Index)
+	if m.Term == n.term && n.role != Learner && (n.votedFor == "" || n.votedFor == m.From) && upToDate {
+		n.votedFor = m.From
+		if n.persist() != nil {
+			return reply // Not a vote it would remember
+		}
+		n.resetDeadline()
+		reply.OK = true
+	}
+	return reply
+}
+
+func (n *Node) handleAppend(m *message) *message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	reply := &message{Kind: msgAppendReply, Term: n.term}
+	if m.Term < n.term {
+		return reply
+	}
+	if m.Term > n.term || n.role != n.follower() {
+		n.stepDown(m.Term)
+	}
+	n.leader = m.From
+	n.resetDeadline()
+	reply.Term = n.term
+
+	// Entries up to our snapshot are committed, so they match the
+	// leader's: skip them.
+	prevIndex, entries := m.Index, m.Entries
+	if base := n.base(); prevIndex < base.Index {
+		skip := min(base.Index-prevIndex, uint64(len(entries)))
+		prevIndex, entries = prevIndex+skip, entries[skip:]
+		if prevIndex < base.Index {
+			reply.OK = true
+			return reply
+		}
+	}
+
+	// The entry before the new ones must match, or the leader backs up:
+	// to just past our log, or to the start of the conflicting term.
+	last, base := n.last(), n.base()
+	if prevIndex > last.Index {
+		reply.Hint = last.Index + 1
+		return reply
+	}
+	if t := n.entry(prevIndex).Term; t != m.LogTerm && prevIndex == m.Index {
+		i := prevIndex
+		for i > base.Index+1 && n.entry(i-1).Term == t {
+			i--
+		}
+		reply.Hint = i
+		return reply
+	}
+
+	for j, e := range entries {
+		if e.Index <= n.last().Index {
+			if n.entry(e.Index).Term == e.Term {
+				continue
+			}
+			n.log = n.log[:e.Index-base.Index]
+		}
+		n.log = append(n.log, entries[j:]...)
+		if n.persist() != nil {
+			return reply // Not entries it would remember
+		}
+		break
+	}
+
+	// An old msgAppend may carry fewer entries than were committed since.
+	if m.Commit > n.commit {
+		n.commit = max(n.commit, min(m.Commit, prevIndex+uint64(len(entries))))
+		n.newCommit.Broadcast()
+	}
+	reply.OK = true
+	return reply
+}
+
+// handlePropose appends a proposal another node forwarded, if this node
+// is the leader. Otherwise it names the leader, if it knows it, and the
+// sender tries there.
+func (n *Node) handlePropose(ctx context.Context, m *message) *message {
+	reply := &message{Kind: msgProposeReply}
+	index, err := n.proposeHere(ctx, m.Data)
+	if err != nil {
+		reply.Err = err.Error()
+		reply.Leader = n.Status().Leader
+		return reply
+	}
+	reply.OK, reply.Hint = true, index
+	return reply
+}
+
+// -------- Helpers --------
+
+// send delivers m to peer and decodes its reply.
+func (n *Node) send(ctx context.Context, peer string, m *message) (*message, error) {
+	data, err := n.transport(ctx, peer, m.encode())
+	if err != nil {
+		return nil, err
+	}
+	return decodeMessage(data)
+}
+
+// last returns the last entry of the log. The caller must hold n.mu.
+func (n *Node) last() Entry {
+	return n.log[len(n.log)-1]
+}
+
+// base returns the entry the log starts after: the last one the snapshot
+// covers, without its data. The caller must hold n.mu.
+func (n *Node) base() Entry {
+	return n.log[0]
+}
+
+// entry returns the entry at index, which must be from base to last. The
+// caller must hold n.mu.
+func (n *Node) entry(index uint64) Entry {
+	return n.log[index-n.base().Index]
+}
+
+// quorum returns how many voters make a majority.
+func (n *Node) quorum() int {
+	return len(n.voters)/2 + 1
+}
+
+// follower returns the role the node has when not leading: Follower for
+// voters, Learner for the others.
+func (n *Node) follower() Role {
+	if slices.Contains(n.voters, n.id) {
+		return Follower
+	}
+	return Learner
+}
+
+// peers returns every node the leader sends entries to. The caller must
+// hold n.mu.
+func (n *Node) peers() []string {
+	var peers []string
+	for _, addr := range append(slices.Clone(n.voters), n.learners...) {
+		if addr != n.id {
+			peers = append(peers, addr)
+		}
+	}
+	return peers
+}
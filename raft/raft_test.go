@@ -0,0 +1,401 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testHeartbeat = 10 * time.Millisecond
+
+// testGroup is a set of nodes talking through memory. Nodes can be cut
+// off, after which nothing reaches them or leaves them.
+type testGroup struct {
+	t      *testing.T
+	voters []string
+
+	mu      sync.Mutex
+	nodes   map[string]*Node
+	cut     map[string]bool
+	applied map[string][]string
+}
+
+func newTestGroup(t *testing.T, voters ...string) *testGroup {
+	g := &testGroup{t: t, voters: voters, nodes: map[string]*Node{}, cut: map[string]bool{}, applied: map[string][]string{}}
+	for _, id := range voters {
+		g.add(id)
+	}
+	t.Cleanup(func() {
+		for _, n := range g.nodes {
+			n.Stop()
+		}
+	})
+	return g
+}
+
+// add starts node id, a learner if it is not one of the voters.
+func (g *testGroup) add(id string, opts ...Option) *Node {
+	transport := func(ctx context.Context, peer string, msg []byte) ([]byte, error) {
+		g.mu.Lock()
+		to, cut := g.nodes[peer], g.cut[id] || g.cut[peer]
+		g.mu.Unlock()
+		if to == nil || cut {
+			return nil, errors.New("unreachable")
+		}
+		return to.Handle(ctx, msg)
+	}
+	apply := func(e Entry) {
+		g.mu.Lock()
+		g.applied[id] = append(g.applied[id], string(e.Data))
+		g.mu.Unlock()
+	}
+	n := New(id, g.voters, transport, apply, append([]Option{WithHeartbeat(testHeartbeat)}, opts...)...)
+	g.mu.Lock()
+	g.nodes[id] = n
+	g.applied[id] = nil
+	g.mu.Unlock()
+	if err := n.Start(); err != nil {
+		g.t.Fatal(err)
+	}
+	return n
+}
+
+func (g *testGroup) setCut(id string, cut bool) {
+	g.mu.Lock()
+	g.cut[id] = cut
+	g.mu.Unlock()
+}
+
+// leader waits for exactly one reachable node to lead.
+func (g *testGroup) leader() string {
+	g.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var leaders []string
+		g.mu.Lock()
+		for id, n := range g.nodes {
+			if !g.cut[id] && n.Status().Role == Leader {
+				leaders = append(leaders, id)
+			}
+		}
+		g.mu.Unlock()
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+		time.Sleep(testHeartbeat)
+	}
+	g.t.Fatal("no single leader elected")
+	return ""
+}
+
+// waitApplied waits until every node in ids applied want.
+func (g *testGroup) waitApplied(want []string, ids ...string) {
+	g.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		g.mu.Lock()
+		done := true
+		for _, id := range ids {
+			done = done && reflect.DeepEqual(g.applied[id], want)
+		}
+		snapshot := fmt.Sprint(g.applied)
+		g.mu.Unlock()
+		if done {
+			return
+		}
+		if time.Now().After(deadline) {
+			g.t.Fatalf("want %v applied on %v, have %s", want, ids, snapshot)
+		}
+		time.Sleep(testHeartbeat)
+	}
+}
+
+func propose(t *testing.T, n *Node, data string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := n.Propose(ctx, []byte(data)); err != nil {
+		t.Fatalf("Propose(%q): %v", data, err)
+	}
+}
+
+func TestElectsOneLeader(t *testing.T) {
+	g := newTestGroup(t, "a", "b", "c")
+	leader := g.leader()
+
+	term := g.nodes[leader].Status().Term
+	deadline := time.Now().Add(time.Second)
+	for _, id := range g.voters {
+		for g.nodes[id].Status().Leader != leader && time.Now().Before(deadline) {
+			time.Sleep(testHeartbeat)
+		}
+		if st := g.nodes[id].Status(); st.Leader != leader || st.Term != term {
+			t.Errorf("%s follows %q in term %d, want %s in term %d", id, st.Leader, st.Term, leader, term)
+		}
+	}
+}
+
+func TestProposalsAppliedInOrderEverywhere(t *testing.T) {
+	g := newTestGroup(t, "a", "b", "c")
+	g.leader()
+
+	// Proposals made on followers go through the leader.
+	var want []string
+	for i := range 12 {
+		data := fmt.Sprint("cmd-", i)
+		propose(t, g.nodes[g.voters[i%3]], data)
+		want = append(want, data)
+	}
+	g.waitApplied(want, g.voters...)
+}
+
+func TestNewLeaderAfterFailure(t *testing.T) {
+	g := newTestGroup(t, "a", "b", "c")
+	old := g.leader()
+	propose(t, g.nodes[old], "before")
+
+	g.setCut(old, true)
+	leader := g.leader()
+	if leader == old {
+		t.Fatal("the cut-off leader is still the only leader")
+	}
+	propose(t, g.nodes[leader], "after")
+	term := g.nodes[leader].Status().Term
+
+	// The old leader catches up once it can talk again, in the new term.
+	g.setCut(old, false)
+	g.waitApplied([]string{"before", "after"}, g.voters...)
+	if st := g.nodes[old].Status(); st.Term < term {
+		t.Errorf("old leader still in term %d, want at least %d", st.Term, term)
+	}
+}
+
+func TestMinorityCannotCommit(t *testing.T) {
+	g := newTestGroup(t, "a", "b", "c")
+	leader := g.leader()
+	for _, id := range g.voters {
+		if id != leader {
+			g.setCut(id, true)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*testHeartbeat)
+	defer cancel()
+	if _, err := g.nodes[leader].Propose(ctx, []byte("alone")); err == nil {
+		t.Fatal("a leader without a majority committed a proposal")
+	}
+}
+
+func TestLearnerFollowsWithoutVoting(t *testing.T) {
+	g := newTestGroup(t, "a", "b", "c")
+	leader := g.leader()
+	propose(t, g.nodes[leader], "one")
+
+	learner := g.add("d")
+	for _, n := range g.nodes {
+		n.SetLearners([]string{"d"})
+	}
+	propose(t, learner, "two") // Learners may propose too
+	g.waitApplied([]string{"one", "two"}, "a", "b", "c", "d")
+
+	// Cut off, a learner waits instead of starting elections.
+	g.setCut("d", true)
+	time.Sleep(40 * testHeartbeat)
+	if st := learner.Status(); st.Role != Learner || st.Term != g.nodes[leader].Status().Term {
+		t.Errorf("learner is a %s in term %d", st.Role, st.Term)
+	}
+}
+
+func TestStorageSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft")
+	g := newTestGroup(t, "a")
+	g.nodes["a"].Stop()
+
+	// A single voter commits on its own.
+	n := g.add("a", WithStorage(path))
+	g.leader()
+	propose(t, n, "x")
+	propose(t, n, "y")
+	term := n.Status().Term
+	n.Stop()
+
+	// Restarted, it reads back its log and applies it again.
+	n = g.add("a", WithStorage(path))
+	g.leader()
+	g.waitApplied([]string{"x", "y"}, "a")
+	if st := n.Status(); st.Term <= term {
+		t.Errorf("term %d after restart, want past %d", st.Term, term)
+	}
+}
+
+func TestStorageFailureStopsNode(t *testing.T) {
+	dir := t.TempDir()
+	g := newTestGroup(t, "a", "b", "c")
+	g.nodes["c"].Stop()
+	g.setCut("c", true)
+	c := g.add("c", WithStorage(filepath.Join(dir, "raft")))
+	g.leader() // a or b, as c is cut off
+	g.setCut("c", false)
+	propose(t, g.nodes["a"], "x")
+	g.waitApplied([]string{"x"}, "a", "b", "c")
+
+	// c can no longer save what it is sent, so it stops rather than
+	// acknowledge entries it would forget.
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	propose(t, g.nodes["a"], "y")
+	g.waitApplied([]string{"x", "y"}, "a", "b")
+	deadline := time.Now().Add(5 * time.Second)
+	for _, err := c.Handle(context.Background(), nil); !errors.Is(err, ErrStopped); _, err = c.Handle(context.Background(), nil) {
+		if time.Now().After(deadline) {
+			t.Fatalf("c still answers messages: %v", err)
+		}
+		time.Sleep(testHeartbeat)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !slices.Equal(g.applied["c"], []string{"x"}) {
+		t.Errorf("c applied %v after its storage failed, want [x]", g.applied["c"])
+	}
+}
+
+// withTestSnapshots has node id snapshot the commands it applied.
+func (g *testGroup) withTestSnapshots(id string, every int) Option {
+	save := func() []byte {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return []byte(strings.Join(g.applied[id], ","))
+	}
+	load := func(data []byte) {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.applied[id] = strings.Split(string(data), ",")
+	}
+	return WithSnapshots(every, save, load)
+}
+
+func TestSnapshotCatchesUpLaggingFollower(t *testing.T) {
+	g := newTestGroup(t)
+	g.voters = []string{"a", "b", "c"}
+	for _, id := range g.voters {
+		g.add(id, g.withTestSnapshots(id, 4))
+	}
+	leader := g.leader()
+	var lagging string
+	for _, id := range g.voters {
+		if id != leader {
+			lagging = id
+			break
+		}
+	}
+
+	// The leader drops the entries the cut-off follower misses, so it has
+	// to send its snapshot.
+	g.setCut(lagging, true)
+	var want []string
+	for i := range 10 {
+		data := fmt.Sprint("cmd-", i)
+		propose(t, g.nodes[leader], data)
+		want = append(want, data)
+	}
+	n := g.nodes[leader]
+	n.mu.Lock()
+	base := n.base().Index
+	n.mu.Unlock()
+	if base == 0 {
+		t.Fatal("the leader took no snapshot")
+	}
+
+	g.setCut(lagging, false)
+	g.waitApplied(want, g.voters...)
+	propose(t, g.nodes[lagging], "after")
+	g.waitApplied(append(want, "after"), g.voters...)
+}
+
+func TestSnapshotSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft")
+	g := newTestGroup(t, "a")
+	g.nodes["a"].Stop()
+
+	n := g.add("a", WithStorage(path), g.withTestSnapshots("a", 2))
+	g.leader()
+	for _, data := range []string{"x", "y", "z"} {
+		propose(t, n, data)
+	}
+	n.Stop()
+
+	n = g.add("a", WithStorage(path), g.withTestSnapshots("a", 2))
+	g.leader()
+	g.waitApplied([]string{"x", "y", "z"}, "a")
+}
+
+func TestReadIndex(t *testing.T) {
+	g := newTestGroup(t, "a", "b", "c")
+	leader := g.leader()
+	propose(t, g.nodes[leader], "x")
+
+	// Once ReadIndex returns, a follower applied what was committed.
+	for _, id := range g.voters {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := g.nodes[id].ReadIndex(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("ReadIndex on %s: %v", id, err)
+		}
+		g.mu.Lock()
+		applied := slices.Clone(g.applied[id])
+		g.mu.Unlock()
+		if !reflect.DeepEqual(applied, []string{"x"}) {
+			t.Errorf("%s applied %v after ReadIndex", id, applied)
+		}
+	}
+
+	// A leader cut off from the others cannot confirm it still leads,
+	// though it does not know it was replaced yet.
+	g.setCut(leader, true)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*testHeartbeat)
+	defer cancel()
+	if err := g.nodes[leader].ReadIndex(ctx); err == nil {
+		t.Error("a cut-off leader confirmed a read index")
+	}
+}
+
+func TestMessageEncoding(t *testing.T) {
+	m := &message{
+		Kind:    msgAppend,
+		Term:    7,
+		From:    "10.0.0.1:7000",
+		Index:   41,
+		LogTerm: 6,
+		Commit:  40,
+		Entries: []Entry{{Index: 42, Term: 7, Data: []byte("join")}, {Index: 43, Term: 7}},
+		OK:      true,
+		Hint:    3,
+		Leader:  "10.0.0.2:7000",
+		Err:     "oops",
+		Data:    []byte("payload"),
+	}
+	got, err := decodeMessage(m.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("decoded %+v, want %+v", got, m)
+	}
+
+	data := m.encode()
+	for _, bad := range [][]byte{data[:len(data)-1], append(data, 0)} {
+		if _, err := decodeMessage(bad); err == nil {
+			t.Errorf("decoded a malformed message of %d bytes", len(bad))
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// -------- Linearizable Reads --------
+// Reading a node's state machine directly may return stale data: a
+// follower may lag, and a leader may have been replaced without knowing
+// it yet. Putting every read through the log would fix that, at the cost
+// of an entry, and a disk write, per read. ReadIndex (section 6.4 of
+// Ongaro's thesis) does without:
+//
+//  1. The leader notes its commit index. It must have committed an entry
+//     of its own term first, or it may not know the latest commits.
+//  2. It checks it is still the leader: a majority of voters must answer
+//     a heartbeat for its term.
+//  3. The reading node waits until it applied up to that index, then
+//     reads locally.
+//
+// Whatever was committed before the read started is then visible, which
+// is what linearizability asks of a read. A follower asks the leader for
+// steps 1 and 2 (msgReadIndex) and does step 3 itself.
+
+var errNotConfirmed = errors.New("raft: leadership not confirmed")
+
+// ReadIndex waits until this node's state machine holds every entry
+// committed before the call, so that reading it is linearizable.
+func (n *Node) ReadIndex(ctx context.Context) error {
+	var lastErr error = ErrNoLeader
+	for {
+		n.mu.Lock()
+		role, leader, stopped := n.role, n.leader, n.stopped
+		n.mu.Unlock()
+		if stopped {
+			return ErrStopped
+		}
+
+		if role == Leader {
+			index, err := n.readIndexHere(ctx)
+			if err == nil {
+				return n.waitApplied(ctx, index)
+			}
+			lastErr = err
+		} else if leader != "" {
+			reply, err := n.send(ctx, leader, &message{Kind: msgReadIndex, From: n.id})
+			switch {
+			case err != nil:
+				lastErr = err
+			case reply.OK:
+				return n.waitApplied(ctx, reply.Hint)
+			default:
+				lastErr = errors.New(reply.Err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(lastErr, ctx.Err())
+		case <-n.done:
+			return ErrStopped
+		case <-time.After(n.heartbeat):
+		}
+	}
+}
+
+// readIndexHere returns the commit index of this node, the leader, once a
+// majority of voters confirmed it still leads.
+func (n *Node) readIndexHere(ctx context.Context) (uint64, error) {
+	n.mu.Lock()
+	if n.role != Leader {
+		n.mu.Unlock()
+		return 0, errNotLeader
+	}
+	if n.entry(n.commit).Term != n.term {
+		n.mu.Unlock()
+		return 0, errNotConfirmed // Its no-op is not committed yet
+	}
+	index, term, base := n.commit, n.term, n.base()
+	// A heartbeat that changes nothing: no entries, no commit index.
+	req := &message{Kind: msgAppend, Term: term, From: n.id, Index: base.Index, LogTerm: base.Term}
+	var others []string
+	for _, voter := range n.voters {
+		if voter != n.id {
+			others = append(others, voter)
+		}
+	}
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*n.heartbeat)
+	defer cancel()
+	acks := make(chan bool, len(others))
+	for _, peer := range others {
+		go func() {
+			reply, err := n.send(ctx, peer, req)
+			if err == nil && reply.Term > term {
+				n.mu.Lock()
+				if reply.Term > n.term {
+					n.stepDown(reply.Term)
+				}
+				n.mu.Unlock()
+			}
+			// A follower that refuses the entry still accepted the term.
+			acks <- err == nil && reply.Term == term
+		}()
+	}
+
+	count := 1
+	for range others {
+		if count >= n.quorum() {
+			break
+		}
+		if <-acks {
+			count++
+		}
+	}
+	if count < n.quorum() {
+		return 0, errNotConfirmed
+	}
+	return index, nil
+}
+
+// handleReadIndex runs steps 1 and 2 for a follower, if this node is the
+// leader.
+func (n *Node) handleReadIndex(ctx context.Context, m *message) *message {
+	reply := &message{Kind: msgReadIndexReply}
+	index, err := n.readIndexHere(ctx)
+	if err != nil {
+		reply.Err = err.Error()
+		reply.Leader = n.Status().Leader
+		return reply
+	}
+	reply.OK, reply.Hint = true, index
+	return reply
+}
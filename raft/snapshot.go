@@ -0,0 +1,83 @@
+package raft
+
+import "slices"
+
+// -------- Snapshots --------
+// A log that is never cut grows with every command, and so does the time
+// a new node takes to replay it. With WithSnapshots, once enough entries
+// were applied since the last snapshot, the node asks the state machine
+// for a copy of its state and drops the entries that built it; log[0]
+// then stands for the last of them.
+//
+// A follower that lacks entries the leader already dropped gets the
+// snapshot instead (msgSnapshot, InstallSnapshot in the paper). It keeps
+// whatever of its log follows the snapshot, if that agrees with it, and
+// otherwise starts over from the snapshot; its applier loads the snapshot
+// into the state machine before applying anything past it.
+//
+// Every node of a group should take snapshots, or none: a node without
+// load cannot install one.
+
+// maybeSnapshot takes a snapshot if WithSnapshots asks for one by now. It
+// runs on the applier, so the state saved is exactly the entries up to
+// applied.
+func (n *Node) maybeSnapshot() {
+	n.mu.Lock()
+	index := n.applied
+	due := n.save != nil && index-n.base().Index >= n.snapEvery
+	n.mu.Unlock()
+	if !due {
+		return
+	}
+
+	data := n.save()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	base := n.base()
+	if index <= base.Index {
+		return // A snapshot from the leader got here first
+	}
+	log := slices.Clone(n.log[index-base.Index:])
+	log[0] = Entry{Index: index, Term: log[0].Term}
+	n.log, n.snapshot = log, data
+	n.persist()
+}
+
+// handleSnapshot installs a snapshot from the leader.
+func (n *Node) handleSnapshot(m *message) *message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	reply := &message{Kind: msgSnapshotReply, Term: n.term}
+	if m.Term < n.term {
+		return reply
+	}
+	if m.Term > n.term || n.role != n.follower() {
+		n.stepDown(m.Term)
+	}
+	n.leader = m.From
+	n.resetDeadline()
+	reply.Term = n.term
+	reply.OK = true
+	if m.Index <= n.commit {
+		return reply // Everything in it is here already
+	}
+
+	base, last := n.base(), n.last()
+	if m.Index <= last.Index && n.entry(m.Index).Term == m.LogTerm {
+		n.log = slices.Clone(n.log[m.Index-base.Index:])
+	} else {
+		n.log = make([]Entry, 1)
+	}
+	n.log[0] = Entry{Index: m.Index, Term: m.LogTerm}
+	n.snapshot = m.Data
+	if n.persist() != nil {
+		reply.OK = false
+		return reply
+	}
+	n.commit = m.Index
+	n.newCommit.Broadcast()
+	n.logger.Info("installed snapshot", "index", m.Index, "term", m.LogTerm)
+	return reply
+}
@@ -0,0 +1,108 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// -------- Storage --------
+// Raft is only safe if a node remembers its term, its vote and its log
+// across restarts: a node that forgot its vote could vote twice in one
+// term, and one that forgot entries could help elect a leader missing
+// committed ones. With WithStorage they are kept in a file, rewritten
+// whole (to a temporary file, synced, then renamed over the old one)
+// whenever they change, so a crash leaves either the old or the new
+// state. That is fine for a small log such as cluster metadata, or a log
+// that snapshots keep short. The snapshot, if any, is kept with the log:
+//
+//	[8 term][s voted for][8 snapshot index][8 snapshot term][b snapshot]
+//	[4 count]([8 index][8 term][b data])...
+//
+// A node that cannot save its state stops at once, as if it had crashed:
+// it must not vote, acknowledge entries or lead on a state it would
+// forget. It can be restarted once the storage is fixed.
+//
+// Without storage the node keeps them in memory only and must not rejoin
+// its group after a restart under the same ID with an empty state unless
+// a majority of the voters kept theirs.
+
+// persist writes the node's state out, or stops the node if it cannot.
+// The caller must hold n.mu.
+func (n *Node) persist() error {
+	if n.path == "" {
+		return nil
+	}
+	var e encoder
+	e.u64(n.term)
+	e.bytes([]byte(n.votedFor))
+	e.u64(n.base().Index)
+	e.u64(n.base().Term)
+	e.bytes(n.snapshot)
+	e.entries(n.log[1:])
+
+	if err := writeFile(n.path, e.buf); err != nil {
+		n.logger.Error("cannot save raft state, stopping", "path", n.path, "err", err)
+		n.halt()
+		return err
+	}
+	return nil
+}
+
+// restore reads the state persist wrote, if there is any.
+func (n *Node) restore() error {
+	data, err := os.ReadFile(n.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	d := decoder{data: data}
+	term := d.u64()
+	votedFor := string(d.bytes())
+	base := Entry{Index: d.u64(), Term: d.u64()}
+	snapshot := d.bytes()
+	entries := d.entries()
+	if d.err == nil && len(d.data) > 0 {
+		d.err = errBadMessage
+	}
+	if d.err != nil {
+		return fmt.Errorf("raft: corrupt state in %s", n.path)
+	}
+	for i, e := range entries {
+		if e.Index != base.Index+uint64(i+1) {
+			return fmt.Errorf("raft: corrupt state in %s", n.path)
+		}
+	}
+
+	// The snapshot holds committed entries only; the applier loads it
+	// first.
+	n.term, n.votedFor = term, votedFor
+	n.log = append([]Entry{base}, entries...)
+	n.snapshot, n.commit = snapshot, base.Index
+	return nil
+}
+
+// writeFile replaces the file at path with data, atomically.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,318 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/consistent"
+	"github.com/BiChong-Jin/distributed-cache/hlc"
+	"github.com/BiChong-Jin/distributed-cache/merkle"
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- Anti-entropy --------
+// Read repair only fixes the keys clients read. To catch the rest, every
+// antiEntropyInterval the node compares its data with each peer it shares
+// replicas with, one token range at a time (see consistent.Ranges):
+//
+//  1. Both build a one-leaf Merkle tree (see the merkle package) over
+//     every range they both keep, and compare the roots.
+//  2. For the ranges whose roots differ, both build merkleDepth-deep trees
+//     and find the leaves that differ.
+//  3. The node asks for the keys and stamps in those leaves (CmdDigest)
+//     and compares them with its own.
+//  4. Keys the peer has a newer copy of are pulled, keys the node has a
+//     newer copy of are pushed, both with their stamps so neither side
+//     takes an older write. Tombstones (see cache/replica.go) are
+//     compared and copied like values, so deletes spread the same way.
+//
+// Most ranges agree, so a round usually costs a root per range and no
+// keys at all.
+
+// DefaultAntiEntropyInterval is how often a node syncs its replicas unless
+// WithAntiEntropy says otherwise.
+const DefaultAntiEntropyInterval = 30 * time.Second
+
+// merkleDepth is the depth of the trees compared in step 2; ranges are cut
+// into 2^merkleDepth leaves.
+const merkleDepth = 6
+
+// antiEntropy runs until Stop.
+func (s *Server) antiEntropy() {
+	ticker := time.NewTicker(s.antiEntropyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.syncReplicas()
+		}
+	}
+}
+
+// syncReplicas syncs with every other ring member, one at a time.
+func (s *Server) syncReplicas() {
+	if s.replicas.Load() <= 1 {
+		return
+	}
+	for _, peer := range s.ring.GetNodes() {
+		if peer == s.Addr {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), s.antiEntropyInterval)
+		pulled, pushed, err := s.syncPeer(ctx, peer)
+		cancel()
+		if err != nil {
+			s.logger.Warn("anti-entropy failed", "peer", peer, "err", err)
+			continue
+		}
+		if pulled+pushed > 0 {
+			s.logger.Info("synced replicas", "peer", peer, "pulled", pulled, "pushed", pushed)
+		}
+	}
+}
+
+// syncPeer runs one anti-entropy round with peer.
+func (s *Server) syncPeer(ctx context.Context, peer string) (pulled, pushed int, err error) {
+	ranges := s.sharedRanges(peer)
+	if len(ranges) == 0 {
+		return 0, 0, nil
+	}
+
+	// 1. Roots.
+	theirs, err := s.peerTrees(ctx, peer, ranges, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	var differ []consistent.Range
+	for i, mine := range s.trees(ranges, 0) {
+		if mine.Root() != theirs[i].Root() {
+			differ = append(differ, ranges[i])
+		}
+	}
+	if len(differ) == 0 {
+		return 0, 0, nil
+	}
+
+	// 2. Leaves.
+	if theirs, err = s.peerTrees(ctx, peer, differ, merkleDepth); err != nil {
+		return 0, 0, err
+	}
+	var leaves []consistent.Range
+	for i, mine := range s.trees(differ, merkleDepth) {
+		parts := differ[i].Split(mine.Leaves())
+		for _, leaf := range merkle.Diff(mine, theirs[i]) {
+			leaves = append(leaves, parts[leaf])
+		}
+	}
+	slices.SortFunc(leaves, func(a, b consistent.Range) int { return cmp.Compare(a.End, b.End) })
+
+	// 3. Keys.
+	res, err := s.sendReplica(ctx, peer, &protocol.Request{CommandType: protocol.CmdDigest, Values: encodeRanges(leaves)})
+	if err != nil {
+		return 0, 0, err
+	}
+	if res.StatusCode != protocol.StatusOK || len(res.Fields) != len(res.Values) {
+		return 0, 0, fmt.Errorf("peer sent a bad digest: %s", res.ErrorMessage)
+	}
+	theirStamps := make(map[string]hlc.Timestamp, len(res.Fields))
+	for i, key := range res.Fields {
+		if len(res.Values[i]) != 8 {
+			return 0, 0, errors.New("peer sent a bad digest")
+		}
+		theirStamps[key] = hlc.Timestamp(binary.BigEndian.Uint64(res.Values[i]))
+	}
+	myStamps := s.stamps(leaves)
+
+	// 4. Repairs. Equal stamps hold the same write, so only stamps are
+	// compared; SetStamped on either side settles the rare tie.
+	for key, stamp := range theirStamps {
+		if mine, ok := myStamps[key]; !ok || stamp > mine {
+			if err := s.pull(ctx, peer, key); err != nil {
+				return pulled, pushed, err
+			}
+			pulled++
+		}
+	}
+	for key, stamp := range myStamps {
+		if theirs, ok := theirStamps[key]; !ok || stamp > theirs {
+			if err := s.push(ctx, peer, key); err != nil {
+				return pulled, pushed, err
+			}
+			pushed++
+		}
+	}
+	return pulled, pushed, nil
+}
+
+// sharedRanges returns the token ranges both this node and peer keep
+// replicas of, sorted by End.
+func (s *Server) sharedRanges(peer string) []consistent.Range {
+	n := int(s.replicas.Load())
+	var shared []consistent.Range
+	for _, r := range s.ring.Ranges() {
+		replicas := s.ring.RangeReplicas(r, n)
+		if slices.Contains(replicas, s.Addr) && slices.Contains(replicas, peer) {
+			shared = append(shared, r)
+		}
+	}
+	return shared
+}
+
+// peerTrees asks peer for its Merkle trees over ranges.
+func (s *Server) peerTrees(ctx context.Context, peer string, ranges []consistent.Range, depth int) ([]*merkle.Tree, error) {
+	res, err := s.sendReplica(ctx, peer, &protocol.Request{CommandType: protocol.CmdMerkle, Values: encodeRanges(ranges), Int: int64(depth)})
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != protocol.StatusOK || len(res.Values) != len(ranges) {
+		return nil, fmt.Errorf("peer sent bad Merkle trees: %s", res.ErrorMessage)
+	}
+	trees := make([]*merkle.Tree, len(ranges))
+	for i, data := range res.Values {
+		if trees[i], err = merkle.Decode(data); err != nil {
+			return nil, err
+		}
+	}
+	return trees, nil
+}
+
+// pull copies peer's copy of key here.
+func (s *Server) pull(ctx context.Context, peer, key string) error {
+	namespace, name, _ := strings.Cut(key, "\x00")
+	res, err := s.sendReplica(ctx, peer, &protocol.Request{CommandType: protocol.CmdReplicaGet, Namespace: namespace, Key: name})
+	if err != nil {
+		return err
+	}
+	ns := s.cache.Namespace(namespace)
+	switch {
+	case res.StatusCode == protocol.StatusOK:
+		_, err = ns.SetStamped(name, res.Value, res.Meta.TTL, res.Meta.Flags, res.Meta.Stamp)
+	case res.StatusCode == protocol.StatusNotFound && res.Meta.Stamp != 0:
+		_, err = ns.DeleteStamped(name, res.Meta.Stamp)
+	default:
+		return nil
+	}
+	if err != nil {
+		// A collection here, or a stamp from a clock too far ahead: not
+		// copied, but no reason to stop syncing the other keys.
+		return nil
+	}
+	s.metrics.repairs.Inc("pulled")
+	return nil
+}
+
+// push copies this node's copy of key to peer.
+func (s *Server) push(ctx context.Context, peer, key string) error {
+	namespace, name, _ := strings.Cut(key, "\x00")
+	val, meta, ok, err := s.cache.Namespace(namespace).Peek(name)
+	if err != nil || (!ok && meta.Stamp == 0) {
+		// Nothing to copy, or a collection, which is not replicated.
+		return nil
+	}
+	_, err = s.sendReplica(ctx, peer, replicaWrite(namespace, name, val, meta.TTL, meta.Flags, meta.Stamp, !ok))
+	if err == nil {
+		s.metrics.repairs.Inc("pushed")
+	}
+	return err
+}
+
+// -------- Local Trees & Digests --------
+// Keys are compared as namespace + "\x00" + key, as one namespace's key
+// may hash anywhere on the ring.
+
+// scan calls fn for every string item and tombstone in one of ranges,
+// which must be sorted by End, with the index of its range and its token.
+func (s *Server) scan(ranges []consistent.Range, fn func(i int, token uint32, key string, stamp hlc.Timestamp)) {
+	for _, ns := range s.cache.Namespaces() {
+		for key, stamp := range s.cache.Namespace(ns).Stamps() {
+			token := s.ring.Token(key)
+			if i := consistent.Find(ranges, token); i >= 0 {
+				fn(i, token, ns+"\x00"+key, stamp)
+			}
+		}
+	}
+}
+
+// trees builds this node's Merkle trees over ranges. A range with fewer
+// tokens than leaves gets a shallower tree.
+func (s *Server) trees(ranges []consistent.Range, depth int) []*merkle.Tree {
+	trees := make([]*merkle.Tree, len(ranges))
+	for i, r := range ranges {
+		trees[i] = merkle.New(min(depth, bits.Len64(r.Size())-1))
+	}
+	s.scan(ranges, func(i int, token uint32, key string, stamp hlc.Timestamp) {
+		t := trees[i]
+		t.Add(ranges[i].Slot(token, t.Leaves()), key, int64(stamp))
+	})
+	return trees
+}
+
+// stamps returns the stamp of every string item and tombstone in ranges.
+func (s *Server) stamps(ranges []consistent.Range) map[string]hlc.Timestamp {
+	stamps := make(map[string]hlc.Timestamp)
+	s.scan(ranges, func(_ int, _ uint32, key string, stamp hlc.Timestamp) {
+		stamps[key] = stamp
+	})
+	return stamps
+}
+
+// merkleTrees answers CmdMerkle.
+func (s *Server) merkleTrees(req *protocol.Request) *protocol.Response {
+	ranges, err := decodeRanges(req.Values)
+	if err != nil {
+		return errorResponse(err)
+	}
+	trees := s.trees(ranges, int(req.Int))
+	res := &protocol.Response{StatusCode: protocol.StatusOK, Values: make([][]byte, len(trees))}
+	for i, t := range trees {
+		res.Values[i] = t.Encode()
+	}
+	return res
+}
+
+// digest answers CmdDigest.
+func (s *Server) digest(req *protocol.Request) *protocol.Response {
+	ranges, err := decodeRanges(req.Values)
+	if err != nil {
+		return errorResponse(err)
+	}
+	res := &protocol.Response{StatusCode: protocol.StatusOK}
+	for key, stamp := range s.stamps(ranges) {
+		res.Fields = append(res.Fields, key)
+		res.Values = append(res.Values, binary.BigEndian.AppendUint64(nil, uint64(stamp)))
+	}
+	return res
+}
+
+// encodeRanges writes each range as [4 start][4 end].
+func encodeRanges(ranges []consistent.Range) [][]byte {
+	values := make([][]byte, len(ranges))
+	for i, r := range ranges {
+		values[i] = binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, r.Start), r.End)
+	}
+	return values
+}
+
+func decodeRanges(values [][]byte) ([]consistent.Range, error) {
+	ranges := make([]consistent.Range, len(values))
+	for i, v := range values {
+		if len(v) != 8 {
+			return nil, errors.New("malformed token range")
+		}
+		ranges[i] = consistent.Range{Start: binary.BigEndian.Uint32(v), End: binary.BigEndian.Uint32(v[4:])}
+	}
+	if !slices.IsSortedFunc(ranges, func(a, b consistent.Range) int { return cmp.Compare(a.End, b.End) }) {
+		return nil, errors.New("token ranges not sorted")
+	}
+	return ranges, nil
+}
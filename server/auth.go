@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- Authentication & ACLs --------
+// With an ACL (see the acl package), every request runs as a user and
+// route() refuses it unless that user may run the command on the key in
+// the request's namespace.
+//
+// Each front-end works out the user its way: the TCP listener and RESP
+// with an AUTH command, HTTP with an Authorization header, gRPC with
+// "authorization" metadata. Clients that do not authenticate run as the
+// "default" user, which is also what memcached clients always run as.
+//
+// When a request is forwarded, the user travels with it in Request.User.
+// The owning node only trusts that field on connections that authenticated
+// with the cluster token, so clients cannot claim to be someone else.
+
+// authorize returns a StatusDenied response if req's user may not run it,
+// or nil if it may.
+func (s *Server) authorize(req *protocol.Request) *protocol.Response {
+	if s.acl == nil || req.CommandType == protocol.CmdPing {
+		return nil
+	}
+
+	user := s.acl.User(req.User)
+	if user == nil {
+		return &protocol.Response{StatusCode: protocol.StatusDenied, ErrorMessage: "NOAUTH authentication required"}
+	}
+	if !user.CanUse(req.Namespace) {
+		return &protocol.Response{
+			StatusCode:   protocol.StatusDenied,
+			ErrorMessage: fmt.Sprintf("NOPERM user %s may not use namespace %q", user.Name, req.Namespace),
+		}
+	}
+	if !user.Can(req.CommandType, req.Key) {
+		return &protocol.Response{
+			StatusCode:   protocol.StatusDenied,
+			ErrorMessage: fmt.Sprintf("NOPERM user %s may not run %s on key %q", user.Name, req.CommandType, req.Key),
+		}
+	}
+	return nil
+}
+
+// authenticate checks the credentials in a CmdAuth request: Key is the
+// user name and Value the password, or Key is empty and Value a token.
+// On success it returns the user the connection now runs as, and whether
+// the connection belongs to another node.
+func (s *Server) authenticate(req *protocol.Request) (res *protocol.Response, user string, peer bool) {
+	if s.acl == nil {
+		return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: "AUTH called without any ACL configured"}, "", false
+	}
+
+	if req.Key == "" && s.acl.IsClusterToken(string(req.Value)) {
+		return &protocol.Response{StatusCode: protocol.StatusOK}, "", true
+	}
+
+	u, ok := s.acl.Authenticate(req.Key, string(req.Value))
+	if !ok {
+		return &protocol.Response{StatusCode: protocol.StatusDenied, ErrorMessage: "WRONGPASS invalid username-password pair or token"}, "", false
+	}
+	return &protocol.Response{StatusCode: protocol.StatusOK}, u.Name, false
+}
+
+// authHeader turns an HTTP-style Authorization value into a CmdAuth
+// request: "Basic base64(user:password)" or "Bearer token".
+func authHeader(value string) *protocol.Request {
+	req := &protocol.Request{CommandType: protocol.CmdAuth}
+	scheme, credentials, _ := strings.Cut(value, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		decoded, err := base64.StdEncoding.DecodeString(credentials)
+		if err == nil {
+			user, password, _ := strings.Cut(string(decoded), ":")
+			req.Key, req.Value = user, []byte(password)
+		}
+	case "bearer":
+		req.Value = []byte(credentials)
+	}
+	return req
+}
+
+// routeAs runs req as user. Front-ends use it for requests from their clients.
+func (s *Server) routeAs(ctx context.Context, user string, req *protocol.Request) *protocol.Response {
+	req.User = user
+	return s.route(ctx, req)
+}
+
+// visibleKeys drops the keys user may not access.
+func (s *Server) visibleKeys(user string, keys []string) []string {
+	if s.acl == nil {
+		return keys
+	}
+
+	u := s.acl.User(user)
+	visible := []string{}
+	for _, k := range keys {
+		if u != nil && u.CanAccess(k) {
+			visible = append(visible, k)
+		}
+	}
+	return visible
+}
+
+// visibleNamespaces drops the namespaces user may not use.
+func (s *Server) visibleNamespaces(user string, names []string) []string {
+	if s.acl == nil {
+		return names
+	}
+
+	u := s.acl.User(user)
+	visible := []string{}
+	for _, ns := range names {
+		if u != nil && u.CanUse(ns) {
+			visible = append(visible, ns)
+		}
+	}
+	return visible
+}
+
+// loginPeer authenticates a new connection to another node with the
+// cluster token, so the node trusts the users on forwarded requests.
+func (s *Server) loginPeer(ctx context.Context, conn *protocol.Conn) error {
+	if s.acl == nil || !conn.Supports(protocol.CmdAuth) {
+		return nil
+	}
+
+	res, err := conn.RoundTripContext(ctx, &protocol.Request{CommandType: protocol.CmdAuth, Value: []byte(s.acl.ClusterToken)})
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != protocol.StatusOK {
+		return fmt.Errorf("peer refused cluster token: %s", res.ErrorMessage)
+	}
+	return nil
+}
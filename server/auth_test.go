@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/BiChong-Jin/distributed-cache/acl"
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+func testACL(t *testing.T) *acl.ACL {
+	t.Helper()
+	a, err := acl.Parse([]byte(`{
+		"cluster_token": "cluster-secret",
+		"users": [
+			{"name": "admin", "password": "admin-secret", "commands": ["@all"], "keys": ["*"], "namespaces": ["*"]},
+			{"name": "tenant-a", "password": "a-secret", "commands": ["@all"], "keys": ["*"], "namespaces": ["a", ""]},
+			{"name": "default", "commands": ["get"], "keys": ["public:"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestNamespaceACL(t *testing.T) {
+	s := startServer(t, "", WithACL(testACL(t)))
+	ctx := context.Background()
+	run := func(user, ns string, cmd protocol.CommandType, key string) *protocol.Response {
+		return s.routeAs(ctx, user, &protocol.Request{CommandType: cmd, Namespace: ns, Key: key, Value: []byte("v")})
+	}
+
+	if res := run("admin", "b", protocol.CmdSet, "k"); res.StatusCode != protocol.StatusOK {
+		t.Fatalf("admin set in b: %+v", res)
+	}
+	if res := run("tenant-a", "a", protocol.CmdSet, "k"); res.StatusCode != protocol.StatusOK {
+		t.Fatalf("tenant-a set in a: %+v", res)
+	}
+	for _, cmd := range []protocol.CommandType{protocol.CmdGet, protocol.CmdSet, protocol.CmdStats} {
+		if res := run("tenant-a", "b", cmd, "k"); res.StatusCode != protocol.StatusDenied {
+			t.Errorf("tenant-a %s in b: got %+v, want denied", cmd, res)
+		}
+	}
+	if res := run("", "b", protocol.CmdGet, "public:k"); res.StatusCode != protocol.StatusDenied {
+		t.Errorf("default user get in b: got %+v, want denied", res)
+	}
+
+	// Stats in the default namespace only cover the caller's namespaces.
+	stats := func(user string) map[string]protocol.NamespaceStats {
+		res := run(user, "", protocol.CmdStats, "")
+		if res.StatusCode != protocol.StatusOK {
+			t.Fatalf("%s stats: %+v", user, res)
+		}
+		var st map[string]protocol.NamespaceStats
+		if err := json.Unmarshal(res.Value, &st); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+	if st := stats("tenant-a"); len(st) != 2 || st["a"].Items != 1 {
+		t.Errorf("tenant-a stats: got %v, want the default namespace and a", st)
+	}
+	if st := stats("admin"); len(st) != 3 || st["b"].Items != 1 {
+		t.Errorf("admin stats: got %v, want every namespace", st)
+	}
+}
+
+func TestACLForwarded(t *testing.T) {
+	// Nodes log in to each other with the cluster token, so a request
+	// keeps its user when the node that got it is not the key's owner.
+	nodes := startCluster(t, 2, WithACL(testACL(t)))
+	a, b := nodes[0], nodes[1]
+	key := keyOwnedBy(t, a, b.Addr)
+	ctx := context.Background()
+
+	res := a.routeAs(ctx, "tenant-a", &protocol.Request{CommandType: protocol.CmdSet, Namespace: "a", Key: key, Value: []byte("v")})
+	if res.StatusCode != protocol.StatusOK {
+		t.Fatalf("forwarded set: %+v", res)
+	}
+	if v, ok, _ := b.cache.Namespace("a").Get(key); !ok || string(v) != "v" {
+		t.Fatalf("owner holds %q, %v", v, ok)
+	}
+	res = a.routeAs(ctx, "tenant-a", &protocol.Request{CommandType: protocol.CmdSet, Namespace: "b", Key: key, Value: []byte("v")})
+	if res.StatusCode != protocol.StatusDenied {
+		t.Errorf("forwarded set in b: got %+v, want denied", res)
+	}
+}
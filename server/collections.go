@@ -0,0 +1,180 @@
+package server
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/BiChong-Jin/distributed-cache/cache"
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- Typed Value Commands --------
+// Hash, list, set and sorted-set commands are routed like any other key, so
+// the whole collection lives on the node that owns its key.
+
+// handleCollection runs a hash, list, set or sorted-set command against c,
+// the request's namespace in the local cache.
+func handleCollection(c *cache.Cache, req *protocol.Request) *protocol.Response {
+	switch req.CommandType {
+	case protocol.CmdHSet:
+		created, err := c.HSet(req.Key, req.Field, req.Value)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Int: boolToInt(created)}
+
+	case protocol.CmdHGet:
+		val, ok, err := c.HGet(req.Key, req.Field)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if !ok {
+			return &protocol.Response{StatusCode: protocol.StatusNotFound}
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Value: val}
+
+	case protocol.CmdHDel:
+		n, err := c.HDel(req.Key, req.Fields...)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Int: int64(n)}
+
+	case protocol.CmdHGetAll:
+		all, err := c.HGetAll(req.Key)
+		if err != nil {
+			return errorResponse(err)
+		}
+		res := &protocol.Response{StatusCode: protocol.StatusOK}
+		for f := range all {
+			res.Fields = append(res.Fields, f)
+		}
+		sort.Strings(res.Fields)
+		for _, f := range res.Fields {
+			res.Values = append(res.Values, all[f])
+		}
+		return res
+
+	case protocol.CmdLPush:
+		n, err := c.LPush(req.Key, req.Values...)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Int: int64(n)}
+
+	case protocol.CmdRPop:
+		val, ok, err := c.RPop(req.Key)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if !ok {
+			return &protocol.Response{StatusCode: protocol.StatusNotFound}
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Value: val}
+
+	case protocol.CmdLRange:
+		vals, err := c.LRange(req.Key, req.Start, req.Stop)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Values: vals}
+
+	case protocol.CmdSAdd:
+		n, err := c.SAdd(req.Key, req.Fields...)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Int: int64(n)}
+
+	case protocol.CmdSIsMember:
+		ok, err := c.SIsMember(req.Key, req.Field)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Int: boolToInt(ok)}
+
+	case protocol.CmdSMembers:
+		members, err := c.SMembers(req.Key)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Fields: members}
+
+	case protocol.CmdZAdd:
+		if len(req.Fields) != len(req.Scores) {
+			return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: "Members and scores do not match."}
+		}
+		members := make([]cache.ZMember, len(req.Fields))
+		for i, m := range req.Fields {
+			members[i] = cache.ZMember{Member: m, Score: req.Scores[i]}
+		}
+		n, err := c.ZAdd(req.Key, members...)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Int: int64(n)}
+
+	case protocol.CmdZRange:
+		members, err := c.ZRange(req.Key, req.Start, req.Stop)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return zmembersResponse(members)
+
+	case protocol.CmdZRangeByScore:
+		members, err := c.ZRangeByScore(req.Key, req.Min, req.Max)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return zmembersResponse(members)
+
+	case protocol.CmdZRem:
+		n, err := c.ZRem(req.Key, req.Fields...)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Int: int64(n)}
+
+	case protocol.CmdZCard:
+		n, err := c.ZCard(req.Key)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Int: int64(n)}
+
+	case protocol.CmdZIncrBy:
+		score, err := c.ZIncrBy(req.Key, req.Field, req.Score)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Score: score}
+
+	default:
+		return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: "Unknown CommandType."}
+	}
+}
+
+// zmembersResponse flattens sorted-set members into Fields and Scores.
+func zmembersResponse(members []cache.ZMember) *protocol.Response {
+	res := &protocol.Response{StatusCode: protocol.StatusOK}
+	for _, m := range members {
+		res.Fields = append(res.Fields, m.Member)
+		res.Scores = append(res.Scores, m.Score)
+	}
+	return res
+}
+
+// errorResponse converts a cache error into a Response.
+func errorResponse(err error) *protocol.Response {
+	if errors.Is(err, cache.ErrWrongType) {
+		return &protocol.Response{StatusCode: protocol.StatusWrongType, ErrorMessage: err.Error()}
+	}
+	return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: err.Error()}
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/BiChong-Jin/distributed-cache/cache"
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+func TestWrongType(t *testing.T) {
+	s := startServer(t, "")
+	run := func(req *protocol.Request) *protocol.Response {
+		return s.route(context.Background(), req)
+	}
+	run(&protocol.Request{CommandType: protocol.CmdSet, Key: "plain", Value: []byte("v")})
+	run(&protocol.Request{CommandType: protocol.CmdLPush, Key: "list", Values: [][]byte{[]byte("x")}})
+
+	// Collection commands on a string...
+	if res := run(&protocol.Request{CommandType: protocol.CmdHSet, Key: "plain", Field: "f", Value: []byte("v")}); res.StatusCode != protocol.StatusWrongType {
+		t.Errorf("HSET on a string: got %+v, want WRONGTYPE", res)
+	}
+	// ...and string commands on a collection.
+	for _, cmd := range []protocol.CommandType{protocol.CmdGet, protocol.CmdGetMeta, protocol.CmdGetSet, protocol.CmdGetDel, protocol.CmdCAS} {
+		res := run(&protocol.Request{CommandType: cmd, Key: "list", Value: []byte("v")})
+		if res.StatusCode != protocol.StatusWrongType {
+			t.Errorf("%s on a list: got %+v, want WRONGTYPE", cmd, res)
+		}
+		if cmd == protocol.CmdGetMeta && res.Meta.Size != 1 {
+			t.Errorf("GETMETA on a list: metadata %+v, want its size", res.Meta)
+		}
+	}
+	if kind, _ := s.cache.Type("list"); kind != cache.KindList {
+		t.Errorf("the list became kind %d", kind)
+	}
+}
+
+func TestZAddNaN(t *testing.T) {
+	s := startServer(t, "")
+	res := s.route(context.Background(), &protocol.Request{CommandType: protocol.CmdZAdd, Key: "z", Fields: []string{"a"}, Scores: []float64{math.NaN()}})
+	if res.StatusCode != protocol.StatusError || res.ErrorMessage != "resulting score is not a number" {
+		t.Fatalf("ZADD NaN: got %+v", res)
+	}
+	if _, ok := s.cache.Type("z"); ok {
+		t.Error("ZADD NaN created the key")
+	}
+}
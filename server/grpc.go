@@ -0,0 +1,342 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/BiChong-Jin/distributed-cache/api"
+	"github.com/BiChong-Jin/distributed-cache/discovery"
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- gRPC Front-end --------
+// An optional gRPC listener implementing api.CacheServer (see api/cache.proto)
+// for services written in other languages. Each call is translated into
+// protocol.Requests and dispatched through route(), like the TCP listener.
+
+const (
+	grpcDefaultScanLimit     = 100
+	grpcDefaultWatchInterval = 500 * time.Millisecond
+
+	// Shorter intervals would have Watch poll the cluster in a busy loop.
+	grpcMinWatchInterval = grpcDefaultWatchInterval / 10
+)
+
+// grpcService adapts a Server to the generated api.CacheServer interface.
+type grpcService struct {
+	api.UnimplementedCacheServer
+	s *Server
+}
+
+// StartGRPC serves the gRPC API on addr. Like Start, it blocks until the
+// server is stopped, and returns net.ErrClosed if it already was.
+func (s *Server) StartGRPC(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.serveGRPC(listener)
+}
+
+// serveGRPC serves the gRPC API on listener until the server is stopped.
+func (s *Server) serveGRPC(listener net.Listener) error {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(s.grpcUnaryAuth),
+		grpc.StreamInterceptor(s.grpcStreamAuth),
+	}
+	if s.tls != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.tls.ServerConfig(s.mutualTLS))))
+	}
+	srv := grpc.NewServer(opts...)
+	api.RegisterCacheServer(srv, &grpcService{s: s})
+	if !s.frontend(func() { s.grpcServer = srv }) {
+		listener.Close()
+		return net.ErrClosed
+	}
+	s.logger.Info("listening", "frontend", "grpc", "addr", listener.Addr().String())
+	return srv.Serve(listener)
+}
+
+func (g *grpcService) Get(ctx context.Context, in *api.GetRequest) (*api.GetResponse, error) {
+	res := g.s.routeAs(ctx, grpcUser(ctx), &protocol.Request{CommandType: protocol.CmdGetMeta, Key: in.Key})
+	if res.StatusCode != protocol.StatusOK {
+		return nil, grpcError(res)
+	}
+	return &api.GetResponse{Value: res.Value, Metadata: grpcMetadata(res.Meta)}, nil
+}
+
+func (g *grpcService) Set(ctx context.Context, in *api.SetRequest) (*api.SetResponse, error) {
+	req := &protocol.Request{CommandType: protocol.CmdSet, Key: in.Key, Value: in.Value}
+	if in.Ttl != nil {
+		if err := in.Ttl.CheckValid(); err != nil || in.Ttl.AsDuration() < 0 {
+			return nil, status.Error(codes.InvalidArgument, "invalid ttl")
+		}
+		req.TTL = in.Ttl.AsDuration()
+	}
+
+	switch {
+	case in.IfAbsent && in.IfVersion != 0:
+		return nil, status.Error(codes.InvalidArgument, "if_absent and if_version are mutually exclusive")
+	case in.IfAbsent:
+		req.CommandType = protocol.CmdAdd
+	case in.IfVersion != 0:
+		req.CommandType = protocol.CmdCAS
+		req.CAS = in.IfVersion
+	}
+
+	res := g.s.routeAs(ctx, grpcUser(ctx), req)
+	if res.StatusCode != protocol.StatusOK {
+		return nil, grpcError(res)
+	}
+	return &api.SetResponse{}, nil
+}
+
+func (g *grpcService) Delete(ctx context.Context, in *api.DeleteRequest) (*api.DeleteResponse, error) {
+	res := g.s.routeAs(ctx, grpcUser(ctx), &protocol.Request{CommandType: protocol.CmdDelete, Key: in.Key})
+	if res.StatusCode != protocol.StatusOK {
+		return nil, grpcError(res)
+	}
+	return &api.DeleteResponse{Deleted: res.Int == 1}, nil
+}
+
+func (g *grpcService) MGet(ctx context.Context, in *api.MGetRequest) (*api.MGetResponse, error) {
+	out := &api.MGetResponse{Entries: make([]*api.Entry, 0, len(in.Keys))}
+	for _, key := range in.Keys {
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+
+		res := g.s.routeAs(ctx, grpcUser(ctx), &protocol.Request{CommandType: protocol.CmdGetMeta, Key: key})
+		entry := &api.Entry{Key: key}
+		switch res.StatusCode {
+		case protocol.StatusOK:
+			entry.Found = true
+			entry.Value = res.Value
+			entry.Metadata = grpcMetadata(res.Meta)
+		case protocol.StatusNotFound:
+		default:
+			return nil, grpcError(res)
+		}
+		out.Entries = append(out.Entries, entry)
+	}
+	return out, nil
+}
+
+// Scan lists the cluster's keys in sorted order. The cursor is the last key
+// of the previous page, so pages stay consistent while keys come and go.
+func (g *grpcService) Scan(ctx context.Context, in *api.ScanRequest) (*api.ScanResponse, error) {
+	limit := int(in.Limit)
+	if limit <= 0 {
+		limit = grpcDefaultScanLimit
+	}
+
+	keys, res := g.s.clusterKeys(ctx, grpcUser(ctx))
+	if res != nil {
+		return nil, grpcError(res)
+	}
+
+	start := 0
+	if in.Cursor != "" {
+		start = sort.SearchStrings(keys, in.Cursor)
+		if start < len(keys) && keys[start] == in.Cursor {
+			start++
+		}
+	}
+
+	out := &api.ScanResponse{}
+	for _, k := range keys[start:] {
+		if !strings.HasPrefix(k, in.Prefix) {
+			continue
+		}
+		if len(out.Keys) == limit {
+			out.NextCursor = out.Keys[len(out.Keys)-1]
+			break
+		}
+		out.Keys = append(out.Keys, k)
+	}
+	return out, nil
+}
+
+// watchInterval is how often Watch polls for in: the default if unset,
+// and never less than grpcMinWatchInterval.
+func watchInterval(in *api.WatchRequest) time.Duration {
+	d := in.GetInterval().AsDuration()
+	if d <= 0 {
+		return grpcDefaultWatchInterval
+	}
+	return max(d, grpcMinWatchInterval)
+}
+
+// Watch polls the watched keys and sends an event whenever a key's version
+// changes or the key disappears. The current value of each existing key is
+// sent first.
+func (g *grpcService) Watch(in *api.WatchRequest, stream grpc.ServerStreamingServer[api.WatchEvent]) error {
+	if len(in.Keys) == 0 {
+		return status.Error(codes.InvalidArgument, "no keys to watch")
+	}
+
+	ctx := stream.Context()
+
+	// versions holds the last version sent for each key; 0 means absent.
+	versions := make(map[string]uint64, len(in.Keys))
+	ticker := time.NewTicker(watchInterval(in))
+	defer ticker.Stop()
+
+	for {
+		for _, key := range in.Keys {
+			res := g.s.routeAs(ctx, grpcUser(ctx), &protocol.Request{CommandType: protocol.CmdGetMeta, Key: key})
+
+			var event *api.WatchEvent
+			switch res.StatusCode {
+			case protocol.StatusOK:
+				if res.Meta.Version != versions[key] {
+					versions[key] = res.Meta.Version
+					event = &api.WatchEvent{Type: api.WatchEvent_TYPE_PUT, Key: key, Value: res.Value, Version: res.Meta.Version}
+				}
+			case protocol.StatusNotFound:
+				if versions[key] != 0 {
+					versions[key] = 0
+					event = &api.WatchEvent{Type: api.WatchEvent_TYPE_DELETE, Key: key}
+				}
+			default:
+				return grpcError(res)
+			}
+
+			if event != nil {
+				if err := stream.Send(event); err != nil {
+					return err
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ClusterInfo needs the same permission as CmdInfo on the TCP listener.
+func (g *grpcService) ClusterInfo(ctx context.Context, in *api.ClusterInfoRequest) (*api.ClusterInfoResponse, error) {
+	if res := g.s.authorize(&protocol.Request{CommandType: protocol.CmdInfo, User: grpcUser(ctx)}); res != nil {
+		return nil, grpcError(res)
+	}
+
+	out := &api.ClusterInfoResponse{Node: g.s.Addr, RingNodes: g.s.ring.GetNodes()}
+	sort.Strings(out.RingNodes)
+
+	for _, node := range g.s.registry.Nodes() {
+		member := &api.Member{Addr: node.Addr, LastHeartbeat: timestamppb.New(node.LastHB)}
+		switch node.CurrStatus {
+		case discovery.StatusAlive:
+			member.Status = api.Member_STATUS_ALIVE
+		case discovery.StatusSuspect:
+			member.Status = api.Member_STATUS_SUSPECT
+		case discovery.StatusDead:
+			member.Status = api.Member_STATUS_DEAD
+		}
+		out.Members = append(out.Members, member)
+	}
+	return out, nil
+}
+
+func grpcMetadata(meta protocol.Metadata) *api.Metadata {
+	out := &api.Metadata{
+		CreatedAt: timestamppb.New(meta.CreatedAt),
+		Version:   meta.Version,
+		Size:      int64(meta.Size),
+		Flags:     meta.Flags,
+	}
+	if meta.TTL > 0 {
+		out.Ttl = durationpb.New(meta.TTL)
+	}
+	return out
+}
+
+// grpcError maps a failed protocol.Response to a gRPC status. Unavailable,
+// which clients retry, is kept for nodes that could not be reached.
+func grpcError(res *protocol.Response) error {
+	switch res.StatusCode {
+	case protocol.StatusNotFound:
+		return status.Error(codes.NotFound, "key not found")
+	case protocol.StatusWrongType:
+		return status.Error(codes.FailedPrecondition, res.ErrorMessage)
+	case protocol.StatusNotStored:
+		return status.Error(codes.FailedPrecondition, "condition not met")
+	case protocol.StatusDenied:
+		if strings.HasPrefix(res.ErrorMessage, "NOAUTH") {
+			return status.Error(codes.Unauthenticated, res.ErrorMessage)
+		}
+		return status.Error(codes.PermissionDenied, res.ErrorMessage)
+	case protocol.StatusUnavailable:
+		return status.Error(codes.Unavailable, res.ErrorMessage)
+	default:
+		return status.Error(codes.Internal, res.ErrorMessage)
+	}
+}
+
+// -------- gRPC Authentication --------
+// With an ACL, callers send "authorization" metadata on each call, either
+// "Basic base64(user:password)" or "Bearer token". The interceptors check it
+// and store the user in the call's context; calls without it run as the
+// default user.
+
+type grpcUserKey struct{}
+
+func grpcUser(ctx context.Context) string {
+	user, _ := ctx.Value(grpcUserKey{}).(string)
+	return user
+}
+
+// grpcAuthenticate returns ctx carrying the caller's user.
+func (s *Server) grpcAuthenticate(ctx context.Context) (context.Context, error) {
+	if s.acl == nil {
+		return ctx, nil
+	}
+	values := metadata.ValueFromIncomingContext(ctx, "authorization")
+	if len(values) == 0 {
+		return ctx, nil
+	}
+
+	res, user, _ := s.authenticate(authHeader(values[0]))
+	if res.StatusCode != protocol.StatusOK {
+		return nil, status.Error(codes.Unauthenticated, res.ErrorMessage)
+	}
+	return context.WithValue(ctx, grpcUserKey{}, user), nil
+}
+
+func (s *Server) grpcUnaryAuth(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := s.grpcAuthenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) grpcStreamAuth(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.grpcAuthenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &grpcAuthStream{ServerStream: stream, ctx: ctx})
+}
+
+// grpcAuthStream replaces a stream's context with one carrying the user.
+type grpcAuthStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcAuthStream) Context() context.Context { return s.ctx }
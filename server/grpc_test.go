@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/BiChong-Jin/distributed-cache/api"
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// dialGRPC serves the gRPC API at a free address and connects to it.
+func dialGRPC(t *testing.T, s *Server) api.CacheClient {
+	t.Helper()
+	addr := freeAddr(t)
+	go s.StartGRPC(addr)
+	waitFor(t, "the gRPC server to start", func() bool { return listening(addr) })
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return api.NewCacheClient(conn)
+}
+
+func TestGRPC(t *testing.T) {
+	s := startServer(t, "")
+	c := dialGRPC(t, s)
+	ctx := context.Background()
+
+	if _, err := c.Set(ctx, &api.SetRequest{Key: "k", Value: []byte("hello"), Ttl: durationpb.New(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Get(ctx, &api.GetRequest{Key: "k"})
+	if err != nil || string(got.Value) != "hello" || got.Metadata.Ttl == nil {
+		t.Fatalf("Get: %v, %v", got, err)
+	}
+	_, err = c.Set(ctx, &api.SetRequest{Key: "k", Value: []byte("x"), IfAbsent: true})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Set if absent over an existing key: %v", err)
+	}
+	if res, err := c.Delete(ctx, &api.DeleteRequest{Key: "k"}); err != nil || !res.Deleted {
+		t.Fatalf("Delete: %v, %v", res, err)
+	}
+	if _, err := c.Get(ctx, &api.GetRequest{Key: "k"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Get after Delete: %v", err)
+	}
+
+	// Malformed requests.
+	if _, err := c.Set(ctx, &api.SetRequest{Key: "k", Ttl: durationpb.New(-1)}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("negative TTL: %v", err)
+	}
+	if _, err := c.Set(ctx, &api.SetRequest{Key: "k", IfAbsent: true, IfVersion: 3}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("if_absent with if_version: %v", err)
+	}
+
+	s.cache.LPush("list", []byte("x"))
+	if _, err := c.Get(ctx, &api.GetRequest{Key: "list"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Get of a list: %v", err)
+	}
+}
+
+func TestWatchInterval(t *testing.T) {
+	for _, tc := range []struct {
+		interval *durationpb.Duration
+		want     time.Duration
+	}{
+		{nil, grpcDefaultWatchInterval},
+		{durationpb.New(0), grpcDefaultWatchInterval},
+		{durationpb.New(-time.Second), grpcDefaultWatchInterval},
+		{durationpb.New(time.Nanosecond), grpcMinWatchInterval},
+		{durationpb.New(2 * time.Second), 2 * time.Second},
+	} {
+		if got := watchInterval(&api.WatchRequest{Keys: []string{"k"}, Interval: tc.interval}); got != tc.want {
+			t.Errorf("interval %v: polls every %v, want %v", tc.interval.AsDuration(), got, tc.want)
+		}
+	}
+}
+
+func TestGRPCAfterStop(t *testing.T) {
+	s := startServer(t, "")
+	s.Stop()
+	if err := s.StartGRPC(freeAddr(t)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("StartGRPC after Stop: %v, want net.ErrClosed", err)
+	}
+}
+
+func TestGRPCStatus(t *testing.T) {
+	// Unavailable, which clients retry, only for nodes that cannot be reached.
+	for code, want := range map[protocol.StatusCode]codes.Code{
+		protocol.StatusNotFound:    codes.NotFound,
+		protocol.StatusWrongType:   codes.FailedPrecondition,
+		protocol.StatusNotStored:   codes.FailedPrecondition,
+		protocol.StatusDenied:      codes.PermissionDenied,
+		protocol.StatusUnavailable: codes.Unavailable,
+		protocol.StatusError:       codes.Internal,
+	} {
+		if got := status.Code(grpcError(&protocol.Response{StatusCode: code})); got != want {
+			t.Errorf("status %d: got %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestGRPCAuth(t *testing.T) {
+	s := startServer(t, "", WithACL(testACL(t)))
+	c := dialGRPC(t, s)
+	ctx := context.Background()
+	as := func(user, password string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, "authorization", "Basic "+basicAuth(user, password))
+	}
+
+	if _, err := c.Get(ctx, &api.GetRequest{Key: "public:k"}); status.Code(err) != codes.NotFound {
+		t.Errorf("default user Get public:k: %v", err)
+	}
+	if _, err := c.Set(ctx, &api.SetRequest{Key: "k", Value: []byte("v")}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("default user Set: %v", err)
+	}
+	if _, err := c.Set(as("admin", "nope"), &api.SetRequest{Key: "k", Value: []byte("v")}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("bad password: %v", err)
+	}
+	if _, err := c.Set(as("admin", "admin-secret"), &api.SetRequest{Key: "k", Value: []byte("v")}); err != nil {
+		t.Errorf("admin Set: %v", err)
+	}
+
+	// The ring and its members are only shown to users allowed CmdInfo.
+	if _, err := c.ClusterInfo(ctx, &api.ClusterInfoRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("default user ClusterInfo: %v", err)
+	}
+	if info, err := c.ClusterInfo(as("admin", "admin-secret"), &api.ClusterInfoRequest{}); err != nil || info.Node != s.Addr {
+		t.Errorf("admin ClusterInfo: %v, %v", info, err)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- Heartbeats --------
+// Every heartbeatInterval the node pings the other ring members over the
+// peer connections and tells the registry which ones answered; the
+// registry marks the silent ones suspect, then dead (see discovery). The
+// node's own entry is refreshed too, as it is plainly alive.
+//
+// A peer that answers while hints wait for it gets them replayed
+// (hints.go). Without WithRaft, its answer also carries its weight
+// (weight.go).
+
+const heartbeatInterval = 2 * time.Second
+
+// heartbeats runs until Stop.
+func (s *Server) heartbeats() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.pingPeers()
+		}
+	}
+}
+
+// pingPeers pings every other ring member at once and waits for them all.
+func (s *Server) pingPeers() {
+	s.registry.Heartbeat(s.Addr)
+
+	var wg sync.WaitGroup
+	for _, peer := range s.ring.GetNodes() {
+		if peer == s.Addr {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), heartbeatInterval)
+			defer cancel()
+
+			res, err := s.peers.roundTrip(ctx, peer, &protocol.Request{CommandType: protocol.CmdPing})
+			if err != nil {
+				s.logger.Debug("heartbeat failed", "peer", peer, "err", err)
+				return
+			}
+			s.registry.Heartbeat(peer)
+			s.learnWeight(peer, res.Int)
+			go s.replayHints(peer)
+		}()
+	}
+	wg.Wait()
+}
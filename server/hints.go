@@ -0,0 +1,269 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/discovery"
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- Hinted Handoff --------
+// When the owner of a key cannot be reached, a CmdSet does not fail: the
+// node keeps the write as a hint for the owner and answers OK. Once the
+// owner answers again, the hints are replayed to it in the order they
+// were stored. That happens when the registry sees the owner come back,
+// or on the next heartbeat (heartbeat.go) for blips too short for the
+// registry to notice.
+//
+// The store is bounded. It holds at most WithHintLimit hints; a later
+// write to the same key replaces its hint rather than adding one. When the
+// store is full, writes fail as they did before. Hints older than
+// hintMaxAge are dropped instead of replayed, and TTLs are shortened by
+// the time the hint waited.
+//
+// Only plain sets and replica writes (replication.go) are hinted: other
+// writes answer with something only the owner knows, like whether the key
+// existed or a list's new length.
+//
+// A hinted set is stamped when it is stored and replayed as a replica
+// write, so it never replaces a copy the owner got later, nor brings back
+// a key deleted since; the same goes for replica writes, which carry
+// their stamp already. Any write forwarded to the owner also drops the
+// hint for its key, which it makes stale.
+
+// DefaultHintLimit is how many hints a node keeps unless WithHintLimit
+// says otherwise.
+const DefaultHintLimit = 10000
+
+// hintMaxAge is how long a hint is worth replaying.
+const hintMaxAge = time.Hour
+
+// hint is a write waiting for its owner.
+type hint struct {
+	req    *protocol.Request
+	stored time.Time
+}
+
+// hintQueue holds one owner's hints in the order they were stored, with
+// at most one per key.
+type hintQueue struct {
+	order *list.List // Of *hint
+	byKey map[string]*list.Element
+}
+
+type hintStore struct {
+	limit int
+
+	mu        sync.Mutex
+	count     int
+	owners    map[string]*hintQueue
+	replaying map[string]bool
+}
+
+func newHintStore(limit int) *hintStore {
+	return &hintStore{limit: limit, owners: make(map[string]*hintQueue), replaying: make(map[string]bool)}
+}
+
+func hintKey(req *protocol.Request) string {
+	return req.Namespace + "\x00" + req.Key
+}
+
+// add keeps req for owner. It reports false if the store is full.
+func (h *hintStore) add(owner string, req *protocol.Request) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	q, ok := h.owners[owner]
+	if !ok {
+		q = &hintQueue{order: list.New(), byKey: make(map[string]*list.Element)}
+		h.owners[owner] = q
+	}
+	key := hintKey(req)
+	if e, ok := q.byKey[key]; ok {
+		q.order.Remove(e)
+		h.count--
+	} else if h.count >= h.limit {
+		return false
+	}
+	q.byKey[key] = q.order.PushBack(&hint{req: req, stored: time.Now()})
+	h.count++
+	return true
+}
+
+// front returns owner's oldest hint.
+func (h *hintStore) front(owner string) (*hint, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	q, ok := h.owners[owner]
+	if !ok || q.order.Len() == 0 {
+		return nil, false
+	}
+	return q.order.Front().Value.(*hint), true
+}
+
+// remove drops hint, unless a newer write has replaced it meanwhile.
+func (h *hintStore) remove(owner string, hint *hint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	q, ok := h.owners[owner]
+	if !ok {
+		return
+	}
+	key := hintKey(hint.req)
+	if e, ok := q.byKey[key]; ok && e.Value == hint {
+		h.unlink(owner, q, key, e)
+	}
+}
+
+// forget drops owner's hint for req's key, which a newer write to the
+// owner made stale.
+func (h *hintStore) forget(owner string, req *protocol.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	q, ok := h.owners[owner]
+	if !ok {
+		return
+	}
+	key := hintKey(req)
+	if e, ok := q.byKey[key]; ok {
+		h.unlink(owner, q, key, e)
+	}
+}
+
+// unlink drops a hint. The caller must hold h.mu.
+func (h *hintStore) unlink(owner string, q *hintQueue, key string, e *list.Element) {
+	q.order.Remove(e)
+	delete(q.byKey, key)
+	h.count--
+	if q.order.Len() == 0 {
+		delete(h.owners, owner)
+	}
+}
+
+// pending returns the number of hints per owner.
+func (h *hintStore) pending() map[string]int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts := make(map[string]int, len(h.owners))
+	for owner, q := range h.owners {
+		counts[owner] = q.order.Len()
+	}
+	return counts
+}
+
+// startReplay claims the replay for owner. It reports false if there is
+// nothing to replay or another replay is already running.
+func (h *hintStore) startReplay(owner string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.owners[owner]; !ok || h.replaying[owner] {
+		return false
+	}
+	h.replaying[owner] = true
+	return true
+}
+
+func (h *hintStore) endReplay(owner string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.replaying, owner)
+}
+
+// -------- Storing & Replaying --------
+
+// hinted lists the writes kept as hints.
+var hinted = map[protocol.CommandType]bool{
+	protocol.CmdSet:        true,
+	protocol.CmdReplicaSet: true,
+}
+
+// hintWrite keeps a write the owner could not take as a hint. It returns
+// the response to send instead of the error, or nil if the write cannot
+// be hinted.
+func (s *Server) hintWrite(ctx context.Context, owner string, req *protocol.Request, err error) *protocol.Response {
+	if s.hints.limit <= 0 || !hinted[req.CommandType] || ctx.Err() != nil || !unreachable(err) {
+		return nil
+	}
+
+	stored := *req
+	stored.TraceParent, stored.TraceState, stored.Timeout = "", "", 0
+	if stored.CommandType == protocol.CmdSet {
+		stored.CommandType, stored.Stamp = protocol.CmdReplicaSet, s.cache.NextStamp()
+	}
+	if !s.hints.add(owner, &stored) {
+		s.metrics.hints.Inc("dropped")
+		s.logger.Warn("hint store full, dropping write", "peer", owner, "key", req.Key)
+		return nil
+	}
+	s.metrics.hints.Inc("stored")
+	s.logger.Warn("owner unreachable, keeping the write as a hint", "peer", owner, "key", req.Key, "err", err)
+	return &protocol.Response{StatusCode: protocol.StatusOK}
+}
+
+// unreachable reports whether err means the peer could not be reached or
+// dropped the connection, as opposed to refusing the request.
+func unreachable(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// nodeStatusChanged is the registry's notify hook.
+func (s *Server) nodeStatusChanged(addr string, status discovery.NodeStatus) {
+	if status == discovery.StatusAlive && addr != s.Addr {
+		go s.replayHints(addr)
+	}
+}
+
+// replayHints sends owner its hints, oldest first, and stops at the first
+// failure; the rest wait for the next chance.
+func (s *Server) replayHints(owner string) {
+	if !s.hints.startReplay(owner) {
+		return
+	}
+	defer s.hints.endReplay(owner)
+
+	replayed := 0
+	for {
+		h, ok := s.hints.front(owner)
+		if !ok {
+			break
+		}
+
+		req := *h.req
+		age := time.Since(h.stored)
+		if age > hintMaxAge || (req.TTL > 0 && req.TTL <= age) {
+			s.hints.remove(owner, h)
+			s.metrics.hints.Inc("expired")
+			continue
+		}
+		if req.TTL > 0 {
+			req.TTL -= age
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), peerDialTimeout)
+		res, err := s.peers.roundTrip(ctx, owner, &req)
+		cancel()
+		if err != nil {
+			s.logger.Warn("hint replay failed", "peer", owner, "replayed", replayed, "err", err)
+			return
+		}
+		if res.StatusCode != protocol.StatusOK {
+			s.logger.Warn("owner refused hinted write", "peer", owner, "key", req.Key, "err", res.ErrorMessage)
+		}
+		s.hints.remove(owner, h)
+		s.metrics.hints.Inc("replayed")
+		replayed++
+	}
+	s.logger.Info("replayed hints", "peer", owner, "count", replayed)
+}
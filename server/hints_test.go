@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+func TestHintLosesToLaterDelete(t *testing.T) {
+	nodes := startCluster(t, 2)
+	a, b := nodes[0], nodes[1]
+	ctx := context.Background()
+	key := keyOwnedBy(t, a, b.Addr)
+	run := func(s *Server, cmd protocol.CommandType, key, value string) *protocol.Response {
+		return s.route(ctx, &protocol.Request{CommandType: cmd, Key: key, Value: []byte(value)})
+	}
+
+	// The owner has the key, a write to it is hinted while the owner
+	// seems down, and the owner takes a delete in the meantime.
+	if res := run(b, protocol.CmdSet, key, "v0"); res.StatusCode != protocol.StatusOK {
+		t.Fatalf("set: %+v", res)
+	}
+	if res := a.hintWrite(ctx, b.Addr, &protocol.Request{CommandType: protocol.CmdSet, Key: key, Value: []byte("v1")}, io.EOF); res == nil {
+		t.Fatal("the write was not hinted")
+	}
+	if res := run(b, protocol.CmdDelete, key, ""); res.StatusCode != protocol.StatusOK {
+		t.Fatalf("delete: %+v", res)
+	}
+
+	a.replayHints(b.Addr)
+	if n := a.hints.pending()[b.Addr]; n != 0 {
+		t.Fatalf("%d hints left after the replay", n)
+	}
+	if res := run(b, protocol.CmdGet, key, ""); res.StatusCode != protocol.StatusNotFound {
+		t.Errorf("the replayed hint brought the deleted key back: %+v", res)
+	}
+}
+
+func TestForwardedWriteDropsHint(t *testing.T) {
+	nodes := startCluster(t, 2)
+	a, b := nodes[0], nodes[1]
+	ctx := context.Background()
+	key := keyOwnedBy(t, a, b.Addr)
+
+	a.hintWrite(ctx, b.Addr, &protocol.Request{CommandType: protocol.CmdSet, Key: key, Value: []byte("v1")}, io.EOF)
+	// Keep the heartbeats from replaying the hint meanwhile.
+	if !a.hints.startReplay(b.Addr) {
+		t.Skip("the hint was replayed before the test could hold it")
+	}
+	defer a.hints.endReplay(b.Addr)
+	if res := a.route(ctx, &protocol.Request{CommandType: protocol.CmdGet, Key: key}); res.StatusCode == protocol.StatusUnavailable {
+		t.Fatalf("get: %+v", res)
+	}
+	if n := a.hints.pending()[b.Addr]; n != 1 {
+		t.Fatalf("a read dropped the hint: %d left", n)
+	}
+
+	if res := a.route(ctx, &protocol.Request{CommandType: protocol.CmdExpire, Key: key, TTL: 1}); res.StatusCode == protocol.StatusUnavailable {
+		t.Fatalf("expire: %+v", res)
+	}
+	if n := a.hints.pending()[b.Addr]; n != 0 {
+		t.Errorf("a forwarded write left the hint: %d left", n)
+	}
+}
+
+func TestHintReplayedWhenOwnerRecovers(t *testing.T) {
+	a := startServer(t, "")
+	owner := freeAddr(t)
+	a.ring.AddNode(owner)
+	ctx := context.Background()
+	key := keyOwnedBy(t, a, owner)
+
+	// Nobody listens at the owner's address yet: the write is hinted.
+	res := a.route(ctx, &protocol.Request{CommandType: protocol.CmdSet, Key: key, Value: []byte("v1")})
+	if res.StatusCode != protocol.StatusOK {
+		t.Fatalf("set: %+v", res)
+	}
+	if n := a.hints.pending()[owner]; n != 1 {
+		t.Fatalf("%d hints for the owner, want 1", n)
+	}
+
+	// The owner comes up, and a's heartbeats replay the hint to it.
+	b := startServer(t, owner)
+	waitFor(t, "the hint to be replayed", func() bool { return a.hints.pending()[owner] == 0 })
+	res = b.route(ctx, &protocol.Request{CommandType: protocol.CmdGet, Key: key})
+	if res.StatusCode != protocol.StatusOK || string(res.Value) != "v1" {
+		t.Errorf("the owner has %+v after the replay, want v1", res)
+	}
+}
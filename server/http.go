@@ -0,0 +1,320 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- HTTP/JSON Gateway --------
+// An optional REST listener for clients that cannot speak the binary protocol:
+//
+//	GET    /keys/{key}        read a value
+//	PUT    /keys/{key}        store a value (TTL via X-TTL header or ?ttl=)
+//	DELETE /keys/{key}        remove a value
+//	GET    /keys?prefix=p     list keys across the cluster
+//
+// With an ACL, clients authenticate with an Authorization header
+// (Basic user:password, or Bearer token) on every request.
+//
+// Values are raw bytes (application/octet-stream) unless the client asks for
+// JSON, in which case they travel base64-encoded inside a JSON envelope.
+// Requests go through route(), exactly like requests on the TCP listener.
+
+const (
+	httpMaxValueLen = 32 << 20
+
+	// How long a client has to send a request's headers, and all of it.
+	// Without a limit, one that sends a byte now and then holds on to its
+	// connection and goroutine forever.
+	httpReadHeaderTimeout = 10 * time.Second
+	httpReadTimeout       = time.Minute
+)
+
+// httpValue is the JSON envelope used when Content-Type or Accept is application/json.
+type httpValue struct {
+	Key     string `json:"key,omitempty"`
+	Value   []byte `json:"value"`
+	TTL     string `json:"ttl,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+}
+
+// httpHandler routes the gateway's endpoints.
+func (s *Server) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /keys/{key}", s.httpGet)
+	mux.HandleFunc("PUT /keys/{key}", s.httpPut)
+	mux.HandleFunc("DELETE /keys/{key}", s.httpDelete)
+	mux.HandleFunc("GET /keys", s.httpList)
+	return mux
+}
+
+// StartHTTP serves the REST gateway on addr. Like Start, it blocks until
+// the server is stopped, and returns net.ErrClosed if it already was.
+func (s *Server) StartHTTP(addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.httpHandler(),
+		ErrorLog:          s.errorLog(),
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		ReadTimeout:       httpReadTimeout,
+	}
+	if s.tls != nil {
+		srv.TLSConfig = s.tls.ServerConfig(s.mutualTLS)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if !s.frontend(func() { s.httpServer = srv }) {
+		listener.Close()
+		return net.ErrClosed
+	}
+	s.logger.Info("listening", "frontend", "http", "addr", listener.Addr().String())
+	if s.tls != nil {
+		err = srv.ServeTLS(listener, "", "")
+	} else {
+		err = srv.Serve(listener)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) httpGet(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.httpUser(w, r)
+	if !ok {
+		return
+	}
+
+	key := r.PathValue("key")
+	res := s.routeAs(r.Context(), user, &protocol.Request{CommandType: protocol.CmdGetMeta, Key: key})
+	if res.StatusCode != protocol.StatusOK {
+		httpError(w, res)
+		return
+	}
+
+	w.Header().Set("X-Version", strconv.FormatUint(res.Meta.Version, 10))
+	if res.Meta.TTL > 0 {
+		w.Header().Set("X-TTL", res.Meta.TTL.Round(time.Millisecond).String())
+	}
+
+	if wantsJSON(r.Header.Get("Accept")) {
+		body := httpValue{Key: key, Value: res.Value, Version: res.Meta.Version}
+		if res.Meta.TTL > 0 {
+			body.TTL = res.Meta.TTL.Round(time.Millisecond).String()
+		}
+		writeJSON(w, http.StatusOK, body)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(res.Value)
+}
+
+// httpPut stores the request body. If-None-Match: * only creates the key and
+// If-Match: <version> only replaces the given version (compare-and-swap).
+func (s *Server) httpPut(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.httpUser(w, r)
+	if !ok {
+		return
+	}
+
+	ttl, err := httpTTL(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, httpMaxValueLen))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	value := body
+	if wantsJSON(r.Header.Get("Content-Type")) {
+		var in httpValue
+		if err := json.Unmarshal(body, &in); err != nil {
+			http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		value = in.Value
+		if in.TTL != "" && ttl == 0 {
+			if ttl, err = parseTTL(in.TTL); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	req := &protocol.Request{CommandType: protocol.CmdSet, Key: r.PathValue("key"), Value: value, TTL: ttl}
+	if r.Header.Get("If-None-Match") == "*" {
+		req.CommandType = protocol.CmdAdd
+	} else if m := r.Header.Get("If-Match"); m != "" {
+		version, err := strconv.ParseUint(strings.Trim(m, `"`), 10, 64)
+		if err != nil {
+			http.Error(w, "If-Match must be an item version", http.StatusBadRequest)
+			return
+		}
+		req.CommandType = protocol.CmdCAS
+		req.CAS = version
+	}
+
+	res := s.routeAs(r.Context(), user, req)
+	if res.StatusCode != protocol.StatusOK {
+		httpError(w, res)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) httpDelete(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.httpUser(w, r)
+	if !ok {
+		return
+	}
+
+	res := s.routeAs(r.Context(), user, &protocol.Request{CommandType: protocol.CmdDelete, Key: r.PathValue("key")})
+	if res.StatusCode != protocol.StatusOK {
+		httpError(w, res)
+		return
+	}
+	if res.Int == 0 {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// httpList returns the sorted keys of the whole cluster, optionally
+// filtered by ?prefix=.
+func (s *Server) httpList(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.httpUser(w, r)
+	if !ok {
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+
+	all, res := s.clusterKeys(r.Context(), user)
+	if res != nil {
+		httpError(w, res)
+		return
+	}
+
+	keys := []string{}
+	for _, k := range all {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// httpUser authenticates the request from its Authorization header, either
+// Basic (user and password) or Bearer (token). Requests without the header
+// run as the default user. On bad credentials it replies 401 and returns false.
+func (s *Server) httpUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if s.acl == nil || r.Header.Get("Authorization") == "" {
+		return "", true
+	}
+
+	res, user, _ := s.authenticate(authHeader(r.Header.Get("Authorization")))
+	if res.StatusCode != protocol.StatusOK {
+		w.Header().Set("WWW-Authenticate", `Basic realm="distributed-cache"`)
+		http.Error(w, res.ErrorMessage, http.StatusUnauthorized)
+		return "", false
+	}
+	return user, true
+}
+
+// httpTTL reads the TTL from the X-TTL header or the ttl query parameter.
+func httpTTL(r *http.Request) (time.Duration, error) {
+	v := r.Header.Get("X-TTL")
+	if v == "" {
+		v = r.URL.Query().Get("ttl")
+	}
+	if v == "" {
+		return 0, nil
+	}
+	return parseTTL(v)
+}
+
+// parseTTL accepts a Go duration ("1m30s") or a number of seconds ("90").
+// More seconds than a time.Duration holds (about 292 years) are refused,
+// rather than wrapping around to a TTL in the past.
+func parseTTL(v string) (time.Duration, error) {
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil && secs >= 0 {
+		if secs > int64(math.MaxInt64/time.Second) {
+			return 0, errors.New("invalid TTL: " + v)
+		}
+		return time.Duration(secs) * time.Second, nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl < 0 {
+		return 0, errors.New("invalid TTL: " + v)
+	}
+	return ttl, nil
+}
+
+// httpStatus maps a protocol status to an HTTP status code. Only a node
+// that could not be reached is a bad gateway: other errors are this one's.
+func httpStatus(code protocol.StatusCode) int {
+	switch code {
+	case protocol.StatusOK:
+		return http.StatusOK
+	case protocol.StatusNotFound:
+		return http.StatusNotFound
+	case protocol.StatusWrongType:
+		return http.StatusConflict
+	case protocol.StatusNotStored:
+		return http.StatusPreconditionFailed
+	case protocol.StatusDenied:
+		return http.StatusForbidden
+	case protocol.StatusUnavailable:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func httpError(w http.ResponseWriter, res *protocol.Response) {
+	status := httpStatus(res.StatusCode)
+	if res.StatusCode == protocol.StatusDenied && strings.HasPrefix(res.ErrorMessage, "NOAUTH") {
+		w.Header().Set("WWW-Authenticate", `Basic realm="distributed-cache"`)
+		status = http.StatusUnauthorized
+	}
+
+	msg := res.ErrorMessage
+	if msg == "" {
+		msg = http.StatusText(status)
+	}
+	http.Error(w, msg, status)
+}
+
+func wantsJSON(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
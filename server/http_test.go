@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// httpDo sends a request to the gateway and returns the status and body.
+func httpDo(t *testing.T, base, method, path, body string, header http.Header) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, base+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(data)
+}
+
+func basicAuth(user, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+}
+
+// startHTTP serves the gateway at a free address and returns its URL.
+func startHTTP(t *testing.T, s *Server) string {
+	t.Helper()
+	addr := freeAddr(t)
+	go s.StartHTTP(addr)
+	waitFor(t, "the gateway to start", func() bool { return listening(addr) })
+	return "http://" + addr
+}
+
+func TestHTTP(t *testing.T) {
+	s := startServer(t, "")
+	srv := startHTTP(t, s)
+
+	if code, body := httpDo(t, srv, "PUT", "/keys/k", "hello", http.Header{"X-Ttl": {"60"}}); code != http.StatusNoContent {
+		t.Fatalf("PUT: %d %s", code, body)
+	}
+	if code, body := httpDo(t, srv, "GET", "/keys/k", "", nil); code != http.StatusOK || body != "hello" {
+		t.Fatalf("GET: %d %q", code, body)
+	}
+	code, body := httpDo(t, srv, "GET", "/keys/k", "", http.Header{"Accept": {"application/json"}})
+	var v httpValue
+	if err := json.Unmarshal([]byte(body), &v); code != http.StatusOK || err != nil || string(v.Value) != "hello" || v.TTL == "" {
+		t.Fatalf("GET as JSON: %d %s (%v)", code, body, err)
+	}
+	if code, _ := httpDo(t, srv, "PUT", "/keys/k", "again", http.Header{"If-None-Match": {"*"}}); code != http.StatusPreconditionFailed {
+		t.Errorf("PUT If-None-Match over an existing key: %d", code)
+	}
+	if code, body := httpDo(t, srv, "DELETE", "/keys/k", "", nil); code != http.StatusNoContent {
+		t.Fatalf("DELETE: %d %s", code, body)
+	}
+	if code, _ := httpDo(t, srv, "GET", "/keys/k", "", nil); code != http.StatusNotFound {
+		t.Errorf("GET after DELETE: %d", code)
+	}
+	if code, _ := httpDo(t, srv, "DELETE", "/keys/k", "", nil); code != http.StatusNotFound {
+		t.Errorf("DELETE of a missing key: %d", code)
+	}
+
+	// Malformed requests.
+	for _, tt := range []struct {
+		method, path, body string
+		header             http.Header
+	}{
+		{"PUT", "/keys/k", "v", http.Header{"X-Ttl": {"soon"}}},
+		{"PUT", "/keys/k", "{not json", http.Header{"Content-Type": {"application/json"}}},
+		{"PUT", "/keys/k", "v", http.Header{"If-Match": {"latest"}}},
+	} {
+		if code, body := httpDo(t, srv, tt.method, tt.path, tt.body, tt.header); code != http.StatusBadRequest {
+			t.Errorf("%s %s %v: %d %s, want 400", tt.method, tt.path, tt.header, code, body)
+		}
+	}
+
+	s.cache.LPush("list", []byte("x"))
+	if code, _ := httpDo(t, srv, "GET", "/keys/list", "", nil); code != http.StatusConflict {
+		t.Errorf("GET of a list: %d, want 409", code)
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	for v, want := range map[string]time.Duration{
+		"0":          0,
+		"90":         90 * time.Second,
+		"1m30s":      90 * time.Second,
+		"9223372036": 9223372036 * time.Second,
+	} {
+		if got, err := parseTTL(v); err != nil || got != want {
+			t.Errorf("parseTTL(%q) = %v, %v; want %v", v, got, err, want)
+		}
+	}
+	// Too many seconds would wrap around to a negative TTL.
+	for _, v := range []string{"-1", "9223372037", "9223372036854775807", "soon"} {
+		if got, err := parseTTL(v); err == nil {
+			t.Errorf("parseTTL(%q) = %v, want an error", v, got)
+		}
+	}
+}
+
+func TestHTTPServer(t *testing.T) {
+	s := startServer(t, "")
+	startHTTP(t, s)
+	s.frontMu.Lock()
+	srv := s.httpServer
+	s.frontMu.Unlock()
+	if srv.ReadHeaderTimeout <= 0 || srv.ReadTimeout <= 0 {
+		t.Errorf("read timeouts %v and %v, want both set", srv.ReadHeaderTimeout, srv.ReadTimeout)
+	}
+
+	// Like the other front-ends, the gateway cannot start once stopped.
+	s.Stop()
+	if err := s.StartHTTP(freeAddr(t)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("StartHTTP after Stop: %v, want net.ErrClosed", err)
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	for code, want := range map[protocol.StatusCode]int{
+		protocol.StatusOK:          http.StatusOK,
+		protocol.StatusNotFound:    http.StatusNotFound,
+		protocol.StatusWrongType:   http.StatusConflict,
+		protocol.StatusNotStored:   http.StatusPreconditionFailed,
+		protocol.StatusDenied:      http.StatusForbidden,
+		protocol.StatusUnavailable: http.StatusBadGateway,
+		protocol.StatusError:       http.StatusInternalServerError,
+	} {
+		if got := httpStatus(code); got != want {
+			t.Errorf("status %d: got %d, want %d", code, got, want)
+		}
+	}
+
+	// An owner nobody answers for is a bad gateway.
+	s := startServer(t, "")
+	srv := startHTTP(t, s)
+	dead := freeAddr(t)
+	s.ring.AddNode(dead)
+	if code, body := httpDo(t, srv, "GET", "/keys/"+keyOwnedBy(t, s, dead), "", nil); code != http.StatusBadGateway {
+		t.Errorf("GET from an unreachable owner: %d %s, want 502", code, body)
+	}
+}
+
+func TestHTTPAuth(t *testing.T) {
+	s := startServer(t, "", WithACL(testACL(t)))
+	srv := startHTTP(t, s)
+	admin := http.Header{"Authorization": {"Basic " + basicAuth("admin", "admin-secret")}}
+
+	if code, _ := httpDo(t, srv, "GET", "/keys/public:k", "", nil); code != http.StatusNotFound {
+		t.Errorf("default user GET public:k: %d, want 404", code)
+	}
+	if code, _ := httpDo(t, srv, "PUT", "/keys/k", "v", nil); code != http.StatusForbidden {
+		t.Errorf("default user PUT: %d, want 403", code)
+	}
+	wrong := http.Header{"Authorization": {"Basic " + basicAuth("admin", "nope")}}
+	if code, _ := httpDo(t, srv, "PUT", "/keys/k", "v", wrong); code != http.StatusUnauthorized {
+		t.Errorf("bad password: %d, want 401", code)
+	}
+	if code, body := httpDo(t, srv, "PUT", "/keys/k", "v", admin); code != http.StatusNoContent {
+		t.Errorf("admin PUT: %d %s", code, body)
+	}
+	if code, body := httpDo(t, srv, "GET", "/keys/k", "", admin); code != http.StatusOK || body != "v" {
+		t.Errorf("admin GET: %d %q", code, body)
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- Node Introspection --------
+// CmdInfo answers with a protocol.NodeInfo snapshot of this node, or of
+// the ring member named in Key (see route).
+
+// Version is the build version reported by CmdInfo. Release builds set it:
+//
+//	go build -ldflags "-X github.com/BiChong-Jin/distributed-cache/server.Version=v1.2.0"
+var Version = "dev"
+
+// routeInfo runs CmdInfo here or on the node named in req.Key. Only ring
+// members are accepted, so a client cannot make the node dial anywhere.
+func (s *Server) routeInfo(ctx context.Context, req *protocol.Request) *protocol.Response {
+	if req.Key == "" || req.Key == s.Addr {
+		return s.handleLocally(ctx, req)
+	}
+
+	if _, ok := s.ring.VirtualNodes()[req.Key]; !ok {
+		return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: "unknown node " + req.Key}
+	}
+	// The target may know itself under another address, so it gets a
+	// request for "this node" rather than for its name.
+	fwd := *req
+	fwd.Key = ""
+	return s.forwardToNode(ctx, req.Key, &fwd)
+}
+
+// nodeInfo answers CmdInfo for this node.
+func (s *Server) nodeInfo() *protocol.Response {
+	info := protocol.NodeInfo{
+		Node:             s.Addr,
+		Version:          Version,
+		UptimeSeconds:    time.Since(s.started).Seconds(),
+		ConnectedClients: int(s.clients.Load()),
+	}
+
+	for _, st := range s.stats("") {
+		info.Items += st.Items
+		info.MemoryBytes += st.Bytes
+		info.Hits += st.Hits
+		info.Misses += st.Misses
+		info.Namespaces++
+	}
+	if lookups := info.Hits + info.Misses; lookups > 0 {
+		info.HitRatio = float64(info.Hits) / float64(lookups)
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	info.HeapBytes = mem.HeapInuse
+
+	s.metaMu.Lock()
+	info.RingHash = s.ringHash
+	s.metaMu.Unlock()
+	for addr, n := range s.ring.VirtualNodes() {
+		info.Ring = append(info.Ring, protocol.RingMember{Addr: addr, Weight: s.ring.Weight(addr), VirtualNodes: n})
+	}
+	sort.Slice(info.Ring, func(i, j int) bool { return info.Ring[i].Addr < info.Ring[j].Addr })
+
+	for _, node := range s.registry.Nodes() {
+		info.Peers = append(info.Peers, protocol.PeerStatus{
+			Addr:          node.Addr,
+			Status:        node.CurrStatus.String(),
+			LastHeartbeat: node.LastHB,
+		})
+	}
+
+	info.Raft = s.raftStatus()
+	info.Shards = s.shardStatus()
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: err.Error()}
+	}
+	return &protocol.Response{StatusCode: protocol.StatusOK, Value: data}
+}
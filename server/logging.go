@@ -0,0 +1,52 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"syscall"
+)
+
+// -------- Logging --------
+// The server logs through log/slog (see WithLogger). Levels:
+//
+//	debug  connections opening and closing, routing decisions, denials
+//	info   listeners starting, cluster membership
+//	warn   failed handshakes, malformed requests, I/O errors, failed forwards
+//	error  responses that cannot be encoded
+//
+// Every line carries the node's address; connection lines also carry the
+// front-end and the remote address.
+
+// openConnection counts a newly accepted connection and returns the logger
+// to use for it. Handlers defer closeConnection right after.
+func (s *Server) openConnection(frontend string, nc net.Conn) *slog.Logger {
+	s.clients.Add(1)
+	log := s.logger.With("frontend", frontend, "remote", nc.RemoteAddr().String())
+	log.Debug("connection opened")
+	return log
+}
+
+// closeConnection closes nc and undoes openConnection.
+func (s *Server) closeConnection(log *slog.Logger, nc net.Conn) {
+	nc.Close()
+	s.clients.Add(-1)
+	log.Debug("connection closed")
+}
+
+// logIOError logs a failed read or write, unless it is just the other end
+// going away.
+func logIOError(log *slog.Logger, msg string, err error) {
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return
+	}
+	log.Warn(msg, "err", err)
+}
+
+// errorLog adapts the logger for http.Server, which wants a *log.Logger.
+func (s *Server) errorLog() *log.Logger {
+	return slog.NewLogLogger(s.logger.Handler(), slog.LevelWarn)
+}
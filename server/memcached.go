@@ -0,0 +1,728 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/cache"
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- Memcached Front-end --------
+// An optional listener that speaks the memcached text and binary protocols,
+// so existing memcached clients can use the cluster. The first byte of each
+// command tells the two apart: binary requests start with the 0x80 magic.
+// Like the RESP front-end, every command becomes a protocol.Request and goes
+// through route(), so keys live on the node the HashRing picks.
+//
+// There is no SASL: with an ACL, memcached clients run as the default user,
+// and what it may not do is refused with CLIENT_ERROR (text) or an
+// authentication error (binary).
+
+const (
+	mcMaxKeyLen   = 250
+	mcMaxValueLen = 1 << 20 // memcached's default item size limit
+	mcMaxLineLen  = 2048    // Longest text command line, as in memcached
+	mcVersion     = "1.6.0-distributed-cache"
+
+	// Expiration times above 30 days are absolute Unix timestamps.
+	mcRelativeExpiryLimit = 60 * 60 * 24 * 30
+)
+
+// StartMemcached listens on addr for memcached clients. Like Start, it
+// blocks until the listener is closed.
+func (s *Server) StartMemcached(addr string) error {
+	listener, err := s.listen(addr)
+	if err != nil {
+		return err
+	}
+	return s.serveMemcached(listener)
+}
+
+// serveMemcached accepts memcached clients on listener until it is closed.
+func (s *Server) serveMemcached(listener net.Listener) error {
+	if !s.frontend(func() { s.memcachedListener = listener }) {
+		listener.Close()
+		return net.ErrClosed
+	}
+	s.logger.Info("listening", "frontend", "memcached", "addr", listener.Addr().String())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.handleMemcachedConnection(conn)
+	}
+}
+
+// handleMemcachedConnection serves text and binary commands until the client
+// disconnects or quits. Replies are flushed once pipelined input is drained.
+func (s *Server) handleMemcachedConnection(conn net.Conn) {
+	log := s.openConnection("memcached", conn)
+	defer s.closeConnection(log, conn)
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		first, err := r.Peek(1)
+		if err != nil {
+			return
+		}
+
+		var quit bool
+		if first[0] == mcMagicRequest {
+			quit, err = s.mcBinaryCommand(r, w)
+		} else {
+			quit, err = s.mcTextCommand(r, w)
+		}
+		if err != nil {
+			logIOError(log, "command failed", err)
+			w.Flush()
+			return
+		}
+
+		if quit || r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				logIOError(log, "write failed", err)
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// mcTTL converts a memcached expiration time into a TTL.
+// 0 never expires, negative values expire immediately and values above
+// 30 days are absolute Unix timestamps.
+func mcTTL(exptime int64) time.Duration {
+	switch {
+	case exptime == 0:
+		return 0
+	case exptime < 0:
+		return time.Nanosecond
+	case exptime > mcRelativeExpiryLimit:
+		ttl := time.Until(time.Unix(exptime, 0))
+		if ttl <= 0 {
+			return time.Nanosecond
+		}
+		return ttl
+	default:
+		return time.Duration(exptime) * time.Second
+	}
+}
+
+// -------- Text Protocol --------
+
+// mcTextCommand reads and runs one text command. It returns true on quit.
+func (s *Server) mcTextCommand(r *bufio.Reader, w *bufio.Writer) (bool, error) {
+	// Read in place, so a client that never sends a newline cannot make
+	// the line grow without bound. Like memcached, a line that is too
+	// long is skipped up to its newline and refused.
+	raw, err := r.ReadSlice('\n')
+	if len(raw) > mcMaxLineLen || err == bufio.ErrBufferFull {
+		for err == bufio.ErrBufferFull {
+			_, err = r.ReadSlice('\n')
+		}
+		if err != nil {
+			return false, err
+		}
+		w.WriteString("CLIENT_ERROR line too long\r\n")
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	line := string(raw)
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		w.WriteString("ERROR\r\n")
+		return false, nil
+	}
+
+	noreply := fields[len(fields)-1] == "noreply"
+	if noreply {
+		fields = fields[:len(fields)-1]
+	}
+
+	var reply string
+	switch fields[0] {
+	case "get", "gets":
+		s.mcTextGet(w, fields[1:], fields[0] == "gets")
+		return false, nil
+
+	case "set", "add", "replace", "cas":
+		reply, err = s.mcTextStore(r, fields)
+		if err != nil {
+			if reply != "" {
+				w.WriteString(reply + "\r\n")
+			}
+			return false, err
+		}
+
+	case "delete":
+		// Old clients may send "delete <key> 0".
+		if len(fields) < 2 || len(fields) > 3 || (len(fields) == 3 && fields[2] != "0") {
+			reply = "CLIENT_ERROR bad command line format"
+			break
+		}
+		res := s.route(context.Background(), &protocol.Request{CommandType: protocol.CmdDelete, Key: fields[1]})
+		switch {
+		case res.StatusCode != protocol.StatusOK:
+			reply = mcTextError(res)
+		case res.Int == 1:
+			reply = "DELETED"
+		default:
+			reply = "NOT_FOUND"
+		}
+
+	case "incr", "decr":
+		reply = s.mcTextIncr(fields)
+
+	case "touch":
+		if len(fields) != 3 {
+			reply = "CLIENT_ERROR bad command line format"
+			break
+		}
+		exptime, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			reply = "CLIENT_ERROR bad command line format"
+			break
+		}
+		res := s.route(context.Background(), &protocol.Request{CommandType: protocol.CmdExpire, Key: fields[1], TTL: mcTTL(exptime)})
+		switch res.StatusCode {
+		case protocol.StatusOK:
+			reply = "TOUCHED"
+		case protocol.StatusNotFound:
+			reply = "NOT_FOUND"
+		default:
+			reply = mcTextError(res)
+		}
+
+	case "version":
+		reply = "VERSION " + mcVersion
+
+	case "quit":
+		return true, nil
+
+	default:
+		reply = "ERROR"
+	}
+
+	if !noreply {
+		w.WriteString(reply + "\r\n")
+	}
+	return false, nil
+}
+
+// mcTextGet writes a VALUE block for every key found, followed by END.
+// gets also includes the CAS unique, which is the item's version.
+func (s *Server) mcTextGet(w *bufio.Writer, keys []string, withCAS bool) {
+	if len(keys) == 0 {
+		w.WriteString("ERROR\r\n")
+		return
+	}
+
+	for _, key := range keys {
+		res := s.route(context.Background(), &protocol.Request{CommandType: protocol.CmdGetMeta, Key: key})
+		if res.StatusCode == protocol.StatusDenied {
+			// An error line ends the reply instead of END.
+			w.WriteString(mcTextError(res) + "\r\n")
+			return
+		}
+		if res.StatusCode != protocol.StatusOK {
+			continue
+		}
+
+		if withCAS {
+			fmt.Fprintf(w, "VALUE %s %d %d %d\r\n", key, res.Meta.Flags, len(res.Value), res.Meta.Version)
+		} else {
+			fmt.Fprintf(w, "VALUE %s %d %d\r\n", key, res.Meta.Flags, len(res.Value))
+		}
+		w.Write(res.Value)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("END\r\n")
+}
+
+// errMcTooLarge closes a connection that announced a value over
+// mcMaxValueLen, rather than reading it in.
+var errMcTooLarge = errors.New("memcached value too large")
+
+// mcTextStore handles set/add/replace/cas <key> <flags> <exptime> <bytes> [<cas unique>].
+// The data block that follows the command line is consumed, unless it is
+// too large to: then the reply is sent before the connection is closed.
+// An error is only returned if the connection can no longer be used.
+func (s *Server) mcTextStore(r *bufio.Reader, fields []string) (string, error) {
+	want := 5
+	if fields[0] == "cas" {
+		want = 6
+	}
+	if len(fields) != want {
+		return "CLIENT_ERROR bad command line format", nil
+	}
+
+	key := fields[1]
+	flags, err1 := strconv.ParseUint(fields[2], 10, 32)
+	exptime, err2 := strconv.ParseInt(fields[3], 10, 64)
+	size, err3 := strconv.Atoi(fields[4])
+	if err1 != nil || err2 != nil || err3 != nil || size < 0 {
+		return "CLIENT_ERROR bad command line format", nil
+	}
+	// Checked before reading the data, whose size the client chose.
+	if size > mcMaxValueLen {
+		return "SERVER_ERROR object too large for cache", errMcTooLarge
+	}
+	if len(key) > mcMaxKeyLen {
+		if _, err := io.CopyN(io.Discard, r, int64(size)+2); err != nil {
+			return "", err
+		}
+		return "CLIENT_ERROR key too long", nil
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		return "CLIENT_ERROR bad data chunk", nil
+	}
+
+	req := &protocol.Request{
+		Key:   key,
+		Value: data[:size],
+		TTL:   mcTTL(exptime),
+		Flags: uint32(flags),
+	}
+	switch fields[0] {
+	case "set":
+		req.CommandType = protocol.CmdSet
+	case "add":
+		req.CommandType = protocol.CmdAdd
+	case "replace":
+		req.CommandType = protocol.CmdReplace
+	case "cas":
+		cas, err := strconv.ParseUint(fields[5], 10, 64)
+		if err != nil {
+			return "CLIENT_ERROR bad command line format", nil
+		}
+		req.CommandType = protocol.CmdCAS
+		req.CAS = cas
+	}
+
+	res := s.route(context.Background(), req)
+	switch res.StatusCode {
+	case protocol.StatusOK:
+		return "STORED", nil
+	case protocol.StatusNotStored:
+		if req.CommandType == protocol.CmdCAS {
+			return "EXISTS", nil
+		}
+		return "NOT_STORED", nil
+	case protocol.StatusNotFound:
+		return "NOT_FOUND", nil
+	default:
+		return mcTextError(res), nil
+	}
+}
+
+// mcTextIncr handles incr/decr <key> <delta>.
+func (s *Server) mcTextIncr(fields []string) string {
+	if len(fields) != 3 {
+		return "CLIENT_ERROR bad command line format"
+	}
+	delta, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || delta < 0 {
+		return "CLIENT_ERROR invalid numeric delta argument"
+	}
+	if fields[0] == "decr" {
+		delta = -delta
+	}
+
+	res := s.route(context.Background(), &protocol.Request{CommandType: protocol.CmdIncrExisting, Key: fields[1], Int: delta})
+	switch res.StatusCode {
+	case protocol.StatusOK:
+		return strconv.FormatInt(res.Int, 10)
+	case protocol.StatusNotFound:
+		return "NOT_FOUND"
+	}
+	if mcNotNumeric(res) {
+		return "CLIENT_ERROR cannot increment or decrement non-numeric value"
+	}
+	return mcTextError(res)
+}
+
+// mcNotNumeric reports whether incr/decr failed because the value is not
+// a number, rather than because the request was refused or never got an
+// answer. Once a request has been forwarded only its message tells.
+func mcNotNumeric(res *protocol.Response) bool {
+	switch res.StatusCode {
+	case protocol.StatusWrongType:
+		return true
+	case protocol.StatusError:
+		return res.ErrorMessage == cache.ErrNotInteger.Error()
+	}
+	return false
+}
+
+// mcTextError is the reply line for a failed request: CLIENT_ERROR if the
+// ACL refused it, SERVER_ERROR otherwise.
+func mcTextError(res *protocol.Response) string {
+	if res.StatusCode == protocol.StatusDenied {
+		return "CLIENT_ERROR " + res.ErrorMessage
+	}
+	return "SERVER_ERROR " + res.ErrorMessage
+}
+
+// -------- Binary Protocol --------
+// Every binary packet starts with a 24-byte header:
+//   [1 magic][1 opcode][2 key len][1 extras len][1 data type][2 vbucket/status]
+//   [4 total body len][4 opaque][8 cas]
+// followed by the extras, the key and the value.
+
+const (
+	mcMagicRequest  = 0x80
+	mcMagicResponse = 0x81
+	mcHeaderLen     = 24
+)
+
+const (
+	mcOpGet        = 0x00
+	mcOpSet        = 0x01
+	mcOpAdd        = 0x02
+	mcOpReplace    = 0x03
+	mcOpDelete     = 0x04
+	mcOpIncrement  = 0x05
+	mcOpDecrement  = 0x06
+	mcOpQuit       = 0x07
+	mcOpGetQ       = 0x09
+	mcOpNoop       = 0x0a
+	mcOpVersion    = 0x0b
+	mcOpGetK       = 0x0c
+	mcOpGetKQ      = 0x0d
+	mcOpSetQ       = 0x11
+	mcOpAddQ       = 0x12
+	mcOpReplaceQ   = 0x13
+	mcOpDeleteQ    = 0x14
+	mcOpIncrementQ = 0x15
+	mcOpDecrementQ = 0x16
+	mcOpQuitQ      = 0x17
+	mcOpTouch      = 0x1c
+)
+
+const (
+	mcStatusOK             = 0x0000
+	mcStatusKeyNotFound    = 0x0001
+	mcStatusKeyExists      = 0x0002
+	mcStatusTooLarge       = 0x0003
+	mcStatusInvalidArgs    = 0x0004
+	mcStatusNotStored      = 0x0005
+	mcStatusNonNumeric     = 0x0006
+	mcStatusAuthError      = 0x0020
+	mcStatusUnknownCommand = 0x0081
+	mcStatusInternalError  = 0x0084
+)
+
+// mcQuietOps maps each quiet opcode to its normal counterpart. Quiet
+// commands only reply on failure (or, for gets, only on a hit).
+var mcQuietOps = map[byte]byte{
+	mcOpGetQ:       mcOpGet,
+	mcOpGetKQ:      mcOpGetK,
+	mcOpSetQ:       mcOpSet,
+	mcOpAddQ:       mcOpAdd,
+	mcOpReplaceQ:   mcOpReplace,
+	mcOpDeleteQ:    mcOpDelete,
+	mcOpIncrementQ: mcOpIncrement,
+	mcOpDecrementQ: mcOpDecrement,
+	mcOpQuitQ:      mcOpQuit,
+}
+
+// mcPacket is a decoded binary request.
+type mcPacket struct {
+	opcode byte
+	opaque uint32
+	cas    uint64
+	extras []byte
+	key    string
+	value  []byte
+}
+
+// mcReply is a binary response before it is written out.
+type mcReply struct {
+	status uint16
+	cas    uint64
+	extras []byte
+	key    string
+	value  []byte
+}
+
+var errMemcachedPacket = errors.New("malformed memcached binary packet")
+
+// mcBinaryCommand reads and runs one binary request. It returns true on quit.
+func (s *Server) mcBinaryCommand(r *bufio.Reader, w *bufio.Writer) (bool, error) {
+	var header [mcHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return false, err
+	}
+
+	keyLen := int(binary.BigEndian.Uint16(header[2:4]))
+	extLen := int(header[4])
+	bodyLen := int(binary.BigEndian.Uint32(header[8:12]))
+	if bodyLen < keyLen+extLen || bodyLen > mcMaxValueLen+mcMaxKeyLen+255 {
+		return false, errMemcachedPacket
+	}
+
+	body := make([]byte, bodyLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return false, err
+	}
+
+	pkt := &mcPacket{
+		opcode: header[1],
+		opaque: binary.BigEndian.Uint32(header[12:16]),
+		cas:    binary.BigEndian.Uint64(header[16:24]),
+		extras: body[:extLen],
+		key:    string(body[extLen : extLen+keyLen]),
+		value:  body[extLen+keyLen:],
+	}
+
+	op, quiet := mcQuietOps[pkt.opcode]
+	if !quiet {
+		op = pkt.opcode
+	}
+
+	reply := s.mcBinaryExec(op, pkt)
+	if quiet {
+		miss := reply.status == mcStatusKeyNotFound && (op == mcOpGet || op == mcOpGetK)
+		if reply.status == mcStatusOK || miss {
+			return op == mcOpQuit, nil
+		}
+	}
+
+	mcWriteReply(w, pkt, reply)
+	return op == mcOpQuit, nil
+}
+
+// mcBinaryExec runs a (non-quiet) binary opcode.
+func (s *Server) mcBinaryExec(op byte, pkt *mcPacket) mcReply {
+	switch op {
+	case mcOpGet, mcOpGetK:
+		res := s.route(context.Background(), &protocol.Request{CommandType: protocol.CmdGetMeta, Key: pkt.key})
+		switch res.StatusCode {
+		case protocol.StatusOK:
+			reply := mcReply{cas: res.Meta.Version, extras: binary.BigEndian.AppendUint32(nil, res.Meta.Flags), value: res.Value}
+			if op == mcOpGetK {
+				reply.key = pkt.key
+			}
+			return reply
+		case protocol.StatusNotFound:
+			return mcReply{status: mcStatusKeyNotFound}
+		default:
+			return mcFailure(res)
+		}
+
+	case mcOpSet, mcOpAdd, mcOpReplace:
+		return s.mcBinaryStore(op, pkt)
+
+	case mcOpDelete:
+		res := s.route(context.Background(), &protocol.Request{CommandType: protocol.CmdDelete, Key: pkt.key})
+		switch {
+		case res.StatusCode != protocol.StatusOK:
+			return mcFailure(res)
+		case res.Int == 0:
+			return mcReply{status: mcStatusKeyNotFound}
+		default:
+			return mcReply{}
+		}
+
+	case mcOpIncrement, mcOpDecrement:
+		return s.mcBinaryIncr(op, pkt)
+
+	case mcOpTouch:
+		if len(pkt.extras) != 4 {
+			return mcReply{status: mcStatusInvalidArgs}
+		}
+		exptime := int64(int32(binary.BigEndian.Uint32(pkt.extras)))
+		res := s.route(context.Background(), &protocol.Request{CommandType: protocol.CmdExpire, Key: pkt.key, TTL: mcTTL(exptime)})
+		switch res.StatusCode {
+		case protocol.StatusOK:
+			return mcReply{}
+		case protocol.StatusNotFound:
+			return mcReply{status: mcStatusKeyNotFound}
+		default:
+			return mcFailure(res)
+		}
+
+	case mcOpNoop, mcOpQuit:
+		return mcReply{}
+
+	case mcOpVersion:
+		return mcReply{value: []byte(mcVersion)}
+
+	default:
+		return mcReply{status: mcStatusUnknownCommand}
+	}
+}
+
+// mcBinaryStore handles Set/Add/Replace. Extras are [4 flags][4 expiration];
+// a non-zero CAS in the header turns Set and Replace into a compare-and-swap.
+func (s *Server) mcBinaryStore(op byte, pkt *mcPacket) mcReply {
+	if len(pkt.extras) != 8 || len(pkt.key) == 0 || (op == mcOpAdd && pkt.cas != 0) {
+		return mcReply{status: mcStatusInvalidArgs}
+	}
+	if len(pkt.key) > mcMaxKeyLen {
+		return mcReply{status: mcStatusInvalidArgs}
+	}
+	if len(pkt.value) > mcMaxValueLen {
+		return mcReply{status: mcStatusTooLarge}
+	}
+
+	req := &protocol.Request{
+		Key:   pkt.key,
+		Value: pkt.value,
+		Flags: binary.BigEndian.Uint32(pkt.extras[0:4]),
+		TTL:   mcTTL(int64(int32(binary.BigEndian.Uint32(pkt.extras[4:8])))),
+	}
+	switch {
+	case pkt.cas != 0:
+		req.CommandType = protocol.CmdCAS
+		req.CAS = pkt.cas
+	case op == mcOpSet:
+		req.CommandType = protocol.CmdSet
+	case op == mcOpAdd:
+		req.CommandType = protocol.CmdAdd
+	default:
+		req.CommandType = protocol.CmdReplace
+	}
+
+	res := s.route(context.Background(), req)
+	switch res.StatusCode {
+	case protocol.StatusOK:
+		return mcReply{}
+	case protocol.StatusNotFound:
+		return mcReply{status: mcStatusKeyNotFound}
+	case protocol.StatusNotStored:
+		if op == mcOpReplace && req.CommandType == protocol.CmdReplace {
+			return mcReply{status: mcStatusKeyNotFound}
+		}
+		return mcReply{status: mcStatusKeyExists}
+	default:
+		return mcFailure(res)
+	}
+}
+
+// mcFailure is the reply for a failed request: an authentication error if
+// the ACL refused it, an internal error otherwise.
+func mcFailure(res *protocol.Response) mcReply {
+	if res.StatusCode == protocol.StatusDenied {
+		return mcReply{status: mcStatusAuthError}
+	}
+	return mcReply{status: mcStatusInternalError}
+}
+
+// mcBinaryIncr handles Increment/Decrement. Extras are
+// [8 delta][8 initial value][4 expiration]; an expiration of 0xffffffff
+// means a missing key is an error instead of being created with the initial value.
+func (s *Server) mcBinaryIncr(op byte, pkt *mcPacket) mcReply {
+	if len(pkt.extras) != 20 {
+		return mcReply{status: mcStatusInvalidArgs}
+	}
+	delta := binary.BigEndian.Uint64(pkt.extras[0:8])
+	initial := binary.BigEndian.Uint64(pkt.extras[8:16])
+	exptime := binary.BigEndian.Uint32(pkt.extras[16:20])
+	if delta > 1<<63-1 || initial > 1<<63-1 {
+		return mcReply{status: mcStatusNonNumeric}
+	}
+
+	d := int64(delta)
+	if op == mcOpDecrement {
+		d = -d
+	}
+
+	// Retry once if another client creates the key between our incr and add.
+	for attempt := 0; attempt < 2; attempt++ {
+		res := s.route(context.Background(), &protocol.Request{CommandType: protocol.CmdIncrExisting, Key: pkt.key, Int: d})
+		switch res.StatusCode {
+		case protocol.StatusOK:
+			return mcReply{value: binary.BigEndian.AppendUint64(nil, uint64(res.Int))}
+		case protocol.StatusNotFound:
+		default:
+			if mcNotNumeric(res) {
+				return mcReply{status: mcStatusNonNumeric}
+			}
+			return mcFailure(res)
+		}
+
+		if exptime == 0xffffffff {
+			return mcReply{status: mcStatusKeyNotFound}
+		}
+		add := &protocol.Request{
+			CommandType: protocol.CmdAdd,
+			Key:         pkt.key,
+			Value:       []byte(strconv.FormatUint(initial, 10)),
+			TTL:         mcTTL(int64(exptime)),
+		}
+		if s.route(context.Background(), add).StatusCode == protocol.StatusOK {
+			return mcReply{value: binary.BigEndian.AppendUint64(nil, initial)}
+		}
+	}
+	return mcReply{status: mcStatusInternalError}
+}
+
+// mcWriteReply writes a binary response echoing the request's opcode and opaque.
+func mcWriteReply(w *bufio.Writer, pkt *mcPacket, reply mcReply) {
+	value := reply.value
+	if reply.status != mcStatusOK && value == nil {
+		value = []byte(mcStatusText(reply.status))
+	}
+
+	var header [mcHeaderLen]byte
+	header[0] = mcMagicResponse
+	header[1] = pkt.opcode
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(reply.key)))
+	header[4] = byte(len(reply.extras))
+	binary.BigEndian.PutUint16(header[6:8], reply.status)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(reply.extras)+len(reply.key)+len(value)))
+	binary.BigEndian.PutUint32(header[12:16], pkt.opaque)
+	binary.BigEndian.PutUint64(header[16:24], reply.cas)
+
+	w.Write(header[:])
+	w.Write(reply.extras)
+	w.WriteString(reply.key)
+	w.Write(value)
+}
+
+func mcStatusText(status uint16) string {
+	switch status {
+	case mcStatusKeyNotFound:
+		return "Not found"
+	case mcStatusKeyExists:
+		return "Data exists for key."
+	case mcStatusTooLarge:
+		return "Too large."
+	case mcStatusInvalidArgs:
+		return "Invalid arguments"
+	case mcStatusNotStored:
+		return "Not stored."
+	case mcStatusNonNumeric:
+		return "Non-numeric server-side value for incr or decr"
+	case mcStatusAuthError:
+		return "Auth failure."
+	case mcStatusUnknownCommand:
+		return "Unknown command"
+	default:
+		return "Internal error"
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func dialMemcached(t *testing.T, s *Server) *textClient {
+	t.Helper()
+	return dialText(t, s.StartMemcached)
+}
+
+func TestMemcachedOversizeValue(t *testing.T) {
+	s := startServer(t, "")
+
+	// Sizes that would overflow or allocate gigabytes close the connection
+	// without reading the data.
+	for _, size := range []string{"9223372036854775807", "4000000000", fmt.Sprint(mcMaxValueLen + 1)} {
+		c := dialMemcached(t, s)
+		c.send("set k 0 0 " + size + "\r\nxx\r\n")
+		c.expect("SERVER_ERROR object too large for cache")
+		c.expectClosed()
+	}
+
+	// A key that is too long is refused, but its data is skipped and the
+	// connection stays usable.
+	c := dialMemcached(t, s)
+	c.send("set " + strings.Repeat("k", mcMaxKeyLen+1) + " 0 0 2\r\nxx\r\n")
+	c.expect("CLIENT_ERROR key too long")
+	c.send("set k 0 0 2\r\nok\r\nget k\r\n")
+	c.expect("STORED")
+	c.expect("VALUE k 0 2")
+	c.expect("ok")
+	c.expect("END")
+}
+
+func TestMemcachedLongLine(t *testing.T) {
+	s := startServer(t, "")
+
+	// Lines up to the limit are read; longer ones, even past the read
+	// buffer, are skipped and the connection stays usable.
+	c := dialMemcached(t, s)
+	c.send("get " + strings.Repeat("k", mcMaxLineLen-6) + "\r\n")
+	c.expect("END")
+	for _, n := range []int{mcMaxLineLen, 100000} {
+		c.send("get " + strings.Repeat("k", n) + "\r\n")
+		c.expect("CLIENT_ERROR line too long")
+	}
+	c.send("set k 0 0 2\r\nok\r\n")
+	c.expect("STORED")
+}
+
+func TestMemcachedText(t *testing.T) {
+	s := startServer(t, "")
+	c := dialMemcached(t, s)
+
+	c.send("set k 5 0 5\r\nhello\r\nget k missing\r\n")
+	c.expect("STORED")
+	c.expect("VALUE k 5 5")
+	c.expect("hello")
+	c.expect("END")
+
+	c.send("add k 0 0 1\r\nx\r\ndelete k\r\ndelete k\r\nget k\r\n")
+	c.expect("NOT_STORED")
+	c.expect("DELETED")
+	c.expect("NOT_FOUND")
+	c.expect("END")
+
+	// Malformed commands get an error and leave the connection usable.
+	c.send("set k 0 0\r\nset k x 0 1\r\nx\r\nset k 0 0 1\r\nxyz\r\nfly\r\nget\r\nversion\r\n")
+	c.expect("CLIENT_ERROR bad command line format")
+	c.expect("CLIENT_ERROR bad command line format")
+	c.expect("ERROR") // the data line, read as a command
+	c.expect("CLIENT_ERROR bad data chunk")
+	c.expect("ERROR") // what was left of the data line
+	c.expect("ERROR")
+	c.expect("ERROR")
+	c.expectPrefix("VERSION ")
+}
+
+// mcBinary sends a binary request and returns the reply's status and value.
+func (c *textClient) mcBinary(op byte, key string, extras, value []byte) (uint16, []byte) {
+	c.t.Helper()
+	header := make([]byte, mcHeaderLen)
+	header[0] = mcMagicRequest
+	header[1] = op
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(key)))
+	header[4] = byte(len(extras))
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(extras)+len(key)+len(value)))
+	c.send(string(header) + string(extras) + key + string(value))
+
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		c.t.Fatal(err)
+	}
+	if header[0] != mcMagicResponse || header[1] != op {
+		c.t.Fatalf("unexpected reply header % x", header)
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header[8:12]))
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		c.t.Fatal(err)
+	}
+	skip := int(header[4]) + int(binary.BigEndian.Uint16(header[2:4]))
+	return binary.BigEndian.Uint16(header[6:8]), body[skip:]
+}
+
+func TestMemcachedBinary(t *testing.T) {
+	s := startServer(t, "")
+	c := dialMemcached(t, s)
+	setExtras := make([]byte, 8) // flags, expiration
+
+	if status, _ := c.mcBinary(mcOpSet, "k", setExtras, []byte("hello")); status != mcStatusOK {
+		t.Fatalf("set: status %#x", status)
+	}
+	if status, v := c.mcBinary(mcOpGet, "k", nil, nil); status != mcStatusOK || string(v) != "hello" {
+		t.Fatalf("get: status %#x, value %q", status, v)
+	}
+	if status, _ := c.mcBinary(mcOpAdd, "k", setExtras, []byte("x")); status != mcStatusKeyExists {
+		t.Errorf("add over an existing key: status %#x", status)
+	}
+	if status, _ := c.mcBinary(mcOpDelete, "k", nil, nil); status != mcStatusOK {
+		t.Fatalf("delete: status %#x", status)
+	}
+	if status, _ := c.mcBinary(mcOpGet, "k", nil, nil); status != mcStatusKeyNotFound {
+		t.Errorf("get after delete: status %#x", status)
+	}
+
+	// Bad arguments get an error; a bad header closes the connection.
+	if status, _ := c.mcBinary(mcOpSet, "k", nil, []byte("x")); status != mcStatusInvalidArgs {
+		t.Errorf("set without extras: status %#x", status)
+	}
+	if status, _ := c.mcBinary(0x7f, "k", nil, nil); status != mcStatusUnknownCommand {
+		t.Errorf("unknown opcode: status %#x", status)
+	}
+	header := make([]byte, mcHeaderLen)
+	header[0] = mcMagicRequest
+	binary.BigEndian.PutUint16(header[2:4], 10) // a key longer than the body
+	c.send(string(header))
+	c.expectClosed()
+}
+
+func TestMemcachedAuth(t *testing.T) {
+	// Memcached clients run as the default user, which may only get
+	// public: keys.
+	s := startServer(t, "", WithACL(testACL(t)))
+	s.cache.Set("public:k", []byte("v"), 0)
+	c := dialMemcached(t, s)
+
+	c.send("get public:k\r\nget k\r\nset k 0 0 1\r\nx\r\ndelete public:k\r\nincr public:k 1\r\n")
+	c.expect("VALUE public:k 0 1")
+	c.expect("v")
+	c.expect("END")
+	c.expectPrefix("CLIENT_ERROR NOPERM")
+	c.expectPrefix("CLIENT_ERROR NOPERM")
+	c.expectPrefix("CLIENT_ERROR NOPERM")
+	c.expectPrefix("CLIENT_ERROR NOPERM")
+
+	if status, _ := c.mcBinary(mcOpSet, "k", make([]byte, 8), []byte("x")); status != mcStatusAuthError {
+		t.Errorf("binary set: status %#x, want an auth error", status)
+	}
+	if status, _ := c.mcBinary(mcOpIncrement, "public:k", make([]byte, 20), nil); status != mcStatusAuthError {
+		t.Errorf("binary incr: status %#x, want an auth error", status)
+	}
+	if status, v := c.mcBinary(mcOpGet, "public:k", nil, nil); status != mcStatusOK || string(v) != "v" {
+		t.Errorf("binary get: status %#x, value %q", status, v)
+	}
+}
+
+func TestMemcachedIncr(t *testing.T) {
+	s := startServer(t, "")
+	c := dialMemcached(t, s)
+	incrExtras := make([]byte, 20) // delta, initial value, expiration
+	binary.BigEndian.PutUint64(incrExtras[0:8], 2)
+
+	c.send("set n 0 0 1\r\n5\r\nincr n 2\r\ndecr n 10\r\nincr missing 1\r\n")
+	c.expect("STORED")
+	c.expect("7")
+	c.expect("0")
+	c.expect("NOT_FOUND")
+	if status, v := c.mcBinary(mcOpIncrement, "n", incrExtras, nil); status != mcStatusOK || binary.BigEndian.Uint64(v) != 2 {
+		t.Errorf("binary incr: status %#x, value %x", status, v)
+	}
+
+	// Only values that are not numbers are reported as such.
+	c.send("set k 0 0 3\r\nabc\r\nincr k 1\r\n")
+	c.expect("STORED")
+	c.expect("CLIENT_ERROR cannot increment or decrement non-numeric value")
+	if status, _ := c.mcBinary(mcOpIncrement, "k", incrExtras, nil); status != mcStatusNonNumeric {
+		t.Errorf("binary incr of a string: status %#x", status)
+	}
+
+	dead := freeAddr(t)
+	s.ring.AddNode(dead)
+	key := keyOwnedBy(t, s, dead)
+	c.send("incr " + key + " 1\r\n")
+	c.expectPrefix("SERVER_ERROR ")
+	if status, _ := c.mcBinary(mcOpIncrement, key, incrExtras, nil); status != mcStatusInternalError {
+		t.Errorf("binary incr on an unreachable owner: status %#x", status)
+	}
+}
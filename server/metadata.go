@@ -0,0 +1,337 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/cache"
+	"github.com/BiChong-Jin/distributed-cache/consistent"
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+	"github.com/BiChong-Jin/distributed-cache/raft"
+)
+
+// -------- Cluster Metadata --------
+// Without WithRaft, a node's ring holds itself and the nodes it was told
+// to join, so two nodes may well disagree on who owns a key. With
+// WithRaft, the cluster's metadata lives in a Raft log (see the raft
+// package) instead:
+//
+//	members      the nodes in the ring, and their weights (see weight.go)
+//	ring config  the replica count and the hash function
+//	namespaces   per-namespace settings (quotas, strong mode)
+//	shards       the voters of each strong-mode shard (see strong.go)
+//
+// Every node applies the log in the same order, so once caught up all
+// rings agree. A few fixed nodes vote on the log (-raft); every other
+// member follows it as a learner, and a node that is not a member yet
+// proposes itself through the voters when it starts. Until its join is
+// applied the node has no ring and cannot route requests.
+//
+// When it starts, a node also proposes its own ring config, quotas and
+// strong namespaces as defaults, which only apply while the cluster has
+// none: the first node's settings win. Later changes go through
+// ConfigureRing, ConfigureNamespace and ConfigureStrong. Nodes only leave
+// the ring through LeaveCluster; being stopped or unreachable does not
+// remove them, which is what replicas and hints are for.
+//
+// Raft messages travel as CmdRaft requests over the peer connections,
+// Key naming the group.
+
+// metaGroup names the metadata group in CmdRaft requests.
+const metaGroup = "meta"
+
+var (
+	errNoRaft = errors.New("cluster metadata needs WithRaft")
+	errNoRing = errors.New("no nodes in the ring yet")
+)
+
+// clusterMeta is the state the metadata log builds.
+type clusterMeta struct {
+	Members    []string
+	Weights    map[string]int // Of the members, as last advertised or configured; 1 if missing
+	Replicas   int
+	Hash       string
+	Configured bool // Ring config set, so defaults no longer apply
+	Namespaces map[string]cache.Quota
+	Strong     map[string]bool  // Namespaces in strong mode, or set not to be
+	Shards     map[int][]string // Voters of the strong-mode shards placed so far
+}
+
+// metaChange is one entry of the metadata log, stored as JSON.
+type metaChange struct {
+	Op        string       `json:"op"` // join, leave, weight, ring, namespace, strong or shard
+	Addr      string       `json:"addr,omitempty"`
+	Weight    int          `json:"weight,omitempty"`
+	Replicas  int          `json:"replicas,omitempty"`
+	Hash      string       `json:"hash,omitempty"`
+	Namespace string       `json:"namespace,omitempty"`
+	Quota     *cache.Quota `json:"quota,omitempty"` // nil removes the namespace's setting
+	Strong    bool         `json:"strong,omitempty"`
+	Shard     int          `json:"shard,omitempty"`
+	Voters    []string     `json:"voters,omitempty"`
+	Default   bool         `json:"default,omitempty"`
+}
+
+// newMeta creates this node's member of the metadata group.
+func (s *Server) newMeta() *raft.Node {
+	opts := []raft.Option{raft.WithLogger(s.logger.With("raft", metaGroup))}
+	if s.raftPath != "" {
+		opts = append(opts, raft.WithStorage(s.raftPath))
+	}
+	return raft.New(s.Addr, s.raftVoters, s.raftTransport(metaGroup), s.applyMeta, opts...)
+}
+
+// startMeta starts the node's member of the metadata group and has the
+// node join the ring through it.
+func (s *Server) startMeta() error {
+	if err := s.meta.Start(); err != nil {
+		return err
+	}
+	go s.proposeDefaults()
+	return nil
+}
+
+// proposeDefaults proposes this node as a member, then its ring config,
+// quotas and strong namespaces as defaults, retrying until they are
+// applied or the node stops.
+func (s *Server) proposeDefaults() {
+	s.metaMu.Lock()
+	hash := s.ringHash
+	s.metaMu.Unlock()
+	changes := []metaChange{
+		{Op: "join", Addr: s.Addr, Weight: int(s.weight.Load())},
+		{Op: "ring", Replicas: int(s.replicas.Load()), Hash: hash, Default: true},
+	}
+	for _, ns := range slices.Sorted(maps.Keys(s.quotas)) {
+		quota := s.quotas[ns]
+		changes = append(changes, metaChange{Op: "namespace", Namespace: ns, Quota: &quota, Default: true})
+	}
+	for _, ns := range s.strongNamespaces {
+		changes = append(changes, metaChange{Op: "strong", Namespace: ns, Strong: true, Default: true})
+	}
+
+	for _, change := range changes {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err := s.proposeMeta(ctx, change)
+			cancel()
+			if err == nil {
+				break
+			}
+			s.logger.Warn("cannot update cluster metadata, retrying", "op", change.Op, "err", err)
+			select {
+			case <-s.done:
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+// ConfigureRing sets the cluster's replica count and ring hash function
+// (see consistent.Hash). Zero and "" leave them as they are. A new hash
+// function moves nearly every key to other nodes, where it is missing.
+func (s *Server) ConfigureRing(ctx context.Context, replicas int, hash string) error {
+	if hash != "" && consistent.Hash(hash) == nil {
+		return fmt.Errorf("unknown hash function %q", hash)
+	}
+	return s.proposeMeta(ctx, metaChange{Op: "ring", Replicas: max(replicas, 0), Hash: hash})
+}
+
+// ConfigureNamespace sets the quota of namespace ns across the cluster,
+// or removes it if quota is nil. "*" is the default for namespaces
+// without their own (see cache.SetQuotas).
+func (s *Server) ConfigureNamespace(ctx context.Context, ns string, quota *cache.Quota) error {
+	return s.proposeMeta(ctx, metaChange{Op: "namespace", Namespace: ns, Quota: quota})
+}
+
+// ConfigureStrong turns strong mode on or off for namespace ns across the
+// cluster (see strong.go). Keys written in one mode are not seen in the
+// other.
+func (s *Server) ConfigureStrong(ctx context.Context, ns string, on bool) error {
+	return s.proposeMeta(ctx, metaChange{Op: "strong", Namespace: ns, Strong: on})
+}
+
+// LeaveCluster takes this node out of the ring for good, e.g. before it
+// is decommissioned.
+func (s *Server) LeaveCluster(ctx context.Context) error {
+	return s.proposeMeta(ctx, metaChange{Op: "leave", Addr: s.Addr})
+}
+
+// proposeMeta appends a change to the metadata log and returns once it is
+// applied here.
+func (s *Server) proposeMeta(ctx context.Context, change metaChange) error {
+	if s.meta == nil {
+		return errNoRaft
+	}
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	_, err = s.meta.Propose(ctx, data)
+	return err
+}
+
+// applyMeta applies one committed entry of the metadata log. Entries are
+// applied in the same order everywhere, so every decision here must only
+// depend on the log.
+func (s *Server) applyMeta(e raft.Entry) {
+	var change metaChange
+	if err := json.Unmarshal(e.Data, &change); err != nil {
+		s.logger.Error("malformed cluster metadata entry", "index", e.Index, "err", err)
+		return
+	}
+
+	s.metaMu.Lock()
+	defer s.metaMu.Unlock()
+	m := &s.metaState
+
+	switch change.Op {
+	case "join":
+		if slices.Contains(m.Members, change.Addr) {
+			// A member starting again advertises its weight anew.
+			s.applyWeight(m, change.Addr, change.Weight, e.Index)
+			return
+		}
+		m.Members = append(m.Members, change.Addr)
+		if validWeight(int64(change.Weight)) {
+			s.ring.AddNodeWithWeight(change.Addr, change.Weight)
+		} else {
+			s.ring.AddNode(change.Addr)
+		}
+		s.registry.Register(change.Addr)
+		s.meta.SetLearners(m.Members)
+		s.applyWeight(m, change.Addr, change.Weight, e.Index)
+		s.logger.Info("node joined the ring", "peer", change.Addr, "weight", s.ring.Weight(change.Addr), "index", e.Index)
+
+	case "weight":
+		if slices.Contains(m.Members, change.Addr) {
+			s.applyWeight(m, change.Addr, change.Weight, e.Index)
+		}
+
+	case "leave":
+		i := slices.Index(m.Members, change.Addr)
+		if i < 0 {
+			return
+		}
+		m.Members = slices.Delete(m.Members, i, i+1)
+		delete(m.Weights, change.Addr)
+		s.ring.RemoveNode(change.Addr)
+		if change.Addr != s.Addr {
+			s.registry.Unregister(change.Addr)
+		}
+		s.meta.SetLearners(m.Members)
+		s.logger.Info("node left the ring", "peer", change.Addr, "index", e.Index)
+
+	case "ring":
+		if change.Default && m.Configured {
+			return
+		}
+		if change.Replicas > 0 {
+			m.Replicas = change.Replicas
+			s.replicas.Store(int64(change.Replicas))
+		}
+		if change.Hash != "" {
+			m.Hash = change.Hash
+			if fn := consistent.Hash(m.Hash); fn == nil {
+				s.logger.Warn("unknown ring hash function", "hash", m.Hash, "index", e.Index)
+			} else if m.Hash != s.ringHash {
+				s.ringHash = m.Hash
+				s.ring.SetHash(fn)
+			}
+		}
+		m.Configured = true
+		s.logger.Info("ring configured", "replicas", s.replicas.Load(), "hash", m.Hash, "index", e.Index)
+
+	case "namespace":
+		if _, ok := m.Namespaces[change.Namespace]; ok && change.Default {
+			return
+		}
+		if m.Namespaces == nil {
+			m.Namespaces = make(map[string]cache.Quota)
+		}
+		if change.Quota == nil {
+			delete(m.Namespaces, change.Namespace)
+		} else {
+			m.Namespaces[change.Namespace] = *change.Quota
+		}
+		s.cache.SetQuotas(m.Namespaces)
+		s.logger.Info("namespace configured", "namespace", change.Namespace, "index", e.Index)
+
+	case "strong":
+		if _, ok := m.Strong[change.Namespace]; ok && change.Default {
+			return
+		}
+		if m.Strong == nil {
+			m.Strong = make(map[string]bool)
+		}
+		m.Strong[change.Namespace] = change.Strong
+		s.logger.Info("strong mode configured", "namespace", change.Namespace, "strong", change.Strong, "index", e.Index)
+
+	case "shard":
+		// The first placement of a shard stands.
+		if _, ok := m.Shards[change.Shard]; ok || change.Shard < 0 || change.Shard >= strongShards || len(change.Voters) == 0 {
+			return
+		}
+		if m.Shards == nil {
+			m.Shards = make(map[int][]string)
+		}
+		m.Shards[change.Shard] = change.Voters
+		if slices.Contains(change.Voters, s.Addr) {
+			s.startShard(change.Shard, change.Voters)
+		}
+		s.logger.Info("strong shard placed", "shard", change.Shard, "voters", change.Voters, "index", e.Index)
+
+	default:
+		s.logger.Warn("unknown cluster metadata change", "op", change.Op, "index", e.Index)
+	}
+}
+
+// -------- Raft Transport --------
+
+// raftTransport returns the transport of the Raft group named group.
+// Heartbeats are frequent, so they are not traced.
+func (s *Server) raftTransport(group string) raft.Transport {
+	return func(ctx context.Context, peer string, msg []byte) ([]byte, error) {
+		res, err := s.peers.roundTrip(ctx, peer, &protocol.Request{CommandType: protocol.CmdRaft, Key: group, Value: msg})
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != protocol.StatusOK {
+			return nil, errors.New(res.ErrorMessage)
+		}
+		return res.Value, nil
+	}
+}
+
+// raftMessage answers CmdRaft, for the metadata group or a shard's.
+func (s *Server) raftMessage(ctx context.Context, req *protocol.Request) *protocol.Response {
+	var handle func(context.Context, []byte) ([]byte, error)
+	if req.Key == metaGroup && s.meta != nil {
+		handle = s.meta.Handle
+	} else if g := s.shardGroup(req.Key); g != nil {
+		handle = g.Handle
+	}
+	if handle == nil {
+		return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: "no raft group " + req.Key}
+	}
+	reply, err := handle(ctx, req.Value)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return &protocol.Response{StatusCode: protocol.StatusOK, Value: reply}
+}
+
+// raftStatus reports the node's place in the metadata group for CmdInfo.
+func (s *Server) raftStatus() *protocol.RaftStatus {
+	if s.meta == nil {
+		return nil
+	}
+	st := s.meta.Status()
+	return &protocol.RaftStatus{Role: st.Role.String(), Term: st.Term, Leader: st.Leader, Applied: st.Applied}
+}
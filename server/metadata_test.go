@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+// startRaftCluster starts n voters of a metadata log and waits until
+// every ring holds them all.
+func startRaftCluster(t *testing.T, n int) []*Server {
+	t.Helper()
+	voters := make([]string, n)
+	for i := range voters {
+		voters[i] = freeAddr(t)
+	}
+	nodes := make([]*Server, n)
+	for i, addr := range voters {
+		nodes[i] = launchServer(t, addr, WithRaft(voters, ""))
+	}
+	for _, s := range nodes {
+		waitFor(t, "the voters to join "+s.Addr, func() bool { return len(s.ring.GetNodes()) == n })
+	}
+	return nodes
+}
+
+func TestRaftMembership(t *testing.T) {
+	nodes := startRaftCluster(t, 3)
+	ctx := context.Background()
+
+	// A learner joins through the log, and every ring takes it in.
+	learner := startServer(t, "", WithRaft(nodes[0].raftVoters, ""), WithWeight(2))
+	nodes = append(nodes, learner)
+	for _, s := range nodes {
+		waitFor(t, "the learner to join "+s.Addr, func() bool {
+			return slices.Contains(s.ring.GetNodes(), learner.Addr) && s.ring.Weight(learner.Addr) == 2
+		})
+	}
+
+	// A weight set on any node is applied everywhere.
+	if err := nodes[1].ConfigureWeight(ctx, learner.Addr, 5); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range nodes {
+		waitFor(t, "the new weight on "+s.Addr, func() bool { return s.ring.Weight(learner.Addr) == 5 })
+	}
+	if w := learner.weight.Load(); w != 5 {
+		t.Errorf("the learner advertises weight %d, want 5", w)
+	}
+
+	// A node without WithRaft cannot change another's weight.
+	if err := startServer(t, "").ConfigureWeight(ctx, learner.Addr, 3); err != errNoRaft {
+		t.Errorf("ConfigureWeight without Raft: %v, want %v", err, errNoRaft)
+	}
+}
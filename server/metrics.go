@@ -0,0 +1,145 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/discovery"
+	"github.com/BiChong-Jin/distributed-cache/metrics"
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- Metrics --------
+// Every node keeps Prometheus metrics (see the metrics package) and serves
+// them on /metrics once StartMetrics is called:
+//
+//	dcache_request_duration_seconds{command}   route() latency, every front-end
+//	dcache_requests_routed_total{route}        "local" or "proxied"
+//	dcache_forward_errors_total{peer}          forwardToNode failures
+//	dcache_cache_*_total{namespace}            hits, misses, sets, deletes,
+//	                                           evictions, expirations
+//	dcache_cache_items{namespace}, dcache_cache_bytes{namespace}
+//	dcache_cache_tombstones{namespace}         deleted keys still remembered
+//	dcache_ring_nodes                          nodes in the hash ring
+//	dcache_registry_nodes{state}               alive, suspect, dead
+//	dcache_hints_total{outcome}                stored, replayed, expired, dropped
+//	dcache_hints_pending{peer}                 hints waiting for their owner
+//	dcache_replica_repairs_total{kind}         read, pulled, pushed
+//
+// Cache, ring and registry values are read at scrape time.
+
+type serverMetrics struct {
+	registry      *metrics.Registry
+	latency       *metrics.Histogram
+	routed        *metrics.Counter
+	forwardErrors *metrics.Counter
+	hints         *metrics.Counter
+	repairs       *metrics.Counter
+}
+
+func newServerMetrics(s *Server) *serverMetrics {
+	reg := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry: reg,
+		latency: reg.Histogram("dcache_request_duration_seconds",
+			"Time taken to serve a request, by command.", metrics.LatencyBuckets, "command"),
+		routed: reg.Counter("dcache_requests_routed_total",
+			"Requests handled on this node (local) or sent to the owning node (proxied).", "route"),
+		forwardErrors: reg.Counter("dcache_forward_errors_total",
+			"Requests that could not be forwarded to the owning node.", "peer"),
+		hints: reg.Counter("dcache_hints_total",
+			"Writes kept for an unreachable owner, and what became of them.", "outcome"),
+		repairs: reg.Counter("dcache_replica_repairs_total",
+			"Stale replica copies fixed by read repair or anti-entropy.", "kind"),
+	}
+
+	counters := []struct {
+		name, help string
+		value      func(protocol.NamespaceStats) float64
+	}{
+		{"hits", "Lookups that found the key.", func(st protocol.NamespaceStats) float64 { return float64(st.Hits) }},
+		{"misses", "Lookups that did not find the key.", func(st protocol.NamespaceStats) float64 { return float64(st.Misses) }},
+		{"sets", "Writes to the cache.", func(st protocol.NamespaceStats) float64 { return float64(st.Sets) }},
+		{"deletes", "Keys deleted by clients.", func(st protocol.NamespaceStats) float64 { return float64(st.Deletes) }},
+		{"evictions", "Keys evicted to stay within the namespace quota.", func(st protocol.NamespaceStats) float64 { return float64(st.Evictions) }},
+		{"expirations", "Expired keys removed by the janitor.", func(st protocol.NamespaceStats) float64 { return float64(st.Expirations) }},
+	}
+	for _, c := range counters {
+		reg.Func("dcache_cache_"+c.name+"_total", c.help, metrics.KindCounter, []string{"namespace"}, func(emit func(float64, ...string)) {
+			for name, st := range s.stats("") {
+				emit(c.value(st), name)
+			}
+		})
+	}
+	reg.Func("dcache_cache_items", "Items stored, including expired ones not yet removed.", metrics.KindGauge, []string{"namespace"},
+		func(emit func(float64, ...string)) {
+			for name, st := range s.stats("") {
+				emit(float64(st.Items), name)
+			}
+		})
+	reg.Func("dcache_cache_bytes", "Size of the keys and values stored.", metrics.KindGauge, []string{"namespace"},
+		func(emit func(float64, ...string)) {
+			for name, st := range s.stats("") {
+				emit(float64(st.Bytes), name)
+			}
+		})
+	reg.Func("dcache_cache_tombstones", "Deleted keys remembered for replication.", metrics.KindGauge, []string{"namespace"},
+		func(emit func(float64, ...string)) {
+			for _, name := range s.cache.Namespaces() {
+				emit(float64(s.cache.Namespace(name).Tombstones()), name)
+			}
+		})
+
+	reg.Func("dcache_ring_nodes", "Nodes in this node's hash ring.", metrics.KindGauge, nil, func(emit func(float64, ...string)) {
+		emit(float64(len(s.ring.GetNodes())))
+	})
+	reg.Func("dcache_registry_nodes", "Nodes known to the registry, by health.", metrics.KindGauge, []string{"state"},
+		func(emit func(float64, ...string)) {
+			count := map[discovery.NodeStatus]int{}
+			for _, node := range s.registry.Nodes() {
+				count[node.CurrStatus]++
+			}
+			for _, state := range []discovery.NodeStatus{discovery.StatusAlive, discovery.StatusSuspect, discovery.StatusDead} {
+				emit(float64(count[state]), state.String())
+			}
+		})
+	reg.Func("dcache_hints_pending", "Hinted writes waiting for their owner.", metrics.KindGauge, []string{"peer"},
+		func(emit func(float64, ...string)) {
+			for peer, n := range s.hints.pending() {
+				emit(float64(n), peer)
+			}
+		})
+	return m
+}
+
+// observe records a served request. It is deferred with the start time:
+//
+//	defer s.metrics.observe(req.CommandType, time.Now())
+func (m *serverMetrics) observe(cmd protocol.CommandType, start time.Time) {
+	m.latency.Observe(time.Since(start).Seconds(), cmd.String())
+}
+
+// StartMetrics serves the node's metrics on /metrics for Prometheus to
+// scrape, over TLS if configured. It blocks like Start, and returns
+// net.ErrClosed if the server is stopped already.
+func (s *Server) StartMetrics(addr string) error {
+	listener, err := s.listen(addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", s.metrics.registry)
+	srv := &http.Server{Handler: mux, ErrorLog: s.errorLog()}
+	if !s.frontend(func() { s.metricsServer = srv }) {
+		listener.Close()
+		return net.ErrClosed
+	}
+	s.logger.Info("listening", "frontend", "metrics", "addr", listener.Addr().String())
+	if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- Namespaces --------
+// Every request runs in the keyspace named by Request.Namespace (see
+// cache/namespace.go), so tenants sharing the cluster get their own keys,
+// quotas and eviction. Keys are still placed on the ring by key alone, so
+// the same key in two namespaces lives on the same node.
+//
+// The TCP listener is the only front-end that carries a namespace; RESP,
+// memcached, HTTP and gRPC clients use the default one.
+//
+// Clients create namespaces by naming them, and each one adds a series to
+// several metrics, so a node holds at most WithMaxNamespaces of them
+// besides those given a quota. Requests for a new namespace past that fail.
+
+// DefaultMaxNamespaces is how many namespaces a node creates for clients
+// unless WithMaxNamespaces says otherwise.
+const DefaultMaxNamespaces = 1024
+
+// namespaceStats answers CmdStats with this node's statistics for ns, or
+// for every namespace user may use when ns is the default one.
+func (s *Server) namespaceStats(user, ns string) *protocol.Response {
+	names := []string{ns}
+	if ns == "" {
+		names = s.visibleNamespaces(user, s.cache.Namespaces())
+	}
+	data, err := json.Marshal(s.namespaceStatsOf(names))
+	if err != nil {
+		return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: err.Error()}
+	}
+	return &protocol.Response{StatusCode: protocol.StatusOK, Value: data}
+}
+
+// stats returns this node's statistics for ns, or for every namespace when
+// ns is the default one.
+func (s *Server) stats(ns string) map[string]protocol.NamespaceStats {
+	names := []string{ns}
+	if ns == "" {
+		names = s.cache.Namespaces()
+	}
+	return s.namespaceStatsOf(names)
+}
+
+// namespaceStatsOf returns this node's statistics for the named namespaces.
+func (s *Server) namespaceStatsOf(names []string) map[string]protocol.NamespaceStats {
+	stats := make(map[string]protocol.NamespaceStats, len(names))
+	for _, name := range names {
+		ns, err := s.cache.OpenNamespace(name)
+		if err != nil {
+			continue
+		}
+		st := ns.Stats()
+		stats[name] = protocol.NamespaceStats{
+			Items:       st.Items,
+			Bytes:       st.Bytes,
+			Hits:        st.Hits,
+			Misses:      st.Misses,
+			Sets:        st.Sets,
+			Deletes:     st.Deletes,
+			Evictions:   st.Evictions,
+			Expirations: st.Expirations,
+			MaxItems:    st.Quota.MaxItems,
+			MaxBytes:    st.Quota.MaxBytes,
+		}
+	}
+
+	return stats
+}
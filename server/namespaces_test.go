@@ -0,0 +1,28 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/BiChong-Jin/distributed-cache/cache"
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+func TestMaxNamespaces(t *testing.T) {
+	s := startServer(t, "", WithMaxNamespaces(2), WithQuotas(map[string]cache.Quota{"configured": {MaxItems: 10}}))
+	set := func(ns string) *protocol.Response {
+		return s.route(context.Background(), &protocol.Request{CommandType: protocol.CmdSet, Namespace: ns, Key: "k", Value: []byte("v")})
+	}
+
+	for _, ns := range []string{"a", "b", "a", "configured", ""} {
+		if res := set(ns); res.StatusCode != protocol.StatusOK {
+			t.Errorf("set in %q: %+v", ns, res)
+		}
+	}
+	if res := set("c"); res.StatusCode != protocol.StatusError {
+		t.Errorf("set in a namespace past the limit: got %+v, want an error", res)
+	}
+	if names := s.cache.Namespaces(); len(names) != 4 {
+		t.Errorf("expected 4 namespaces, got %v", names)
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/BiChong-Jin/distributed-cache/acl"
+	"github.com/BiChong-Jin/distributed-cache/cache"
+	"github.com/BiChong-Jin/distributed-cache/certs"
+)
+
+// -------- Server Options --------
+// Optional settings passed to NewServer, e.g.
+//
+//	server.NewServer(":7000", server.WithTLS(reloader), server.WithMutualTLS())
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithTLS serves every listener over TLS and dials peers over TLS, using
+// the certificates from r. If r has a CA, peers present their certificate
+// and client certificates are verified when given.
+func WithTLS(r *certs.Reloader) Option {
+	return func(s *Server) { s.tls = r }
+}
+
+// WithMutualTLS requires every client and peer to present a certificate
+// signed by the CA. It has no effect without WithTLS.
+func WithMutualTLS() Option {
+	return func(s *Server) { s.mutualTLS = true }
+}
+
+// WithACL requires clients to authenticate and limits what each user may
+// do (see auth.go).
+func WithACL(a *acl.ACL) Option {
+	return func(s *Server) { s.acl = a }
+}
+
+// WithQuotas limits the size of namespaces, "" being the default one and
+// cache.DefaultQuota applying to every namespace not listed.
+func WithQuotas(quotas map[string]cache.Quota) Option {
+	return func(s *Server) { s.quotas = quotas }
+}
+
+// WithMaxNamespaces caps how many namespaces clients may create on the
+// node, not counting those WithQuotas names (see namespaces.go). Zero
+// means no limit.
+func WithMaxNamespaces(n int) Option {
+	return func(s *Server) { s.maxNamespaces = n }
+}
+
+// WithLogger sets where the server, its cache and its registry log (see
+// logging.go). The default is slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Server) { s.logger = l }
+}
+
+// WithHintLimit sets how many writes the node keeps for owners it cannot
+// reach (see hints.go). Zero turns hinted handoff off.
+func WithHintLimit(n int) Option {
+	return func(s *Server) { s.hintLimit = n }
+}
+
+// WithReplicas keeps every key on n nodes instead of one (see
+// replication.go). The default is 1.
+func WithReplicas(n int) Option {
+	return func(s *Server) { s.replicas.Store(int64(max(n, 1))) }
+}
+
+// WithRingHash names the hash function the ring places nodes and keys
+// with, one of those consistent.Hash knows. The default is
+// consistent.DefaultHash. Every node must use the same one; with WithRaft
+// the cluster's metadata decides.
+func WithRingHash(name string) Option {
+	return func(s *Server) { s.ringHash = name }
+}
+
+// WithWeight gives the node n times the keys of a node of weight 1, e.g.
+// for a machine with n times the memory (see weight.go). The default is 1;
+// NewServer keeps it if n is not between 1 and consistent.MaxWeight.
+func WithWeight(n int) Option {
+	return func(s *Server) { s.weight.Store(int64(n)) }
+}
+
+// WithQuorum sets how many replicas must answer a read and take a write
+// before the client is answered. Zero means a majority of WithReplicas.
+func WithQuorum(read, write int) Option {
+	return func(s *Server) { s.readQuorum, s.writeQuorum = read, write }
+}
+
+// WithAntiEntropy sets how often the node compares its data with the
+// other replicas (see antientropy.go). Zero turns it off.
+func WithAntiEntropy(interval time.Duration) Option {
+	return func(s *Server) { s.antiEntropyInterval = interval }
+}
+
+// WithTombstoneGrace sets how long deleted keys are remembered so that
+// replicas which missed the delete cannot bring them back (see
+// replication.go). The default is cache.DefaultTombstoneGrace.
+func WithTombstoneGrace(d time.Duration) Option {
+	return func(s *Server) { s.tombstoneGrace = d }
+}
+
+// WithRaft keeps the ring membership, ring config and namespace settings
+// in a Raft log voted on by voters (see metadata.go). path is the file
+// this node keeps its Raft state in; "" keeps it in memory only.
+func WithRaft(voters []string, path string) Option {
+	return func(s *Server) { s.raftVoters, s.raftPath = voters, path }
+}
+
+// WithStrongNamespaces runs the namespaces in strong mode unless the
+// cluster's metadata says otherwise (see strong.go). It needs WithRaft.
+func WithStrongNamespaces(namespaces ...string) Option {
+	return func(s *Server) { s.strongNamespaces = append(s.strongNamespaces, namespaces...) }
+}
+
+// WithTracerProvider sets where the server's spans go. The default is the
+// global provider, see tracing.SetupOTLP.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(s *Server) { s.tracerProvider = tp }
+}
+
+// listen opens a TCP listener on addr, wrapped in TLS if configured.
+func (s *Server) listen(addr string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.tls != nil {
+		listener = tls.NewListener(listener, s.tls.ServerConfig(s.mutualTLS))
+	}
+	return listener, nil
+}
+
+// dial connects to another node, over TLS if configured.
+func (s *Server) dial(ctx context.Context, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: peerDialTimeout}
+	if s.tls != nil {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: s.tls.ClientConfig(addr)}
+		return tlsDialer.DialContext(ctx, "tcp", addr)
+	}
+	return dialer.DialContext(ctx, "tcp", addr)
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- Peer Connections --------
+// Forwarded requests reuse connections to other nodes instead of dialing
+// (and running the handshake) for every request. Each connection carries
+// one request at a time; idle ones wait in a small per-peer free list.
+//
+// A forwarded request is bounded by the context it came with: the peer is
+// told how long is left (Request.Timeout) and the connection is dropped if
+// the time runs out or the caller goes away mid-request.
+//
+// A request that fails on a connection taken from the free list is sent
+// again on a new one if it is idempotent, or if it never left this node
+// (protocol.ErrNotSent): the peer may have closed the idle connection.
+// Failures to connect wrap protocol.ErrNotSent as well, so callers can
+// tell a peer that never saw the request from one that may have run it.
+
+const (
+	peerMaxIdle     = 4
+	peerDialTimeout = 5 * time.Second
+)
+
+type peerPool struct {
+	dial  func(ctx context.Context, addr string) (net.Conn, error)
+	login func(ctx context.Context, conn *protocol.Conn) error
+
+	mu   sync.Mutex
+	idle map[string][]*protocol.Conn
+}
+
+func newPeerPool(dial func(ctx context.Context, addr string) (net.Conn, error), login func(ctx context.Context, conn *protocol.Conn) error) *peerPool {
+	return &peerPool{dial: dial, login: login, idle: make(map[string][]*protocol.Conn)}
+}
+
+// roundTrip sends req to addr over an idle or new connection.
+func (p *peerPool) roundTrip(ctx context.Context, addr string, req *protocol.Request) (*protocol.Response, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if req.Timeout = time.Until(deadline); req.Timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+	}
+
+	conn, reused, err := p.get(ctx, addr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", protocol.ErrNotSent, err)
+	}
+
+	if f := conn.Missing(req); f != "" {
+		p.put(addr, conn)
+		return nil, fmt.Errorf("node %s does not support %s", addr, f)
+	}
+
+	res, err := conn.RoundTripContext(ctx, req)
+	if err != nil && reused && ctx.Err() == nil && (errors.Is(err, protocol.ErrNotSent) || req.CommandType.Idempotent()) {
+		// The peer may have closed the idle connection (e.g. it restarted),
+		// so try once more on a fresh one.
+		conn.Close()
+		if conn, err = p.connect(ctx, addr); err != nil {
+			return nil, fmt.Errorf("%w: %w", protocol.ErrNotSent, err)
+		}
+		res, err = conn.RoundTripContext(ctx, req)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	p.put(addr, conn)
+	return res, nil
+}
+
+func (p *peerPool) get(ctx context.Context, addr string) (conn *protocol.Conn, reused bool, err error) {
+	p.mu.Lock()
+	if conns := p.idle[addr]; len(conns) > 0 {
+		conn = conns[len(conns)-1]
+		p.idle[addr] = conns[:len(conns)-1]
+		p.mu.Unlock()
+		return conn, true, nil
+	}
+	p.mu.Unlock()
+
+	conn, err = p.connect(ctx, addr)
+	return conn, false, err
+}
+
+// connect dials addr, runs the handshake and logs in.
+func (p *peerPool) connect(ctx context.Context, addr string) (*protocol.Conn, error) {
+	conn, err := protocol.DialContext(ctx, func(ctx context.Context) (net.Conn, error) {
+		return p.dial(ctx, addr)
+	}, protocol.DefaultHello())
+	if err != nil {
+		return nil, err
+	}
+	if err := p.login(ctx, conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (p *peerPool) put(addr string, conn *protocol.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.idle[addr]) >= peerMaxIdle {
+		conn.Close()
+		return
+	}
+	p.idle[addr] = append(p.idle[addr], conn)
+}
+
+// close closes every idle connection.
+func (p *peerPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for addr, conns := range p.idle {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		delete(p.idle, addr)
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// flakyPeer is a node that answers every request with StatusOK, but hangs
+// up on a request, after reading it, when told to.
+type flakyPeer struct {
+	l net.Listener
+
+	mu     sync.Mutex
+	hangUp bool
+	seen   map[protocol.CommandType]int
+}
+
+func startFlakyPeer(t *testing.T) *flakyPeer {
+	p := &flakyPeer{l: listenLocal(t), seen: make(map[protocol.CommandType]int)}
+	t.Cleanup(func() { p.l.Close() })
+	go func() {
+		for {
+			nc, err := p.l.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(nc)
+		}
+	}()
+	return p
+}
+
+func (p *flakyPeer) serve(nc net.Conn) {
+	defer nc.Close()
+	conn, _, err := protocol.Accept(nc, protocol.DefaultHello())
+	if err != nil {
+		return
+	}
+	for {
+		data, err := protocol.ReadFrame(conn)
+		if err != nil {
+			return
+		}
+		req, err := protocol.DecodeRequest(data)
+		if err != nil {
+			return
+		}
+
+		p.mu.Lock()
+		p.seen[req.CommandType]++
+		hangUp := p.hangUp
+		p.hangUp = false
+		p.mu.Unlock()
+		if hangUp {
+			return
+		}
+
+		data, _ = (&protocol.Response{StatusCode: protocol.StatusOK}).EncodeWith(conn.Codec)
+		if protocol.WriteFrame(conn, data) != nil {
+			return
+		}
+	}
+}
+
+// send primes the pool with an idle connection, then sends cmd on it while
+// the peer hangs up after reading the request.
+func (p *flakyPeer) send(pool *peerPool, cmd protocol.CommandType) (int, error) {
+	ctx := context.Background()
+	addr := p.l.Addr().String()
+	if _, err := pool.roundTrip(ctx, addr, &protocol.Request{CommandType: protocol.CmdPing}); err != nil {
+		return 0, err
+	}
+
+	p.mu.Lock()
+	p.hangUp = true
+	p.mu.Unlock()
+	_, err := pool.roundTrip(ctx, addr, &protocol.Request{CommandType: cmd, Key: "k"})
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.seen[cmd], err
+}
+
+func testPeerPool(t *testing.T) *peerPool {
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	pool := newPeerPool(dial, func(context.Context, *protocol.Conn) error { return nil })
+	t.Cleanup(pool.close)
+	return pool
+}
+
+func TestPeerPoolResend(t *testing.T) {
+	p := startFlakyPeer(t)
+	pool := testPeerPool(t)
+
+	// A read may run twice: it is sent again on a new connection.
+	if n, err := p.send(pool, protocol.CmdGet); err != nil || n != 2 {
+		t.Errorf("get: sent %d times (%v), want 2 and no error", n, err)
+	}
+
+	// An increment may have been applied, so it is not.
+	if n, err := p.send(pool, protocol.CmdIncr); err == nil || n != 1 {
+		t.Errorf("incr: sent %d times (%v), want 1 and an error", n, err)
+	}
+}
+
+func TestPeerPoolNotSent(t *testing.T) {
+	pool := testPeerPool(t)
+
+	// A peer that cannot be reached never saw the request.
+	_, err := pool.roundTrip(context.Background(), freeAddr(t), &protocol.Request{CommandType: protocol.CmdIncr, Key: "k"})
+	if !errors.Is(err, protocol.ErrNotSent) {
+		t.Errorf("dial failure: expected ErrNotSent, got %v", err)
+	}
+
+	// A peer that hangs up after reading it may have run it.
+	p := startFlakyPeer(t)
+	if _, err := p.send(pool, protocol.CmdIncr); err == nil || errors.Is(err, protocol.ErrNotSent) {
+		t.Errorf("hang-up after the request: expected an error without ErrNotSent, got %v", err)
+	}
+}
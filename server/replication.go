@@ -0,0 +1,340 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/BiChong-Jin/distributed-cache/cache"
+	"github.com/BiChong-Jin/distributed-cache/hlc"
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+	"github.com/BiChong-Jin/distributed-cache/tracing"
+)
+
+// -------- Replication --------
+// With WithReplicas(n), a key is kept on the first n nodes of its
+// preference list (see consistent.PreferenceList) instead of its owner
+// alone. Get, GetMeta, Set and Delete are coordinated by one of those
+// replicas: the node that received the request if it is one, otherwise
+// the first replica that can be reached.
+//
+//	writes  the coordinator stamps the write with its hybrid logical clock
+//	        (see cache/replica.go), sends it to every replica at once and
+//	        answers once writeQuorum of them stored it. A replica keeps
+//	        the newer of two writes, so concurrent writes settle on the
+//	        same value everywhere. A delete is a write too: it leaves a
+//	        tombstone with its stamp.
+//	reads   the coordinator asks every replica and answers with the newest
+//	        copy once readQuorum of them replied; a newest copy that is a
+//	        tombstone reads as not found. Replicas that sent an older copy,
+//	        or none, are then sent the newest one (read repair), including
+//	        those that reply after the client got its answer.
+//
+// With readQuorum + writeQuorum > n, a read always hears from a replica
+// that took the last acknowledged write. Both default to a majority.
+// GetMeta answers with the newest copy's metadata; its Version is that
+// replica's own, so CAS, which runs on the owner, may see another one.
+//
+// Collections are kept by their key's owner alone (see below). A replica
+// holding one answers reads and replica writes of its key with
+// WrongType, which the coordinator passes on, and a read no replica has a
+// copy for waits for the owner too, so that it cannot miss one.
+//
+// A write that misses its quorum is reported as failed, though the
+// replicas that took it keep it. Replica writes to unreachable nodes are
+// kept as hints (hints.go).
+//
+// Other commands run on the key's owner alone, as without replication.
+// The strings they write (Add, Incr, Expire and the like) reach the other
+// replicas through read repair and anti-entropy (antientropy.go); hashes,
+// lists, sets and sorted sets are not replicated. Tombstones are kept for
+// the cache's tombstone grace period: a replica that missed a delete for
+// longer than that can bring the key back.
+
+// replicated lists the commands coordinated across replicas.
+var replicated = map[protocol.CommandType]bool{
+	protocol.CmdGet:     true,
+	protocol.CmdGetMeta: true,
+	protocol.CmdSet:     true,
+	protocol.CmdDelete:  true,
+}
+
+// quorums returns how many replies reads and writes wait for among n
+// replicas.
+func (s *Server) quorums(n int) (read, write int) {
+	majority := int(s.replicas.Load())/2 + 1
+	read, write = s.readQuorum, s.writeQuorum
+	if read <= 0 {
+		read = majority
+	}
+	if write <= 0 {
+		write = majority
+	}
+	// A ring smaller than the replica count holds fewer copies.
+	return min(read, n), min(write, n)
+}
+
+// routeReplicated coordinates req here if this node is one of the key's
+// replicas, or forwards it to the first replica that answers.
+func (s *Server) routeReplicated(ctx context.Context, req *protocol.Request) *protocol.Response {
+	replicas := s.ring.PreferenceList(req.Key, int(s.replicas.Load()))
+	if len(replicas) == 0 {
+		return errorResponse(errNoRing)
+	}
+	s.logger.Debug("routing request", "cmd", req.CommandType, "key", req.Key, "replicas", replicas)
+	if slices.Contains(replicas, s.Addr) {
+		s.metrics.routed.Inc("local")
+		if req.CommandType == protocol.CmdGet || req.CommandType == protocol.CmdGetMeta {
+			return s.quorumRead(ctx, replicas, req)
+		}
+		return s.quorumWrite(ctx, replicas, req)
+	}
+
+	s.metrics.routed.Inc("proxied")
+	var err error
+	for _, addr := range replicas {
+		var res *protocol.Response
+		if res, err = s.forward(ctx, addr, req); err == nil {
+			return res
+		}
+		s.metrics.forwardErrors.Inc(addr)
+		if !unreachable(err) || ctx.Err() != nil {
+			break
+		}
+		s.logger.Warn("replica unreachable, trying the next", "peer", addr, "key", req.Key, "err", err)
+	}
+	s.logger.Warn("forward failed", "cmd", req.CommandType, "key", req.Key, "err", err)
+	return unavailable(err.Error())
+}
+
+// quorumWrite sends a stamped Set or Delete to every replica.
+func (s *Server) quorumWrite(ctx context.Context, replicas []string, req *protocol.Request) *protocol.Response {
+	write := replicaWrite(req.Namespace, req.Key, req.Value, req.TTL, req.Flags, s.cache.NextStamp(), req.CommandType == protocol.CmdDelete)
+
+	_, quorum := s.quorums(len(replicas))
+	replies := s.fanOut(ctx, replicas, write)
+	acks := 0
+	existed := false
+	for range replicas {
+		select {
+		case r := <-replies:
+			if r.err == nil && r.res.StatusCode == protocol.StatusWrongType {
+				return r.res
+			}
+			if r.err == nil && r.res.StatusCode == protocol.StatusOK {
+				acks++
+				existed = existed || r.res.Int == 1
+			}
+		case <-ctx.Done():
+			return errorResponse(ctx.Err())
+		}
+		if acks >= quorum {
+			res := &protocol.Response{StatusCode: protocol.StatusOK}
+			if req.CommandType == protocol.CmdDelete {
+				res.Int = boolToInt(existed)
+			}
+			return res
+		}
+	}
+	return unavailable(fmt.Sprintf("write quorum not reached: %d of %d replicas, %d needed", acks, len(replicas), quorum))
+}
+
+// quorumRead asks every replica for its copy and answers with the newest
+// one once a quorum has replied. Read repair carries on in the background.
+func (s *Server) quorumRead(ctx context.Context, replicas []string, req *protocol.Request) *protocol.Response {
+	read := &protocol.Request{CommandType: protocol.CmdReplicaGet, Namespace: req.Namespace, Key: req.Key}
+
+	quorum, _ := s.quorums(len(replicas))
+	replies := s.fanOut(ctx, replicas, read)
+	var got []replicaReply
+	answered := 0
+	heardOwner := false
+	for len(got) < len(replicas) && (answered < quorum || (!heardOwner && newest(got) == nil)) {
+		select {
+		case r := <-replies:
+			got = append(got, r)
+			heardOwner = heardOwner || r.addr == replicas[0]
+			if r.err == nil && (r.res.StatusCode == protocol.StatusOK || r.res.StatusCode == protocol.StatusNotFound || r.res.StatusCode == protocol.StatusWrongType) {
+				answered++
+			}
+		case <-ctx.Done():
+			return errorResponse(ctx.Err())
+		}
+	}
+	go s.readRepair(req, got, replies, len(replicas)-len(got))
+
+	if answered < quorum {
+		return unavailable(fmt.Sprintf("read quorum not reached: %d of %d replicas, %d needed", answered, len(replicas), quorum))
+	}
+	for _, r := range got {
+		if r.err == nil && r.res.StatusCode == protocol.StatusWrongType {
+			res := &protocol.Response{StatusCode: protocol.StatusWrongType, ErrorMessage: r.res.ErrorMessage}
+			if req.CommandType == protocol.CmdGetMeta {
+				res.Meta = r.res.Meta
+			}
+			return res
+		}
+	}
+	best := newest(got)
+	if best == nil {
+		return &protocol.Response{StatusCode: protocol.StatusNotFound}
+	}
+	// Whatever the client does next comes after the write it saw.
+	s.cache.Observe(best.Meta.Stamp)
+	if best.StatusCode == protocol.StatusNotFound {
+		return &protocol.Response{StatusCode: protocol.StatusNotFound}
+	}
+	res := &protocol.Response{StatusCode: protocol.StatusOK, Value: best.Value}
+	if req.CommandType == protocol.CmdGetMeta {
+		res.Meta = best.Meta
+	}
+	return res
+}
+
+// readRepair waits for the replies still to come, then sends the newest
+// copy to every replica that replied with an older one or none.
+func (s *Server) readRepair(req *protocol.Request, got []replicaReply, rest <-chan replicaReply, pending int) {
+	for range pending {
+		got = append(got, <-rest)
+	}
+	best := newest(got)
+	if best == nil {
+		return
+	}
+
+	for _, r := range got {
+		if !stale(r, best) {
+			continue
+		}
+		s.logger.Debug("repairing stale replica", "peer", r.addr, "namespace", req.Namespace, "key", req.Key)
+		s.metrics.repairs.Inc("read")
+		ctx, cancel := context.WithTimeout(context.Background(), peerDialTimeout)
+		s.sendReplica(ctx, r.addr, replicaWrite(req.Namespace, req.Key, best.Value, best.Meta.TTL, best.Meta.Flags, best.Meta.Stamp,
+			best.StatusCode == protocol.StatusNotFound))
+		cancel()
+	}
+}
+
+// replicaWrite returns the replica request writing value under key with
+// stamp, or deleting key if deleted.
+func replicaWrite(namespace, key string, value []byte, ttl time.Duration, flags uint32, stamp hlc.Timestamp, deleted bool) *protocol.Request {
+	if deleted {
+		return &protocol.Request{CommandType: protocol.CmdReplicaDelete, Namespace: namespace, Key: key, Stamp: stamp}
+	}
+	return &protocol.Request{
+		CommandType: protocol.CmdReplicaSet,
+		Namespace:   namespace,
+		Key:         key,
+		Value:       value,
+		TTL:         ttl,
+		Flags:       flags,
+		Stamp:       stamp,
+	}
+}
+
+// newest returns the newest copy among the replies, which is either a
+// value (StatusOK) or a tombstone (StatusNotFound with a stamp), or nil if
+// no replica has either.
+func newest(replies []replicaReply) *protocol.Response {
+	var best *protocol.Response
+	for _, r := range replies {
+		if r.err != nil || !hasCopy(r.res) {
+			continue
+		}
+		if best == nil || newerCopy(r.res, best) {
+			best = r.res
+		}
+	}
+	return best
+}
+
+// stale reports whether a replica replied with an older copy than best,
+// or with none.
+func stale(r replicaReply, best *protocol.Response) bool {
+	if r.err != nil {
+		return false
+	}
+	switch r.res.StatusCode {
+	case protocol.StatusOK, protocol.StatusNotFound:
+		return !hasCopy(r.res) || newerCopy(best, r.res)
+	}
+	return false
+}
+
+// hasCopy reports whether a CmdReplicaGet reply holds a value or a
+// tombstone.
+func hasCopy(res *protocol.Response) bool {
+	return res.StatusCode == protocol.StatusOK || (res.StatusCode == protocol.StatusNotFound && res.Meta.Stamp != 0)
+}
+
+// newerCopy reports whether copy a wins over copy b. As in the cache, a
+// tombstone wins over a value with the same stamp.
+func newerCopy(a, b *protocol.Response) bool {
+	aDeleted, bDeleted := a.StatusCode == protocol.StatusNotFound, b.StatusCode == protocol.StatusNotFound
+	if aDeleted || bDeleted {
+		if a.Meta.Stamp != b.Meta.Stamp {
+			return a.Meta.Stamp > b.Meta.Stamp
+		}
+		return aDeleted && !bDeleted
+	}
+	return cache.Newer(a.Meta.Stamp, a.Value, b.Meta.Stamp, b.Value)
+}
+
+// -------- Fan-out --------
+
+// replicaReply is one replica's answer to a fanned-out request.
+type replicaReply struct {
+	addr string
+	res  *protocol.Response
+	err  error
+}
+
+// fanOut sends req to every replica at once, running it here for this
+// node, and delivers the replies on the returned channel as they come.
+// The requests outlive ctx's cancellation, so replicas the caller stops
+// waiting for still get their write; each is bounded by peerDialTimeout
+// instead.
+func (s *Server) fanOut(ctx context.Context, replicas []string, req *protocol.Request) <-chan replicaReply {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), peerDialTimeout)
+	replies := make(chan replicaReply, len(replicas))
+
+	var wg sync.WaitGroup
+	for _, addr := range replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := s.sendReplica(ctx, addr, req)
+			replies <- replicaReply{addr: addr, res: res, err: err}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+	}()
+	return replies
+}
+
+// sendReplica runs an internal request on addr, which may be this node.
+// A replica write addr cannot take is kept as a hint.
+func (s *Server) sendReplica(ctx context.Context, addr string, req *protocol.Request) (*protocol.Response, error) {
+	if addr == s.Addr {
+		return s.handleLocally(ctx, req), nil
+	}
+
+	r := *req
+	ctx, span := tracing.Start(ctx, s.tracer, "replica", trace.SpanKindClient, &r, attribute.String("cache.peer", addr))
+	tracing.Inject(ctx, &r)
+	res, err := s.peers.roundTrip(ctx, addr, &r)
+	tracing.End(span, res, err)
+	if err != nil {
+		s.metrics.forwardErrors.Inc(addr)
+		s.hintWrite(ctx, addr, &r, err)
+		s.logger.Debug("replica request failed", "peer", addr, "cmd", req.CommandType, "err", err)
+	}
+	return res, err
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/BiChong-Jin/distributed-cache/cache"
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+func TestReadRepair(t *testing.T) {
+	nodes := startCluster(t, 3, WithReplicas(3))
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	ctx := context.Background()
+
+	if res := a.route(ctx, &protocol.Request{CommandType: protocol.CmdSet, Key: "k", Value: []byte("v1")}); res.StatusCode != protocol.StatusOK {
+		t.Fatalf("set: %+v", res)
+	}
+	// A newer write reaches a and b only, leaving c with v1.
+	newer := replicaWrite("", "k", []byte("v2"), 0, 0, a.cache.NextStamp(), false)
+	for _, s := range []*Server{a, b} {
+		if res := s.execute(newer); res.Int != 1 {
+			t.Fatalf("%s did not take the newer write: %+v", s.Addr, res)
+		}
+	}
+	copyOn := func(s *Server) string {
+		return string(s.execute(&protocol.Request{CommandType: protocol.CmdReplicaGet, Key: "k"}).Value)
+	}
+	waitFor(t, "v1 to reach c", func() bool { return copyOn(c) == "v1" })
+
+	// A read through c answers with the newest copy and repairs c's.
+	res := c.route(ctx, &protocol.Request{CommandType: protocol.CmdGet, Key: "k"})
+	if res.StatusCode != protocol.StatusOK || string(res.Value) != "v2" {
+		t.Fatalf("get: %+v, want v2", res)
+	}
+	waitFor(t, "c to be repaired", func() bool { return copyOn(c) == "v2" })
+}
+
+func TestReplicatedWrongType(t *testing.T) {
+	nodes := startCluster(t, 3, WithReplicas(3))
+	ctx := context.Background()
+	if res := nodes[0].route(ctx, &protocol.Request{CommandType: protocol.CmdLPush, Key: "list", Values: [][]byte{[]byte("x")}}); res.StatusCode != protocol.StatusOK {
+		t.Fatalf("lpush: %+v", res)
+	}
+	var owner *Server
+	for _, s := range nodes {
+		if s.Addr == nodes[0].ring.GetNode("list") {
+			owner = s
+		}
+	}
+
+	// Only the owner holds the list, whichever node coordinates the read.
+	for _, s := range nodes {
+		if res := s.route(ctx, &protocol.Request{CommandType: protocol.CmdGet, Key: "list"}); res.StatusCode != protocol.StatusWrongType {
+			t.Errorf("GET through %s: %+v, want WRONGTYPE", s.Addr, res)
+		}
+		res := s.route(ctx, &protocol.Request{CommandType: protocol.CmdGetMeta, Key: "list"})
+		if res.StatusCode != protocol.StatusWrongType || res.Meta.Size != 1 {
+			t.Errorf("GETMETA through %s: %+v, want WRONGTYPE with the list's metadata", s.Addr, res)
+		}
+	}
+
+	// A replicated SET leaves the owner's list alone.
+	for _, s := range nodes {
+		s.route(ctx, &protocol.Request{CommandType: protocol.CmdSet, Key: "list", Value: []byte("v")})
+	}
+	if kind, _ := owner.cache.Type("list"); kind != cache.KindList {
+		t.Errorf("the owner's list became kind %d", kind)
+	}
+}
@@ -0,0 +1,718 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/acl"
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- RESP Front-end --------
+// An optional second listener that speaks the Redis serialization protocol
+// (RESP2, and RESP3 after HELLO 3), so redis-cli and Redis client libraries
+// can talk to the cluster. Every command is translated into a
+// protocol.Request and goes through the same route() as the TCP listener,
+// so keys are still owned by the node the HashRing picks.
+
+const (
+	respMaxBulkLen  = protocol.MaxFrameSize // Larger values could not be forwarded to their owner
+	respMaxArrayLen = 1 << 20
+	// Clients announce sizes before sending the data: buffers only start
+	// this large and grow as the data arrives.
+	respPrealloc     = 64 << 10 // Bytes of a bulk string
+	respPreallocArgs = 1024     // Arguments of a command
+)
+
+var errRESPProtocol = errors.New("protocol error")
+
+// respConn is the per-connection state of a RESP client.
+type respConn struct {
+	r     *bufio.Reader
+	w     *bufio.Writer
+	proto int    // 2 or 3, switched with HELLO
+	user  string // Set by AUTH, see auth.go
+}
+
+// StartRESP listens on addr for RESP clients. Like Start, it blocks until
+// the listener is closed.
+func (s *Server) StartRESP(addr string) error {
+	listener, err := s.listen(addr)
+	if err != nil {
+		return err
+	}
+	return s.serveRESP(listener)
+}
+
+// serveRESP accepts RESP clients on listener until it is closed.
+func (s *Server) serveRESP(listener net.Listener) error {
+	if !s.frontend(func() { s.respListener = listener }) {
+		listener.Close()
+		return net.ErrClosed
+	}
+	s.logger.Info("listening", "frontend", "resp", "addr", listener.Addr().String())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.handleRESPConnection(conn)
+	}
+}
+
+// handleRESPConnection serves commands until the client disconnects or sends QUIT.
+// Replies are buffered and flushed once every pipelined command has been read.
+func (s *Server) handleRESPConnection(conn net.Conn) {
+	log := s.openConnection("resp", conn)
+	defer s.closeConnection(log, conn)
+
+	rc := &respConn{r: bufio.NewReader(conn), w: bufio.NewWriter(conn), proto: 2}
+	for {
+		args, err := rc.readCommand()
+		if err != nil {
+			if errors.Is(err, errRESPProtocol) {
+				log.Warn("malformed command", "err", err)
+				rc.writeError("ERR Protocol error")
+				rc.w.Flush()
+			} else {
+				logIOError(log, "read failed", err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := s.execRESP(rc, args)
+		if quit || rc.r.Buffered() == 0 {
+			if err := rc.w.Flush(); err != nil {
+				logIOError(log, "write failed", err)
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// execRESP runs one command and writes its reply. It returns true on QUIT.
+func (s *Server) execRESP(rc *respConn, args [][]byte) bool {
+	name := strings.ToUpper(string(args[0]))
+	args = args[1:]
+
+	switch name {
+	case "PING":
+		if len(args) > 0 {
+			rc.writeBulk(args[0])
+		} else {
+			rc.writeSimple("PONG")
+		}
+
+	case "ECHO":
+		if !rc.arity(name, args, 1, 1) {
+			return false
+		}
+		rc.writeBulk(args[0])
+
+	case "QUIT":
+		rc.writeSimple("OK")
+		return true
+
+	case "HELLO":
+		s.respHello(rc, args)
+
+	case "AUTH":
+		if !rc.arity(name, args, 1, 2) {
+			return false
+		}
+		user, password := acl.DefaultUser, args[0]
+		if len(args) == 2 {
+			user, password = string(args[0]), args[1]
+		}
+		if s.respAuth(rc, user, password) {
+			rc.writeSimple("OK")
+		}
+
+	case "SELECT":
+		// There is a single keyspace, which Redis clients know as DB 0.
+		if !rc.arity(name, args, 1, 1) {
+			return false
+		}
+		if string(args[0]) != "0" {
+			rc.writeError("ERR DB index is out of range")
+			return false
+		}
+		rc.writeSimple("OK")
+
+	case "CLIENT":
+		// Accept CLIENT SETNAME / SETINFO sent by client libraries on connect.
+		rc.writeSimple("OK")
+
+	case "COMMAND":
+		rc.writeArrayLen(0)
+
+	case "GET":
+		if !rc.arity(name, args, 1, 1) {
+			return false
+		}
+		res := s.routeAs(context.Background(), rc.user, &protocol.Request{CommandType: protocol.CmdGet, Key: string(args[0])})
+		switch res.StatusCode {
+		case protocol.StatusOK:
+			rc.writeBulk(res.Value)
+		case protocol.StatusNotFound:
+			rc.writeNull()
+		default:
+			rc.writeResponseError(res)
+		}
+
+	case "SET":
+		s.respSet(rc, args)
+
+	case "DEL":
+		if !rc.arity(name, args, 1, -1) {
+			return false
+		}
+		var removed int64
+		for _, key := range args {
+			res := s.routeAs(context.Background(), rc.user, &protocol.Request{CommandType: protocol.CmdDelete, Key: string(key)})
+			if res.StatusCode != protocol.StatusOK {
+				rc.writeResponseError(res)
+				return false
+			}
+			removed += res.Int
+		}
+		rc.writeInt(removed)
+
+	case "INCR", "DECR", "INCRBY", "DECRBY":
+		s.respIncr(rc, name, args)
+
+	case "EXPIRE", "PEXPIRE":
+		s.respExpire(rc, name, args)
+
+	case "TTL", "PTTL":
+		if !rc.arity(name, args, 1, 1) {
+			return false
+		}
+		res := s.routeAs(context.Background(), rc.user, &protocol.Request{CommandType: protocol.CmdGetMeta, Key: string(args[0])})
+		switch {
+		case res.StatusCode == protocol.StatusNotFound:
+			rc.writeInt(-2)
+		case res.StatusCode != protocol.StatusOK && res.StatusCode != protocol.StatusWrongType:
+			// Collections have a TTL too, though GETMETA has no value for them.
+			rc.writeResponseError(res)
+		case res.Meta.TTL == 0:
+			rc.writeInt(-1)
+		case name == "PTTL":
+			rc.writeInt(res.Meta.TTL.Milliseconds())
+		default:
+			rc.writeInt(int64((res.Meta.TTL + time.Second/2) / time.Second))
+		}
+
+	case "KEYS":
+		// Like CmdKeys, KEYS and SCAN only list the keys held by this node.
+		if !rc.arity(name, args, 1, 1) {
+			return false
+		}
+		if res := s.authorize(&protocol.Request{CommandType: protocol.CmdKeys, User: rc.user}); res != nil {
+			rc.writeResponseError(res)
+			return false
+		}
+		keys := []string{}
+		for _, k := range s.visibleKeys(rc.user, s.cache.Keys()) {
+			if globMatch(string(args[0]), k) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		rc.writeStrings(keys)
+
+	case "SCAN":
+		s.respScan(rc, args)
+
+	default:
+		rc.writeError(fmt.Sprintf("ERR unknown command '%s'", name))
+	}
+	return false
+}
+
+// respHello handles HELLO [protover [AUTH user pass] [SETNAME name]].
+func (s *Server) respHello(rc *respConn, args [][]byte) {
+	proto := rc.proto
+	if len(args) > 0 {
+		v, err := strconv.Atoi(string(args[0]))
+		if err != nil || (v != 2 && v != 3) {
+			rc.writeError("NOPROTO unsupported protocol version")
+			return
+		}
+		proto = v
+	}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "AUTH":
+			if i+2 >= len(args) {
+				rc.writeError("ERR syntax error")
+				return
+			}
+			if !s.respAuth(rc, string(args[i+1]), args[i+2]) {
+				return
+			}
+			i += 2
+		case "SETNAME":
+			i++
+		}
+	}
+	rc.proto = proto
+
+	rc.writeMapLen(4)
+	rc.writeBulk([]byte("server"))
+	rc.writeBulk([]byte("distributed-cache"))
+	rc.writeBulk([]byte("proto"))
+	rc.writeInt(int64(rc.proto))
+	rc.writeBulk([]byte("mode"))
+	rc.writeBulk([]byte("cluster"))
+	rc.writeBulk([]byte("role"))
+	rc.writeBulk([]byte("master"))
+}
+
+// respAuth authenticates the connection, writing an error reply and
+// returning false if the credentials are wrong. Redis clients send a lone
+// password as user "default", so for that user the password may also be a
+// token.
+func (s *Server) respAuth(rc *respConn, user string, password []byte) bool {
+	res, name, _ := s.authenticate(&protocol.Request{CommandType: protocol.CmdAuth, Key: user, Value: password})
+	if res.StatusCode == protocol.StatusDenied && user == acl.DefaultUser {
+		res, name, _ = s.authenticate(&protocol.Request{CommandType: protocol.CmdAuth, Value: password})
+	}
+	if res.StatusCode != protocol.StatusOK {
+		rc.writeResponseError(res)
+		return false
+	}
+	rc.user = name
+	return true
+}
+
+// respSet handles SET key value [EX seconds | PX milliseconds] [NX | XX].
+func (s *Server) respSet(rc *respConn, args [][]byte) {
+	if !rc.arity("SET", args, 2, -1) {
+		return
+	}
+
+	req := &protocol.Request{CommandType: protocol.CmdSet, Key: string(args[0]), Value: args[1]}
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			if req.CommandType == protocol.CmdReplace {
+				rc.writeError("ERR syntax error")
+				return
+			}
+			req.CommandType = protocol.CmdAdd
+		case "XX":
+			if req.CommandType == protocol.CmdAdd {
+				rc.writeError("ERR syntax error")
+				return
+			}
+			req.CommandType = protocol.CmdReplace
+		case "EX", "PX":
+			unit := time.Second
+			if strings.EqualFold(string(args[i]), "PX") {
+				unit = time.Millisecond
+			}
+			if i+1 >= len(args) || req.TTL != 0 {
+				rc.writeError("ERR syntax error")
+				return
+			}
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil || n <= 0 {
+				rc.writeError("ERR invalid expire time in 'set' command")
+				return
+			}
+			req.TTL = time.Duration(n) * unit
+			i++
+		default:
+			rc.writeError("ERR syntax error")
+			return
+		}
+	}
+
+	res := s.routeAs(context.Background(), rc.user, req)
+	switch res.StatusCode {
+	case protocol.StatusOK:
+		rc.writeSimple("OK")
+	case protocol.StatusNotStored:
+		rc.writeNull()
+	default:
+		rc.writeResponseError(res)
+	}
+}
+
+// respIncr handles INCR, DECR, INCRBY and DECRBY.
+func (s *Server) respIncr(rc *respConn, name string, args [][]byte) {
+	var delta int64 = 1
+	if name == "INCRBY" || name == "DECRBY" {
+		if !rc.arity(name, args, 2, 2) {
+			return
+		}
+		n, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			rc.writeError("ERR value is not an integer or out of range")
+			return
+		}
+		delta = n
+	} else if !rc.arity(name, args, 1, 1) {
+		return
+	}
+	if name == "DECR" || name == "DECRBY" {
+		delta = -delta
+	}
+
+	res := s.routeAs(context.Background(), rc.user, &protocol.Request{CommandType: protocol.CmdIncr, Key: string(args[0]), Int: delta})
+	if res.StatusCode != protocol.StatusOK {
+		rc.writeResponseError(res)
+		return
+	}
+	rc.writeInt(res.Int)
+}
+
+// respExpire handles EXPIRE key seconds and PEXPIRE key milliseconds.
+// A non-positive TTL deletes the key, as in Redis.
+func (s *Server) respExpire(rc *respConn, name string, args [][]byte) {
+	if !rc.arity(name, args, 2, 2) {
+		return
+	}
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		rc.writeError("ERR value is not an integer or out of range")
+		return
+	}
+
+	unit := time.Second
+	if name == "PEXPIRE" {
+		unit = time.Millisecond
+	}
+
+	req := &protocol.Request{CommandType: protocol.CmdExpire, Key: string(args[0]), TTL: time.Duration(n) * unit}
+	if n <= 0 {
+		req = &protocol.Request{CommandType: protocol.CmdDelete, Key: string(args[0])}
+	}
+
+	res := s.routeAs(context.Background(), rc.user, req)
+	switch res.StatusCode {
+	case protocol.StatusOK:
+		if req.CommandType == protocol.CmdDelete {
+			rc.writeInt(res.Int)
+		} else {
+			rc.writeInt(1)
+		}
+	case protocol.StatusNotFound:
+		rc.writeInt(0)
+	default:
+		rc.writeResponseError(res)
+	}
+}
+
+// respScan handles SCAN cursor [MATCH pattern] [COUNT count].
+// The cursor is an offset into this node's sorted key list.
+func (s *Server) respScan(rc *respConn, args [][]byte) {
+	if !rc.arity("SCAN", args, 1, -1) {
+		return
+	}
+	cursor, err := strconv.Atoi(string(args[0]))
+	if err != nil || cursor < 0 {
+		rc.writeError("ERR invalid cursor")
+		return
+	}
+
+	pattern, count := "*", 10
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			rc.writeError("ERR syntax error")
+			return
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = string(args[i+1])
+		case "COUNT":
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil || count < 1 {
+				rc.writeError("ERR syntax error")
+				return
+			}
+		default:
+			rc.writeError("ERR syntax error")
+			return
+		}
+	}
+
+	if res := s.authorize(&protocol.Request{CommandType: protocol.CmdKeys, User: rc.user}); res != nil {
+		rc.writeResponseError(res)
+		return
+	}
+	keys := s.visibleKeys(rc.user, s.cache.Keys())
+	sort.Strings(keys)
+
+	matched := []string{}
+	next := cursor
+	for ; next < len(keys) && next < cursor+count; next++ {
+		if globMatch(pattern, keys[next]) {
+			matched = append(matched, keys[next])
+		}
+	}
+	if next >= len(keys) {
+		next = 0
+	}
+
+	rc.writeArrayLen(2)
+	rc.writeBulk([]byte(strconv.Itoa(next)))
+	rc.writeStrings(matched)
+}
+
+// -------- RESP Encoding --------
+
+// readCommand reads either a RESP array of bulk strings or an inline
+// command (space separated words, as typed into telnet).
+func (rc *respConn) readCommand() ([][]byte, error) {
+	line, err := rc.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+
+	if line[0] != '*' {
+		var args [][]byte
+		for _, f := range strings.Fields(string(line)) {
+			args = append(args, []byte(f))
+		}
+		return args, nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > respMaxArrayLen {
+		return nil, errRESPProtocol
+	}
+
+	args := make([][]byte, 0, min(max(n, 0), respPreallocArgs))
+	for i := 0; i < n; i++ {
+		line, err := rc.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errRESPProtocol
+		}
+
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > respMaxBulkLen {
+			return nil, errRESPProtocol
+		}
+
+		arg, err := rc.readBulk(size)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// readBulk reads a bulk string of size bytes and the \r\n after it.
+func (rc *respConn) readBulk(size int) ([]byte, error) {
+	var buf []byte
+	if size+2 <= respPrealloc {
+		buf = make([]byte, size+2)
+		if _, err := io.ReadFull(rc.r, buf); err != nil {
+			return nil, err
+		}
+	} else {
+		var b bytes.Buffer
+		b.Grow(respPrealloc)
+		if _, err := io.CopyN(&b, rc.r, int64(size)+2); err != nil {
+			return nil, err
+		}
+		buf = b.Bytes()
+	}
+	if buf[size] != '\r' || buf[size+1] != '\n' {
+		return nil, errRESPProtocol
+	}
+	return buf[:size], nil
+}
+
+// readLine reads a line terminated by \r\n (or a bare \n for inline commands).
+func (rc *respConn) readLine() ([]byte, error) {
+	line, err := rc.r.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return nil, errRESPProtocol
+		}
+		return nil, err
+	}
+
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// arity checks the number of arguments, writing an error if it is wrong.
+// A max of -1 means no upper bound.
+func (rc *respConn) arity(name string, args [][]byte, min, max int) bool {
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		rc.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return false
+	}
+	return true
+}
+
+func (rc *respConn) writeSimple(s string) {
+	rc.w.WriteString("+" + s + "\r\n")
+}
+
+func (rc *respConn) writeError(msg string) {
+	rc.w.WriteString("-" + msg + "\r\n")
+}
+
+// writeResponseError writes a failed protocol.Response as a RESP error.
+func (rc *respConn) writeResponseError(res *protocol.Response) {
+	if res.StatusCode == protocol.StatusWrongType {
+		rc.writeError("WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+	if res.StatusCode == protocol.StatusDenied {
+		// The message already starts with a Redis error code (NOAUTH, NOPERM, WRONGPASS).
+		rc.writeError(res.ErrorMessage)
+		return
+	}
+	rc.writeError("ERR " + res.ErrorMessage)
+}
+
+func (rc *respConn) writeInt(n int64) {
+	rc.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (rc *respConn) writeBulk(b []byte) {
+	rc.w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	rc.w.Write(b)
+	rc.w.WriteString("\r\n")
+}
+
+// writeNull writes the null reply, which RESP3 encodes differently from RESP2.
+func (rc *respConn) writeNull() {
+	if rc.proto == 3 {
+		rc.w.WriteString("_\r\n")
+		return
+	}
+	rc.w.WriteString("$-1\r\n")
+}
+
+func (rc *respConn) writeArrayLen(n int) {
+	rc.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// writeMapLen starts a map of n pairs; RESP2 has no maps and uses a flat array.
+func (rc *respConn) writeMapLen(n int) {
+	if rc.proto == 3 {
+		rc.w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+		return
+	}
+	rc.writeArrayLen(2 * n)
+}
+
+func (rc *respConn) writeStrings(ss []string) {
+	rc.writeArrayLen(len(ss))
+	for _, s := range ss {
+		rc.writeBulk([]byte(s))
+	}
+}
+
+// globMatch reports whether name matches a Redis-style glob pattern
+// supporting *, ?, [abc], [^abc], [a-z] and backslash escapes.
+//
+// Every other element of the pattern matches exactly one byte, so on a
+// mismatch only the last star seen needs to take one more byte: earlier
+// stars could not do better. That keeps matching linear in the pattern
+// times the name, where trying every split for every star is exponential.
+func globMatch(pattern, name string) bool {
+	p, n := 0, 0
+	star, starName := -1, 0 // Just after the last star, and where it began in name
+	for n < len(name) {
+		if p < len(pattern) && pattern[p] == '*' {
+			p++
+			star, starName = p, n
+			continue
+		}
+		if p < len(pattern) {
+			if width, ok := globStep(pattern[p:], name[n]); ok {
+				p, n = p+width, n+1
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		starName++
+		p, n = star, starName
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// globStep matches c against the element pattern starts with, which is
+// not a star. It returns the element's length and whether c matches.
+func globStep(pattern string, c byte) (int, bool) {
+	switch pattern[0] {
+	case '?':
+		return 1, true
+
+	case '[':
+		end := strings.IndexByte(pattern[1:], ']')
+		if end < 0 {
+			// No closing bracket: match '[' literally.
+			return 1, c == '['
+		}
+		class := pattern[1 : end+1]
+		negate := len(class) > 0 && class[0] == '^'
+		if negate {
+			class = class[1:]
+		}
+		return end + 2, classMatch(class, c) != negate
+
+	case '\\':
+		if len(pattern) > 1 {
+			return 2, pattern[1] == c
+		}
+	}
+	return 1, pattern[0] == c
+}
+
+// classMatch reports whether c is in a bracket expression such as "a-z0_".
+func classMatch(class string, c byte) bool {
+	for i := 0; i < len(class); i++ {
+		if i+2 < len(class) && class[i+1] == '-' {
+			if class[i] <= c && c <= class[i+2] {
+				return true
+			}
+			i += 2
+			continue
+		}
+		if class[i] == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bufio"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dialRESP(t *testing.T, s *Server) *textClient {
+	t.Helper()
+	return dialText(t, s.StartRESP)
+}
+
+func TestRESP(t *testing.T) {
+	s := startServer(t, "")
+	c := dialRESP(t, s)
+
+	c.send("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\nhello\r\n")
+	c.expect("+OK")
+	c.send("*2\r\n$3\r\nGET\r\n$1\r\nk\r\n")
+	c.expect("$5")
+	c.expect("hello")
+
+	// Inline commands, pipelined.
+	c.send("DEL k\r\nGET k\r\nDEL k\r\n")
+	c.expect(":1")
+	c.expect("$-1")
+	c.expect(":0")
+
+	c.send("GET\r\nFLY k\r\nSET k v EX soon\r\n")
+	c.expect("-ERR wrong number of arguments for 'get' command")
+	c.expect("-ERR unknown command 'FLY'")
+	c.expectPrefix("-ERR")
+
+	s.cache.LPush("list", []byte("x"))
+	c.send("GET list\r\n")
+	c.expect("-WRONGTYPE Operation against a key holding the wrong kind of value")
+}
+
+func TestRESPMalformed(t *testing.T) {
+	s := startServer(t, "")
+	for _, input := range []string{
+		"*1\r\n+GET\r\n",        // not a bulk string
+		"*1\r\n$-4\r\n",         // negative length
+		"*1\r\n$3\r\nGETXX\r\n", // bulk longer than announced
+		"*x\r\n",                // bad array length
+		"*99999999\r\n",         // too many arguments
+		"*1\r\n$67108865\r\n",   // longer than a frame
+	} {
+		c := dialRESP(t, s)
+		c.send(input)
+		c.expect("-ERR Protocol error")
+		c.expectClosed()
+	}
+}
+
+func TestRESPAnnouncedSizes(t *testing.T) {
+	// Sizes announced by a client that then sends little or nothing must
+	// not be allocated up front.
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for _, input := range []string{"*1\r\n$60000000\r\nshort", "*1000000\r\n"} {
+		rc := &respConn{r: bufio.NewReader(strings.NewReader(input))}
+		if _, err := rc.readCommand(); err == nil {
+			t.Errorf("%q: read a command from a truncated input", input)
+		}
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("%d bytes allocated for a few bytes of input", n)
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*", "", true},
+		{"user:*", "user:1", true},
+		{"user:*", "order:1", false},
+		{"*:1", "user:1", true},
+		{"u*r*1", "user:1", true},
+		{"u*r*2", "user:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[llo", "h[llo", true},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"a*b*c", "abxbxc", true},
+		{"a*b*c", "abxbxcx", false},
+	} {
+		if got := globMatch(tc.pattern, tc.name); got != tc.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+
+	// Backtracking into every star would take years here.
+	start := time.Now()
+	if globMatch(strings.Repeat("*a", 20)+"*b", strings.Repeat("a", 1000)) {
+		t.Error("matched a name without the final b")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("pathological pattern took %v", d)
+	}
+}
+
+func TestRESPAuth(t *testing.T) {
+	s := startServer(t, "", WithACL(testACL(t)))
+	c := dialRESP(t, s)
+
+	// Unauthenticated connections run as the default user.
+	c.send("GET public:k\r\nSET k v\r\nGET k\r\n")
+	c.expect("$-1")
+	c.expectPrefix("-NOPERM")
+	c.expectPrefix("-NOPERM")
+
+	c.send("AUTH admin wrong\r\n")
+	c.expectPrefix("-WRONGPASS")
+	c.send("SET k v\r\n")
+	c.expectPrefix("-NOPERM")
+
+	c.send("AUTH admin admin-secret\r\nSET k v\r\nGET k\r\n")
+	c.expect("+OK")
+	c.expect("+OK")
+	c.expect("$1")
+	c.expect("v")
+}
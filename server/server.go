@@ -1,14 +1,29 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"log/slog"
 	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+
+	"github.com/BiChong-Jin/distributed-cache/acl"
 	"github.com/BiChong-Jin/distributed-cache/cache"
+	"github.com/BiChong-Jin/distributed-cache/certs"
 	"github.com/BiChong-Jin/distributed-cache/consistent"
 	"github.com/BiChong-Jin/distributed-cache/discovery"
 	"github.com/BiChong-Jin/distributed-cache/protocol"
+	"github.com/BiChong-Jin/distributed-cache/raft"
+	"github.com/BiChong-Jin/distributed-cache/strong"
+	"github.com/BiChong-Jin/distributed-cache/tracing"
 )
 
 // -------- Cache Server (one per node) --------
@@ -24,31 +39,141 @@ type Server struct {
 	cache    *cache.Cache
 	ring     *consistent.HashRing
 	registry *discovery.Registry
-	listener net.Listener
+	peers    *peerPool
+
+	// The listeners and servers Stop closes, which the Start functions set
+	// from their own goroutines. Besides the TCP listener, these are the
+	// optional front-ends for Redis (resp.go), memcached (memcached.go),
+	// HTTP (http.go) and gRPC (grpc.go) clients, and the metrics endpoint.
+	frontMu           sync.Mutex
+	listener          net.Listener
+	respListener      net.Listener
+	memcachedListener net.Listener
+	httpServer        *http.Server
+	grpcServer        *grpc.Server
+	metricsServer     *http.Server
+
+	// Transport security and access control, see options.go and auth.go.
+	tls           *certs.Reloader
+	mutualTLS     bool
+	acl           *acl.ACL
+	quotas        map[string]cache.Quota // Applied to the cache once it exists
+	maxNamespaces int                    // See namespaces.go
+
+	// Prometheus metrics, see metrics.go.
+	metrics *serverMetrics
+
+	logger *slog.Logger // See logging.go
+
+	// Spans go to tracerProvider, or the global one if nil (see the tracing
+	// package).
+	tracerProvider trace.TracerProvider
+	tracer         trace.Tracer
+
+	// Writes kept for unreachable owners, see hints.go.
+	hints     *hintStore
+	hintLimit int
+
+	// Copies kept of each key and how they are kept in step, see
+	// replication.go and antientropy.go.
+	replicas            atomic.Int64 // Set by the metadata log too, see metadata.go
+	ringHash            string       // Likewise, and guarded by metaMu once started
+	weight              atomic.Int64 // This node's ring weight, see weight.go
+	readQuorum          int
+	writeQuorum         int
+	antiEntropyInterval time.Duration
+	tombstoneGrace      time.Duration
+
+	// Cluster metadata kept in a Raft log, see metadata.go. meta is nil
+	// without WithRaft.
+	raftVoters []string
+	raftPath   string
+	meta       *raft.Node
+	metaMu     sync.Mutex
+	metaState  clusterMeta
+
+	// Strong mode, see strong.go: the namespaces proposed as strong by
+	// default, and this node's members of the shard groups by name.
+	strongNamespaces []string
+	shards           map[string]*strong.Group // Guarded by metaMu
+
+	done chan struct{} // Closed by Stop
+
+	// Reported by CmdInfo, see info.go.
+	started time.Time
+	clients atomic.Int64 // Open TCP, RESP and memcached connections
 }
 
 // NewServer creates a Server but does not start listening yet.
-func NewServer(addr string) *Server {
-	return &Server{
-		Addr:     addr,
-		cache:    cache.NewCache(5 * time.Second),
-		ring:     consistent.NewHashRing(150),
-		registry: discovery.NewRegistry(10 * time.Second),
+func NewServer(addr string, opts ...Option) *Server {
+	s := &Server{
+		Addr:                addr,
+		started:             time.Now(),
+		hintLimit:           DefaultHintLimit,
+		antiEntropyInterval: DefaultAntiEntropyInterval,
+		tombstoneGrace:      cache.DefaultTombstoneGrace,
+		maxNamespaces:       DefaultMaxNamespaces,
+		done:                make(chan struct{}),
+		logger:              slog.Default(),
+		ringHash:            consistent.DefaultHash,
+	}
+	s.replicas.Store(1)
+	s.weight.Store(1)
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.tracer = tracing.Tracer(s.tracerProvider)
+	s.logger = s.logger.With("node", addr)
+	if !validWeight(s.weight.Load()) {
+		s.logger.Warn("weight out of range, using 1", "weight", s.weight.Load(), "max", consistent.MaxWeight)
+		s.weight.Store(1)
+	}
+	hash := consistent.Hash(s.ringHash)
+	if hash == nil {
+		s.logger.Warn("unknown ring hash function, using the default", "hash", s.ringHash, "default", consistent.DefaultHash)
+		s.ringHash, hash = consistent.DefaultHash, consistent.Hash(consistent.DefaultHash)
+	}
+	s.ring = consistent.NewHashRing(150, consistent.WithHash(hash))
+	s.cache = cache.NewCache(5*time.Second, cache.WithLogger(s.logger), cache.WithTombstoneGrace(s.tombstoneGrace), cache.WithMaxNamespaces(s.maxNamespaces))
+	if s.quotas != nil {
+		s.cache.SetQuotas(s.quotas)
 	}
+	s.registry = discovery.NewRegistry(10*time.Second, discovery.WithLogger(s.logger), discovery.WithNotify(s.nodeStatusChanged))
+	s.hints = newHintStore(s.hintLimit)
+	s.peers = newPeerPool(s.dial, s.loginPeer)
+	s.metrics = newServerMetrics(s)
+	if s.raftVoters != nil {
+		s.meta = s.newMeta()
+	}
+	return s
 }
 
 // Start begins listening on TCP and accepting connections.
 func (s *Server) Start() error {
 	addr := s.Addr
 	hr := s.ring
-	listener, err := net.Listen("tcp", addr)
+	listener, err := s.listen(addr)
 	if err != nil {
 		return err
 	}
 
-	s.listener = listener
+	if !s.frontend(func() { s.listener = listener }) {
+		listener.Close()
+		return net.ErrClosed
+	}
 	s.registry.Register(addr)
-	hr.AddNode(addr)
+	if s.meta == nil {
+		hr.AddNodeWithWeight(addr, int(s.weight.Load()))
+	} else if err := s.startMeta(); err != nil {
+		listener.Close()
+		return err
+	}
+	s.logger.Info("listening", "frontend", "tcp", "addr", listener.Addr().String())
+	go s.heartbeats()
+	// With Raft the replica count may go up later.
+	if s.antiEntropyInterval > 0 && (s.replicas.Load() > 1 || s.meta != nil) {
+		go s.antiEntropy()
+	}
 
 	for {
 		conn, err := listener.Accept()
@@ -58,125 +183,484 @@ func (s *Server) Start() error {
 
 		go s.handleConnection(conn)
 	}
-	return nil
 }
 
 // Stop gracefully shuts down the server.
 func (s *Server) Stop() error {
-	err := s.listener.Close()
-	if err != nil {
+	s.logger.Info("stopping")
+	if err := s.closeFrontends(); err != nil {
 		return err
 	}
 
+	if s.meta != nil {
+		s.meta.Stop()
+	}
+	s.stopShards()
+	s.peers.close()
+
 	s.registry.Unregister(s.Addr)
 	s.ring.RemoveNode(s.Addr)
 
 	return nil
 }
 
+// frontend runs set, which records a listener or server for Stop to
+// close, unless the server is stopped already.
+func (s *Server) frontend(set func()) bool {
+	s.frontMu.Lock()
+	defer s.frontMu.Unlock()
+
+	select {
+	case <-s.done:
+		return false
+	default:
+		set()
+		return true
+	}
+}
+
+// closeFrontends closes the TCP listener, then the optional front-ends.
+func (s *Server) closeFrontends() error {
+	s.frontMu.Lock()
+	defer s.frontMu.Unlock()
+
+	if s.listener != nil {
+		if err := s.listener.Close(); err != nil {
+			return err
+		}
+	}
+	close(s.done)
+	if s.respListener != nil {
+		s.respListener.Close()
+	}
+	if s.memcachedListener != nil {
+		s.memcachedListener.Close()
+	}
+	if s.httpServer != nil {
+		s.httpServer.Close()
+	}
+	if s.grpcServer != nil {
+		s.grpcServer.Stop()
+	}
+	if s.metricsServer != nil {
+		s.metricsServer.Close()
+	}
+	return nil
+}
+
 // handleConnection reads requests from a TCP connection and sends responses.
-//  1. Read bytes from conn → DecodeRequest
+//  0. Run the HELLO handshake to agree on a protocol version and codec
+//  1. Read a frame from conn → DecodeRequestWith the agreed codec
+//     (CmdAuth is handled here, as it changes the connection's user)
 //  2. Check if this node owns the key (via hash ring)
 //     - If yes: handle locally (get/set/delete on local cache)
 //     - If no:  forward the request to the correct node (proxy)
-//  3. Encode the Response and write it back
-func (s *Server) handleConnection(conn net.Conn) {
-	defer conn.Close()
+//  3. Encode the Response with the agreed codec and write it back as a frame
+//
+// A connection may carry several requests, one after another.
+func (s *Server) handleConnection(nc net.Conn) {
+	log := s.openConnection("tcp", nc)
+	defer s.closeConnection(log, nc)
 
-	buf := make([]byte, 4096)
-	n, err := conn.Read(buf)
+	conn, data, err := protocol.Accept(nc, protocol.DefaultHello())
 	if err != nil {
+		logIOError(log, "handshake failed", err)
 		return
 	}
+	log.Debug("handshake done", "version", conn.Version, "features", conn.Features)
 
-	data := buf[:n]
-	req, err := protocol.DecodeRequest(data)
-	if err != nil {
-		return
+	// The user this connection runs as, and whether it is another node
+	// whose requests carry their own user (see auth.go).
+	var user string
+	var peer bool
+
+	for {
+		if data == nil {
+			if data, err = protocol.ReadFrame(conn); err != nil {
+				logIOError(log, "read failed", err)
+				return
+			}
+		}
+
+		req, err := protocol.DecodeRequestWith(data, conn.Codec)
+		if err != nil {
+			log.Warn("malformed request", "err", err)
+			return
+		}
+		data = nil
+
+		var res *protocol.Response
+		if req.CommandType == protocol.CmdAuth {
+			var newUser string
+			var newPeer bool
+			if res, newUser, newPeer = s.authenticate(req); res.StatusCode == protocol.StatusOK {
+				user, peer = newUser, newPeer
+				log.Debug("authenticated", "user", user, "peer", peer)
+			} else {
+				log.Warn("authentication failed", "user", req.Key, "err", res.ErrorMessage)
+			}
+		} else if req.CommandType.Internal() && s.acl != nil && !peer {
+			// Internal commands skip the ACL, so only nodes may send them.
+			res = &protocol.Response{StatusCode: protocol.StatusDenied, ErrorMessage: "NOPERM only nodes may run " + req.CommandType.String()}
+		} else {
+			if !peer {
+				req.User = user
+			}
+			res = s.serve(req)
+		}
+
+		respBytes, err := res.EncodeWith(conn.Codec)
+		if err != nil {
+			log.Error("cannot encode response", "cmd", req.CommandType, "err", err)
+			return
+		}
+
+		if err := protocol.WriteFrame(conn, respBytes); err != nil {
+			logIOError(log, "write failed", err)
+			return
+		}
+	}
+}
+
+// serve routes a request read from a TCP connection. The request's
+// deadline, if the sender set one, and trace context carry over to the
+// context it is routed with.
+func (s *Server) serve(req *protocol.Request) *protocol.Response {
+	ctx := tracing.Extract(context.Background(), req)
+	if req.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, req.Timeout)
+		defer cancel()
 	}
 
-	k := req.Key
-	no := s.ring.GetNode(k)
-	var res *protocol.Response
+	ctx, span := tracing.Start(ctx, s.tracer, "server", trace.SpanKindServer, req, attribute.String("cache.user", req.User))
+	res := s.route(ctx, req)
+	tracing.End(span, res, nil)
+	return res
+}
 
-	if s.Addr == no {
-		res = s.handleLocally(req)
-	} else {
-		res = s.forwardToNode(no, req)
+// route checks the request against the ACL, then handles it locally if
+// this node owns the key, or proxies it to the owning node otherwise.
+// Commands about the node itself (ping, key listing, stats) are never proxied;
+// info is proxied to the node it names. With replication, reads and writes
+// of plain values go to all of the key's replicas (see replication.go).
+// Internal commands from other nodes are always handled here.
+func (s *Server) route(ctx context.Context, req *protocol.Request) *protocol.Response {
+	defer s.metrics.observe(req.CommandType, time.Now())
+
+	// Whoever sent the request has stopped waiting for it.
+	if err := ctx.Err(); err != nil {
+		return errorResponse(err)
+	}
+	if req.CommandType == protocol.CmdRaft {
+		return s.raftMessage(ctx, req)
+	}
+	if req.CommandType.Internal() {
+		return s.handleLocally(ctx, req)
 	}
 
-	respBytes, err := res.Encode()
-	if err != nil {
-		return
+	if res := s.authorize(req); res != nil {
+		s.logger.Debug("request denied", "user", req.User, "cmd", req.CommandType, "key", req.Key)
+		return res
+	}
+	switch req.CommandType {
+	case protocol.CmdPing, protocol.CmdKeys, protocol.CmdStats:
+		return s.handleLocally(ctx, req)
+	case protocol.CmdInfo:
+		return s.routeInfo(ctx, req)
+	}
+
+	if s.strongMode(req) {
+		return s.routeStrong(ctx, req)
+	}
+	if s.replicas.Load() > 1 && replicated[req.CommandType] {
+		return s.routeReplicated(ctx, req)
 	}
 
-	conn.Write(respBytes)
+	owner := s.ring.GetNode(req.Key)
+	if owner == "" {
+		return errorResponse(errNoRing)
+	}
+	s.logger.Debug("routing request", "cmd", req.CommandType, "key", req.Key, "owner", owner, "local", owner == s.Addr)
+	if s.Addr == owner {
+		s.metrics.routed.Inc("local")
+		return s.handleLocally(ctx, req)
+	}
+	s.metrics.routed.Inc("proxied")
+	return s.forwardToNode(ctx, owner, req)
 }
 
 // handleLocally processes a request against this node's local cache.
-func (s *Server) handleLocally(req *protocol.Request) *protocol.Response {
+func (s *Server) handleLocally(ctx context.Context, req *protocol.Request) *protocol.Response {
+	_, span := tracing.Start(ctx, s.tracer, "local", trace.SpanKindInternal, req)
+	res := s.execute(req)
+	tracing.End(span, res, nil)
+	return res
+}
+
+// execute runs a request in its namespace's keyspace.
+func (s *Server) execute(req *protocol.Request) *protocol.Response {
+	c, err := s.cache.OpenNamespace(req.Namespace)
+	if err != nil {
+		return errorResponse(err)
+	}
 	switch req.CommandType {
 	case protocol.CmdGet:
-		val, ok := s.cache.Get(req.Key)
+		val, ok, err := c.Get(req.Key)
+		if err != nil {
+			return errorResponse(err)
+		}
 		if !ok {
 			return &protocol.Response{StatusCode: protocol.StatusNotFound}
 		}
 		return &protocol.Response{StatusCode: protocol.StatusOK, Value: val}
 
 	case protocol.CmdSet:
-		s.cache.Set(req.Key, req.Value, req.TTL)
+		c.SetWithFlags(req.Key, req.Value, req.TTL, req.Flags)
 		return &protocol.Response{StatusCode: protocol.StatusOK}
 
 	case protocol.CmdDelete:
-		s.cache.Delete(req.Key)
+		existed := c.Delete(req.Key)
+		return &protocol.Response{StatusCode: protocol.StatusOK, Int: boolToInt(existed)}
+
+	case protocol.CmdAdd:
+		if !c.Add(req.Key, req.Value, req.TTL, req.Flags) {
+			return &protocol.Response{StatusCode: protocol.StatusNotStored}
+		}
 		return &protocol.Response{StatusCode: protocol.StatusOK}
 
-	case protocol.CmdPing:
+	case protocol.CmdReplace:
+		if !c.Replace(req.Key, req.Value, req.TTL, req.Flags) {
+			return &protocol.Response{StatusCode: protocol.StatusNotStored}
+		}
 		return &protocol.Response{StatusCode: protocol.StatusOK}
 
+	case protocol.CmdCAS:
+		stored, found, err := c.CompareAndSwap(req.Key, req.Value, req.TTL, req.Flags, req.CAS)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if !found {
+			return &protocol.Response{StatusCode: protocol.StatusNotFound}
+		}
+		if !stored {
+			return &protocol.Response{StatusCode: protocol.StatusNotStored}
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK}
+
+	case protocol.CmdIncrExisting:
+		n, ok, err := c.IncrExisting(req.Key, req.Int)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if !ok {
+			return &protocol.Response{StatusCode: protocol.StatusNotFound}
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Int: n}
+
+	case protocol.CmdIncr:
+		n, err := c.Incr(req.Key, req.Int)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Int: n}
+
+	case protocol.CmdExpire:
+		if !c.Expire(req.Key, req.TTL) {
+			return &protocol.Response{StatusCode: protocol.StatusNotFound}
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK}
+
+	case protocol.CmdPing:
+		// Heartbeats learn the node's weight from the reply, see weight.go.
+		return &protocol.Response{StatusCode: protocol.StatusOK, Int: s.weight.Load()}
+
+	case protocol.CmdGetMeta:
+		val, meta, ok, err := c.GetWithMetadata(req.Key)
+		if err != nil {
+			// Collections still have metadata, for TTL.
+			res := errorResponse(err)
+			res.Meta = protocolMetadata(meta)
+			return res
+		}
+		if !ok {
+			return &protocol.Response{StatusCode: protocol.StatusNotFound}
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Value: val, Meta: protocolMetadata(meta)}
+
+	case protocol.CmdGetSet:
+		old, ok, err := c.GetAndSet(req.Key, req.Value, req.TTL)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if !ok {
+			return &protocol.Response{StatusCode: protocol.StatusNotFound}
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Value: old}
+
+	case protocol.CmdGetDel:
+		val, ok, err := c.GetAndDelete(req.Key)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if !ok {
+			return &protocol.Response{StatusCode: protocol.StatusNotFound}
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Value: val}
+
 	case protocol.CmdKeys:
-		keys := s.cache.Keys()
+		keys := s.visibleKeys(req.User, c.Keys())
 		data, err := json.Marshal(keys)
 		if err != nil {
 			return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: "Failed to get keys."}
 		}
 		return &protocol.Response{StatusCode: protocol.StatusOK, Value: data}
 
+	case protocol.CmdStats:
+		return s.namespaceStats(req.User, req.Namespace)
+
+	case protocol.CmdInfo:
+		return s.nodeInfo()
+
+	case protocol.CmdHSet, protocol.CmdHGet, protocol.CmdHDel, protocol.CmdHGetAll,
+		protocol.CmdLPush, protocol.CmdRPop, protocol.CmdLRange,
+		protocol.CmdSAdd, protocol.CmdSIsMember, protocol.CmdSMembers,
+		protocol.CmdZAdd, protocol.CmdZRange, protocol.CmdZRangeByScore,
+		protocol.CmdZRem, protocol.CmdZCard, protocol.CmdZIncrBy:
+		return handleCollection(c, req)
+
+	case protocol.CmdReplicaGet:
+		val, meta, ok, err := c.Peek(req.Key)
+		if err != nil {
+			res := errorResponse(err)
+			res.Meta = protocolMetadata(meta)
+			return res
+		}
+		if !ok {
+			// A deleted key answers with its tombstone's stamp.
+			return &protocol.Response{StatusCode: protocol.StatusNotFound, Meta: protocol.Metadata{Stamp: meta.Stamp}}
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Value: val, Meta: protocolMetadata(meta)}
+
+	case protocol.CmdReplicaSet:
+		stored, err := c.SetStamped(req.Key, req.Value, req.TTL, req.Flags, req.Stamp)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Int: boolToInt(stored)}
+
+	case protocol.CmdReplicaDelete:
+		existed, err := c.DeleteStamped(req.Key, req.Stamp)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{StatusCode: protocol.StatusOK, Int: boolToInt(existed)}
+
+	case protocol.CmdMerkle:
+		return s.merkleTrees(req)
+
+	case protocol.CmdDigest:
+		return s.digest(req)
+
 	default:
 		return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: "Unknown CommandType."}
 	}
 }
 
-// forwardToNode sends a request to another node and returns its response.
-func (s *Server) forwardToNode(addr string, req *protocol.Request) *protocol.Response {
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: err.Error()}
+// protocolMetadata converts an item's metadata for the wire.
+func protocolMetadata(meta cache.Metadata) protocol.Metadata {
+	return protocol.Metadata{
+		CreatedAt: meta.CreatedAt,
+		TTL:       meta.TTL,
+		Version:   meta.Version,
+		Stamp:     meta.Stamp,
+		Size:      meta.Size,
+		Flags:     meta.Flags,
 	}
-	defer conn.Close()
+}
 
-	reqBytes, err := req.Encode()
+// forwardToNode sends a request to another node and returns its response.
+// Connections to peers are reused (see peers.go). The request carries the
+// forward span's trace context, so the peer's spans join the same trace,
+// and what is left of ctx's deadline. A set the owner cannot take is kept
+// as a hint, and any write it takes drops the key's hint (see hints.go).
+// Only a node that cannot be reached makes the request unavailable; a
+// request that could not be sent for another reason is an error.
+func (s *Server) forwardToNode(ctx context.Context, addr string, req *protocol.Request) *protocol.Response {
+	res, err := s.forward(ctx, addr, req)
 	if err != nil {
-		return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: err.Error()}
+		s.metrics.forwardErrors.Inc(addr)
+		if res := s.hintWrite(ctx, addr, req, err); res != nil {
+			return res
+		}
+		s.logger.Warn("forward failed", "peer", addr, "cmd", req.CommandType, "err", err)
+		if !unreachable(err) {
+			return errorResponse(err)
+		}
+		return unavailable(err.Error())
+	}
+	if !req.CommandType.ReadOnly() && res.StatusCode != protocol.StatusError && res.StatusCode != protocol.StatusDenied && res.StatusCode != protocol.StatusUnavailable {
+		s.hints.forget(addr, req)
 	}
-	conn.Write(reqBytes)
+	return res
+}
 
-	buf := make([]byte, 4096)
-	n, err := conn.Read(buf)
-	if err != nil {
-		return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: err.Error()}
+// unavailable answers a request that could not reach the node, or the
+// quorum of nodes, it needed.
+func unavailable(msg string) *protocol.Response {
+	return &protocol.Response{StatusCode: protocol.StatusUnavailable, ErrorMessage: msg}
+}
+
+// forward sends req to addr under a "forward" span.
+func (s *Server) forward(ctx context.Context, addr string, req *protocol.Request) (*protocol.Response, error) {
+	ctx, span := tracing.Start(ctx, s.tracer, "forward", trace.SpanKindClient, req, attribute.String("cache.peer", addr))
+	tracing.Inject(ctx, req)
+	res, err := s.peers.roundTrip(ctx, addr, req)
+	tracing.End(span, res, err)
+	return res, err
+}
+
+// clusterKeys asks every node in the ring for the keys user may see and
+// returns the sorted union. If a node fails, its error response is
+// returned instead.
+func (s *Server) clusterKeys(ctx context.Context, user string) ([]string, *protocol.Response) {
+	req := &protocol.Request{CommandType: protocol.CmdKeys, User: user}
+	if res := s.authorize(req); res != nil {
+		return nil, res
 	}
 
-	res, err := protocol.DecodeResponse(buf[:n])
-	if err != nil {
-		return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: err.Error()}
+	keys := []string{}
+	for _, node := range s.ring.GetNodes() {
+		var res *protocol.Response
+		if node == s.Addr {
+			res = s.handleLocally(ctx, req)
+		} else {
+			res = s.forwardToNode(ctx, node, req)
+		}
+		if res.StatusCode != protocol.StatusOK {
+			return nil, res
+		}
+
+		var nodeKeys []string
+		if err := json.Unmarshal(res.Value, &nodeKeys); err != nil {
+			return nil, &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: err.Error()}
+		}
+		keys = append(keys, nodeKeys...)
 	}
 
-	return res
+	sort.Strings(keys)
+	return keys, nil
 }
 
 // JoinCluster adds a known peer node to this server's ring and registry.
+// With WithRaft the ring comes from the metadata log instead, and nodes
+// join it themselves (see metadata.go), so this does nothing.
 func (s *Server) JoinCluster(peerAddr string) {
+	if s.meta != nil {
+		s.logger.Info("ring membership comes from the metadata log, not joining", "peer", peerAddr)
+		return
+	}
+	s.logger.Info("joining cluster", "peer", peerAddr)
 	s.ring.AddNode(peerAddr)
 	s.registry.Register(peerAddr)
 }
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// freeAddr returns a local address nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// waitFor polls cond until it holds, failing the test after 10 seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// listening reports whether something accepts connections at addr.
+func listening(addr string) bool {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// startServer starts a node at addr, or on a free port if addr is "",
+// and stops it when the test ends. It returns once the node is in its
+// own ring.
+func startServer(t *testing.T, addr string, opts ...Option) *Server {
+	t.Helper()
+	s := launchServer(t, addr, opts...)
+	waitFor(t, "the node to start", func() bool { return s.ring.Weight(s.Addr) > 0 })
+	return s
+}
+
+// launchServer starts a node without waiting for it to join its ring,
+// which with WithRaft takes a quorum of voters.
+func launchServer(t *testing.T, addr string, opts ...Option) *Server {
+	t.Helper()
+	if addr == "" {
+		addr = freeAddr(t)
+	}
+	opts = append([]Option{WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))}, opts...)
+	s := NewServer(addr, opts...)
+	go s.Start()
+	t.Cleanup(func() { s.Stop() })
+	return s
+}
+
+// startCluster starts n nodes that all know each other.
+func startCluster(t *testing.T, n int, opts ...Option) []*Server {
+	t.Helper()
+	nodes := make([]*Server, n)
+	for i := range nodes {
+		nodes[i] = startServer(t, "", opts...)
+	}
+	for _, a := range nodes {
+		for _, b := range nodes {
+			if a != b {
+				a.JoinCluster(b.Addr)
+			}
+		}
+	}
+	return nodes
+}
+
+// keyOwnedBy returns a key that s's ring places on owner.
+func keyOwnedBy(t *testing.T, s *Server, owner string) string {
+	t.Helper()
+	for i := range 10000 {
+		if key := fmt.Sprintf("key-%d", i); s.ring.GetNode(key) == owner {
+			return key
+		}
+	}
+	t.Fatalf("no key owned by %s", owner)
+	return ""
+}
+
+// listenLocal returns a listener on a free local port.
+func listenLocal(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// textClient is a raw connection to one of the node's line-based
+// front-ends (RESP, memcached).
+type textClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialText connects to a front-end that start serves at a free address.
+func dialText(t *testing.T, start func(addr string) error) *textClient {
+	t.Helper()
+	addr := freeAddr(t)
+	go start(addr)
+	waitFor(t, "the front-end to start", func() bool { return listening(addr) })
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return &textClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// send writes raw protocol text.
+func (c *textClient) send(text string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.conn, text); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// line reads a reply line without its line ending.
+func (c *textClient) line() string {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("reading a reply: %v", err)
+	}
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+// expect reads a reply line and checks it.
+func (c *textClient) expect(want string) {
+	c.t.Helper()
+	if got := c.line(); got != want {
+		c.t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+// expectPrefix reads a reply line and checks how it starts.
+func (c *textClient) expectPrefix(want string) {
+	c.t.Helper()
+	if got := c.line(); !strings.HasPrefix(got, want) {
+		c.t.Fatalf("got %q, want %q...", got, want)
+	}
+}
+
+// expectClosed checks that the server hung up.
+func (c *textClient) expectClosed() {
+	c.t.Helper()
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		c.t.Errorf("connection still open (%v)", err)
+	}
+}
+
+func TestStopWhileFrontendsStart(t *testing.T) {
+	s := startServer(t, "")
+	errs := make(chan error, 4)
+	go func() { errs <- s.serveRESP(listenLocal(t)) }()
+	go func() { errs <- s.serveMemcached(listenLocal(t)) }()
+	go func() { errs <- s.serveGRPC(listenLocal(t)) }()
+	go func() { errs <- s.StartMetrics("127.0.0.1:0") }()
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each front-end either was closed by Stop or saw it had happened.
+	for range 4 {
+		select {
+		case <-errs:
+		case <-time.After(5 * time.Second):
+			t.Fatal("a front-end kept serving after Stop")
+		}
+	}
+	if err := s.StartMetrics("127.0.0.1:0"); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("StartMetrics after Stop: %v, want net.ErrClosed", err)
+	}
+}
+
+func TestForwardErrors(t *testing.T) {
+	nodes := startCluster(t, 2)
+	s := nodes[0]
+	ctx := context.Background()
+
+	// A request too large to send is an error, not a sign the owner is down.
+	big := &protocol.Request{CommandType: protocol.CmdSet, Key: keyOwnedBy(t, s, nodes[1].Addr), Value: make([]byte, protocol.MaxFrameSize)}
+	if res := s.route(ctx, big); res.StatusCode != protocol.StatusError {
+		t.Errorf("oversized forward: status %d (%s), want an error", res.StatusCode, res.ErrorMessage)
+	}
+
+	dead := freeAddr(t)
+	s.ring.AddNode(dead)
+	get := &protocol.Request{CommandType: protocol.CmdGet, Key: keyOwnedBy(t, s, dead)}
+	if res := s.route(ctx, get); res.StatusCode != protocol.StatusUnavailable {
+		t.Errorf("forward to an unreachable owner: status %d (%s), want unavailable", res.StatusCode, res.ErrorMessage)
+	}
+}
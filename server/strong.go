@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/BiChong-Jin/distributed-cache/consistent"
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+	"github.com/BiChong-Jin/distributed-cache/raft"
+	"github.com/BiChong-Jin/distributed-cache/strong"
+	"github.com/BiChong-Jin/distributed-cache/tracing"
+)
+
+// -------- Strong Mode --------
+// Replicated keys are eventually consistent (see replication.go). Keys in
+// strong mode are linearizable instead: the ring's token space is cut
+// into strongShards equal ranges, and each range is kept by a consensus
+// group (see the strong package) among its replicas on the ring. Keys
+// are hashed into ranges with crc32 whatever the ring's hash function,
+// which ConfigureRing may change: that must not move keys between groups. A request
+// runs in strong mode if it says so (Request.Strong) or if its namespace
+// does (ConfigureStrong), which needs WithRaft.
+//
+//	key ──token──▶ shard ──metadata log──▶ voters ──▶ strong.Group
+//
+// A shard's voters are placed in the metadata log the first time the
+// shard is used, as the replicas of its range at that point, and stay put
+// as the ring changes later on: a group's members cannot change, but it
+// keeps working as long as a majority of them is up. Every voter runs a
+// member of the group; a node that is not one forwards the request to a
+// voter, and the group hands writes to its leader.
+//
+// Only Get, GetMeta, Set, Add, Replace, CAS and Delete run in strong mode.
+// Strong keys live in the groups, apart from the cache: a key written in
+// one mode is not seen in the other, nor listed by Keys.
+
+// strongShards is how many ranges strong mode cuts the ring into.
+const strongShards = 16
+
+var errNoStrong = errors.New("strong mode needs WithRaft")
+
+// strongOps maps the commands strong mode runs.
+var strongOps = map[protocol.CommandType]strong.Op{
+	protocol.CmdGet:     strong.OpGet,
+	protocol.CmdGetMeta: strong.OpGet,
+	protocol.CmdSet:     strong.OpSet,
+	protocol.CmdAdd:     strong.OpAdd,
+	protocol.CmdReplace: strong.OpReplace,
+	protocol.CmdCAS:     strong.OpCAS,
+	protocol.CmdDelete:  strong.OpDelete,
+}
+
+func shardName(shard int) string {
+	return fmt.Sprintf("shard-%d", shard)
+}
+
+// strongMode reports whether req runs in strong mode.
+func (s *Server) strongMode(req *protocol.Request) bool {
+	if req.Strong {
+		return true
+	}
+	if s.meta == nil {
+		return false
+	}
+	s.metaMu.Lock()
+	defer s.metaMu.Unlock()
+	return s.metaState.Strong[req.Namespace]
+}
+
+// routeStrong runs req in its shard's group if this node is one of the
+// voters, or forwards it to the first voter that can be reached.
+func (s *Server) routeStrong(ctx context.Context, req *protocol.Request) *protocol.Response {
+	if s.meta == nil {
+		return errorResponse(errNoStrong)
+	}
+	op, ok := strongOps[req.CommandType]
+	if !ok {
+		return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: req.CommandType.String() + " is not available in strong mode"}
+	}
+
+	shard := consistent.Range{}.Slot(crc32.ChecksumIEEE([]byte(req.Key)), strongShards)
+	voters, err := s.shardVoters(ctx, shard)
+	if err != nil {
+		return errorResponse(err)
+	}
+	s.logger.Debug("routing strong request", "cmd", req.CommandType, "key", req.Key, "shard", shard, "voters", voters)
+	if slices.Contains(voters, s.Addr) {
+		s.metrics.routed.Inc("local")
+		return s.runStrong(ctx, shard, req, op)
+	}
+
+	s.metrics.routed.Inc("proxied")
+	fwd := *req
+	fwd.Strong = true
+	for _, addr := range voters {
+		var res *protocol.Response
+		if res, err = s.forward(ctx, addr, &fwd); err == nil {
+			return res
+		}
+		s.metrics.forwardErrors.Inc(addr)
+		// Only try the next voter if this one cannot have run it: a
+		// timeout or a dropped connection says nothing either way.
+		if !errors.Is(err, protocol.ErrNotSent) || ctx.Err() != nil {
+			break
+		}
+	}
+	s.logger.Warn("forward failed", "cmd", req.CommandType, "key", req.Key, "err", err)
+	return unavailable(err.Error())
+}
+
+// shardVoters returns the voters of shard, placing it first if it has
+// none yet.
+func (s *Server) shardVoters(ctx context.Context, shard int) ([]string, error) {
+	s.metaMu.Lock()
+	voters := s.metaState.Shards[shard]
+	s.metaMu.Unlock()
+	if voters != nil {
+		return voters, nil
+	}
+
+	r := consistent.Range{}.Split(strongShards)[shard]
+	voters = s.ring.RangeReplicas(r, int(s.replicas.Load()))
+	if len(voters) == 0 {
+		return nil, errNoRing
+	}
+	if err := s.proposeMeta(ctx, metaChange{Op: "shard", Shard: shard, Voters: voters}); err != nil {
+		return nil, err
+	}
+	// Another node may have placed it first.
+	s.metaMu.Lock()
+	defer s.metaMu.Unlock()
+	return s.metaState.Shards[shard], nil
+}
+
+// runStrong runs req in this node's member of the shard's group.
+func (s *Server) runStrong(ctx context.Context, shard int, req *protocol.Request, op strong.Op) *protocol.Response {
+	g := s.shardGroup(shardName(shard))
+	if g == nil {
+		return &protocol.Response{StatusCode: protocol.StatusError, ErrorMessage: shardName(shard) + " is not running on this node"}
+	}
+	ctx, span := tracing.Start(ctx, s.tracer, "strong", trace.SpanKindInternal, req)
+	r, err := g.Do(ctx, strong.Command{
+		Op: op,
+		// The groups hold every namespace's keys.
+		Key:     req.Namespace + "\x00" + req.Key,
+		Value:   req.Value,
+		Flags:   req.Flags,
+		TTL:     req.TTL,
+		Version: req.CAS,
+	})
+	res := strongResponse(req, r, err)
+	tracing.End(span, res, err)
+	return res
+}
+
+// strongResponse answers req with what its command did, the same way the
+// cache would have.
+func strongResponse(req *protocol.Request, r strong.Result, err error) *protocol.Response {
+	if err != nil {
+		return errorResponse(err)
+	}
+	switch req.CommandType {
+	case protocol.CmdGet, protocol.CmdGetMeta:
+		if !r.Found {
+			return &protocol.Response{StatusCode: protocol.StatusNotFound}
+		}
+		res := &protocol.Response{StatusCode: protocol.StatusOK, Value: r.Item.Value}
+		if req.CommandType == protocol.CmdGetMeta {
+			res.Meta = protocol.Metadata{CreatedAt: r.Item.Written, Version: r.Item.Version, Size: len(r.Item.Value), Flags: r.Item.Flags}
+			if !r.Item.Expires.IsZero() {
+				res.Meta.TTL = max(time.Until(r.Item.Expires), 0)
+			}
+		}
+		return res
+	case protocol.CmdDelete:
+		return &protocol.Response{StatusCode: protocol.StatusOK, Int: boolToInt(r.Found)}
+	case protocol.CmdCAS:
+		if !r.Found {
+			return &protocol.Response{StatusCode: protocol.StatusNotFound}
+		}
+	}
+	if !r.Stored {
+		return &protocol.Response{StatusCode: protocol.StatusNotStored}
+	}
+	return &protocol.Response{StatusCode: protocol.StatusOK}
+}
+
+// -------- Shard Groups --------
+
+// startShard starts this node's member of the shard's group. The caller
+// must hold s.metaMu.
+func (s *Server) startShard(shard int, voters []string) {
+	name := shardName(shard)
+	raftOpts := []raft.Option{raft.WithLogger(s.logger.With("raft", name))}
+	if s.raftPath != "" {
+		raftOpts = append(raftOpts, raft.WithStorage(filepath.Join(filepath.Dir(s.raftPath), name+".raft")))
+	}
+	g := strong.NewGroup(s.Addr, voters, s.raftTransport(name), strong.WithRaft(raftOpts...))
+	if err := g.Start(); err != nil {
+		s.logger.Error("cannot start strong shard", "shard", shard, "err", err)
+		return
+	}
+	if s.shards == nil {
+		s.shards = make(map[string]*strong.Group)
+	}
+	s.shards[name] = g
+}
+
+// shardGroup returns this node's member of the named shard group, or nil.
+func (s *Server) shardGroup(name string) *strong.Group {
+	s.metaMu.Lock()
+	defer s.metaMu.Unlock()
+	return s.shards[name]
+}
+
+// stopShards stops every shard group member of this node.
+func (s *Server) stopShards() {
+	s.metaMu.Lock()
+	defer s.metaMu.Unlock()
+	for _, g := range s.shards {
+		g.Stop()
+	}
+}
+
+// shardStatus reports the node's place in its shard groups for CmdInfo.
+func (s *Server) shardStatus() map[string]protocol.RaftStatus {
+	s.metaMu.Lock()
+	defer s.metaMu.Unlock()
+	if len(s.shards) == 0 {
+		return nil
+	}
+	status := make(map[string]protocol.RaftStatus, len(s.shards))
+	for name, g := range s.shards {
+		st := g.Status()
+		status[name] = protocol.RaftStatus{Role: st.Role.String(), Term: st.Term, Leader: st.Leader, Applied: st.Applied}
+	}
+	return status
+}
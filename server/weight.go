@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/BiChong-Jin/distributed-cache/consistent"
+)
+
+// -------- Node Weights --------
+// A node of weight n gets n times the keys of a node of weight 1 (see
+// consistent.HashRing), so a fleet can mix machines of different sizes.
+// Each node advertises its own weight (WithWeight) to the others:
+//
+//   - With WithRaft, through the metadata log: the node's join carries
+//     it, also when the node is a member already.
+//   - Without, in its answers to heartbeats (heartbeat.go).
+//
+// ConfigureWeight changes a weight at runtime, until the node starts
+// again with its own. Only the changed node's keys move, to or from it.
+//
+// Weights go from 1 to consistent.MaxWeight: a node of a huge weight
+// would fill the ring with virtual nodes. Weights outside that range are
+// refused when set and ignored when advertised.
+
+var errBadWeight = fmt.Errorf("weight must be between 1 and %d", consistent.MaxWeight)
+
+// validWeight reports whether weight is in range.
+func validWeight(weight int64) bool {
+	return weight >= 1 && weight <= consistent.MaxWeight
+}
+
+// ConfigureWeight sets the weight of the ring member addr. Without
+// WithRaft a node can only change its own, which its peers learn from the
+// next heartbeat.
+func (s *Server) ConfigureWeight(ctx context.Context, addr string, weight int) error {
+	if !validWeight(int64(weight)) {
+		return errBadWeight
+	}
+	if s.meta != nil {
+		return s.proposeMeta(ctx, metaChange{Op: "weight", Addr: addr, Weight: weight})
+	}
+	if addr != s.Addr {
+		return errNoRaft
+	}
+	s.weight.Store(int64(weight))
+	s.ring.SetWeight(s.Addr, weight)
+	s.logger.Info("weight changed", "weight", weight)
+	return nil
+}
+
+// learnWeight takes the weight peer advertised in a heartbeat answer,
+// unless the metadata log decides. Peers from before weights answer 0.
+func (s *Server) learnWeight(peer string, weight int64) {
+	if s.meta != nil || !validWeight(weight) || int(weight) == s.ring.Weight(peer) {
+		return
+	}
+	if s.ring.SetWeight(peer, int(weight)) {
+		s.logger.Info("peer weight changed", "peer", peer, "weight", weight)
+	}
+}
+
+// applyWeight sets the weight of member addr from the metadata log; 0,
+// or any weight out of range, leaves it as it is. The caller must hold
+// s.metaMu.
+func (s *Server) applyWeight(m *clusterMeta, addr string, weight int, index uint64) {
+	if !validWeight(int64(weight)) {
+		return
+	}
+	if m.Weights == nil {
+		m.Weights = make(map[string]int)
+	}
+	m.Weights[addr] = weight
+	if weight == s.ring.Weight(addr) {
+		return
+	}
+	s.ring.SetWeight(addr, weight)
+	if addr == s.Addr {
+		s.weight.Store(int64(weight))
+	}
+	s.logger.Info("node weight changed", "peer", addr, "weight", weight, "index", index)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/BiChong-Jin/distributed-cache/consistent"
+)
+
+func TestWeightRange(t *testing.T) {
+	s := startServer(t, "", WithWeight(1<<40))
+	if w := s.ring.Weight(s.Addr); w != 1 {
+		t.Fatalf("WithWeight(1<<40) gave weight %d, want 1", w)
+	}
+
+	if err := s.ConfigureWeight(context.Background(), s.Addr, consistent.MaxWeight+1); err != errBadWeight {
+		t.Fatalf("ConfigureWeight(MaxWeight+1) = %v, want %v", err, errBadWeight)
+	}
+	if err := s.ConfigureWeight(context.Background(), s.Addr, consistent.MaxWeight); err != nil {
+		t.Fatal(err)
+	}
+	if w := s.ring.Weight(s.Addr); w != consistent.MaxWeight {
+		t.Fatalf("weight %d, want %d", w, consistent.MaxWeight)
+	}
+
+	// A peer advertising a weight out of range keeps the one it had.
+	const peer = "10.0.0.1:7000"
+	s.ring.AddNode(peer)
+	s.learnWeight(peer, 1<<40)
+	s.learnWeight(peer, -3)
+	if w := s.ring.Weight(peer); w != 1 {
+		t.Errorf("peer weight %d after bad advertisements, want 1", w)
+	}
+	s.learnWeight(peer, 3)
+	if w := s.ring.Weight(peer); w != 3 {
+		t.Errorf("peer weight %d, want 3", w)
+	}
+}
+
+func TestWeightFromHeartbeats(t *testing.T) {
+	a := startServer(t, "")
+	b := startServer(t, "", WithWeight(3))
+	a.JoinCluster(b.Addr)
+	b.JoinCluster(a.Addr)
+
+	// a learns b's weight from b's answers to its heartbeats, then the
+	// weight b is given later on.
+	waitFor(t, "a to learn b's weight", func() bool { return a.ring.Weight(b.Addr) == 3 })
+	if err := b.ConfigureWeight(context.Background(), b.Addr, 5); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "a to learn b's new weight", func() bool { return a.ring.Weight(b.Addr) == 5 })
+	if w := b.ring.Weight(a.Addr); w != 1 {
+		t.Errorf("b has a at weight %d, want 1", w)
+	}
+}
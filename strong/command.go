@@ -0,0 +1,107 @@
+package strong
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+// -------- Commands --------
+
+// Op is what a Command does.
+type Op byte
+
+const (
+	opTick    Op = iota // Only move the group's clock on (see Group.get)
+	OpGet               // Read the key
+	OpSet               // Store the value
+	OpAdd               // Store the value only if the key is missing
+	OpReplace           // Store the value only if the key exists
+	OpCAS               // Store the value only if the key's version is still Version
+	OpDelete            // Remove the key
+)
+
+// Command is one operation on a key of a Group.
+type Command struct {
+	Op      Op
+	Key     string
+	Value   []byte
+	Flags   uint32
+	TTL     time.Duration // 0 means the value never expires
+	Version uint64        // OpCAS
+}
+
+// Result is what a Command did.
+type Result struct {
+	Found  bool // The key existed (before a write)
+	Stored bool // A write took place
+	Item   Item // OpGet: the key's item
+}
+
+// Item is a value in a Group, with its metadata.
+type Item struct {
+	Value   []byte
+	Flags   uint32
+	Version uint64    // The log index of the write that stored it
+	Written time.Time // When that write was proposed
+	Expires time.Time // Zero if it never expires
+}
+
+// -------- Entry Encoding --------
+// Writes go through the log as:
+//
+//	[8 id][8 proposed at, unix ms][1 op][2 key len][key][4 value len][value]
+//	[4 flags][8 ttl][8 version]
+//
+// The ID tells a retried proposal apart from a new one (see group.go).
+// Keys are at most MaxKeyLen bytes, which Do checks before proposing.
+
+// MaxKeyLen is the longest key a Group takes.
+const MaxKeyLen = math.MaxUint16
+
+// ErrKeyTooLong is returned by Do for keys over MaxKeyLen.
+var ErrKeyTooLong = errors.New("strong: key too long")
+
+var errBadEntry = errors.New("strong: malformed log entry")
+
+func encodeEntry(id uint64, at int64, cmd Command) []byte {
+	b := make([]byte, 0, 47+len(cmd.Key)+len(cmd.Value))
+	b = binary.BigEndian.AppendUint64(b, id)
+	b = binary.BigEndian.AppendUint64(b, uint64(at))
+	b = append(b, byte(cmd.Op))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(cmd.Key)))
+	b = append(b, cmd.Key...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(cmd.Value)))
+	b = append(b, cmd.Value...)
+	b = binary.BigEndian.AppendUint32(b, cmd.Flags)
+	b = binary.BigEndian.AppendUint64(b, uint64(cmd.TTL))
+	b = binary.BigEndian.AppendUint64(b, cmd.Version)
+	return b
+}
+
+func decodeEntry(data []byte) (id uint64, at int64, cmd Command, err error) {
+	take := func(n int) []byte {
+		if err != nil || n > len(data) {
+			err = errBadEntry
+			return make([]byte, min(n, 8)) // Enough to read zeros from
+		}
+		b := data[:n]
+		data = data[n:]
+		return b
+	}
+	id = binary.BigEndian.Uint64(take(8))
+	at = int64(binary.BigEndian.Uint64(take(8)))
+	cmd.Op = Op(take(1)[0])
+	cmd.Key = string(take(int(binary.BigEndian.Uint16(take(2)))))
+	if value := take(int(binary.BigEndian.Uint32(take(4)))); len(value) > 0 {
+		cmd.Value = value
+	}
+	cmd.Flags = binary.BigEndian.Uint32(take(4))
+	cmd.TTL = time.Duration(binary.BigEndian.Uint64(take(8)))
+	cmd.Version = binary.BigEndian.Uint64(take(8))
+	if err == nil && len(data) > 0 {
+		err = errBadEntry
+	}
+	return id, at, cmd, err
+}
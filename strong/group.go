@@ -0,0 +1,316 @@
+package strong
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/raft"
+)
+
+// -------- Strongly Consistent Keys --------
+// The cache's replicas settle their differences after the fact (read
+// repair, anti-entropy), so a read may miss a write that already
+// returned. That will not do for, say, a feature flag being turned off or
+// a leader lease changing hands. A Group keeps keys in a Raft log
+// instead (see the raft package):
+//
+//   - Writes are log entries, applied in the same order on every member,
+//     and return once applied.
+//   - Reads wait on raft.Node.ReadIndex, so they see every write that
+//     returned before they started, from any member.
+//
+// Every operation thus takes effect at a single point between its call
+// and its return: the group is linearizable (see the lincheck package,
+// which the tests check it with). It stays available as long as a
+// majority of its voters can talk to each other.
+//
+// Each key has a version, the log index of the write that stored it,
+// which OpCAS compares against. Expiry follows the log: entries carry the
+// time they were proposed, and the group's clock is the latest of those,
+// so every member expires a key at the same entry. Reads go by that clock
+// alone: when a key has expired by a member's own clock but not yet by
+// the log's, the member proposes an entry that only moves the log's clock
+// on, and reads again. Expiry is thus only as precise as the members'
+// clocks agree, but every member sees it happen at the same point.
+//
+// A proposal may reach the log twice when a reply is lost and it is
+// retried (see raft.Node.Propose). Each one carries a random ID and the
+// group keeps the results of the last maxResults, so a repeat returns the
+// first result instead of, say, adding the key again.
+
+// DefaultSnapshotEvery is how many entries a group applies between
+// snapshots unless WithSnapshotEvery says otherwise.
+const DefaultSnapshotEvery = 1024
+
+// maxResults bounds the results kept to answer retried proposals.
+const maxResults = 1024
+
+var errNoResult = errors.New("strong: proposal applied without a result")
+
+// item is an Item as the group keeps it, times in unix ms.
+type item struct {
+	Value   []byte `json:"value,omitempty"`
+	Flags   uint32 `json:"flags,omitempty"`
+	Version uint64 `json:"version"`
+	Written int64  `json:"written"`
+	Expires int64  `json:"expires,omitempty"` // 0 if never
+}
+
+func (it item) expired(now int64) bool {
+	return it.Expires != 0 && it.Expires <= now
+}
+
+func (it item) export() Item {
+	out := Item{Value: slices.Clone(it.Value), Flags: it.Flags, Version: it.Version, Written: time.UnixMilli(it.Written)}
+	if it.Expires != 0 {
+		out.Expires = time.UnixMilli(it.Expires)
+	}
+	return out
+}
+
+// result is a remembered Result, by proposal ID.
+type result struct {
+	ID     uint64 `json:"id"`
+	Found  bool   `json:"found,omitempty"`
+	Stored bool   `json:"stored,omitempty"`
+}
+
+// state is everything the log builds, which a snapshot saves.
+type state struct {
+	Clock   int64           `json:"clock"`
+	Items   map[string]item `json:"items"`
+	Results []result        `json:"results"` // Oldest first
+}
+
+// Group is one member of a group of nodes keeping keys in a Raft log. It
+// is safe for concurrent use.
+type Group struct {
+	node          *raft.Node
+	snapshotEvery int
+	raftOpts      []raft.Option
+
+	mu      sync.Mutex
+	state   state
+	results map[uint64]result        // state.Results by ID
+	pending map[uint64]chan<- result // Proposals of this member awaiting their result
+}
+
+// NewGroup creates the member id of the group of voters. Messages to the
+// other members go through transport and theirs come in through Handle.
+func NewGroup(id string, voters []string, transport raft.Transport, opts ...Option) *Group {
+	g := &Group{
+		snapshotEvery: DefaultSnapshotEvery,
+		state:         state{Items: make(map[string]item)},
+		results:       make(map[uint64]result),
+		pending:       make(map[uint64]chan<- result),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	raftOpts := append(g.raftOpts, raft.WithSnapshots(g.snapshotEvery, g.save, g.load))
+	g.node = raft.New(id, voters, transport, g.apply, raftOpts...)
+	return g
+}
+
+// Start starts the member, see raft.Node.Start.
+func (g *Group) Start() error {
+	return g.node.Start()
+}
+
+// Stop stops the member.
+func (g *Group) Stop() {
+	g.node.Stop()
+}
+
+// Handle answers a message from another member.
+func (g *Group) Handle(ctx context.Context, msg []byte) ([]byte, error) {
+	return g.node.Handle(ctx, msg)
+}
+
+// Status returns the member's view of its Raft group.
+func (g *Group) Status() raft.Status {
+	return g.node.Status()
+}
+
+// Do runs cmd and returns what it did. Writes that return an error may
+// still have taken effect.
+func (g *Group) Do(ctx context.Context, cmd Command) (Result, error) {
+	if len(cmd.Key) > MaxKeyLen {
+		return Result{}, ErrKeyTooLong
+	}
+	if cmd.Op == OpGet {
+		return g.get(ctx, cmd.Key)
+	}
+	return g.propose(ctx, cmd)
+}
+
+// propose puts cmd in the log and returns what it did.
+func (g *Group) propose(ctx context.Context, cmd Command) (Result, error) {
+	id := rand.Uint64()
+	done := make(chan result, 1)
+	g.mu.Lock()
+	g.pending[id] = done
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		delete(g.pending, id)
+		g.mu.Unlock()
+	}()
+
+	if _, err := g.node.Propose(ctx, encodeEntry(id, time.Now().UnixMilli(), cmd)); err != nil {
+		return Result{}, err
+	}
+	// Propose returns once the entry was applied here, result and all.
+	select {
+	case r := <-done:
+		return Result{Found: r.Found, Stored: r.Stored}, nil
+	default:
+		return Result{}, errNoResult
+	}
+}
+
+// get reads key once every write that returned before is applied here.
+func (g *Group) get(ctx context.Context, key string) (Result, error) {
+	if err := g.node.ReadIndex(ctx); err != nil {
+		return Result{}, err
+	}
+	for ticked := false; ; ticked = true {
+		g.mu.Lock()
+		it, ok := g.state.Items[key]
+		clock := g.state.Clock
+		g.mu.Unlock()
+		if !ok || it.expired(clock) {
+			return Result{}, nil
+		}
+		if ticked || !it.expired(time.Now().UnixMilli()) {
+			return Result{Found: true, Item: it.export()}, nil
+		}
+		// Expired here, but not yet by the log: once the tick is applied
+		// here, so is every write before it.
+		if _, err := g.propose(ctx, Command{Op: opTick}); err != nil {
+			return Result{}, err
+		}
+	}
+}
+
+// -------- State Machine --------
+
+// apply runs one committed write. Only the entry may decide what it does,
+// so that every member does the same.
+func (g *Group) apply(e raft.Entry) {
+	id, at, cmd, err := decodeEntry(e.Data)
+	if err != nil {
+		return // Not written by encodeEntry; every member skips it alike
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.state.Clock = max(g.state.Clock, at)
+	if cmd.Op == opTick {
+		if done, ok := g.pending[id]; ok {
+			done <- result{ID: id}
+			delete(g.pending, id)
+		}
+		return
+	}
+	r, ok := g.results[id]
+	if !ok {
+		r = g.execute(e.Index, cmd)
+		r.ID = id
+		g.remember(r)
+	}
+	if done, ok := g.pending[id]; ok {
+		done <- r
+		delete(g.pending, id)
+	}
+}
+
+// execute applies cmd, the entry at index. The caller must hold g.mu.
+func (g *Group) execute(index uint64, cmd Command) result {
+	now := g.state.Clock
+	it, found := g.state.Items[cmd.Key]
+	if found && it.expired(now) {
+		delete(g.state.Items, cmd.Key)
+		found = false
+	}
+
+	switch cmd.Op {
+	case OpAdd:
+		if found {
+			return result{Found: true}
+		}
+	case OpReplace:
+		if !found {
+			return result{}
+		}
+	case OpCAS:
+		if !found || it.Version != cmd.Version {
+			return result{Found: found}
+		}
+	case OpDelete:
+		delete(g.state.Items, cmd.Key)
+		return result{Found: found}
+	case OpSet:
+	default:
+		return result{Found: found}
+	}
+
+	stored := item{Value: cmd.Value, Flags: cmd.Flags, Version: index, Written: now}
+	if cmd.TTL > 0 {
+		stored.Expires = now + cmd.TTL.Milliseconds()
+	}
+	g.state.Items[cmd.Key] = stored
+	return result{Found: found, Stored: true}
+}
+
+// remember keeps r for retries of its proposal, forgetting the oldest
+// result past maxResults. The caller must hold g.mu.
+func (g *Group) remember(r result) {
+	g.state.Results = append(g.state.Results, r)
+	g.results[r.ID] = r
+	if len(g.state.Results) > maxResults {
+		delete(g.results, g.state.Results[0].ID)
+		g.state.Results = slices.Delete(g.state.Results, 0, 1)
+	}
+}
+
+// save returns a snapshot of the state. Expired keys are dropped on the
+// way: no member can see them any more.
+func (g *Group) save() []byte {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for key, it := range g.state.Items {
+		if it.expired(g.state.Clock) {
+			delete(g.state.Items, key)
+		}
+	}
+	data, err := json.Marshal(&g.state)
+	if err != nil {
+		panic(err) // Plain values only
+	}
+	return data
+}
+
+// load replaces the state with a snapshot save returned.
+func (g *Group) load(data []byte) {
+	st := state{Items: make(map[string]item)}
+	if err := json.Unmarshal(data, &st); err != nil {
+		panic("strong: corrupt snapshot: " + err.Error())
+	}
+	if st.Items == nil {
+		st.Items = make(map[string]item)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.state = st
+	g.results = make(map[uint64]result, len(st.Results))
+	for _, r := range st.Results {
+		g.results[r.ID] = r
+	}
+}
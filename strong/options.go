@@ -0,0 +1,23 @@
+package strong
+
+import "github.com/BiChong-Jin/distributed-cache/raft"
+
+// -------- Group Options --------
+// Optional settings passed to NewGroup, e.g.
+//
+//	strong.NewGroup(id, voters, transport, strong.WithRaft(raft.WithStorage("shard-3.raft")))
+
+// Option configures a Group.
+type Option func(*Group)
+
+// WithSnapshotEvery sets how many entries the group applies between
+// snapshots. The default is DefaultSnapshotEvery.
+func WithSnapshotEvery(n int) Option {
+	return func(g *Group) { g.snapshotEvery = n }
+}
+
+// WithRaft passes options on to the group's raft.Node, e.g. its logger,
+// heartbeat or storage.
+func WithRaft(opts ...raft.Option) Option {
+	return func(g *Group) { g.raftOpts = append(g.raftOpts, opts...) }
+}
@@ -0,0 +1,324 @@
+package strong
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BiChong-Jin/distributed-cache/lincheck"
+	"github.com/BiChong-Jin/distributed-cache/raft"
+)
+
+const testHeartbeat = 5 * time.Millisecond
+
+// cluster is a group of members talking through memory, with a nemesis
+// that can cut members off.
+type cluster struct {
+	mu      sync.Mutex
+	members map[string]*Group
+	cut     map[string]bool
+	ids     []string
+}
+
+func newCluster(t *testing.T, ids []string, opts ...Option) *cluster {
+	c := &cluster{members: map[string]*Group{}, cut: map[string]bool{}, ids: ids}
+	for _, id := range ids {
+		transport := func(ctx context.Context, peer string, msg []byte) ([]byte, error) {
+			c.mu.Lock()
+			to, cut := c.members[peer], c.cut[id] || c.cut[peer]
+			c.mu.Unlock()
+			if to == nil || cut {
+				return nil, errors.New("unreachable")
+			}
+			return to.Handle(ctx, msg)
+		}
+		g := NewGroup(id, ids, transport, append([]Option{WithRaft(raft.WithHeartbeat(testHeartbeat))}, opts...)...)
+		c.members[id] = g
+	}
+	for _, g := range c.members {
+		if err := g.Start(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(g.Stop)
+	}
+	return c
+}
+
+func (c *cluster) setCut(id string, cut bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cut[id] = cut
+}
+
+func do(t *testing.T, g *Group, cmd Command) Result {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r, err := g.Do(ctx, cmd)
+	if err != nil {
+		t.Fatalf("%v on %q: %v", cmd.Op, cmd.Key, err)
+	}
+	return r
+}
+
+func TestCommands(t *testing.T) {
+	c := newCluster(t, []string{"a", "b", "c"})
+	a, b := c.members["a"], c.members["b"]
+
+	if r := do(t, a, Command{Op: OpAdd, Key: "lease", Value: []byte("a")}); !r.Stored {
+		t.Error("Add of a missing key did not store")
+	}
+	if r := do(t, b, Command{Op: OpAdd, Key: "lease", Value: []byte("b")}); r.Stored || !r.Found {
+		t.Errorf("Add of an existing key = %+v", r)
+	}
+	// Reads on any member see the write that returned.
+	r := do(t, b, Command{Op: OpGet, Key: "lease"})
+	if !r.Found || string(r.Item.Value) != "a" {
+		t.Fatalf("Get = %+v, want a", r)
+	}
+
+	version := r.Item.Version
+	if r := do(t, b, Command{Op: OpCAS, Key: "lease", Value: []byte("b"), Version: version + 1}); r.Stored {
+		t.Error("CAS with a wrong version stored")
+	}
+	if r := do(t, b, Command{Op: OpCAS, Key: "lease", Value: []byte("b"), Version: version}); !r.Stored {
+		t.Error("CAS with the current version did not store")
+	}
+	if r := do(t, a, Command{Op: OpReplace, Key: "missing", Value: []byte("x")}); r.Stored {
+		t.Error("Replace of a missing key stored")
+	}
+	if r := do(t, a, Command{Op: OpDelete, Key: "lease"}); !r.Found {
+		t.Error("Delete did not find the key")
+	}
+	if r := do(t, c.members["c"], Command{Op: OpGet, Key: "lease"}); r.Found {
+		t.Errorf("Get after Delete = %+v", r)
+	}
+
+	// Expired keys are gone for reads and conditional writes alike.
+	do(t, a, Command{Op: OpSet, Key: "flag", Value: []byte("on"), TTL: 50 * time.Millisecond})
+	time.Sleep(60 * time.Millisecond)
+	if r := do(t, b, Command{Op: OpGet, Key: "flag"}); r.Found {
+		t.Errorf("Get of an expired key = %+v", r)
+	}
+	if r := do(t, b, Command{Op: OpAdd, Key: "flag", Value: []byte("off")}); !r.Stored {
+		t.Error("Add over an expired key did not store")
+	}
+}
+
+func TestExpiryFollowsTheLog(t *testing.T) {
+	c := newCluster(t, []string{"a", "b", "c"})
+	a, b := c.members["a"], c.members["b"]
+
+	do(t, a, Command{Op: OpSet, Key: "flag", Value: []byte("on"), TTL: 50 * time.Millisecond})
+	expires := do(t, a, Command{Op: OpGet, Key: "flag"}).Item.Expires.UnixMilli()
+	time.Sleep(60 * time.Millisecond)
+
+	// No write has moved the log's clock past the expiry yet, so the read
+	// does it before answering, and every member then agrees.
+	b.mu.Lock()
+	results := len(b.state.Results)
+	b.mu.Unlock()
+	if r := do(t, b, Command{Op: OpGet, Key: "flag"}); r.Found {
+		t.Errorf("Get of an expired key = %+v", r)
+	}
+	for _, g := range c.members {
+		waitClock := func() bool {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			return g.state.Clock >= expires
+		}
+		for deadline := time.Now().Add(5 * time.Second); !waitClock(); time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal("a member's clock did not reach the expiry")
+			}
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.state.Results) != results {
+		t.Error("a clock tick was kept as a proposal result")
+	}
+}
+
+func TestKeyTooLong(t *testing.T) {
+	c := newCluster(t, []string{"a"})
+	key := string(make([]byte, MaxKeyLen+1))
+	for _, op := range []Op{OpSet, OpGet} {
+		if _, err := c.members["a"].Do(context.Background(), Command{Op: op, Key: key, Value: []byte("v")}); !errors.Is(err, ErrKeyTooLong) {
+			t.Errorf("op %d with a key of %d bytes: expected ErrKeyTooLong, got %v", op, len(key), err)
+		}
+	}
+	if r := do(t, c.members["a"], Command{Op: OpSet, Key: key[:MaxKeyLen], Value: []byte("v")}); !r.Stored {
+		t.Errorf("Set with a key of MaxKeyLen bytes = %+v", r)
+	}
+}
+
+func TestRetriedProposalAppliesOnce(t *testing.T) {
+	c := newCluster(t, []string{"a"})
+	g := c.members["a"]
+
+	// The same proposal twice, as after a lost reply.
+	data := encodeEntry(42, time.Now().UnixMilli(), Command{Op: OpAdd, Key: "k", Value: []byte("v")})
+	for range 2 {
+		done := make(chan result, 1)
+		g.mu.Lock()
+		g.pending[42] = done
+		g.mu.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := g.node.Propose(ctx, data)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := <-done; !r.Stored {
+			t.Errorf("the repeat of a stored Add = %+v, want the first result", r)
+		}
+	}
+}
+
+func TestEntryEncoding(t *testing.T) {
+	cmd := Command{Op: OpCAS, Key: "k", Value: []byte("v"), Flags: 7, TTL: time.Second, Version: 9}
+	data := encodeEntry(1, 2, cmd)
+	id, at, got, err := decodeEntry(data)
+	if err != nil || id != 1 || at != 2 || fmt.Sprint(got) != fmt.Sprint(cmd) {
+		t.Errorf("decoded %d %d %+v %v, want 1 2 %+v", id, at, got, err, cmd)
+	}
+	for _, bad := range [][]byte{data[:len(data)-1], append(data, 0), nil} {
+		if _, _, _, err := decodeEntry(bad); err == nil {
+			t.Errorf("decoded a malformed entry of %d bytes", len(bad))
+		}
+	}
+}
+
+// -------- Linearizability --------
+// Clients run random operations on a few keys against random members
+// while a nemesis keeps cutting members off, as Jepsen does, and the
+// history they record is checked against a model of a key-value store.
+
+type kvInput struct {
+	key   string
+	op    Op // OpGet, OpSet, OpAdd or OpDelete
+	value string
+}
+
+type kvOutput struct {
+	done   bool // Zero if the outcome is unknown
+	found  bool
+	stored bool
+	value  string
+}
+
+type kvState struct {
+	present bool
+	value   string
+}
+
+var kvModel = lincheck.Model[kvState, kvInput, kvOutput]{
+	Init: func() kvState { return kvState{} },
+	Step: func(s kvState, in kvInput, out kvOutput) (bool, kvState) {
+		next := s
+		switch in.op {
+		case OpSet:
+			next = kvState{true, in.value}
+		case OpAdd:
+			if !s.present {
+				next = kvState{true, in.value}
+			}
+			if out.done && out.stored == s.present {
+				return false, s
+			}
+		case OpDelete:
+			next = kvState{}
+			if out.done && out.found != s.present {
+				return false, s
+			}
+		case OpGet:
+			if out.done && (out.found != s.present || out.value != s.value) {
+				return false, s
+			}
+		}
+		return true, next
+	},
+	Partition: func(history []lincheck.Operation[kvInput, kvOutput]) [][]lincheck.Operation[kvInput, kvOutput] {
+		byKey := map[string][]lincheck.Operation[kvInput, kvOutput]{}
+		for _, op := range history {
+			byKey[op.Input.key] = append(byKey[op.Input.key], op)
+		}
+		var parts [][]lincheck.Operation[kvInput, kvOutput]
+		for _, part := range byKey {
+			parts = append(parts, part)
+		}
+		return parts
+	},
+}
+
+func TestLinearizableUnderPartitions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs for a couple of seconds")
+	}
+	// Frequent snapshots, so cut-off members also catch up from them.
+	c := newCluster(t, []string{"a", "b", "c", "d", "e"}, WithSnapshotEvery(16))
+
+	stop := make(chan struct{})
+	var nemesis sync.WaitGroup
+	nemesis.Go(func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Duration(10+rand.N(20)) * testHeartbeat):
+			}
+			// Cut off up to two members, which leaves a majority, or
+			// sometimes three, which leaves none.
+			for _, id := range c.ids {
+				c.setCut(id, false)
+			}
+			for range rand.N(4) {
+				c.setCut(c.ids[rand.N(len(c.ids))], true)
+			}
+		}
+	})
+
+	var rec lincheck.Recorder[kvInput, kvOutput]
+	var clients sync.WaitGroup
+	ops := []Op{OpGet, OpGet, OpSet, OpAdd, OpDelete}
+	deadline := time.Now().Add(2 * time.Second)
+	for client := range 6 {
+		clients.Go(func() {
+			for i := 0; time.Now().Before(deadline); i++ {
+				time.Sleep(time.Duration(rand.N(3)) * time.Millisecond)
+				in := kvInput{key: fmt.Sprint("k", rand.N(3)), op: ops[rand.N(len(ops))], value: fmt.Sprint(client, "-", i)}
+				g := c.members[c.ids[rand.N(len(c.ids))]]
+				done := rec.Call(client, in)
+				ctx, cancel := context.WithTimeout(context.Background(), 40*testHeartbeat)
+				r, err := g.Do(ctx, Command{Op: in.op, Key: in.key, Value: []byte(in.value)})
+				cancel()
+				if err == nil {
+					done(kvOutput{done: true, found: r.Found, stored: r.Stored, value: string(r.Item.Value)})
+				}
+			}
+		})
+	}
+	clients.Wait()
+	close(stop)
+	nemesis.Wait()
+
+	history := rec.History()
+	completed := 0
+	for _, op := range history {
+		if op.Return != 0 {
+			completed++
+		}
+	}
+	t.Logf("%d operations, %d completed", len(history), completed)
+	if completed == 0 {
+		t.Fatal("no operation completed")
+	}
+	if !lincheck.Check(kvModel, history) {
+		t.Error("history is not linearizable")
+	}
+}
@@ -0,0 +1,136 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+)
+
+// -------- Distributed Tracing --------
+// Requests carry W3C trace context in Request.TraceParent and
+// Request.TraceState, so a trace started by a client continues on the node
+// it talks to and on the node the request is proxied to:
+//
+//	cache.client get      client.sendRequest        (client)
+//	  cache.server get    Server.handleConnection   (node A)
+//	    cache.forward get forwardToNode             (node A → B)
+//	      cache.server get                          (node B)
+//	        cache.local get handleLocally           (node B)
+//
+// Spans go to the OpenTelemetry TracerProvider given with WithTracerProvider
+// on the server or client, or else to the global one, which SetupOTLP
+// installs. Without either, tracing costs next to nothing.
+
+// Name is the instrumentation name of every tracer in this module.
+const Name = "github.com/BiChong-Jin/distributed-cache"
+
+var propagator = propagation.TraceContext{}
+
+// Tracer returns the module's tracer from tp, or from the global provider
+// if tp is nil.
+func Tracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(Name)
+}
+
+// Inject writes the span context of ctx into req.
+func Inject(ctx context.Context, req *protocol.Request) {
+	propagator.Inject(ctx, carrier{req})
+}
+
+// Extract returns ctx with the span context carried by req as its remote
+// parent. Requests without one leave ctx unchanged.
+func Extract(ctx context.Context, req *protocol.Request) context.Context {
+	return propagator.Extract(ctx, carrier{req})
+}
+
+// Start starts a span for req named "cache.<stage> <command>".
+func Start(ctx context.Context, tracer trace.Tracer, stage string, kind trace.SpanKind, req *protocol.Request, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("cache.command", req.CommandType.String()))
+	if req.Namespace != "" {
+		attrs = append(attrs, attribute.String("cache.namespace", req.Namespace))
+	}
+	return tracer.Start(ctx, "cache."+stage+" "+req.CommandType.String(),
+		trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// End records how the request went and ends the span. Only failures count
+// as errors; a missing key or a refused conditional write does not.
+func End(span trace.Span, res *protocol.Response, err error) {
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case res != nil && (res.StatusCode == protocol.StatusError || res.StatusCode == protocol.StatusDenied || res.StatusCode == protocol.StatusUnavailable):
+		span.SetStatus(codes.Error, res.ErrorMessage)
+	}
+	span.End()
+}
+
+// carrier lets the propagator read and write a Request's trace fields.
+type carrier struct{ req *protocol.Request }
+
+func (c carrier) Get(key string) string {
+	switch key {
+	case "traceparent":
+		return c.req.TraceParent
+	case "tracestate":
+		return c.req.TraceState
+	}
+	return ""
+}
+
+func (c carrier) Set(key, value string) {
+	switch key {
+	case "traceparent":
+		c.req.TraceParent = value
+	case "tracestate":
+		c.req.TraceState = value
+	}
+}
+
+func (c carrier) Keys() []string {
+	return []string{"traceparent", "tracestate"}
+}
+
+// -------- OTLP Export --------
+
+// SetupOTLP installs a global TracerProvider that batches spans to an
+// OTLP/gRPC collector at endpoint (e.g. "localhost:4317"). An empty
+// endpoint falls back to the OTEL_EXPORTER_OTLP_* environment variables.
+// Call the returned function on shutdown to flush the last spans.
+func SetupOTLP(ctx context.Context, endpoint string, insecure bool, service, instance string) (func(context.Context) error, error) {
+	var opts []otlptracegrpc.Option
+	if endpoint != "" {
+		opts = append(opts, otlptracegrpc.WithEndpoint(endpoint))
+	}
+	if insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", service),
+			attribute.String("service.instance.id", instance),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+	return tp.Shutdown, nil
+}
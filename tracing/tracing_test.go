@@ -0,0 +1,153 @@
+package tracing_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/BiChong-Jin/distributed-cache/client"
+	"github.com/BiChong-Jin/distributed-cache/consistent"
+	"github.com/BiChong-Jin/distributed-cache/protocol"
+	"github.com/BiChong-Jin/distributed-cache/server"
+	"github.com/BiChong-Jin/distributed-cache/tracing"
+)
+
+func TestInjectExtract(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	ctx, span := tp.Tracer("test").Start(context.Background(), "parent")
+	defer span.End()
+
+	req := &protocol.Request{CommandType: protocol.CmdGet, Key: "k"}
+	tracing.Inject(ctx, req)
+	if req.TraceParent == "" {
+		t.Fatal("Inject did not set TraceParent")
+	}
+
+	// The request survives the wire with its trace context.
+	data, err := req.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := protocol.DecodeRequest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote := trace.SpanContextFromContext(tracing.Extract(context.Background(), decoded))
+	if !remote.IsRemote() || remote.TraceID() != span.SpanContext().TraceID() || remote.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("extracted %v, want the remote parent %v", remote, span.SpanContext())
+	}
+
+	// Without trace context there is nothing to extract.
+	if sc := trace.SpanContextFromContext(tracing.Extract(context.Background(), &protocol.Request{})); sc.IsValid() {
+		t.Errorf("extracted %v from a request without trace context", sc)
+	}
+}
+
+// TestTraceAcrossProxyHop sends a request to a node that does not own the
+// key and checks that every span along the way lands in one trace, each
+// the child of the one before.
+func TestTraceAcrossProxyHop(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	addrA, addrB := freeAddr(t), freeAddr(t)
+	a := server.NewServer(addrA, server.WithTracerProvider(tp))
+	b := server.NewServer(addrB, server.WithTracerProvider(tp))
+	for _, s := range []*server.Server{a, b} {
+		go s.Start()
+		defer s.Stop()
+	}
+	a.JoinCluster(addrB)
+	b.JoinCluster(addrA)
+	waitForListener(t, addrA)
+	waitForListener(t, addrB)
+
+	// Find a key owned by B, so A has to forward it.
+	ring := consistent.NewHashRing(150)
+	ring.AddNode(addrA)
+	ring.AddNode(addrB)
+	key := ""
+	for i := 0; ring.GetNode(key) != addrB; i++ {
+		key = fmt.Sprintf("key-%d", i)
+	}
+
+	c := client.NewClient(addrA, client.WithTracerProvider(tp))
+	defer c.Close()
+	if err := c.Set(key, []byte("v"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Heartbeats between the nodes make traces of their own; keep the
+	// client's.
+	var spans tracetest.SpanStubs
+	var clientSpan tracetest.SpanStub
+	for _, s := range exporter.GetSpans() {
+		if s.Name == "cache.client set" {
+			clientSpan = s
+		}
+	}
+	byName := map[string]tracetest.SpanStub{}
+	for _, s := range exporter.GetSpans() {
+		if s.SpanContext.TraceID() == clientSpan.SpanContext.TraceID() {
+			spans = append(spans, s)
+			byName[s.Name] = s
+		}
+	}
+	chain := []string{"cache.client set", "cache.server set", "cache.forward set", "cache.server set", "cache.local set"}
+	if len(spans) != len(chain) {
+		t.Fatalf("got %d spans, want %d: %v", len(spans), len(chain), spanNames(spans))
+	}
+
+	// Spans end innermost first, so walk up from the local span.
+	parentOf := map[trace.SpanID]tracetest.SpanStub{}
+	for _, s := range spans {
+		parentOf[s.SpanContext.SpanID()] = s
+	}
+	span := byName["cache.local set"]
+	for i := len(chain) - 1; i >= 0; i-- {
+		if span.Name != chain[i] {
+			t.Fatalf("span %d is %q, want %q", i, span.Name, chain[i])
+		}
+		if i > 0 {
+			span = parentOf[span.Parent.SpanID()]
+		} else if span.Parent.IsValid() {
+			t.Errorf("the client span has a parent")
+		}
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func waitForListener(t *testing.T, addr string) {
+	for range 100 {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s is not listening", addr)
+}
+
+func spanNames(spans tracetest.SpanStubs) []string {
+	names := make([]string, len(spans))
+	for i, s := range spans {
+		names[i] = s.Name
+	}
+	return names
+}